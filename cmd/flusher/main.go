@@ -2,9 +2,11 @@ package main
 
 import (
 	"GoStacker/internal/meta/chat/group"
+	"GoStacker/internal/meta/chat/retention"
 	chatsend "GoStacker/internal/send/chat/send"
 	"GoStacker/pkg/bootstrap"
 	"GoStacker/pkg/config"
+	"GoStacker/pkg/monitor"
 	"flag"
 	"fmt"
 	"os"
@@ -38,6 +40,18 @@ func main() {
 	go group.RunGroupFlusher(interval, batch, stopCh)
 	go chatsend.StartMessageFlusher(5*time.Second, 100, stopCh)
 
+	// message retention purge; flusher has no HTTP server, expose metrics separately if configured
+	if rc := config.Conf.RetentionConfig; rc != nil && rc.Enabled {
+		go retention.RunPurger(rc, stopCh)
+		if rc.MetricsAddr != "" {
+			go func() {
+				if err := monitor.StartExporter(rc.MetricsAddr, 5*time.Second); err != nil {
+					fmt.Printf("metrics exporter failed: %v\n", err)
+				}
+			}()
+		}
+	}
+
 	// block forever
 	select {}
 }
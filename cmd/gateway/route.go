@@ -2,6 +2,8 @@ package main
 
 import (
 	"GoStacker/internal/gateway/center"
+	"GoStacker/internal/gateway/debug"
+	"GoStacker/internal/gateway/push"
 	"GoStacker/internal/gateway/user/ws"
 	"GoStacker/pkg/logger"
 	"GoStacker/pkg/middleware"
@@ -10,19 +12,33 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func InitRouter() *gin.Engine {
+func InitRouter(gw *push.Gateway) *gin.Engine {
+	wsHandler := ws.NewHandler(gw)
 	r := gin.New()
 	r.Use(logger.GinLogger(), logger.GinRecovery(true))
 	// metrics endpoint for Prometheus
 	r.GET("/metrics", gin.WrapH(monitor.Handler()))
+	// the upgrade accepts either a Bearer token or a one-time ?ticket= (browsers cannot set headers)
+	r.GET("/api/ws", ws.AuthMiddleware(), wsHandler.WebSocketHandler)
+	// fallback transports for clients whose proxies block WebSocket upgrades
+	r.GET("/api/events", ws.AuthMiddleware(), wsHandler.SSEHandler)
 	User := r.Group("/api", middleware.JWTAuthMiddleware())
 	{
-		User.GET("/ws", ws.WebSocketHandler)
+		User.POST("/ws/ticket", ws.TicketHandler)
+		User.GET("/poll", wsHandler.PollHandler)
+		User.POST("/ack", wsHandler.AckHandler)
 	}
 	Center := r.Group("/center")
 	{
-		Center.POST("/forward", center.ForwardHandler)
+		Center.POST("/forward", center.NewHandler(gw).ForwardHandler)
 
 	}
+	// connection introspection for ops, requires X-Debug-Token
+	debugHandler := debug.NewHandler(gw)
+	Debug := r.Group("/debug", debug.AuthMiddleware())
+	{
+		Debug.GET("/connections", debugHandler.ConnectionsHandler)
+		Debug.GET("/connections/:user_id", debugHandler.UserConnectionsHandler)
+	}
 	return r
 }
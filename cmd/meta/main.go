@@ -1,6 +1,7 @@
 package main
 
 import (
+	"GoStacker/internal/meta/chat/export"
 	"GoStacker/pkg/bootstrap"
 	"GoStacker/pkg/config"
 	"flag"
@@ -20,6 +21,11 @@ func main() {
 	}
 	defer cleanup()
 
+	if err := export.Init(config.Conf.ExportConfig); err != nil {
+		fmt.Printf("export init failed: %v\n", err)
+		os.Exit(1)
+	}
+
 	engine := NewRouter()
 	addr := fmt.Sprintf(":%d", config.Conf.Port)
 	srv := &http.Server{Addr: addr, Handler: engine}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"GoStacker/internal/meta/chat/export"
 	"GoStacker/internal/meta/chat/group"
+	"GoStacker/internal/meta/chat/retention"
+	"GoStacker/internal/meta/keys"
 	"GoStacker/internal/meta/user"
 	"GoStacker/pkg/logger"
 	"GoStacker/pkg/middleware"
@@ -26,9 +29,17 @@ func NewRouter() *gin.Engine {
 	g.POST("/register", user.RegisterHandler)
 	g.POST("/login", user.LoginHandler)
 
+	// internal endpoints for operators, require the shared internal token
+	internal := g.Group("/internal", middleware.InternalAuthMiddleware())
+	{
+		internal.POST("/user/ban", user.InternalBanHandler)
+	}
+
 	// authenticated metadata routes
 	auth := g.Group("/api", middleware.JWTAuthMiddleware())
 	{
+		auth.POST("/logout", user.LogoutHandler)
+
 		auth.POST("/chat/group/create", group.CreateRoomHandler)
 		auth.POST("/chat/group/add_member", group.AddRoomMemberHandler)
 		auth.POST("/chat/group/add_members", group.AddRoomMembersHandler)
@@ -41,6 +52,21 @@ func NewRouter() *gin.Engine {
 		auth.GET("/chat/group/join/requests", group.GetPendingJoinRequestsHandler)
 		auth.POST("/chat/group/join/respond", group.RespondJoinRequestHandler)
 		auth.GET("/joined_rooms", group.GetJoinedRoomsHandler)
+		auth.GET("/chat/group/retention", retention.GetPolicyHandler)
+		auth.POST("/chat/group/retention", retention.SetPolicyHandler)
+		auth.POST("/chat/group/e2e", group.SetRoomE2EHandler)
+
+		// end-to-end encryption key directory
+		auth.POST("/keys/bundle", keys.UploadBundleHandler)
+		auth.GET("/keys/bundle", keys.FetchBundlesHandler)
+		auth.POST("/keys/prekeys", keys.UploadPreKeysHandler)
+		auth.GET("/keys/prekeys/count", keys.PreKeyCountHandler)
+		auth.DELETE("/keys/device", keys.RemoveDeviceHandler)
+
+		// transcript export (room owner/admin)
+		auth.POST("/chat/export", export.CreateExportHandler)
+		auth.GET("/chat/export/status", export.ExportStatusHandler)
+		auth.GET("/chat/export/download", export.ExportDownloadHandler)
 	}
 
 	return g
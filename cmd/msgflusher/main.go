@@ -33,6 +33,7 @@ func main() {
 	}
 	defer rdb.Close()
 	monitor.InitMonitor()
+	defer monitor.Stop()
 
 	reclaimer, err := msgflusher.NewReclaimer(config.Conf)
 	if err != nil {
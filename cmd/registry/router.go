@@ -1,6 +1,7 @@
 package main
 
 import (
+	"GoStacker/internal/registry/admin"
 	"GoStacker/internal/registry/gateway"
 	"GoStacker/internal/registry/send"
 	"GoStacker/internal/registry/user"
@@ -32,6 +33,7 @@ func NewRouter() *gin.Engine {
 		{
 			gwGroup.POST("/register", gateway.RegisterHandler)
 			gwGroup.POST("/heartbeat", gateway.HeartbeatHandler)
+			gwGroup.POST("/drain", gateway.DrainHandler)
 			gwGroup.DELETE("/:gateway_id", gateway.UnregisterHandler)
 			gwGroup.GET("/instances", gateway.ListGatewaysHandler)
 		}
@@ -53,6 +55,12 @@ func NewRouter() *gin.Engine {
 			userGroup.POST("/routes/batch", user.BatchQueryRoutesHandler)
 		}
 
+		// Admin operations (X-Admin-Token)
+		adminGroup := api.Group("/admin", admin.AuthMiddleware())
+		{
+			adminGroup.POST("/kick", admin.KickHandler)
+		}
+
 		// Gateway discovery (for clients)
 		api.GET("/gateway/available", user.GetAvailableGatewayHandler)
 		// Send instance discovery (for clients)
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"GoStacker/internal/send/notify"
+	"GoStacker/internal/send/presence"
 	"GoStacker/internal/send/push"
 	"GoStacker/internal/send/route"
+	"GoStacker/internal/send/upload"
 	"GoStacker/pkg/bootstrap"
 	"GoStacker/pkg/config"
 	rdb "GoStacker/pkg/db/redis"
@@ -90,6 +93,16 @@ func main() {
 		}
 	}
 
+	// offline mobile push for recipients without a live connection
+	notify.Init(config.Conf.NotifyConfig)
+
+	presence.Init(config.Conf.PresenceConfig)
+
+	if err := upload.Init(config.Conf.UploadConfig); err != nil {
+		fmt.Printf("init upload service failed: %v\n", err)
+		os.Exit(1)
+	}
+
 	// build send service router and start server on configured port
 	engine := NewRouter()
 	addr := fmt.Sprintf(":%d", config.Conf.Port)
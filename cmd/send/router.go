@@ -2,8 +2,11 @@ package main
 
 import (
 	"GoStacker/internal/send/chat/send"
+	"GoStacker/internal/send/notify"
+	"GoStacker/internal/send/presence"
 	"GoStacker/internal/send/pushback"
 	"GoStacker/internal/send/pushnotify"
+	"GoStacker/internal/send/upload"
 	"GoStacker/pkg/logger"
 	"GoStacker/pkg/middleware"
 	"GoStacker/pkg/monitor"
@@ -25,19 +28,55 @@ func NewRouter() *gin.Engine {
 	})
 	g.GET("/metrics", gin.WrapH(monitor.Handler()))
 
-	// Internal API (no auth required) - for Gateway to call
+	// Internal API - for Gateway to call, not behind JWT
 	internal := g.Group("/internal")
 	{
-		internal.POST("/pushback", pushback.PushbackHandler)
-		internal.POST("/push/notify_online", pushnotify.NotifyOnlineHandler)
+		// pushback and online notification fill and drain offline queues for any user,
+		// so they require the shared internal token as well
+		internal.POST("/pushback", middleware.InternalAuthMiddleware(), pushback.PushbackHandler)
+		internal.POST("/push/notify_online", middleware.InternalAuthMiddleware(), pushnotify.NotifyOnlineHandler)
+		// frames forwarded from gateway websocket clients; user_id in the body is trusted,
+		// so these require the shared internal token
+		chat := internal.Group("/chat", middleware.InternalAuthMiddleware())
+		chat.POST("/send_message", send.InternalSendHandler)
+		chat.POST("/typing", send.InternalTypingHandler)
+		chat.POST("/read", send.InternalReadHandler)
+		chat.POST("/sync", send.InternalSyncHandler)
+		// presence: device connect/disconnect reported by gateways and client presence frames,
+		// user_id in the body is trusted as well
+		presenceGroup := internal.Group("/presence", middleware.InternalAuthMiddleware())
+		presenceGroup.POST("/connect", presence.InternalConnectHandler)
+		presenceGroup.POST("/disconnect", presence.InternalDisconnectHandler)
+		presenceGroup.POST("/set", presence.InternalSetStateHandler)
+		presenceGroup.POST("/subscribe", presence.InternalSubscribeHandler)
+		presenceGroup.POST("/unsubscribe", presence.InternalUnsubscribeHandler)
 	}
 
+	// attachment download via signed url (no JWT, signature carries the user)
+	g.GET("/files/:file_id", upload.DownloadHandler)
+
 	// authenticated routes
 	auth := g.Group("/api", middleware.JWTAuthMiddleware())
 	{
 		// send route is always registered in send service; handler decides standalone/gateway
 		auth.POST("/chat/send_message", send.SendMessageHandler)
 		auth.POST("/chat/resend_message", send.ResendHandler)
+		auth.POST("/chat/send_batch", send.SendBatchHandler)
+		auth.GET("/chat/message_status", send.MessageStatusHandler)
+
+		// offline push notification settings
+		auth.POST("/notify/device", notify.RegisterDeviceHandler)
+		auth.DELETE("/notify/device", notify.UnregisterDeviceHandler)
+		auth.GET("/notify/dnd", notify.GetDNDHandler)
+		auth.POST("/notify/dnd", notify.SetDNDHandler)
+		auth.GET("/notify/outcomes", notify.OutcomesHandler)
+
+		// attachment upload (chunked, resumable) and signed download urls
+		auth.POST("/upload/init", upload.InitUploadHandler)
+		auth.PUT("/upload/chunk", upload.UploadChunkHandler)
+		auth.GET("/upload/status", upload.UploadStatusHandler)
+		auth.POST("/upload/complete", upload.CompleteUploadHandler)
+		auth.GET("/files/sign", upload.SignDownloadHandler)
 	}
 
 	return g
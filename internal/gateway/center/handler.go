@@ -8,13 +8,22 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func ForwardHandler(c *gin.Context) {
+// Handler 接收 send 服务转发的推送消息，交给 gw 分发
+type Handler struct {
+	gw *push.Gateway
+}
+
+func NewHandler(gw *push.Gateway) *Handler {
+	return &Handler{gw: gw}
+}
+
+func (h *Handler) ForwardHandler(c *gin.Context) {
 	var msg types.PushMessage
 	if err := c.ShouldBindJSON(&msg); err != nil {
 		response.ReplyBadRequest(c, "invalid request payload")
 		return
 	}
-	if err := push.Dispatch(msg); err != nil {
+	if err := h.gw.Dispatch(msg); err != nil {
 		response.ReplyError500(c, "failed to forward message")
 		return
 	}
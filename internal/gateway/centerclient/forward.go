@@ -0,0 +1,108 @@
+package centerclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"GoStacker/pkg/middleware"
+	"GoStacker/pkg/registry_client"
+
+	"go.uber.org/zap"
+)
+
+// instanceCacheTTL 控制 send 实例列表的缓存时间，避免每个客户端帧都查询 registry
+const instanceCacheTTL = 10 * time.Second
+
+// SendError 是 send 服务返回的业务错误，Code 沿用其 HTTP 响应中的 code
+type SendError struct {
+	Code int
+	Msg  string
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("send service error %d: %s", e.Code, e.Msg)
+}
+
+type sendResponse struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// pickSendInstance 通过 registry 随机选择一个 send 实例，实例列表短时间缓存
+func (c *Client) pickSendInstance(refresh bool) (registry_client.SendInstanceInfo, error) {
+	c.instancesMu.Lock()
+	defer c.instancesMu.Unlock()
+	if refresh || len(c.cachedInstances) == 0 || time.Since(c.instancesLoadedAt) > instanceCacheTTL {
+		if c.registryURL == "" {
+			return registry_client.SendInstanceInfo{}, errors.New("registry url not configured")
+		}
+		if c.registrySendCli == nil {
+			c.registrySendCli = registry_client.NewSendClient(c.registryURL, "gateway-forward-client")
+		}
+		instances, err := c.registrySendCli.ListSendInstances()
+		if err != nil {
+			if len(c.cachedInstances) == 0 {
+				return registry_client.SendInstanceInfo{}, err
+			}
+			// registry 暂时不可用时继续使用旧列表
+			zap.L().Warn("refresh send instances failed, using cached list", zap.Error(err))
+		} else {
+			c.cachedInstances = instances
+			c.instancesLoadedAt = time.Now()
+		}
+	}
+	if len(c.cachedInstances) == 0 {
+		return registry_client.SendInstanceInfo{}, errors.New("no send instances available")
+	}
+	return c.cachedInstances[rand.Intn(len(c.cachedInstances))], nil
+}
+
+// CallSend 把请求转发到一个 send 实例的内部接口，并把响应中的 data 解到 out。
+// 网络错误时换一个实例重试一次；send 返回的业务错误以 *SendError 返回，不重试。
+func (c *Client) CallSend(path string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		inst, err := c.pickSendInstance(attempt > 0)
+		if err != nil {
+			return err
+		}
+		url := fmt.Sprintf("http://%s:%d%s", inst.Address, inst.Port, path)
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.InternalTokenHeader, c.internalToken)
+		resp, err := c.forwardHTTPClient.Do(req)
+		if err != nil {
+			lastErr = err
+			zap.L().Warn("forward to send instance failed", zap.String("url", url), zap.Int("attempt", attempt), zap.Error(err))
+			continue
+		}
+		var sr sendResponse
+		err = json.NewDecoder(resp.Body).Decode(&sr)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("decode send response: %w", err)
+			continue
+		}
+		if sr.Code != 0 {
+			return &SendError{Code: sr.Code, Msg: sr.Msg}
+		}
+		if out != nil && len(sr.Data) > 0 {
+			return json.Unmarshal(sr.Data, out)
+		}
+		return nil
+	}
+	return lastErr
+}
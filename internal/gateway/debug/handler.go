@@ -0,0 +1,92 @@
+// Package debug 提供网关连接诊断接口，用于排查“收不到消息”一类问题。
+// 接口只对内部开放，需要请求头 X-Debug-Token 与 dispatcher.debug_token 一致。
+package debug
+
+import (
+	"GoStacker/internal/gateway/push"
+	"GoStacker/pkg/config"
+	"GoStacker/pkg/response"
+	"crypto/subtle"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTop = 20
+	maxTop     = 500
+)
+
+// AuthMiddleware 校验 X-Debug-Token，未配置 debug_token 时诊断接口不可用
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var token string
+		if config.Conf != nil && config.Conf.GatewayDispatcherConfig != nil {
+			token = config.Conf.GatewayDispatcherConfig.DebugToken
+		}
+		provided := c.GetHeader("X-Debug-Token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			zap.L().Warn("Rejected debug request", zap.String("path", c.FullPath()), zap.String("ip", c.ClientIP()))
+			response.ReplyUnauthorized(c, "Debug token required")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Handler 诊断接口的处理函数，查看 gw 的连接
+type Handler struct {
+	gw *push.Gateway
+}
+
+func NewHandler(gw *push.Gateway) *Handler {
+	return &Handler{gw: gw}
+}
+
+// ConnectionsHandler 网关连接概况，附带最慢的 top 个连接
+func (h *Handler) ConnectionsHandler(c *gin.Context) {
+	top := defaultTop
+	if v := c.Query("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			response.ReplyBadRequest(c, "Invalid top")
+			return
+		}
+		top = min(n, maxTop)
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{
+		"connections":   h.gw.GetConnectionCount(),
+		"pending_tasks": h.gw.PendingTasks(),
+		"draining":      h.gw.IsDraining(),
+		"slowest":       h.gw.SlowestConnections(top),
+	})
+}
+
+// UserConnectionsHandler 用户在本网关各设备连接的详细信息
+func (h *Handler) UserConnectionsHandler(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		response.ReplyBadRequest(c, "Invalid user_id")
+		return
+	}
+	conns := h.gw.UserConnStats(userID)
+	if len(conns) == 0 {
+		response.ReplyNotFound(c, "User not connected to this gateway")
+		return
+	}
+	data := gin.H{"user_id": userID, "devices": conns}
+	// 等待队列按设备区分，这里给出该用户所有已连接设备的合计
+	var waiting int64
+	for _, conn := range conns {
+		n, err := push.WaitQueueLength(userID, conn.DeviceID)
+		if err != nil {
+			zap.L().Warn("Failed to read wait queue length", zap.Int64("user_id", userID), zap.String("device_id", conn.DeviceID), zap.Error(err))
+			continue
+		}
+		waiting += n
+	}
+	data["wait_queue"] = waiting
+	response.ReplySuccessWithData(c, "success", data)
+}
@@ -0,0 +1,344 @@
+package push
+
+import (
+	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/config"
+	Redis "GoStacker/pkg/db/redis"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxPushBackBackoff 后台重试退回离线队列的最大间隔
+const maxPushBackBackoff = 30 * time.Second
+
+// ackTracker 端到端确认：stream 条目在每个目标设备都用 ack 帧确认（或转入离线队列）后才 XACK。
+// 写出后 ackTimeout 内未确认的帧重发，超过 maxRedeliveries 次或连接断开时退回离线队列
+type ackTracker struct {
+	timeout         time.Duration
+	maxRedeliveries int
+
+	mu sync.Mutex
+	// device connection -> outstanding deliveries of that device
+	deliveries map[connKey]map[deliveryKey]*delivery
+	// stream entry ID -> number of targets not yet settled
+	entryRemaining map[string]int
+	// cache batches the XACKs of settled entries, created when stream consumption starts
+	cache *Redis.AckCache
+}
+
+type deliveryKey struct {
+	msgID   int64
+	msgType string
+}
+
+type delivery struct {
+	streamID string
+	msg      types.ClientMessage
+	// sentAt is zero while the frame waits in the send channel
+	sentAt   time.Time
+	attempts int
+}
+
+// needsClientAck 只有聊天消息需要客户端确认，ACK 通知、输入中等临时事件写出即算送达
+func needsClientAck(msg types.ClientMessage) bool {
+	return msg.Type == "chat" && msg.ID > 0
+}
+
+func (a *ackTracker) init(Conf *config.GatewayDispatcherConfig) {
+	a.timeout = 10 * time.Second
+	a.maxRedeliveries = 3
+	a.deliveries = make(map[connKey]map[deliveryKey]*delivery)
+	a.entryRemaining = make(map[string]int)
+	if Conf.AckTimeout > 0 {
+		a.timeout = time.Duration(Conf.AckTimeout) * time.Second
+	}
+	if Conf.MaxRedeliveries > 0 {
+		a.maxRedeliveries = Conf.MaxRedeliveries
+	}
+}
+
+// beginEntry 记录一个 stream 条目需要等待的目标数
+func (gw *Gateway) beginEntry(streamID string, targets int) {
+	if streamID == "" {
+		return
+	}
+	if targets <= 0 {
+		gw.xack(streamID)
+		return
+	}
+	gw.ack.mu.Lock()
+	gw.ack.entryRemaining[streamID] += targets
+	gw.ack.mu.Unlock()
+}
+
+// settleTarget 一个目标已确认或已转入离线队列，全部目标结束后 XACK 该条目
+func (gw *Gateway) settleTarget(streamID string) {
+	if streamID == "" {
+		return
+	}
+	gw.ack.mu.Lock()
+	n, ok := gw.ack.entryRemaining[streamID]
+	if !ok {
+		gw.ack.mu.Unlock()
+		return
+	}
+	n--
+	if n > 0 {
+		gw.ack.entryRemaining[streamID] = n
+		gw.ack.mu.Unlock()
+		return
+	}
+	delete(gw.ack.entryRemaining, streamID)
+	gw.ack.mu.Unlock()
+	gw.xack(streamID)
+}
+
+// deliver 登记投递并推入设备的发送队列。登记先于入队，避免 writerLoop 先写出而漏记写出时间；
+// 入队失败时撤销登记，只有确实进入发送队列的消息才参与重发与断开时的离线转移
+func (gw *Gateway) deliver(holder *ConnectionHolder, timeout time.Duration, msg types.ClientMessage, streamID string) error {
+	if needsClientAck(msg) && gw.alreadyDelivered(holder.key(), msg, streamID) {
+		return nil
+	}
+	gw.trackDelivery(holder.key(), msg, streamID)
+	if err := gw.enqueueHolder(holder, timeout, msg); err != nil {
+		gw.takeDelivery(holder.key(), deliveryKey{msgID: msg.ID, msgType: msg.Type})
+		return err
+	}
+	return nil
+}
+
+// alreadyDelivered 设备已有同一消息在等待确认或刚确认过（如会话恢复时补发的离线消息）时不再入队。
+// 新的 stream 条目并入没有条目的已有投递，随其确认结束；否则直接结束
+func (gw *Gateway) alreadyDelivered(conn connKey, msg types.ClientMessage, streamID string) bool {
+	key := deliveryKey{msgID: msg.ID, msgType: msg.Type}
+	gw.ack.mu.Lock()
+	d, tracked := gw.ack.deliveries[conn][key]
+	adopted := tracked && d.streamID == ""
+	if adopted {
+		d.streamID = streamID
+	}
+	gw.ack.mu.Unlock()
+	if !tracked && !gw.recentlyAcked(conn, msg.ID) {
+		return false
+	}
+	if !adopted {
+		gw.settleTarget(streamID)
+	}
+	return true
+}
+
+// handedOff 该条目是否已由其他连接的会话恢复补发给了用户
+func (gw *Gateway) handedOff(streamID string, userID int64) bool {
+	_, ok := gw.handedOffUsers(streamID)[userID]
+	return ok
+}
+
+// trackDelivery 在消息入队前登记，写出后开始计时等待客户端确认
+func (gw *Gateway) trackDelivery(conn connKey, msg types.ClientMessage, streamID string) {
+	key := deliveryKey{msgID: msg.ID, msgType: msg.Type}
+	gw.ack.mu.Lock()
+	m, ok := gw.ack.deliveries[conn]
+	if !ok {
+		m = make(map[deliveryKey]*delivery)
+		gw.ack.deliveries[conn] = m
+	}
+	old := m[key]
+	m[key] = &delivery{streamID: streamID, msg: msg}
+	gw.ack.mu.Unlock()
+	if old != nil {
+		// 同一消息再次投递（如重发），旧条目由新的投递取代
+		gw.settleTarget(old.streamID)
+	}
+}
+
+func (gw *Gateway) takeDelivery(conn connKey, key deliveryKey) *delivery {
+	gw.ack.mu.Lock()
+	defer gw.ack.mu.Unlock()
+	m, ok := gw.ack.deliveries[conn]
+	if !ok {
+		return nil
+	}
+	d, ok := m[key]
+	if !ok {
+		return nil
+	}
+	delete(m, key)
+	if len(m) == 0 {
+		delete(gw.ack.deliveries, conn)
+	}
+	return d
+}
+
+// markWritten 由 writerLoop 在消息成功写入 socket 后调用
+func (gw *Gateway) markWritten(conn connKey, msg types.ClientMessage) {
+	key := deliveryKey{msgID: msg.ID, msgType: msg.Type}
+	if !needsClientAck(msg) {
+		if d := gw.takeDelivery(conn, key); d != nil {
+			gw.settleTarget(d.streamID)
+		}
+		return
+	}
+	gw.ack.mu.Lock()
+	if d, ok := gw.ack.deliveries[conn][key]; ok {
+		d.sentAt = time.Now()
+		d.attempts++
+	}
+	gw.ack.mu.Unlock()
+}
+
+// AckDelivered 处理客户端某个设备的 ack 帧，返回实际确认的消息数
+func (gw *Gateway) AckDelivered(userID int64, deviceID string, msgIDs []int64) int {
+	conn := connKey{userID: userID, deviceID: deviceID}
+	n := 0
+	for _, id := range msgIDs {
+		if d := gw.takeDelivery(conn, deliveryKey{msgID: id, msgType: "chat"}); d != nil {
+			gw.settleTarget(d.streamID)
+			gw.noteAcked(conn, d.streamID, id)
+			n++
+		}
+	}
+	return n
+}
+
+// requeueUnacked 设备在本网关重新建立连接时，把已写出但未确认的消息写到新连接
+func (gw *Gateway) requeueUnacked(conn connKey) {
+	gw.ack.mu.Lock()
+	var msgs []types.ClientMessage
+	for _, d := range gw.ack.deliveries[conn] {
+		if !d.sentAt.IsZero() {
+			d.sentAt = time.Time{}
+			msgs = append(msgs, d.msg)
+		}
+	}
+	gw.ack.mu.Unlock()
+	for _, msg := range msgs {
+		if err := gw.EnqueueToDevice(conn.userID, conn.deviceID, 100*time.Millisecond, msg); err != nil {
+			gw.giveUpDelivery(conn, msg)
+		}
+	}
+}
+
+// deliveryCounts 设备尚未结束的投递：queued 仍在发送队列中，unacked 已写出、等待客户端确认
+func (gw *Gateway) deliveryCounts(conn connKey) (queued, unacked int) {
+	gw.ack.mu.Lock()
+	defer gw.ack.mu.Unlock()
+	for _, d := range gw.ack.deliveries[conn] {
+		if d.sentAt.IsZero() {
+			queued++
+		} else {
+			unacked++
+		}
+	}
+	return queued, unacked
+}
+
+// releaseDevice 设备连接断开时把该设备所有未确认的消息转入离线队列，返回已处理的消息，
+// 调用方据此跳过发送队列中重复的条目
+func (gw *Gateway) releaseDevice(conn connKey) map[deliveryKey]struct{} {
+	gw.ack.mu.Lock()
+	m := gw.ack.deliveries[conn]
+	delete(gw.ack.deliveries, conn)
+	gw.ack.mu.Unlock()
+
+	handled := make(map[deliveryKey]struct{}, len(m))
+	for key, d := range m {
+		handled[key] = struct{}{}
+		if needsClientAck(d.msg) && !gw.handedOff(d.streamID, conn.userID) {
+			gw.pushBackOffline(conn.userID, d.msg, d.streamID)
+		}
+		gw.settleTarget(d.streamID)
+	}
+	return handled
+}
+
+// giveUpDelivery 放弃在线投递，转入离线队列后结束该目标
+func (gw *Gateway) giveUpDelivery(conn connKey, msg types.ClientMessage) {
+	d := gw.takeDelivery(conn, deliveryKey{msgID: msg.ID, msgType: msg.Type})
+	if d == nil {
+		return
+	}
+	if needsClientAck(msg) {
+		gw.pushBackOffline(conn.userID, msg, d.streamID)
+	}
+	gw.settleTarget(d.streamID)
+}
+
+// pushBackOffline 把消息退回 send 服务的离线队列。失败时为 stream 条目多占一个目标并在后台重试，
+// 调用方照常结束自己的目标，条目在退回成功后才会 XACK
+func (gw *Gateway) pushBackOffline(userID int64, msg types.ClientMessage, streamID string) {
+	err := gw.pushBack(msg, userID)
+	if err == nil {
+		return
+	}
+	zap.L().Error("push back message failed, retrying in background", zap.Int64("userID", userID), zap.Int64("msgID", msg.ID), zap.Error(err))
+	gw.beginEntry(streamID, 1)
+	go gw.retryPushBack(userID, msg, streamID)
+}
+
+// retryPushBack 按指数退避重试退回离线队列，成功后结束占用的目标。
+// 网关停止时放弃，未 XACK 的条目在下次启动时从 stream 重放
+func (gw *Gateway) retryPushBack(userID int64, msg types.ClientMessage, streamID string) {
+	backoff := time.Second
+	for {
+		select {
+		case <-gw.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		err := gw.pushBack(msg, userID)
+		if err == nil {
+			gw.settleTarget(streamID)
+			return
+		}
+		zap.L().Warn("push back retry failed", zap.Int64("userID", userID), zap.Int64("msgID", msg.ID), zap.Duration("backoff", backoff), zap.Error(err))
+		if backoff < maxPushBackBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// redeliverLoop 周期性重发超时未确认的消息
+func (gw *Gateway) redeliverLoop() {
+	ticker := time.NewTicker(gw.ack.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gw.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		type expired struct {
+			conn     connKey
+			msg      types.ClientMessage
+			attempts int
+		}
+		var due []expired
+		now := time.Now()
+		gw.ack.mu.Lock()
+		for conn, m := range gw.ack.deliveries {
+			for _, d := range m {
+				if d.sentAt.IsZero() || now.Sub(d.sentAt) < gw.ack.timeout {
+					continue
+				}
+				d.sentAt = time.Time{}
+				due = append(due, expired{conn: conn, msg: d.msg, attempts: d.attempts})
+			}
+		}
+		gw.ack.mu.Unlock()
+
+		for _, e := range due {
+			if e.attempts > gw.ack.maxRedeliveries {
+				zap.L().Warn("message not acknowledged by client, moving to offline queue",
+					zap.Int64("userID", e.conn.userID), zap.String("deviceID", e.conn.deviceID),
+					zap.Int64("msgID", e.msg.ID), zap.Int("attempts", e.attempts))
+				gw.giveUpDelivery(e.conn, e.msg)
+				continue
+			}
+			if err := gw.EnqueueToDevice(e.conn.userID, e.conn.deviceID, 100*time.Millisecond, e.msg); err != nil {
+				gw.giveUpDelivery(e.conn, e.msg)
+			}
+		}
+	}
+}
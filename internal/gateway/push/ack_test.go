@@ -0,0 +1,240 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"GoStacker/internal/gateway/push/types"
+	Redis "GoStacker/pkg/db/redis"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// pushBackRecorder 记录网关退回离线队列的消息，fail 次数内返回错误
+type pushBackRecorder struct {
+	mu   sync.Mutex
+	fail int
+	got  []int64
+}
+
+func (r *pushBackRecorder) pushBack(msg types.ClientMessage, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail > 0 {
+		r.fail--
+		return errors.New("send unavailable")
+	}
+	r.got = append(r.got, msg.ID)
+	return nil
+}
+
+func (r *pushBackRecorder) pushed() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.got...)
+}
+
+func newTestGateway(t *testing.T, rec *pushBackRecorder) *Gateway {
+	t.Helper()
+	gw := New(Config{}, Deps{
+		PushBack: rec.pushBack,
+		CallSend: func(string, interface{}, interface{}) error { return nil },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	gw.ctx, gw.cancel = ctx, cancel
+	t.Cleanup(cancel)
+	return gw
+}
+
+// unreachableRedis 让依赖 Redis 的查询立即失败，测试按查不到记录的分支进行
+func unreachableRedis(t *testing.T) {
+	t.Helper()
+	prev := Redis.Rdb
+	Redis.Rdb = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 50 * time.Millisecond})
+	t.Cleanup(func() {
+		Redis.Rdb.Close()
+		Redis.Rdb = prev
+	})
+}
+
+func remaining(gw *Gateway, streamID string) (int, bool) {
+	gw.ack.mu.Lock()
+	defer gw.ack.mu.Unlock()
+	n, ok := gw.ack.entryRemaining[streamID]
+	return n, ok
+}
+
+func chatMsg(id int64) types.ClientMessage {
+	return types.ClientMessage{ID: id, Type: "chat", RoomID: 1, SenderID: 2}
+}
+
+func TestSettleTargetReleasesEntryAfterLastTarget(t *testing.T) {
+	gw := newTestGateway(t, &pushBackRecorder{})
+	gw.beginEntry("1-0", 2)
+
+	gw.settleTarget("1-0")
+	if n, ok := remaining(gw, "1-0"); !ok || n != 1 {
+		t.Fatalf("after first settle: remaining = %d, tracked = %v, want 1, true", n, ok)
+	}
+	gw.settleTarget("1-0")
+	if _, ok := remaining(gw, "1-0"); ok {
+		t.Fatal("entry still tracked after every target settled")
+	}
+	// 多余的结束不应重新登记或出现负数
+	gw.settleTarget("1-0")
+	gw.settleTarget("")
+	if _, ok := remaining(gw, "1-0"); ok {
+		t.Fatal("extra settle re-created the entry")
+	}
+}
+
+func TestBeginEntryAccumulatesTargets(t *testing.T) {
+	gw := newTestGateway(t, &pushBackRecorder{})
+	gw.beginEntry("1-0", 1)
+	gw.beginEntry("1-0", 2)
+	if n, _ := remaining(gw, "1-0"); n != 3 {
+		t.Fatalf("remaining = %d, want 3", n)
+	}
+	gw.beginEntry("2-0", 0)
+	if _, ok := remaining(gw, "2-0"); ok {
+		t.Fatal("entry without targets should be acknowledged at once")
+	}
+}
+
+func TestAckDeliveredSettlesOnce(t *testing.T) {
+	gw := newTestGateway(t, &pushBackRecorder{})
+	conn := connKey{userID: 7, deviceID: "phone"}
+	gw.beginEntry("1-0", 1)
+	gw.trackDelivery(conn, chatMsg(100), "1-0")
+	gw.markWritten(conn, chatMsg(100))
+
+	if n := gw.AckDelivered(7, "phone", []int64{100}); n != 1 {
+		t.Fatalf("AckDelivered = %d, want 1", n)
+	}
+	if _, ok := remaining(gw, "1-0"); ok {
+		t.Fatal("entry still tracked after the only target acknowledged")
+	}
+	if n := gw.AckDelivered(7, "phone", []int64{100}); n != 0 {
+		t.Fatalf("duplicate ack confirmed %d messages, want 0", n)
+	}
+}
+
+func TestAckIsPerDevice(t *testing.T) {
+	gw := newTestGateway(t, &pushBackRecorder{})
+	phone := connKey{userID: 7, deviceID: "phone"}
+	desktop := connKey{userID: 7, deviceID: "desktop"}
+	gw.beginEntry("1-0", 2)
+	gw.trackDelivery(phone, chatMsg(100), "1-0")
+	gw.trackDelivery(desktop, chatMsg(100), "1-0")
+
+	gw.AckDelivered(7, "phone", []int64{100})
+	if n, ok := remaining(gw, "1-0"); !ok || n != 1 {
+		t.Fatalf("remaining = %d, tracked = %v, want the desktop target still open", n, ok)
+	}
+	if queued, _ := gw.deliveryCounts(desktop); queued != 1 {
+		t.Fatalf("desktop queued = %d, want 1", queued)
+	}
+}
+
+func TestTrackDeliveryReplacesEarlierDelivery(t *testing.T) {
+	gw := newTestGateway(t, &pushBackRecorder{})
+	conn := connKey{userID: 7, deviceID: "phone"}
+	gw.beginEntry("1-0", 1)
+	gw.beginEntry("2-0", 1)
+	gw.trackDelivery(conn, chatMsg(100), "1-0")
+	gw.trackDelivery(conn, chatMsg(100), "2-0")
+
+	if _, ok := remaining(gw, "1-0"); ok {
+		t.Fatal("replaced delivery did not settle its entry")
+	}
+	if n, ok := remaining(gw, "2-0"); !ok || n != 1 {
+		t.Fatalf("new entry remaining = %d, tracked = %v, want 1, true", n, ok)
+	}
+}
+
+func TestMarkWrittenSettlesMessagesWithoutClientAck(t *testing.T) {
+	gw := newTestGateway(t, &pushBackRecorder{})
+	conn := connKey{userID: 7, deviceID: "phone"}
+	typing := types.ClientMessage{ID: 0, Type: "typing", RoomID: 1, SenderID: 2}
+	gw.beginEntry("1-0", 1)
+	gw.trackDelivery(conn, typing, "1-0")
+	gw.markWritten(conn, typing)
+
+	if _, ok := remaining(gw, "1-0"); ok {
+		t.Fatal("written ephemeral event did not settle its entry")
+	}
+	if queued, unacked := gw.deliveryCounts(conn); queued+unacked != 0 {
+		t.Fatalf("ephemeral event still tracked: queued = %d, unacked = %d", queued, unacked)
+	}
+}
+
+func TestGiveUpDeliveryPushesBackChat(t *testing.T) {
+	rec := &pushBackRecorder{}
+	gw := newTestGateway(t, rec)
+	conn := connKey{userID: 7, deviceID: "phone"}
+	gw.beginEntry("1-0", 1)
+	gw.trackDelivery(conn, chatMsg(100), "1-0")
+
+	gw.giveUpDelivery(conn, chatMsg(100))
+	if got := rec.pushed(); len(got) != 1 || got[0] != 100 {
+		t.Fatalf("pushed back %v, want [100]", got)
+	}
+	if _, ok := remaining(gw, "1-0"); ok {
+		t.Fatal("entry still tracked after the message went to the offline queue")
+	}
+	// 已放弃的投递不会被重复处理
+	gw.giveUpDelivery(conn, chatMsg(100))
+	if got := rec.pushed(); len(got) != 1 {
+		t.Fatalf("second give up pushed back again: %v", got)
+	}
+}
+
+func TestFailedPushBackHoldsEntryUntilRetrySucceeds(t *testing.T) {
+	rec := &pushBackRecorder{fail: 1}
+	gw := newTestGateway(t, rec)
+	conn := connKey{userID: 7, deviceID: "phone"}
+	gw.beginEntry("1-0", 1)
+	gw.trackDelivery(conn, chatMsg(100), "1-0")
+
+	gw.giveUpDelivery(conn, chatMsg(100))
+	if n, ok := remaining(gw, "1-0"); !ok || n != 1 {
+		t.Fatalf("remaining = %d, tracked = %v, want the entry held by the pending push back", n, ok)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := remaining(gw, "1-0"); !ok {
+			if got := rec.pushed(); len(got) != 1 || got[0] != 100 {
+				t.Fatalf("pushed back %v, want [100]", got)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("entry not released after the background push back succeeded")
+}
+
+func TestReleaseDeviceMovesUnackedToOfflineQueue(t *testing.T) {
+	unreachableRedis(t)
+	rec := &pushBackRecorder{}
+	gw := newTestGateway(t, rec)
+	conn := connKey{userID: 7, deviceID: "phone"}
+	typing := types.ClientMessage{Type: "typing", RoomID: 1, SenderID: 2}
+	gw.beginEntry("1-0", 2)
+	gw.trackDelivery(conn, chatMsg(100), "1-0")
+	gw.trackDelivery(conn, typing, "1-0")
+
+	handled := gw.releaseDevice(conn)
+	if len(handled) != 2 {
+		t.Fatalf("handled %d deliveries, want 2", len(handled))
+	}
+	if got := rec.pushed(); len(got) != 1 || got[0] != 100 {
+		t.Fatalf("pushed back %v, want only the chat message", got)
+	}
+	if _, ok := remaining(gw, "1-0"); ok {
+		t.Fatal("entry still tracked after the device was released")
+	}
+}
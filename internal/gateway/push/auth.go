@@ -0,0 +1,111 @@
+package push
+
+import (
+	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/revocation"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// 因 token 失效关闭连接时使用的 close code（4000-4999 为应用自定义）
+const (
+	CloseTokenExpired = 4001
+	CloseTokenRevoked = 4003
+)
+
+const (
+	authCheckInterval = 5 * time.Second
+	// authWarnBefore token 过期前多久推送 auth_expiring 提醒
+	authWarnBefore = time.Minute
+)
+
+var ErrTokenUserMismatch = errors.New("token belongs to another user")
+
+// ConnAuth 连接所用 token 的身份信息
+type ConnAuth struct {
+	JTI       string
+	IssuedAt  time.Time
+	ExpiresAt time.Time // 零值表示不过期
+}
+
+// startAuth JWT 只在升级时校验一次：token 过期的连接以 CloseTokenExpired 关闭（之前先发送 auth_expiring，
+// 客户端可在连接内刷新），其他服务发布的吊销事件以 CloseTokenRevoked 关闭对应连接
+func (gw *Gateway) startAuth() {
+	go gw.authExpiryLoop()
+	go revocation.Listen(gw.ctx, gw.handleRevocation)
+}
+
+// SetConnectionAuth 记录连接所用的 token，连接建立后立即调用
+func SetConnectionAuth(holder *ConnectionHolder, a ConnAuth) {
+	holder.auth.Store(&a)
+	holder.authWarned.Store(false)
+}
+
+// RefreshConnectionAuth 客户端在连接上换用新 token，之后按新 token 的过期时间与吊销状态处理
+func (gw *Gateway) RefreshConnectionAuth(userID int64, deviceID string, conn *websocket.Conn, a ConnAuth) error {
+	holder, ok := gw.GetConnectionHolder(userID, deviceID)
+	if !ok || holder.Conn != conn {
+		return ErrNoConn
+	}
+	SetConnectionAuth(holder, a)
+	return nil
+}
+
+func (gw *Gateway) authExpiryLoop() {
+	ticker := time.NewTicker(authCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gw.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		for _, holder := range gw.allHolders() {
+			a := holder.auth.Load()
+			if a == nil || a.ExpiresAt.IsZero() {
+				continue
+			}
+			if !now.Before(a.ExpiresAt) {
+				gw.closeHolder(holder, CloseTokenExpired, "token expired")
+				continue
+			}
+			if a.ExpiresAt.Sub(now) <= authWarnBefore && holder.authWarned.CompareAndSwap(false, true) {
+				_ = WriteJSONSafe(holder, time.Second, types.ClientMessage{
+					ID:       -1,
+					Type:     "auth_expiring",
+					RoomID:   -1,
+					SenderID: -1,
+					Payload:  map[string]int64{"expires_at": a.ExpiresAt.Unix()},
+				})
+			}
+		}
+	}
+}
+
+// handleRevocation 关闭用户被吊销 token 的连接，使用其他 token 的设备不受影响
+func (gw *Gateway) handleRevocation(e revocation.Event) {
+	gw.connMu.RLock()
+	var affected []*ConnectionHolder
+	for _, holder := range gw.connStore[e.UserID] {
+		if a := holder.auth.Load(); a != nil && e.Matches(a.JTI, a.IssuedAt) {
+			affected = append(affected, holder)
+		}
+	}
+	gw.connMu.RUnlock()
+	for _, holder := range affected {
+		zap.L().Info("closing connection with revoked token",
+			zap.Int64("userID", holder.userID), zap.String("deviceID", holder.deviceID), zap.String("reason", e.Reason))
+		gw.closeHolder(holder, CloseTokenRevoked, "token revoked: "+e.Reason)
+	}
+}
+
+// closeHolder 以指定的 close code 关闭连接：与 drainHolder 相同，先告知客户端关闭原因再移除连接，
+// 未确认的消息转入离线队列
+func (gw *Gateway) closeHolder(holder *ConnectionHolder, code int, reason string) {
+	holder.transport.Shutdown(code, reason)
+	gw.RemoveHolder(holder)
+}
@@ -0,0 +1,220 @@
+package push
+
+import (
+	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/config"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// CloseTooSlow 慢消费者被断开时使用的 close code
+const CloseTooSlow = 4008
+
+const (
+	PolicyNone       = "none"
+	PolicyCoalesce   = "coalesce"
+	PolicyDrop       = "drop"
+	PolicyDisconnect = "disconnect"
+)
+
+// backpressureSettings 慢消费者的处理策略：队列超过高水位或写出延迟超过上限的连接入队时不再等待。
+// coalesce 合并同类临时事件，drop 丢弃临时事件，disconnect 持续变慢超过 slowGrace 后断开；聊天消息从不合并或丢弃
+type backpressureSettings struct {
+	policy             string
+	queueHighWatermark float64
+	// maxWriteLatency 为 0 时不按写出延迟判断
+	maxWriteLatency time.Duration
+	slowGrace       time.Duration
+}
+
+var (
+	// errFrameDropped 慢连接上的临时事件被丢弃，调用方按已处理对待，不再进入等待队列
+	errFrameDropped = errors.New("frame dropped for slow consumer")
+	errQueueFull    = errors.New("send queue full")
+
+	slowConsumerFrames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_slow_consumer_frames_total",
+		Help: "Frames to slow consumers that were coalesced into a queued frame or dropped",
+	}, []string{"action"})
+	slowConsumerDisconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_slow_consumer_disconnects_total",
+		Help: "Connections closed because the client did not keep up with its frames",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(slowConsumerFrames, slowConsumerDisconnects)
+}
+
+// coalesceKey 同一 key 的临时事件只保留最新的一条
+type coalesceKey struct {
+	msgType  string
+	roomID   int64
+	senderID int64
+}
+
+// coalescedFrame 占用发送队列的一个位置，写出时取该 key 当前最新的事件
+type coalescedFrame struct {
+	key coalesceKey
+}
+
+func (b *backpressureSettings) init(cfg *config.GatewayBackpressureConfig) {
+	b.policy = PolicyNone
+	b.queueHighWatermark = 0.8
+	b.slowGrace = 10 * time.Second
+	if cfg != nil {
+		switch cfg.Policy {
+		case "", PolicyNone:
+		case PolicyCoalesce, PolicyDrop, PolicyDisconnect:
+			b.policy = cfg.Policy
+		default:
+			zap.L().Warn("unknown backpressure policy, slow consumers are not handled", zap.String("policy", cfg.Policy))
+		}
+		if cfg.QueueHighWatermark > 0 && cfg.QueueHighWatermark <= 1 {
+			b.queueHighWatermark = cfg.QueueHighWatermark
+		}
+		if cfg.MaxWriteLatency > 0 {
+			b.maxWriteLatency = time.Duration(cfg.MaxWriteLatency) * time.Millisecond
+		}
+		if cfg.SlowGrace > 0 {
+			b.slowGrace = time.Duration(cfg.SlowGrace) * time.Second
+		}
+	}
+	zap.L().Info("backpressure initialized",
+		zap.String("policy", b.policy),
+		zap.Float64("queue_high_watermark", b.queueHighWatermark),
+		zap.Duration("max_write_latency", b.maxWriteLatency),
+		zap.Duration("slow_grace", b.slowGrace))
+}
+
+// lowPriority 可合并、可丢弃的临时事件，后到的事件包含了先到事件的全部信息
+func lowPriority(msg types.ClientMessage) bool {
+	switch msg.Type {
+	case "typing", "read", "presence":
+		return true
+	}
+	return false
+}
+
+// observeWrite 以 1/8 的权重更新写出延迟的滑动平均，只由 writerLoop 调用
+func (ch *ConnectionHolder) observeWrite(d time.Duration) {
+	avg := ch.writeLatency.Load()
+	if avg == 0 {
+		ch.writeLatency.Store(int64(d))
+		return
+	}
+	ch.writeLatency.Store(avg + (int64(d)-avg)/8)
+}
+
+// queueDepth 发送队列中等待写出的帧数
+func (ch *ConnectionHolder) queueDepth() int {
+	return len(ch.sendCh)
+}
+
+// avgWriteLatency 写出延迟的滑动平均
+func (ch *ConnectionHolder) avgWriteLatency() time.Duration {
+	return time.Duration(ch.writeLatency.Load())
+}
+
+// lagging 按队列水位与写出延迟判断连接是否跟不上
+func (ch *ConnectionHolder) lagging() bool {
+	b := &ch.gw.backpressure
+	if float64(ch.queueDepth()) >= b.queueHighWatermark*float64(cap(ch.sendCh)) {
+		return true
+	}
+	return b.maxWriteLatency > 0 && ch.avgWriteLatency() >= b.maxWriteLatency
+}
+
+// isSlow 同 lagging，并记录开始变慢的时间
+func (ch *ConnectionHolder) isSlow() bool {
+	if !ch.lagging() {
+		ch.slowSince.Store(0)
+		return false
+	}
+	ch.slowSince.CompareAndSwap(0, time.Now().UnixNano())
+	return true
+}
+
+// enqueueSlow 处理慢连接的入队，不等待队列腾出空间
+func (gw *Gateway) enqueueSlow(holder *ConnectionHolder, message interface{}) error {
+	msg, isClientMsg := message.(types.ClientMessage)
+	if isClientMsg && lowPriority(msg) {
+		switch gw.backpressure.policy {
+		case PolicyDrop:
+			slowConsumerFrames.WithLabelValues("dropped").Inc()
+			return errFrameDropped
+		case PolicyCoalesce:
+			return gw.enqueueCoalesced(holder, msg)
+		}
+	}
+	if gw.backpressure.policy == PolicyDisconnect {
+		since := time.Unix(0, holder.slowSince.Load())
+		if len(holder.sendCh) == cap(holder.sendCh) || time.Since(since) >= gw.backpressure.slowGrace {
+			gw.disconnectSlow(holder)
+			return ErrNoConn
+		}
+	}
+	select {
+	case holder.sendCh <- sendRequest{msg: message}:
+		gw.incPending(1)
+		return nil
+	case <-holder.closeCh:
+		return ErrNoConn
+	default:
+		return errQueueFull
+	}
+}
+
+// enqueueCoalesced 队列中已有同 key 的事件时替换为新事件，被替换的事件视为已送达
+func (gw *Gateway) enqueueCoalesced(holder *ConnectionHolder, msg types.ClientMessage) error {
+	key := coalesceKey{msgType: msg.Type, roomID: msg.RoomID, senderID: msg.SenderID}
+	holder.coalesceMu.Lock()
+	if old, ok := holder.coalesced[key]; ok {
+		holder.coalesced[key] = msg
+		holder.coalesceMu.Unlock()
+		gw.giveUpDelivery(holder.key(), old)
+		slowConsumerFrames.WithLabelValues("coalesced").Inc()
+		return nil
+	}
+	var err error
+	select {
+	case holder.sendCh <- sendRequest{msg: coalescedFrame{key: key}}:
+		gw.incPending(1)
+		if holder.coalesced == nil {
+			holder.coalesced = make(map[coalesceKey]types.ClientMessage)
+		}
+		holder.coalesced[key] = msg
+	default:
+		// 队列已满且没有可合并的事件
+		slowConsumerFrames.WithLabelValues("dropped").Inc()
+		err = errFrameDropped
+	}
+	holder.coalesceMu.Unlock()
+	return err
+}
+
+// takeCoalesced 取出 key 当前最新的事件
+func (ch *ConnectionHolder) takeCoalesced(key coalesceKey) (types.ClientMessage, bool) {
+	ch.coalesceMu.Lock()
+	defer ch.coalesceMu.Unlock()
+	msg, ok := ch.coalesced[key]
+	delete(ch.coalesced, key)
+	return msg, ok
+}
+
+// disconnectSlow 断开慢连接，只执行一次；关闭在后台进行，不阻塞分发
+func (gw *Gateway) disconnectSlow(holder *ConnectionHolder) {
+	if !holder.tooSlow.CompareAndSwap(false, true) {
+		return
+	}
+	slowConsumerDisconnects.Inc()
+	zap.L().Warn("disconnecting slow consumer",
+		zap.Int64("userID", holder.userID),
+		zap.String("deviceID", holder.deviceID),
+		zap.Int("queue_depth", holder.queueDepth()),
+		zap.Duration("write_latency", holder.avgWriteLatency()))
+	go gw.closeHolder(holder, CloseTooSlow, "too slow")
+}
@@ -13,52 +13,61 @@ import (
 	"go.uber.org/zap"
 )
 
-var waitSet sync.Map
+type waitQueue struct {
+	// device connections (connKey) with messages waiting in their redis wait queue
+	set sync.Map
+	// use when redis is down <connKey,chan<string>>
+	msg sync.Map
+}
 
-// use when redis is down <int,chan<string>>
-var waitMsg sync.Map
+// waitQueueKey 等待队列按设备区分，重试时只推给入队失败的那个设备
+func waitQueueKey(conn connKey) string {
+	return "wait:push:" + strconv.FormatInt(conn.userID, 10) + ":" + conn.deviceID
+}
 
-func InsertWaitSet(userID int64) {
-	_, ok := waitSet.Load(userID)
+func (gw *Gateway) InsertWaitSet(conn connKey) {
+	_, ok := gw.wait.set.Load(conn)
 	if ok {
 		return
 	}
-	waitSet.Store(userID, struct{}{})
-}     
+	gw.wait.set.Store(conn, struct{}{})
+}
 
-func RemoveWaitSet(userID int64) {
-	_, ok := waitSet.Load(userID)
+func (gw *Gateway) RemoveWaitSet(conn connKey) {
+	_, ok := gw.wait.set.Load(conn)
 	if !ok {
 		return
 	}
-	waitSet.Delete(userID)
+	gw.wait.set.Delete(conn)
 }
 
-func InsertWaitMsg(userID int64, msg string) {
-	chInterface, ok := waitMsg.Load(userID)
+func (gw *Gateway) InsertWaitMsg(conn connKey, msg string) {
+	chInterface, ok := gw.wait.msg.Load(conn)
 	if !ok {
 		ch := make(chan string, 100)
-		waitMsg.Store(userID, ch)
+		gw.wait.msg.Store(conn, ch)
 		chInterface = ch
 	}
 	ch := chInterface.(chan string)
 	ch <- msg
 }
 
-func InsertWaitQueue(userID int64, marshaledMsg string) {
-	InsertWaitSet(userID)
-	err := redis.RPushWithRetry(2, "wait:push:"+strconv.FormatInt(userID, 10), marshaledMsg)
+// InsertWaitQueue 设备发送队列已满时把消息放入该设备的等待队列，由 ListeningWaitQueue 重试
+func (gw *Gateway) InsertWaitQueue(userID int64, deviceID string, marshaledMsg string) {
+	conn := connKey{userID: userID, deviceID: deviceID}
+	gw.InsertWaitSet(conn)
+	err := redis.RPushWithRetry(2, waitQueueKey(conn), marshaledMsg)
 	if err != nil {
-		zap.L().Error("Failed to RPush wait push message,try store local", zap.Int64("userID", userID), zap.Error(err))
-		InsertWaitMsg(userID, marshaledMsg)
+		zap.L().Error("Failed to RPush wait push message,try store local", zap.Int64("userID", userID), zap.String("deviceID", deviceID), zap.Error(err))
+		gw.InsertWaitMsg(conn, marshaledMsg)
 	}
 }
 
-func ListeningWaitQueue() {
+func (gw *Gateway) ListeningWaitQueue() {
 	for {
 		// exit promptly if dispatcher is shutting down
 		select {
-		case <-dispatcherCtx.Done():
+		case <-gw.ctx.Done():
 			zap.L().Info("ListeningWaitQueue exiting due to dispatcher shutdown")
 			return
 		default:
@@ -66,39 +75,28 @@ func ListeningWaitQueue() {
 
 		// Iterate local waitSet instead of scanning Redis set.
 		anyMember := false
-		waitSet.Range(func(k, _ interface{}) bool {
+		gw.wait.set.Range(func(k, _ interface{}) bool {
 			// check shutdown signal inside the inner loop as well
 			select {
-			case <-dispatcherCtx.Done():
+			case <-gw.ctx.Done():
 				zap.L().Info("ListeningWaitQueue exiting due to dispatcher shutdown")
 				return false
 			default:
 			}
 
-			var uid int64
-			switch v := k.(type) {
-			case int64:
-				uid = v
-			case int:
-				uid = int64(v)
-			case string:
-				parsed, err := strconv.ParseInt(v, 10, 64)
-				if err != nil {
-					return true
-				}
-				uid = parsed
-			default:
+			conn, ok := k.(connKey)
+			if !ok {
 				return true
 			}
 
 			anyMember = true
-			uidStr := strconv.FormatInt(uid, 10)
+			key := waitQueueKey(conn)
 
 			// pop message from wait queue
-			msgStr, err := redis.LPopWithRetry(2, "wait:push:"+uidStr)
+			msgStr, err := redis.LPopWithRetry(2, key)
 			if err != nil {
 				if err != Redis.Nil {
-					zap.L().Error("Failed to LPop wait push message", zap.Int64("userID", uid), zap.Error(err))
+					zap.L().Error("Failed to LPop wait push message", zap.Int64("userID", conn.userID), zap.String("deviceID", conn.deviceID), zap.Error(err))
 				}
 				return true
 			}
@@ -107,27 +105,38 @@ func ListeningWaitQueue() {
 				// malformed message, skip
 				return true
 			}
-			// try enqueue directly to the user's send channel
-			if err := EnqueueMessage(uid, 100*time.Millisecond, clientMsg); err != nil {
+			// retry only the device whose send queue was full; once queued it is tracked like
+			// any other delivery and goes to the offline queue if the device disconnects
+			err = ErrNoConn
+			if holder, ok := gw.GetConnectionHolder(conn.userID, conn.deviceID); ok {
+				err = gw.deliver(holder, 100*time.Millisecond, clientMsg, "")
+			}
+			switch err {
+			case nil, errFrameDropped:
+			case ErrNoConn:
+				if needsClientAck(clientMsg) {
+					gw.pushBackOffline(conn.userID, clientMsg, "")
+				}
+			default:
 				// push back to wait queue
-				err2 := redis.RPushWithRetry(2, "wait:push:"+uidStr, msgStr)
+				err2 := redis.RPushWithRetry(2, key, msgStr)
 				if err2 != nil {
-					zap.L().Error("Failed to RPush wait push message back. Message missed", zap.Int64("userID", uid), zap.Error(err2))
+					zap.L().Error("Failed to RPush wait push message back. Message missed", zap.Int64("userID", conn.userID), zap.String("deviceID", conn.deviceID), zap.Error(err2))
 				}
 				return false // stop iteration when queue is busy
 			}
 			// if wait queue is empty remove from local waitSet
 			ctx := context.Background()
-			lenOfWaitQueue, err := redis.Rdb.LLen(ctx, "wait:push:"+uidStr).Result()
+			lenOfWaitQueue, err := redis.Rdb.LLen(ctx, key).Result()
 			if err != nil {
 				if err != Redis.Nil {
-					zap.L().Error("Failed to get length of wait push queue", zap.Int64("userID", uid), zap.Error(err))
+					zap.L().Error("Failed to get length of wait push queue", zap.Int64("userID", conn.userID), zap.String("deviceID", conn.deviceID), zap.Error(err))
 					return true
 				}
 				return true
 			}
 			if lenOfWaitQueue == 0 {
-				RemoveWaitSet(uid)
+				gw.RemoveWaitSet(conn)
 			}
 			return true
 		})
@@ -6,29 +6,43 @@ import (
 	"time"
 )
 
-func PushViaWS(userID int64, writeWait time.Duration, message types.ClientMessage) (err error) {
+func (gw *Gateway) PushViaWS(userID int64, writeWait time.Duration, message types.ClientMessage) (err error) {
 	t := monitor.NewTask()
 	defer func() {
-		if pushWSMonitor != nil {
-			pushWSMonitor.CompleteTask(t, err == nil)
+		if gw.pushWSMonitor != nil {
+			gw.pushWSMonitor.CompleteTask(t, err == nil)
 		}
 	}()
 
-	holder, ok := GetConnectionHolder(userID)
-	if !ok {
+	holders := gw.userHolders(userID)
+	if len(holders) == 0 {
 		return ErrNoConn
 	}
-	err = WriteJSONSafe(holder, writeWait, message)
-	if err != nil && err != ErrNoConn {
-		RemoveConnection(userID)
+	// write to every device of the user, succeeding when at least one write went through
+	var lastErr error
+	sent := false
+	for _, holder := range holders {
+		werr := WriteJSONSafe(holder, writeWait, message)
+		if werr == nil {
+			sent = true
+			continue
+		}
+		lastErr = werr
+		if werr != ErrNoConn {
+			gw.RemoveHolder(holder)
+		}
+	}
+	if sent {
+		return nil
 	}
+	err = lastErr
 	return err
 }
 
-func PushViaWSWithRetry(userID int64, times int, writeWait time.Duration, message types.ClientMessage) error {
+func (gw *Gateway) PushViaWSWithRetry(userID int64, times int, writeWait time.Duration, message types.ClientMessage) error {
 	var err error
 	//initial try
-	err = PushViaWS(userID, writeWait, message)
+	err = gw.PushViaWS(userID, writeWait, message)
 	if err == nil {
 		return nil
 	}
@@ -37,7 +51,7 @@ func PushViaWSWithRetry(userID int64, times int, writeWait time.Duration, messag
 	}
 	//retry
 	for i := 1; i < times; i++ {
-		err = PushViaWS(userID, writeWait, message)
+		err = gw.PushViaWS(userID, writeWait, message)
 		if err == nil {
 			return nil
 		}
@@ -0,0 +1,152 @@
+package push
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// compressionSettings permessage-deflate 设置：只有较大的批量帧（离线 batch、sync 响应）压缩写出，
+// 底层连接被包装以统计压缩节省的字节数
+type compressionSettings struct {
+	enabled bool
+	level   int
+	// minSize 编码后小于该字节数的帧不压缩
+	minSize int
+}
+
+var (
+	compressedFrames = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_ws_compressed_frames_total",
+		Help: "WebSocket frames written with permessage-deflate",
+	})
+	compressionBytesIn = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_ws_compression_input_bytes_total",
+		Help: "Uncompressed size of frames written with permessage-deflate",
+	})
+	compressionBytesSaved = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_ws_compression_saved_bytes_total",
+		Help: "Bytes saved on the wire by permessage-deflate",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(compressedFrames, compressionBytesIn, compressionBytesSaved)
+}
+
+// CompressibleFrame 由其他包的帧类型实现，声明自己是否属于值得压缩的批量帧
+type CompressibleFrame interface {
+	Compressible() bool
+}
+
+func (c *compressionSettings) init(cfg *config.GatewayCompressionConfig) {
+	c.level = flate.BestSpeed
+	c.minSize = 1024
+	if cfg == nil || !cfg.Enabled {
+		zap.L().Info("WebSocket compression disabled")
+		return
+	}
+	if cfg.Level != 0 {
+		if cfg.Level < flate.HuffmanOnly || cfg.Level > flate.BestCompression {
+			zap.L().Warn("invalid compression level, using default", zap.Int("level", cfg.Level))
+		} else {
+			c.level = cfg.Level
+		}
+	}
+	if cfg.MinSize > 0 {
+		c.minSize = cfg.MinSize
+	}
+	c.enabled = true
+	zap.L().Info("WebSocket compression enabled", zap.Int("level", c.level), zap.Int("min_size", c.minSize))
+}
+
+// apply 在协商了压缩的连接上使用网关的压缩级别与阈值
+func (c *compressionSettings) apply(t *wsTransport) {
+	if !t.compress {
+		return
+	}
+	t.minSize = c.minSize
+	t.conn.SetCompressionLevel(c.level)
+}
+
+// CompressionEnabled 供 upgrader 决定是否协商 permessage-deflate
+func (gw *Gateway) CompressionEnabled() bool {
+	return gw.compression.enabled
+}
+
+// ClientOffersDeflate 客户端握手是否请求了 permessage-deflate
+func ClientOffersDeflate(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBatchFrame 只有批量帧值得压缩
+func isBatchFrame(message interface{}) bool {
+	switch m := message.(type) {
+	case types.ClientMessage:
+		return m.Type == "batch"
+	case CompressibleFrame:
+		return m.Compressible()
+	}
+	return false
+}
+
+// countingConn 统计写到底层连接的字节数
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn}, brw, nil
+}
+
+// CountingResponseWriter 包装 ResponseWriter，使升级后的连接统计写出字节；
+// 只在协商压缩的连接上使用，RegisterConnection 据此开启该连接的压缩写
+func CountingResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	return countingResponseWriter{ResponseWriter: w}
+}
+
+// recordCompressed 记录一帧压缩写的效果，wireBytes 为写出前后底层连接的字节差（含帧头）
+func recordCompressed(size int, wireBytes int64) {
+	compressedFrames.Inc()
+	compressionBytesIn.Add(float64(size))
+	if saved := int64(size) - wireBytes; saved > 0 {
+		compressionBytesSaved.Add(float64(saved))
+	}
+}
@@ -0,0 +1,70 @@
+package push
+
+import (
+	Redis "GoStacker/pkg/db/redis"
+	"GoStacker/pkg/registry_client"
+	"encoding/json"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// CloseKicked 管理员强制断开连接时使用的 close code
+const CloseKicked = 4009
+
+// startControlListener 订阅本网关的控制频道：kick 命令以 CloseKicked 关闭用户（或某个设备）的连接，
+// 重连封禁已由 registry 写入，升级时经 KickBlocked 检查
+func (gw *Gateway) startControlListener() {
+	go Redis.ListenChannel(gw.ctx, registry_client.ControlChannel(gw.registry.GatewayID()), gw.handleControl)
+}
+
+func (gw *Gateway) handleControl(payload string) {
+	var cmd registry_client.ControlCommand
+	if err := json.Unmarshal([]byte(payload), &cmd); err != nil {
+		zap.L().Warn("invalid control command", zap.String("payload", payload), zap.Error(err))
+		return
+	}
+	switch cmd.Command {
+	case registry_client.ControlKick:
+		gw.kickUser(cmd)
+	default:
+		zap.L().Warn("unknown control command", zap.String("cmd", cmd.Command))
+	}
+}
+
+func (gw *Gateway) kickUser(cmd registry_client.ControlCommand) {
+	closed := 0
+	for _, holder := range gw.userHolders(cmd.UserID) {
+		if cmd.DeviceID != "" && holder.deviceID != cmd.DeviceID {
+			continue
+		}
+		gw.closeHolder(holder, CloseKicked, "kicked: "+cmd.Reason)
+		closed++
+	}
+	zap.L().Info("user kicked by control command",
+		zap.Int64("userID", cmd.UserID),
+		zap.String("deviceID", cmd.DeviceID),
+		zap.String("reason", cmd.Reason),
+		zap.Int("closed", closed))
+}
+
+// KickBlocked 检查用户（或该设备）是否在踢下线后的禁止重连期内，返回剩余时间与原因。
+// Redis 不可用时不阻止连接
+func KickBlocked(userID int64, deviceID string) (time.Duration, string) {
+	for _, key := range []string{registry_client.KickBlockKey(userID, ""), registry_client.KickBlockKey(userID, deviceID)} {
+		reason, err := Redis.GetWithRetry(1, key)
+		if err != nil {
+			if err != goredis.Nil {
+				zap.L().Warn("kick block check failed", zap.Int64("userID", userID), zap.Error(err))
+			}
+			continue
+		}
+		ttl, err := Redis.TTLWithRetry(1, key)
+		if err != nil || ttl <= 0 {
+			ttl = time.Second
+		}
+		return ttl, reason
+	}
+	return 0, ""
+}
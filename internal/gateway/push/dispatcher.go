@@ -1,27 +1,14 @@
 package push
 
 import (
-	"GoStacker/internal/gateway/centerclient"
 	"GoStacker/internal/gateway/push/types"
-	"GoStacker/pkg/config"
-	"GoStacker/pkg/monitor"
-	"GoStacker/pkg/pendingTask"
-	"context"
 	"encoding/json"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-var dispatcherCtx context.Context
-var dispatcherCancel context.CancelFunc
-var MaxConnections int = 100000 // default max connections
-var pushWSMonitor *monitor.Monitor
-
-func Dispatch(msg types.PushMessage) error {
+func (gw *Gateway) Dispatch(msg types.PushMessage) error {
 
 	clientMsg := types.ClientMessage{
 		ID:       msg.ID,
@@ -30,60 +17,46 @@ func Dispatch(msg types.PushMessage) error {
 		SenderID: msg.SenderID,
 		Payload:  msg.Payload,
 	}
-	pendingTask.DefaultPendingManager.Init(msg.ID, int32(len(msg.TargetIDs)))
 	zap.L().Debug("Dispatching push message", zap.Any("message", clientMsg))
 	marshaledMsg, err := json.Marshal(clientMsg)
 	if err != nil {
 		zap.L().Error("Failed to marshal client message", zap.Error(err), zap.Any("message", clientMsg))
 		return err
 	}
+	// the stream entry is acknowledged once every device of every target confirmed or the
+	// message was handed to the offline queue; hold it open until all devices are registered
+	gw.beginEntry(msg.StreamID, 1)
+	defer gw.settleTarget(msg.StreamID)
 	for _, uid := range msg.TargetIDs {
 		zap.L().Debug("Dispatching to user", zap.Int64("userID", uid))
-		// try to enqueue directly to the user's send channel; small timeout to avoid blocking
-		if err := EnqueueMessage(uid, 100*time.Millisecond, clientMsg); err != nil {
-			if err == ErrNoConn {
-				zap.L().Error("User not connected,try to push back msg", zap.Int64("userID", uid), zap.Error(err))
-				// send push back request to center server
-				forwardReq := types.ClientMessage{
-					ID:       msg.ID,
-					Type:     msg.Type,
-					RoomID:   msg.RoomID,
-					SenderID: msg.SenderID,
-					Payload:  msg.Payload,
-				}
-				err2 := centerclient.SendPushBackRequest(config.Conf.CenterConfig, forwardReq, uid)
-				if err2 != nil {
-					zap.L().Error("SendPushBackRequest failed", zap.Int64("userID", uid), zap.Error(err2))
-					continue
-				}
-				pendingTask.DefaultPendingManager.Done(msg.ID)
+		holders := gw.userHolders(uid)
+		if len(holders) == 0 {
+			zap.L().Error("User not connected,try to push back msg", zap.Int64("userID", uid))
+			// send push back request to center server, retried in the background on failure
+			gw.pushBackOffline(uid, clientMsg, msg.StreamID)
+			continue
+		}
+		for _, holder := range holders {
+			gw.beginEntry(msg.StreamID, 1)
+			// try to enqueue directly to the device's send channel; small timeout to avoid blocking
+			err := gw.deliver(holder, 100*time.Millisecond, clientMsg, msg.StreamID)
+			switch err {
+			case nil:
+				// settled once the device acknowledges it
 				continue
+			case ErrNoConn:
+				// the device went away in the meantime
+				if needsClientAck(clientMsg) {
+					gw.pushBackOffline(uid, clientMsg, msg.StreamID)
+				}
+			case errFrameDropped:
+				// the event was dropped for a slow consumer
+			default:
+				// the send queue is full, the wait queue takes the message over and retries it
+				gw.InsertWaitQueue(uid, holder.deviceID, string(marshaledMsg))
 			}
-			InsertWaitQueue(uid, string(marshaledMsg))
+			gw.settleTarget(msg.StreamID)
 		}
 	}
 	return nil
 }
-
-func waitForDispatchShutdown() {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	zap.L().Info("Push dispatcher shutdown signal received, canceling dispatcher context")
-	if dispatcherCancel != nil {
-		dispatcherCancel()
-	}
-	// ListeningWaitQueue and other goroutines should observe dispatcherCtx.Done() and exit.
-	zap.L().Info("Push dispatcher shutdown initiated; background listeners will exit")
-}
-
-func InitDispatcher(Conf *config.GatewayDispatcherConfig) {
-	dispatcherCtx, dispatcherCancel = context.WithCancel(context.Background())
-	MaxConnections = Conf.MaxConnections
-	// create push monitor for websocket push path
-	pushWSMonitor = monitor.NewMonitor("push_ws", 1000, 10000, 60000)
-	pushWSMonitor.Run()
-
-	go waitForDispatchShutdown()
-	go ListeningWaitQueue()
-}
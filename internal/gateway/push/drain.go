@@ -0,0 +1,217 @@
+package push
+
+import (
+	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/config"
+	Redis "GoStacker/pkg/db/redis"
+	"GoStacker/pkg/registry_client"
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// drainState 滚动发布时的排空：在 registry 标记为 draining，用 reconnect 帧通知客户端并分批关闭连接，
+// stream 中的条目全部读完并确认后才退出
+type drainState struct {
+	draining atomic.Bool
+
+	waveSize     int
+	waveInterval time.Duration
+	timeout      time.Duration
+}
+
+func (d *drainState) init(Conf *config.GatewayDispatcherConfig) {
+	d.waveSize = 200
+	d.waveInterval = time.Second
+	d.timeout = 60 * time.Second
+	if Conf.DrainWaveSize > 0 {
+		d.waveSize = Conf.DrainWaveSize
+	}
+	if Conf.DrainWaveInterval > 0 {
+		d.waveInterval = time.Duration(Conf.DrainWaveInterval) * time.Second
+	}
+	if Conf.DrainTimeout > 0 {
+		d.timeout = time.Duration(Conf.DrainTimeout) * time.Second
+	}
+}
+
+// IsDraining 网关进入排空模式后拒绝新的 WebSocket 连接
+func (gw *Gateway) IsDraining() bool {
+	return gw.drain.draining.Load()
+}
+
+// Drain 排空本网关：标记 draining、分批通知客户端重连并关闭连接，直到 stream 中的条目全部处理完。
+// 整个过程最长 drain_timeout，之后 Stop 再停止 stream 消费和其余后台任务。
+func (gw *Gateway) Drain(ctx context.Context) {
+	if !gw.drain.draining.CompareAndSwap(false, true) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, gw.drain.timeout)
+	defer cancel()
+	zap.L().Info("gateway draining started", zap.Int("connections", gw.GetConnectionCount()))
+
+	if gw.registry != nil {
+		if err := gw.registry.MarkDraining(); err != nil {
+			zap.L().Warn("Failed to mark gateway as draining in registry", zap.Error(err))
+		}
+	}
+
+	frame := types.ClientMessage{
+		ID:       -1,
+		Type:     "reconnect",
+		RoomID:   -1,
+		SenderID: -1,
+		Payload:  reconnectPayload(gw.pickReconnectTarget()),
+	}
+	gw.closeConnectionsInWaves(ctx, frame)
+	gw.flushWaitQueues()
+	gw.waitStreamEmpty(ctx)
+	zap.L().Info("gateway draining finished")
+}
+
+// pickReconnectTarget 选择负载最低的其他网关作为建议的重连目标，找不到时客户端自行向 registry 查询
+func (gw *Gateway) pickReconnectTarget() *registry_client.GatewayInstanceInfo {
+	if gw.registry == nil {
+		return nil
+	}
+	gateways, err := gw.registry.ListGateways()
+	if err != nil {
+		zap.L().Warn("Failed to list gateways for reconnect suggestion", zap.Error(err))
+		return nil
+	}
+	var best *registry_client.GatewayInstanceInfo
+	for i := range gateways {
+		other := &gateways[i]
+		if other.Draining || other.GatewayID == gw.registry.GatewayID() {
+			continue
+		}
+		if other.Capacity > 0 && other.ConnectedUsers >= other.Capacity {
+			continue
+		}
+		if best == nil || other.Load < best.Load {
+			best = other
+		}
+	}
+	return best
+}
+
+func reconnectPayload(target *registry_client.GatewayInstanceInfo) map[string]interface{} {
+	payload := map[string]interface{}{"reason": "draining"}
+	if target != nil {
+		payload["gateway_id"] = target.GatewayID
+		payload["address"] = target.Address
+		payload["port"] = target.Port
+	}
+	return payload
+}
+
+func (gw *Gateway) allHolders() []*ConnectionHolder {
+	gw.connMu.RLock()
+	defer gw.connMu.RUnlock()
+	var holders []*ConnectionHolder
+	for _, devices := range gw.connStore {
+		for _, holder := range devices {
+			holders = append(holders, holder)
+		}
+	}
+	return holders
+}
+
+// closeConnectionsInWaves 每一波关闭 drain_wave_size 个连接，超时后剩余连接一次性关闭
+func (gw *Gateway) closeConnectionsInWaves(ctx context.Context, frame types.ClientMessage) {
+	holders := gw.allHolders()
+	for start := 0; start < len(holders); start += gw.drain.waveSize {
+		end := start + gw.drain.waveSize
+		if end > len(holders) || ctx.Err() != nil {
+			end = len(holders)
+		}
+		var wg sync.WaitGroup
+		for _, holder := range holders[start:end] {
+			wg.Add(1)
+			go func(holder *ConnectionHolder) {
+				defer wg.Done()
+				gw.drainHolder(holder, frame)
+			}(holder)
+		}
+		wg.Wait()
+		zap.L().Info("drain wave closed", zap.Int("closed", end), zap.Int("total", len(holders)))
+		if end >= len(holders) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(gw.drain.waveInterval):
+		}
+	}
+}
+
+// drainHolder 先写出 reconnect 帧（排在它之前的消息照常写出）和关闭帧，再移除连接：
+// 队列中剩余的消息和未确认的消息由 RemoveConnection 转入离线队列
+func (gw *Gateway) drainHolder(holder *ConnectionHolder, frame types.ClientMessage) {
+	if err := WriteJSONSafe(holder, 2*time.Second, frame); err != nil {
+		zap.L().Debug("reconnect frame not written", zap.Int64("userID", holder.userID), zap.String("deviceID", holder.deviceID), zap.Error(err))
+	}
+	holder.transport.Shutdown(websocket.CloseGoingAway, "gateway draining")
+	gw.RemoveHolder(holder)
+}
+
+// flushWaitQueues 把等待队列中已无连接设备的消息转入离线队列
+func (gw *Gateway) flushWaitQueues() {
+	gw.wait.set.Range(func(k, _ interface{}) bool {
+		conn, ok := k.(connKey)
+		if !ok {
+			return true
+		}
+		if _, connected := gw.GetConnectionHolder(conn.userID, conn.deviceID); connected {
+			return true
+		}
+		key := waitQueueKey(conn)
+		for {
+			msgStr, err := Redis.LPopWithRetry(2, key)
+			if err != nil {
+				break
+			}
+			var msg types.ClientMessage
+			if err := json.Unmarshal([]byte(msgStr), &msg); err != nil {
+				continue
+			}
+			if err := gw.pushBack(msg, conn.userID); err != nil {
+				zap.L().Error("SendPushBackRequest failed during drain", zap.Int64("userID", conn.userID), zap.Error(err))
+			}
+		}
+		gw.RemoveWaitSet(conn)
+		return true
+	})
+}
+
+// waitStreamEmpty 连接关闭后继续消费 stream（无连接的目标转入离线队列），
+// 直到所有条目都已读取并 XACK
+func (gw *Gateway) waitStreamEmpty(ctx context.Context) {
+	c := &gw.stream
+	if !c.running.Load() {
+		return
+	}
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		pending, unread, err := Redis.XGroupBacklogWithRetry(2, c.name, c.group)
+		if err != nil {
+			zap.L().Warn("Failed to check stream backlog during drain", zap.Error(err))
+		} else if pending == 0 && !unread {
+			zap.L().Info("gateway stream drained", zap.String("stream", c.name))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			zap.L().Warn("drain timeout, remaining stream entries are replayed on next start",
+				zap.String("stream", c.name), zap.Int64("pending", pending), zap.Bool("unread", unread))
+			return
+		case <-ticker.C:
+		}
+	}
+}
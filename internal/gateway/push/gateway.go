@@ -0,0 +1,287 @@
+package push
+
+import (
+	"GoStacker/internal/gateway/centerclient"
+	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/config"
+	"GoStacker/pkg/monitor"
+	"GoStacker/pkg/registry_client"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxConnections  = 100000
+	defaultSendChannelSize = 128
+	heartbeatInterval      = 10 * time.Second
+)
+
+var (
+	ErrGatewayStarted = errors.New("gateway already started")
+	ErrGatewayStopped = errors.New("gateway stopped")
+)
+
+// Config 网关实例的配置。Address / Port 是注册到 registry 的对外地址，
+// ResumeSecret 为 resume token 的签名密钥（通常与 JWT 密钥相同），
+// RegistryURL / InternalToken 用于查找 send 实例并调用其内部接口
+type Config struct {
+	Address       string
+	Port          int
+	ResumeSecret  string
+	RegistryURL   string
+	InternalToken string
+
+	Dispatcher   *config.GatewayDispatcherConfig
+	Compression  *config.GatewayCompressionConfig
+	Backpressure *config.GatewayBackpressureConfig
+}
+
+// ConfigFrom 取出应用配置中网关使用的部分
+func ConfigFrom(app *config.AppConfig) Config {
+	cfg := Config{
+		Address:      app.Address,
+		Port:         app.Port,
+		Dispatcher:   app.GatewayDispatcherConfig,
+		Compression:  app.GatewayCompressionConfig,
+		Backpressure: app.GatewayBackpressureConfig,
+	}
+	if app.JWTConfig != nil {
+		cfg.ResumeSecret = app.JWTConfig.Secret
+	}
+	if app.RegistryConfig != nil {
+		cfg.RegistryURL = app.RegistryConfig.URL
+		cfg.InternalToken = app.RegistryConfig.InternalToken
+	}
+	return cfg
+}
+
+// PushBackFunc 把消息退回 send 服务，由其写入用户的离线队列
+type PushBackFunc func(msg types.ClientMessage, userID int64) error
+
+// SendCaller 调用 send 服务的内部接口
+type SendCaller func(path string, body interface{}, out interface{}) error
+
+// Deps 网关依赖的外部服务
+type Deps struct {
+	// Registry 为 nil 时网关不注册、不上报连接，也不消费 stream
+	Registry *registry_client.GatewayClient
+	// Center 为 nil 时按 Config.RegistryURL / InternalToken 创建
+	Center *centerclient.Client
+	// PushBack 为 nil 时经 Center 退回 send 服务
+	PushBack PushBackFunc
+	// CallSend 为 nil 时使用 Center.CallSend
+	CallSend SendCaller
+}
+
+// Gateway 一个网关实例：设备连接、投递跟踪、会话、stream 消费与 registry 上报
+// 同一进程内可以运行多个实例，信号处理由调用方负责
+type Gateway struct {
+	cfg      Config
+	registry *registry_client.GatewayClient
+	pushBack PushBackFunc
+	callSend SendCaller
+
+	maxConnections  int
+	sendChannelSize int
+	pollSessionTTL  time.Duration
+
+	connMu sync.RWMutex
+	// userID -> deviceID -> holder
+	connStore    map[int64]map[string]*ConnectionHolder
+	connCount    atomic.Int64
+	totalPending atomic.Int64
+
+	ack          ackTracker
+	stream       streamConsumer
+	wait         waitQueue
+	drain        drainState
+	resume       resumeStore
+	timeline     timelineIndex
+	backpressure backpressureSettings
+	compression  compressionSettings
+
+	pushWSMonitor *monitor.Monitor
+
+	// ctx 在 Start 时创建，Stop 或 Start 的 ctx 结束时取消，所有后台任务随之退出
+	lifeMu  sync.Mutex
+	started bool
+	stopped bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// New 按配置创建网关，Start 之前不启动任何后台任务
+func New(cfg Config, deps Deps) *Gateway {
+	dispatcher := cfg.Dispatcher
+	if dispatcher == nil {
+		dispatcher = &config.GatewayDispatcherConfig{}
+		cfg.Dispatcher = dispatcher
+	}
+	gw := &Gateway{
+		cfg:             cfg,
+		registry:        deps.Registry,
+		pushBack:        deps.PushBack,
+		callSend:        deps.CallSend,
+		maxConnections:  defaultMaxConnections,
+		sendChannelSize: defaultSendChannelSize,
+		pollSessionTTL:  defaultPollSessionTTL,
+		connStore:       make(map[int64]map[string]*ConnectionHolder),
+		ctx:             context.Background(),
+	}
+	center := deps.Center
+	if center == nil {
+		center = centerclient.New(cfg.RegistryURL, cfg.InternalToken)
+	}
+	if gw.pushBack == nil {
+		gw.pushBack = center.PushBack
+	}
+	if gw.callSend == nil {
+		gw.callSend = center.CallSend
+	}
+	if dispatcher.MaxConnections > 0 {
+		gw.maxConnections = dispatcher.MaxConnections
+	}
+	if dispatcher.SendChannelSize > 0 {
+		gw.sendChannelSize = dispatcher.SendChannelSize
+	}
+	if dispatcher.PollSessionTTL > 0 {
+		gw.pollSessionTTL = time.Duration(dispatcher.PollSessionTTL) * time.Second
+	}
+	gw.ack.init(dispatcher)
+	gw.stream.init(dispatcher)
+	gw.drain.init(dispatcher)
+	gw.resume.init(dispatcher, cfg.ResumeSecret)
+	gw.backpressure.init(cfg.Backpressure)
+	gw.compression.init(cfg.Compression)
+	gw.timeline.init()
+	return gw
+}
+
+// CallSend 调用 send 服务的内部接口，供连接上转发的客户端帧使用
+func (gw *Gateway) CallSend(path string, body interface{}, out interface{}) error {
+	return gw.callSend(path, body, out)
+}
+
+// Start 注册到 registry 并启动后台任务，ctx 结束时后台任务随之退出（不做排空），
+// 正常停机应调用 Stop
+func (gw *Gateway) Start(ctx context.Context) error {
+	gw.lifeMu.Lock()
+	defer gw.lifeMu.Unlock()
+	if gw.stopped {
+		return ErrGatewayStopped
+	}
+	if gw.started {
+		return ErrGatewayStarted
+	}
+	gw.started = true
+	gw.ctx, gw.cancel = context.WithCancel(ctx)
+
+	// create push monitor for websocket push path
+	gw.pushWSMonitor = monitor.NewMonitor("push_ws", 1000, 10000, 60000)
+	gw.pushWSMonitor.RunContext(gw.ctx)
+
+	go gw.ListeningWaitQueue()
+	go gw.redeliverLoop()
+	go gw.resumeFlushLoop()
+	gw.startAuth()
+
+	if gw.registry == nil {
+		zap.L().Warn("Registry client not configured, gateway will not register")
+		return nil
+	}
+	gw.startControlListener()
+	if err := gw.registry.Register(gw.cfg.Address, gw.cfg.Port, 10000); err != nil {
+		// Continue anyway, will retry on heartbeat
+		zap.L().Error("Failed to register with registry service", zap.Error(err))
+	} else {
+		zap.L().Info("Gateway registered with registry",
+			zap.String("gateway_id", gw.registry.GatewayID()),
+			zap.String("address", gw.cfg.Address),
+			zap.Int("port", gw.cfg.Port))
+	}
+	go gw.heartbeatLoop()
+
+	// consume the gateway stream and large room timelines (read diffusion)
+	gw.startStream()
+	zap.L().Info("Initialized stream and group for push dispatcher", zap.String("stream", gw.stream.name))
+	gw.startTimeline()
+	return nil
+}
+
+// Stop 排空网关（通知客户端重连、处理完 stream）、停止后台任务并从 registry 注销。
+// ctx 限制排空的时长，排空本身最长 drain_timeout
+func (gw *Gateway) Stop(ctx context.Context) error {
+	gw.lifeMu.Lock()
+	if gw.stopped {
+		gw.lifeMu.Unlock()
+		return nil
+	}
+	gw.stopped = true
+	started := gw.started
+	gw.lifeMu.Unlock()
+	if !started {
+		return nil
+	}
+
+	// Drain: move clients to other gateways and finish the stream before leaving.
+	// Heartbeats keep running so the registry keeps seeing this gateway as draining.
+	gw.Drain(ctx)
+	gw.stopStream()
+	if gw.ack.cache != nil {
+		gw.ack.cache.Stop()
+	}
+	gw.cancel()
+	zap.L().Info("Push dispatcher stopped; background listeners will exit")
+
+	if gw.registry != nil {
+		if err := gw.registry.Unregister(); err != nil {
+			zap.L().Error("Failed to unregister from registry", zap.Error(err))
+			return err
+		}
+		zap.L().Info("Gateway unregistered from registry")
+	}
+	return nil
+}
+
+// heartbeatLoop 周期性向 registry 上报负载，Stop 时在排空之后才退出
+func (gw *Gateway) heartbeatLoop() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gw.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		connCount := gw.GetConnectionCount()
+		load := float32(connCount) / float32(gw.maxConnections)
+		if err := gw.registry.Heartbeat(load, connCount, 0.0, 0); err != nil {
+			zap.L().Warn("Failed to send heartbeat to registry", zap.Error(err))
+		}
+	}
+}
+
+// MaxConnections 本网关接受的最大连接数，达到后拒绝新连接
+func (gw *Gateway) MaxConnections() int {
+	return gw.maxConnections
+}
+
+// GatewayID 网关在 registry 中的标识，没有 registry 时为空
+func (gw *Gateway) GatewayID() string {
+	if gw.registry == nil {
+		return ""
+	}
+	return gw.registry.GatewayID()
+}
+
+// xack 把已结束的 stream 条目交给 AckCache 批量 XACK
+func (gw *Gateway) xack(streamID string) {
+	if gw.ack.cache != nil {
+		gw.ack.cache.Insert(streamID)
+	}
+}
@@ -1,11 +1,7 @@
 package push
 
 import (
-	"GoStacker/internal/gateway/centerclient"
 	"GoStacker/internal/gateway/push/types"
-	"GoStacker/pkg/config"
-	"GoStacker/pkg/pendingTask"
-	"GoStacker/pkg/registry_client"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -15,44 +11,67 @@ import (
 	"go.uber.org/zap"
 )
 
-var connStore sync.Map // key: int64, value: *ConnectionHolder
+// DefaultDeviceID 客户端握手未带 device_id 时使用，等价于单设备登录
+const DefaultDeviceID = "default"
 
-var ErrNoConn = errors.New("no connection for user")
-
-var connCount int = 0
-var connCountLock sync.Mutex
-
-var totalPending int64
-
-var registryClient *registry_client.GatewayClient
-
-// SetRegistryClient sets the registry client for reporting user connections
-func SetRegistryClient(client *registry_client.GatewayClient) {
-	registryClient = client
+// connKey 标识一个设备连接，同一用户的多个设备可以同时在线
+type connKey struct {
+	userID   int64
+	deviceID string
 }
 
+var ErrNoConn = errors.New("no connection for user")
+
 type sendRequest struct {
 	msg  interface{}
 	resp chan error
 }
 
 type ConnectionHolder struct {
-	Conn    *websocket.Conn
-	sendCh  chan sendRequest
-	closeCh chan struct{}
+	gw       *Gateway
+	userID   int64
+	deviceID string
+	// Conn 仅 WebSocket 连接不为 nil，用于识别请求来自哪个连接
+	Conn      *websocket.Conn
+	transport Transport
+	sendCh    chan sendRequest
+	closeCh   chan struct{}
+	// auth 连接所用 token 的身份信息，客户端在连接上刷新 token 时替换
+	auth atomic.Pointer[ConnAuth]
+	// authWarned 已推送过即将过期的提醒
+	authWarned atomic.Bool
+	// writeLatency 写出延迟的滑动平均（ns），slowSince 连接开始变慢的时间（ns），0 表示未变慢
+	writeLatency atomic.Int64
+	slowSince    atomic.Int64
+	// tooSlow 已因跟不上而被断开
+	tooSlow atomic.Bool
+	// coalesced 队列中 coalescedFrame 对应的最新事件
+	coalesceMu sync.Mutex
+	coalesced  map[coalesceKey]types.ClientMessage
+	// stats 连接的收发统计，供诊断接口查看
+	stats connStats
+}
+
+func (ch *ConnectionHolder) key() connKey {
+	return connKey{userID: ch.userID, deviceID: ch.deviceID}
 }
 
-func incPending(count int64) {
-	atomic.AddInt64(&totalPending, count)
+// DeviceID 返回该连接所属的设备
+func (ch *ConnectionHolder) DeviceID() string {
+	return ch.deviceID
 }
 
-func decPending(count int64) {
-	atomic.AddInt64(&totalPending, -count)
-	PullTask()
+func (gw *Gateway) incPending(count int64) {
+	gw.totalPending.Add(count)
+}
+
+func (gw *Gateway) decPending(count int64) {
+	gw.totalPending.Add(-count)
+	gw.PullTask()
 }
 
 // writerLoop 串行化对 websocket 的写入并在完成后将结果返回给请求方
-func writerLoop(ch *ConnectionHolder) {
+func (ch *ConnectionHolder) writerLoop() {
 	for {
 		select {
 		case req, ok := <-ch.sendCh:
@@ -60,22 +79,33 @@ func writerLoop(ch *ConnectionHolder) {
 				return
 			}
 			// one task consumed from channel
-			decPending(1)
+			ch.gw.decPending(1)
+			if ref, ok := req.msg.(coalescedFrame); ok {
+				msg, ok := ch.takeCoalesced(ref.key)
+				if !ok {
+					continue
+				}
+				req.msg = msg
+			}
 			var err error
 			// If caller passed a websocket control message type (e.g. websocket.PingMessage)
 			// write it as a control frame instead of JSON.
 			if mt, ok := req.msg.(int); ok && mt == websocket.PingMessage {
-				err = ch.Conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second))
+				err = ch.transport.WritePing()
 			} else {
 				zap.L().Debug("writerLoop sending message", zap.Any("message", req.msg))
-				ch.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-				err = ch.Conn.WriteJSON(req.msg)
+				start := time.Now()
+				var n int
+				n, err = ch.transport.WriteFrame(req.msg)
+				ch.observeWrite(time.Since(start))
 				if err == nil {
+					ch.stats.recordOut(n)
 					if msgraw, ok := req.msg.(types.ClientMessage); ok {
-						pendingTask.DefaultPendingManager.Done(msgraw.ID)
+						ch.gw.markWritten(ch.key(), msgraw)
 					}
 				}
 			}
+			ch.stats.recordWrite(err)
 			// send result back if caller expects it
 			if req.resp != nil {
 				select {
@@ -85,7 +115,7 @@ func writerLoop(ch *ConnectionHolder) {
 			}
 			if err != nil {
 				// on write error, close connection and exit writer
-				ch.Conn.Close()
+				ch.transport.Close()
 				return
 			}
 		case <-ch.closeCh:
@@ -104,8 +134,8 @@ func WriteJSONSafe(holder *ConnectionHolder, timeout time.Duration, message inte
 	// enqueue with timeout
 	select {
 	case holder.sendCh <- req:
-		// increment global task count for this enqueue
-		incPending(1)
+		// increment the gateway's task count for this enqueue
+		holder.gw.incPending(1)
 		// wait for write result or timeout
 		select {
 		case err := <-req.resp:
@@ -121,203 +151,324 @@ func WriteJSONSafe(holder *ConnectionHolder, timeout time.Duration, message inte
 	}
 }
 
-// EnqueueMessage 将消息尽力推入连接发送队列，不等待写入执行，仅等待入队成功或超时。
-func EnqueueMessage(userID int64, timeout time.Duration, message interface{}) error {
-	zap.L().Debug("EnqueueMessage", zap.Int64("userID", userID), zap.Any("message", message))
-	val, ok := connStore.Load(userID)
-	if !ok {
+// enqueueHolder 把消息推入一个设备连接的发送队列，不等待写入执行。慢连接按 backpressure 策略处理，
+// 临时事件被丢弃时返回 errFrameDropped。timeout 不大于 0 时不等待，队列已满立即返回 errQueueFull
+func (gw *Gateway) enqueueHolder(holder *ConnectionHolder, timeout time.Duration, message interface{}) error {
+	select {
+	case <-holder.closeCh:
 		return ErrNoConn
+	default:
+	}
+	if holder.tooSlow.Load() {
+		return ErrNoConn
+	}
+	if gw.backpressure.policy != PolicyNone && holder.isSlow() {
+		return gw.enqueueSlow(holder, message)
 	}
-	holder := val.(*ConnectionHolder)
 	req := sendRequest{msg: message, resp: nil}
-	zap.L().Debug("EnqueueMessage prepared request", zap.Int64("userID", userID), zap.Any("request", req))
+	if timeout <= 0 {
+		select {
+		case holder.sendCh <- req:
+			gw.incPending(1)
+			return nil
+		default:
+			return errQueueFull
+		}
+	}
 	select {
 	case holder.sendCh <- req:
-		incPending(1)
+		gw.incPending(1)
 		return nil
+	case <-holder.closeCh:
+		return ErrNoConn
 	case <-time.After(timeout):
 		return errors.New("enqueue timeout")
 	}
 }
 
-// GetConnectionHolder 返回 holder，用于在调用方检查/访问 Conn
-func GetConnectionHolder(userID int64) (*ConnectionHolder, bool) {
-	val, ok := connStore.Load(userID)
+// EnqueueMessage 将消息尽力推入用户所有设备连接的发送队列，不等待写入执行，仅等待入队成功或超时。
+// 至少一个设备入队成功即返回 nil，所有设备都失败时返回最后一个错误。
+func (gw *Gateway) EnqueueMessage(userID int64, timeout time.Duration, message interface{}) error {
+	zap.L().Debug("EnqueueMessage", zap.Int64("userID", userID), zap.Any("message", message))
+	holders := gw.userHolders(userID)
+	if len(holders) == 0 {
+		return ErrNoConn
+	}
+	var lastErr error
+	sent := false
+	for _, holder := range holders {
+		if err := gw.enqueueHolder(holder, timeout, message); err != nil && err != errFrameDropped {
+			lastErr = err
+			continue
+		}
+		sent = true
+	}
+	if sent {
+		return nil
+	}
+	return lastErr
+}
+
+// EnqueueToDevice 只推入用户指定设备的发送队列
+func (gw *Gateway) EnqueueToDevice(userID int64, deviceID string, timeout time.Duration, message interface{}) error {
+	holder, ok := gw.GetConnectionHolder(userID, deviceID)
 	if !ok {
-		return nil, false
+		return ErrNoConn
 	}
-	return val.(*ConnectionHolder), true
+	return gw.enqueueHolder(holder, timeout, message)
+}
+
+// GetConnectionHolder 返回用户某个设备的 holder，用于在调用方检查/访问 Conn
+func (gw *Gateway) GetConnectionHolder(userID int64, deviceID string) (*ConnectionHolder, bool) {
+	gw.connMu.RLock()
+	defer gw.connMu.RUnlock()
+	holder, ok := gw.connStore[userID][deviceID]
+	return holder, ok
 }
 
-func RegisterConnection(userID int64, conn *websocket.Conn) {
-	// determine send channel buffer size from config, fallback to 128
-	buf := 128
-	if config.Conf != nil && config.Conf.GatewayDispatcherConfig != nil && config.Conf.GatewayDispatcherConfig.SendChannelSize > 0 {
-		buf = config.Conf.GatewayDispatcherConfig.SendChannelSize
+// userHolders 返回用户在本网关的所有设备连接
+func (gw *Gateway) userHolders(userID int64) []*ConnectionHolder {
+	gw.connMu.RLock()
+	defer gw.connMu.RUnlock()
+	devices := gw.connStore[userID]
+	holders := make([]*ConnectionHolder, 0, len(devices))
+	for _, holder := range devices {
+		holders = append(holders, holder)
 	}
+	return holders
+}
+
+// IsUserConnected 用户是否有任一设备连接在本网关
+func (gw *Gateway) IsUserConnected(userID int64) bool {
+	gw.connMu.RLock()
+	defer gw.connMu.RUnlock()
+	return len(gw.connStore[userID]) > 0
+}
 
-	// If there's an existing connection, stop its writer, drain buffered messages, and migrate them to the new sendCh.
+// stopHolder 停止 writer 并取出发送队列中尚未写出的请求
+func stopHolder(holder *ConnectionHolder) []sendRequest {
 	var drained []sendRequest
-	if val, ok := connStore.Load(userID); ok {
-		old := val.(*ConnectionHolder)
-		// signal old writer to stop
-		func() {
-			defer func() { _ = recover() }()
-			close(old.closeCh)
-		}()
-		// drain any buffered items (non-blocking) into slice
-		for {
-			select {
-			case req, ok := <-old.sendCh:
-				if !ok {
-					goto drainedDone
-				}
-				drained = append(drained, req)
-			default:
+	// signal writer to stop
+	func() {
+		defer func() { _ = recover() }()
+		close(holder.closeCh)
+	}()
+	// drain any buffered items (non-blocking) into slice
+	for {
+		select {
+		case req, ok := <-holder.sendCh:
+			if !ok {
 				goto drainedDone
 			}
+			drained = append(drained, req)
+		default:
+			goto drainedDone
 		}
-	drainedDone:
-		// close old sendCh safely
-		func() {
-			defer func() { _ = recover() }()
-			close(old.sendCh)
-		}()
-		if old.Conn != nil {
-			old.Conn.Close()
+	}
+drainedDone:
+	// 合并帧换成其最新的事件，迁移到新连接或退回离线队列
+	for i := range drained {
+		if ref, ok := drained[i].msg.(coalescedFrame); ok {
+			if msg, ok := holder.takeCoalesced(ref.key); ok {
+				drained[i].msg = msg
+			}
 		}
-		connCountLock.Lock()
-		connCount--
-		connCountLock.Unlock()
-		connStore.Delete(userID)
+	}
+	// close the sendCh safely
+	func() {
+		defer func() { _ = recover() }()
+		close(holder.sendCh)
+	}()
+	holder.transport.Close()
+	return drained
+}
+
+// RegisterConnection 登记一个设备连接。同一设备重复连接时替换旧连接并迁移其未发送的消息，
+// 其他设备的连接不受影响。
+func (gw *Gateway) RegisterConnection(userID int64, deviceID string, conn *websocket.Conn) *ConnectionHolder {
+	return gw.registerConnection(userID, deviceID, WebSocketTransport(conn), nil)
+}
+
+// registerConnection initial 中的消息在连接对外可见之前排入发送队列，先于任何实时消息写出。
+// 同一设备换用其他传输方式（例如 WebSocket 被代理拦截后改用 SSE）时同样替换旧连接
+func (gw *Gateway) registerConnection(userID int64, deviceID string, t Transport, initial []types.ClientMessage) *ConnectionHolder {
+	buf := gw.sendChannelSize
+
+	gw.connMu.Lock()
+	devices, ok := gw.connStore[userID]
+	if !ok {
+		devices = make(map[string]*ConnectionHolder)
+		gw.connStore[userID] = devices
+	}
+	firstDevice := len(devices) == 0
+	old, replaced := devices[deviceID]
+
+	// If the device has an existing connection, stop its writer, drain buffered messages, and migrate them to the new sendCh.
+	var drained []sendRequest
+	if replaced {
+		drained = stopHolder(old)
+		gw.connCount.Add(-1)
 	}
 
 	// create new holder with capacity to hold drained items plus configured buffer
-	newBuf := buf + len(drained)
+	newBuf := buf + len(initial) + len(drained)
 	holder := &ConnectionHolder{
-		Conn:    conn,
-		sendCh:  make(chan sendRequest, newBuf),
-		closeCh: make(chan struct{}),
+		gw:        gw,
+		userID:    userID,
+		deviceID:  deviceID,
+		transport: t,
+		sendCh:    make(chan sendRequest, newBuf),
+		closeCh:   make(chan struct{}),
+	}
+	if wt, ok := t.(*wsTransport); ok {
+		holder.Conn = wt.conn
+		gw.compression.apply(wt)
+	}
+	holder.stats.connectedAt = time.Now()
+	holder.stats.remoteAddr = t.RemoteAddr()
+	for _, msg := range initial {
+		gw.trackDelivery(holder.key(), msg, "")
+		holder.sendCh <- sendRequest{msg: msg}
+		gw.incPending(1)
 	}
 	// migrate drained items into new sendCh without changing TotalTaskCount (they were already counted)
 	for _, req := range drained {
 		holder.sendCh <- req
 	}
+	devices[deviceID] = holder
+	gw.connMu.Unlock()
 
-	connStore.Store(userID, holder)
-	connCountLock.Lock()
-	connCount++
-	connCountLock.Unlock()
-	go writerLoop(holder)
+	gw.connCount.Add(1)
+	go holder.writerLoop()
+	if firstDevice {
+		gw.indexConnection(userID)
+	}
+	if replaced {
+		// frames written to the old socket but never acknowledged go out again on the new one
+		gw.requeueUnacked(holder.key())
+	}
 
-	// Report user connection to Registry
-	if registryClient != nil {
-		if err := registryClient.ReportUserConnect(userID); err != nil {
+	// Report device connection to Registry
+	if gw.registry != nil {
+		if err := gw.registry.ReportUserConnect(userID, deviceID); err != nil {
 			zap.L().Warn("Failed to report user connection to registry",
 				zap.Int64("user_id", userID),
+				zap.String("device_id", deviceID),
 				zap.Error(err))
 		}
 	}
+	gw.reportPresence("connect", userID, deviceID)
+	return holder
 }
 
-func RemoveConnection(userID int64) error {
-	val, ok := connStore.Load(userID)
-	if !ok {
+// RemoveConnection 移除一个设备连接。conn 不为 nil 时只在该设备当前连接仍是 conn 时移除，
+// 避免旧连接退出时误删同一设备新建立的连接。
+func (gw *Gateway) RemoveConnection(userID int64, deviceID string, conn *websocket.Conn) error {
+	return gw.removeConnection(userID, deviceID, func(holder *ConnectionHolder) bool {
+		return conn == nil || holder.Conn == conn
+	})
+}
+
+// RemoveHolder 只在 holder 仍是该设备的当前连接时移除，适用于所有传输方式
+func (gw *Gateway) RemoveHolder(holder *ConnectionHolder) error {
+	return gw.removeConnection(holder.userID, holder.deviceID, func(current *ConnectionHolder) bool {
+		return current == holder
+	})
+}
+
+func (gw *Gateway) removeConnection(userID int64, deviceID string, match func(*ConnectionHolder) bool) error {
+	gw.connMu.Lock()
+	holder, ok := gw.connStore[userID][deviceID]
+	if !ok || !match(holder) {
+		gw.connMu.Unlock()
 		return ErrNoConn
 	}
-	holder := val.(*ConnectionHolder)
+	delete(gw.connStore[userID], deviceID)
+	lastDevice := len(gw.connStore[userID]) == 0
+	if lastDevice {
+		delete(gw.connStore, userID)
+	}
+	gw.connMu.Unlock()
+
 	// drain pending items and send them back to center
-	var drained []sendRequest
-	func() {
-		defer func() { _ = recover() }()
-		// signal writer to stop
-		close(holder.closeCh)
-		// non-blocking drain
-		for {
-			select {
-			case req, ok := <-holder.sendCh:
-				if !ok {
-					goto drainedDone
-				}
-				drained = append(drained, req)
-			default:
-				goto drainedDone
-			}
-		}
-	drainedDone:
-		// close the sendCh
-		func() {
-			defer func() { _ = recover() }()
-			close(holder.sendCh)
-		}()
-	}()
+	drained := stopHolder(holder)
 
-	// decrement global task count by number drained
+	// decrement the gateway's task count by number drained
 	if len(drained) > 0 {
-		decPending(int64(len(drained)))
+		gw.decPending(int64(len(drained)))
 	}
 
+	// record the final position first, then unacknowledged deliveries go to the offline queue
+	// and are replayed on the next connection
+	gw.closeSession(holder.key())
+	handled := gw.releaseDevice(holder.key())
+
 	// send drained tasks back to center server
 	for _, req := range drained {
 		// attempt to convert message to types.ClientMessage
 		if msg, ok := req.msg.(types.ClientMessage); ok {
-			if config.Conf != nil {
-				if err := centerclient.SendPushBackRequest(config.Conf.CenterConfig, msg, userID); err != nil {
-					zap.L().Error("SendPushBackRequest failed during RemoveConnection", zap.Int64("userID", userID), zap.Error(err))
-					continue
-				}
-				pendingTask.DefaultPendingManager.Done(msg.ID)
+			if _, done := handled[deliveryKey{msgID: msg.ID, msgType: msg.Type}]; done {
+				continue
+			}
+			if err := gw.pushBack(msg, userID); err != nil {
+				zap.L().Error("SendPushBackRequest failed during RemoveConnection", zap.Int64("userID", userID), zap.Error(err))
+				continue
 			}
 		} else {
 			zap.L().Warn("skipping pushback for non-client message", zap.Any("msg", req.msg))
 		}
 	}
 
-	if holder.Conn != nil {
-		holder.Conn.Close()
+	gw.connCount.Add(-1)
+	if lastDevice {
+		gw.unindexConnection(userID)
 	}
-	connStore.Delete(userID)
-	connCountLock.Lock()
-	connCount--
-	connCountLock.Unlock()
 
-	// Report user disconnection to Registry
-	if registryClient != nil {
-		if err := registryClient.ReportUserDisconnect(userID); err != nil {
+	// Report device disconnection to Registry
+	if gw.registry != nil {
+		if err := gw.registry.ReportUserDisconnect(userID, deviceID); err != nil {
 			zap.L().Warn("Failed to report user disconnection to registry",
 				zap.Int64("user_id", userID),
+				zap.String("device_id", deviceID),
 				zap.Error(err))
 		}
 	}
+	gw.reportPresence("disconnect", userID, deviceID)
 
 	return nil
 }
 
-func GetConnection(userID int64) (*websocket.Conn, bool) {
-	val, ok := connStore.Load(userID)
+func (gw *Gateway) GetConnection(userID int64, deviceID string) (*websocket.Conn, bool) {
+	holder, ok := gw.GetConnectionHolder(userID, deviceID)
 	if !ok {
 		return nil, false
 	}
-	holder := val.(*ConnectionHolder)
 	return holder.Conn, true
 }
 
 // compatibility: keep these helpers but note they are no-ops for lock-based access
-func GetConnectionLock(userID int64) (*sync.Mutex, bool) {
+func (gw *Gateway) GetConnectionLock(userID int64) (*sync.Mutex, bool) {
 	return nil, false
 }
 
-func GetConnectionAndLock(userID int64) (*websocket.Conn, *sync.Mutex, bool) {
-	val, ok := connStore.Load(userID)
+func (gw *Gateway) GetConnectionAndLock(userID int64, deviceID string) (*websocket.Conn, *sync.Mutex, bool) {
+	holder, ok := gw.GetConnectionHolder(userID, deviceID)
 	if !ok {
 		return nil, nil, false
 	}
-	holder := val.(*ConnectionHolder)
 	return holder.Conn, nil, true
 }
 
-func GetConnectionCount() int {
-	connCountLock.Lock()
-	defer connCountLock.Unlock()
-	return connCount
+func (gw *Gateway) GetConnectionCount() int {
+	return int(gw.connCount.Load())
+}
+
+// WriteToConn 仅当 conn 仍是用户某个设备的当前连接时写入，避免把旧连接上请求的响应写到新建立的连接
+func (gw *Gateway) WriteToConn(userID int64, conn *websocket.Conn, timeout time.Duration, message interface{}) error {
+	for _, holder := range gw.userHolders(userID) {
+		if holder.Conn == conn {
+			return WriteJSONSafe(holder, timeout, message)
+		}
+	}
+	return ErrNoConn
 }
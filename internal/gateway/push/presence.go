@@ -0,0 +1,20 @@
+package push
+
+import (
+	"go.uber.org/zap"
+)
+
+// reportPresence 异步通知 send 服务设备连接变化，驱动在线状态；
+// 在 registry 上报之后调用，send 以 registry 中的设备路由判断用户是否在线
+func (gw *Gateway) reportPresence(event string, userID int64, deviceID string) {
+	go func() {
+		body := map[string]interface{}{"user_id": userID, "device_id": deviceID}
+		if err := gw.callSend("/internal/presence/"+event, body, nil); err != nil {
+			zap.L().Warn("Failed to report presence to send service",
+				zap.String("event", event),
+				zap.Int64("user_id", userID),
+				zap.String("device_id", deviceID),
+				zap.Error(err))
+		}
+	}()
+}
@@ -4,41 +4,70 @@ package push
 
 import (
 	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/config"
 	Redis "GoStacker/pkg/db/redis"
-	"GoStacker/pkg/pendingTask"
+	"GoStacker/pkg/wire"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"os/signal"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
-var (
-	streamName    = "push_tasks_stream"
-	groupName     = "push_tasks_group"
-	consumerGroup = "push_tasks_group"
-	pullCancel    context.CancelFunc
-	pullCtx       context.Context
-	isPulling     = false
-	taskChan      = make(chan types.PushMessage, 1000)
-	batchSize     = 100
-	threshold     = int64(10000)
-	lastID        = ">"
-)
+// streamBatchSize 单次从 stream 读取的条目数
+const streamBatchSize = 100
+
+type streamConsumer struct {
+	name      string
+	group     string
+	consumer  string
+	interval  time.Duration
+	threshold int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// running 在 ctx 创建之后置位，之前 PullTask 不启动 pullLoop
+	running atomic.Bool
+	pulling atomic.Bool
+	tasks   chan types.PushMessage
+	// 启动时先从 "0" 读取本消费者未 XACK 的条目（上次退出前未被客户端确认），读完后切换到 ">"
+	lastID string
+}
+
+func (c *streamConsumer) init(Conf *config.GatewayDispatcherConfig) {
+	c.name = "push_tasks_stream"
+	c.group = "push_tasks_group"
+	c.consumer = "push_tasks_group"
+	c.threshold = 10000
+	c.interval = time.Second
+	c.tasks = make(chan types.PushMessage, 1000)
+	c.lastID = "0"
+	if Conf.StreamName != "" {
+		c.name = Conf.StreamName
+	}
+	if Conf.GroupName != "" {
+		c.group = Conf.GroupName
+	}
+	if Conf.ConsumerName != "" {
+		c.consumer = Conf.ConsumerName
+	}
+	if Conf.Interval > 0 {
+		c.interval = time.Duration(Conf.Interval) * time.Second
+	}
+	if Conf.ThresholdPending > 0 {
+		c.threshold = Conf.ThresholdPending
+	}
+}
 
-func dispatchWorker() {
+func (gw *Gateway) dispatchWorker() {
 	for {
 		select {
-		case tasks := <-taskChan:
-			Dispatch(tasks)
-		case <-pullCtx.Done():
+		case tasks := <-gw.stream.tasks:
+			gw.Dispatch(tasks)
+		case <-gw.stream.ctx.Done():
 			zap.L().Info("Dispatch worker received shutdown signal")
 			return
 		}
@@ -50,46 +79,42 @@ func XStream2PushMessage(xstream []redis.XStream) ([]types.PushMessage, error) {
 	for _, xs := range xstream {
 		for _, m := range xs.Messages {
 			var msgData types.PushMessage
-			dataBytes, ok := m.Values["data"].(string)
-			if !ok {
-				fmt.Println("Error converting message data to string")
-				continue
-			}
-			err := json.Unmarshal([]byte(dataBytes), &msgData)
+			err := wire.DecodeStreamValues(m.Values, &msgData)
 			if err != nil {
 				fmt.Println("Error unmarshaling message data:", err)
 				continue
 			}
+			msgData.StreamID = m.ID
 			out = append(out, msgData)
 		}
 	}
 	return out, nil
 }
 
-func pullLoop() {
-	isPulling = true
-	defer func() { isPulling = false }()
+func (gw *Gateway) pullLoop() {
+	c := &gw.stream
+	defer c.pulling.Store(false)
 
 	for {
 		select {
-		case <-pullCtx.Done():
+		case <-c.ctx.Done():
 			zap.L().Info("pullLoop canceled")
 			return
 		default:
 		}
 
-		if atomic.LoadInt64(&totalPending) > threshold {
+		if gw.totalPending.Load() > c.threshold {
 			return
 		}
 
 		xstreams, err := Redis.XReadGroupBlockingWithContext(
-			pullCtx,
-			streamName,
-			groupName,
-			consumerGroup,
-			int64(batchSize),
+			c.ctx,
+			c.name,
+			c.group,
+			c.consumer,
+			streamBatchSize,
 			time.Second,
-			lastID,
+			c.lastID,
 		)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
@@ -103,16 +128,27 @@ func pullLoop() {
 			continue
 		}
 
+		if c.lastID != ">" && pendingEntryCount(xstreams) == 0 {
+			zap.L().Info("pending stream entries replayed, switching to new entries")
+			c.lastID = ">"
+			continue
+		}
+
 		tasks, err := XStream2PushMessage(xstreams)
 		if err != nil {
 			zap.L().Error("XStream2PushMessage error", zap.Error(err))
 			continue
 		}
+		replaying := c.lastID != ">"
 
 		for _, task := range tasks {
+			if replaying {
+				// users that resumed on another gateway already got these entries
+				task = gw.dropHandedOff(task)
+			}
 			select {
-			case taskChan <- task:
-			case <-pullCtx.Done():
+			case c.tasks <- task:
+			case <-c.ctx.Done():
 				zap.L().Info("pullLoop canceled")
 				return
 			}
@@ -120,52 +156,60 @@ func pullLoop() {
 	}
 }
 
+func pendingEntryCount(xstreams []redis.XStream) int {
+	n := 0
+	for _, xs := range xstreams {
+		n += len(xs.Messages)
+	}
+	return n
+}
+
 // blocking pull task from redis stream
-func PullTask() {
-	if isPulling {
+func (gw *Gateway) PullTask() {
+	c := &gw.stream
+	if !c.running.Load() {
 		return
 	}
-	taskChanCount := len(taskChan)
+	taskChanCount := len(c.tasks)
 	if taskChanCount > 800 {
 		zap.L().Debug("taskChan is full, skipping pull", zap.Int("taskChanCount", taskChanCount))
 		return
 	}
-	if atomic.LoadInt64(&totalPending) > threshold {
-		zap.L().Debug("totalPending exceed threshold, skipping pull", zap.Int64("totalPending", atomic.LoadInt64(&totalPending)), zap.Int64("threshold", threshold))
+	if pending := gw.totalPending.Load(); pending > c.threshold {
+		zap.L().Debug("totalPending exceed threshold, skipping pull", zap.Int64("totalPending", pending), zap.Int64("threshold", c.threshold))
 		return
 	}
-
-	go pullLoop()
+	if !c.pulling.CompareAndSwap(false, true) {
+		return
+	}
+	go gw.pullLoop()
 }
 
-func startDispatchWorkers(numWorkers int) {
+func (gw *Gateway) startDispatchWorkers(numWorkers int) {
 	for i := 0; i < numWorkers; i++ {
-		go dispatchWorker()
+		go gw.dispatchWorker()
 	}
 }
 
-func waitForPullShutdown() {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	zap.L().Info("Pull Task recive shutdown signal,delivering cancel to pull task dispatcher")
-	pullCancel()
-
+// stopStream 停止消费本网关的 stream，Drain 确认 stream 已清空后调用
+func (gw *Gateway) stopStream() {
+	c := &gw.stream
+	if !c.running.Load() {
+		return
+	}
+	zap.L().Info("stopping stream consumption", zap.String("stream", c.name))
+	c.cancel()
 }
 
-func InitStreamAndGroup(StreamName string, GroupName string, ConsumerName string, interval time.Duration, thresholdPending int64) {
-	pendingTask.DefaultPendingManager.SetDoneFunc(func(msgID int64) {
-		Redis.InsertAckCache(fmt.Sprintf("%d", msgID))
-	})
-	pullCtx, pullCancel = context.WithCancel(context.Background())
-	streamName = StreamName
-	groupName = GroupName
-	consumerGroup = ConsumerName
-	threshold = thresholdPending
+// startStream 创建 stream 与消费组并开始消费，已结束的条目经 AckCache 批量 XACK
+func (gw *Gateway) startStream() {
+	c := &gw.stream
+	c.ctx, c.cancel = context.WithCancel(gw.ctx)
 	//init redis stream and group
-	Redis.XGroupCreateMkStreamWithRetry(2, StreamName, GroupName, "0")
-	Redis.InitAckCache(StreamName, GroupName, interval)
-	go waitForPullShutdown()
-	startDispatchWorkers(5)
-	go pullLoop()
+	Redis.XGroupCreateMkStreamWithRetry(2, c.name, c.group, "0")
+	gw.ack.cache = Redis.NewAckCache(c.name, c.group, c.interval)
+	gw.startDispatchWorkers(5)
+	c.running.Store(true)
+	c.pulling.Store(true)
+	go gw.pullLoop()
 }
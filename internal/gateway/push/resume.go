@@ -0,0 +1,440 @@
+package push
+
+import (
+	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/config"
+	Redis "GoStacker/pkg/db/redis"
+	"GoStacker/pkg/wire"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	resumeKeyPrefix = "resume:session:"
+	// resumeHandoffPrefix 记录 stream 条目已由会话恢复补发给了哪些用户
+	resumeHandoffPrefix = "resume:handoff:"
+	// replayLimit 单次恢复从每个来源最多补发的消息数
+	replayLimit = 1000
+	// resumeAckedKeep 会话记录中保留的最近确认的消息 ID 数，用于补发去重
+	resumeAckedKeep = 256
+)
+
+var ErrInvalidResumeToken = errors.New("invalid resume token")
+
+// resumeStore 会话恢复：resume token 记录会话、投递所在的 stream 与设备的确认位置。带 token 重连到任意网关时，
+// 先补发旧 stream 中未确认的条目与离线队列中的消息（按 ID 去重），再恢复实时投递
+type resumeStore struct {
+	ttl    time.Duration
+	secret []byte
+
+	mu       sync.Mutex
+	sessions map[connKey]*resumeState
+	// sessions whose position changed since the last flush to Redis
+	dirty map[connKey]struct{}
+}
+
+// resumeState 会话在 Redis 中的记录，同时也是 resume token 的内容（不含 Acked）
+type resumeState struct {
+	SessionID string `json:"sid"`
+	UserID    int64  `json:"uid"`
+	DeviceID  string `json:"dev"`
+	GatewayID string `json:"gw,omitempty"`
+	// Stream / Group 持有该会话投递的网关 stream
+	Stream string `json:"stream,omitempty"`
+	Group  string `json:"group,omitempty"`
+	// StreamPos 该设备尚未确认的最早条目；没有未确认条目时为最后确认的条目
+	StreamPos string `json:"pos,omitempty"`
+	// LastMsgID 该设备确认过的最大消息 ID
+	LastMsgID int64   `json:"mid,omitempty"`
+	Acked     []int64 `json:"acked,omitempty"`
+
+	lastAckedStream string
+}
+
+type resumeToken struct {
+	resumeState
+	ExpiresAt int64 `json:"exp"`
+}
+
+func (r *resumeStore) init(Conf *config.GatewayDispatcherConfig, secret string) {
+	r.ttl = time.Hour
+	if Conf.ResumeTTL > 0 {
+		r.ttl = time.Duration(Conf.ResumeTTL) * time.Second
+	}
+	r.secret = []byte(secret)
+	r.sessions = make(map[connKey]*resumeState)
+	r.dirty = make(map[connKey]struct{})
+}
+
+// OpenSession 登记连接并建立会话。resumeToken 有效时沿用原会话，并在任何实时消息之前
+// 补发上一个连接未确认的消息；token 无效或为空时开始新会话。
+func (gw *Gateway) OpenSession(userID int64, deviceID string, t Transport, token string) *ConnectionHolder {
+	var (
+		state  *resumeState
+		replay []types.ClientMessage
+	)
+	if token != "" {
+		prev, err := gw.resume.parseToken(token, userID, deviceID)
+		if err != nil {
+			zap.L().Info("resume token rejected, starting new session", zap.Int64("userID", userID), zap.String("deviceID", deviceID), zap.Error(err))
+		} else {
+			state = prev
+			replay = gw.collectReplay(state)
+		}
+	}
+	resumed := state != nil
+	if !resumed {
+		state = &resumeState{SessionID: newSessionID(), UserID: userID, DeviceID: deviceID}
+	}
+	state.GatewayID = gw.GatewayID()
+	state.Stream, state.Group = gw.stream.name, gw.stream.group
+
+	frame := types.ClientMessage{
+		ID:       -1,
+		Type:     "session",
+		RoomID:   -1,
+		SenderID: -1,
+		Payload: map[string]interface{}{
+			"session_id":   state.SessionID,
+			"resume_token": gw.resume.signToken(state),
+			"resumed":      resumed,
+			"replayed":     len(replay),
+		},
+	}
+	key := connKey{userID: userID, deviceID: deviceID}
+	gw.resume.mu.Lock()
+	gw.resume.sessions[key] = state
+	gw.resume.dirty[key] = struct{}{}
+	gw.resume.mu.Unlock()
+
+	if resumed {
+		zap.L().Info("session resumed", zap.Int64("userID", userID), zap.String("deviceID", deviceID),
+			zap.String("session", state.SessionID), zap.Int("replayed", len(replay)))
+	}
+	return gw.registerConnection(userID, deviceID, t, append([]types.ClientMessage{frame}, replay...))
+}
+
+// noteAcked 设备确认消息后前移会话位置
+func (gw *Gateway) noteAcked(conn connKey, streamID string, msgID int64) {
+	gw.resume.mu.Lock()
+	defer gw.resume.mu.Unlock()
+	state, ok := gw.resume.sessions[conn]
+	if !ok {
+		return
+	}
+	if streamID != "" && (state.lastAckedStream == "" || Redis.CompareStreamID(streamID, state.lastAckedStream) > 0) {
+		state.lastAckedStream = streamID
+	}
+	if msgID > state.LastMsgID {
+		state.LastMsgID = msgID
+	}
+	state.Acked = append(state.Acked, msgID)
+	if len(state.Acked) > resumeAckedKeep {
+		state.Acked = state.Acked[len(state.Acked)-resumeAckedKeep:]
+	}
+	gw.resume.dirty[conn] = struct{}{}
+}
+
+// closeSession 连接断开时写入最终位置，会话记录保留 resume_ttl 供重连恢复
+func (gw *Gateway) closeSession(conn connKey) {
+	gw.resume.mu.Lock()
+	state, ok := gw.resume.sessions[conn]
+	delete(gw.resume.sessions, conn)
+	delete(gw.resume.dirty, conn)
+	gw.resume.mu.Unlock()
+	if ok {
+		gw.saveSession(conn, state)
+	}
+}
+
+func (gw *Gateway) resumeFlushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gw.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		gw.resume.mu.Lock()
+		dirty := make(map[connKey]*resumeState, len(gw.resume.dirty))
+		for conn := range gw.resume.dirty {
+			if state, ok := gw.resume.sessions[conn]; ok {
+				dirty[conn] = state
+			}
+		}
+		gw.resume.dirty = make(map[connKey]struct{})
+		gw.resume.mu.Unlock()
+		for conn, state := range dirty {
+			gw.saveSession(conn, state)
+		}
+	}
+}
+
+func (gw *Gateway) saveSession(conn connKey, state *resumeState) {
+	pos := gw.oldestUnacked(conn)
+	gw.resume.mu.Lock()
+	if pos == "" {
+		pos = state.lastAckedStream
+	}
+	state.StreamPos = pos
+	data, err := json.Marshal(state)
+	gw.resume.mu.Unlock()
+	if err != nil {
+		return
+	}
+	if err := Redis.SetEXWithRetry(2, resumeKeyPrefix+state.SessionID, string(data), gw.resume.ttl); err != nil {
+		zap.L().Warn("Failed to save session position", zap.String("session", state.SessionID), zap.Error(err))
+	}
+}
+
+// oldestUnacked 设备仍在等待确认的最早 stream 条目
+func (gw *Gateway) oldestUnacked(conn connKey) string {
+	gw.ack.mu.Lock()
+	defer gw.ack.mu.Unlock()
+	oldest := ""
+	for _, d := range gw.ack.deliveries[conn] {
+		if d.streamID == "" {
+			continue
+		}
+		if oldest == "" || Redis.CompareStreamID(d.streamID, oldest) < 0 {
+			oldest = d.streamID
+		}
+	}
+	return oldest
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (r *resumeStore) signPart(payload string) string {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signToken token 为 base64(JSON).签名，携带会话与签发时的投递位置
+func (r *resumeStore) signToken(state *resumeState) string {
+	r.mu.Lock()
+	tok := resumeToken{resumeState: *state, ExpiresAt: time.Now().Add(r.ttl).Unix()}
+	r.mu.Unlock()
+	tok.Acked = nil
+	data, _ := json.Marshal(tok)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + r.signPart(payload)
+}
+
+// parseToken 校验 token 并取回会话，Redis 中的记录比 token 中的位置更新，优先使用
+func (r *resumeStore) parseToken(token string, userID int64, deviceID string) (*resumeState, error) {
+	tok, err := r.verifyToken(token, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	state := tok.resumeState
+	if raw, err := Redis.GetWithRetry(2, resumeKeyPrefix+tok.SessionID); err == nil {
+		var saved resumeState
+		if err := json.Unmarshal([]byte(raw), &saved); err == nil && saved.UserID == userID && saved.DeviceID == deviceID {
+			state = saved
+		}
+	}
+	state.lastAckedStream = ""
+	return &state, nil
+}
+
+// verifyToken 校验签名、有效期以及 token 是否属于该用户的该设备
+func (r *resumeStore) verifyToken(token string, userID int64, deviceID string) (*resumeToken, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(r.signPart(payload))) {
+		return nil, ErrInvalidResumeToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidResumeToken
+	}
+	var tok resumeToken
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return nil, ErrInvalidResumeToken
+	}
+	if tok.ExpiresAt < time.Now().Unix() || tok.UserID != userID || tok.DeviceID != deviceID || tok.SessionID == "" {
+		return nil, ErrInvalidResumeToken
+	}
+	return &tok, nil
+}
+
+// collectReplay 汇总上一个连接未确认的消息：旧网关 stream 中仍未 XACK 且以该用户为目标的条目，
+// 以及用户的离线队列。按消息 ID 去重（包括设备已确认过的），按 ID 排序。
+// 补发的 stream 条目记为已移交，旧网关重放或释放这些条目时不再投递给该用户
+func (gw *Gateway) collectReplay(state *resumeState) []types.ClientMessage {
+	msgs, entryIDs := pendingStreamMessages(state)
+	gw.recordHandoff(state, entryIDs)
+	return mergeReplay(state.Acked, msgs, peekOfflineQueue(state.UserID))
+}
+
+// mergeReplay 合并各来源的补发消息，跳过已确认的与重复的消息，按 ID 排序
+func mergeReplay(acked []int64, sources ...[]types.ClientMessage) []types.ClientMessage {
+	skip := make(map[deliveryKey]struct{}, len(acked))
+	for _, id := range acked {
+		skip[deliveryKey{msgID: id, msgType: "chat"}] = struct{}{}
+	}
+	var out []types.ClientMessage
+	for _, msgs := range sources {
+		for _, msg := range msgs {
+			key := deliveryKey{msgID: msg.ID, msgType: msg.Type}
+			if msg.ID > 0 {
+				if _, dup := skip[key]; dup {
+					continue
+				}
+				skip[key] = struct{}{}
+			}
+			out = append(out, msg)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// pendingStreamMessages 返回旧网关 stream 中以该用户为目标的未确认条目，以及这些条目的 ID
+func pendingStreamMessages(state *resumeState) ([]types.ClientMessage, []string) {
+	if state.Stream == "" || state.Group == "" {
+		return nil, nil
+	}
+	start := state.StreamPos
+	if start == "" {
+		start = "-"
+	}
+	entries, err := Redis.XPendingMessagesWithRetry(2, state.Stream, state.Group, start, replayLimit)
+	if err != nil {
+		zap.L().Warn("Failed to read pending stream entries for resume", zap.String("stream", state.Stream), zap.Error(err))
+		return nil, nil
+	}
+	var (
+		out []types.ClientMessage
+		ids []string
+	)
+	for _, entry := range entries {
+		var msg types.PushMessage
+		if err := wire.DecodeStreamValues(entry.Values, &msg); err != nil {
+			continue
+		}
+		for _, uid := range msg.TargetIDs {
+			if uid == state.UserID {
+				out = append(out, types.ClientMessage{
+					ID:       msg.ID,
+					Type:     msg.Type,
+					RoomID:   msg.RoomID,
+					SenderID: msg.SenderID,
+					Payload:  msg.Payload,
+				})
+				ids = append(ids, entry.ID)
+				break
+			}
+		}
+	}
+	return out, ids
+}
+
+func handoffKey(stream, entryID string) string {
+	return resumeHandoffPrefix + stream + ":" + entryID
+}
+
+// recordHandoff 记录这些条目已补发给会话的用户。条目还有其他目标，不能在旧 stream 中 XACK；
+// 记录保留 resume_ttl，超过后仍未处理的条目可能再投递一次，由客户端按消息 ID 去重
+func (gw *Gateway) recordHandoff(state *resumeState, entryIDs []string) {
+	for _, id := range entryIDs {
+		if err := Redis.SAddEXWithRetry(2, handoffKey(state.Stream, id), gw.resume.ttl, state.UserID); err != nil {
+			zap.L().Warn("Failed to record resume handoff", zap.String("stream", state.Stream), zap.String("entry", id), zap.Error(err))
+		}
+	}
+}
+
+// handedOffUsers 返回本网关 stream 中某个条目已由其他连接补发过的用户
+func (gw *Gateway) handedOffUsers(streamID string) map[int64]struct{} {
+	if streamID == "" {
+		return nil
+	}
+	members, err := Redis.SMembersWithRetry(2, handoffKey(gw.stream.name, streamID))
+	if err != nil || len(members) == 0 {
+		return nil
+	}
+	users := make(map[int64]struct{}, len(members))
+	for _, m := range members {
+		if uid, err := strconv.ParseInt(m, 10, 64); err == nil {
+			users[uid] = struct{}{}
+		}
+	}
+	return users
+}
+
+// dropHandedOff 重放本网关遗留的条目时去掉已由会话恢复补发过的目标
+func (gw *Gateway) dropHandedOff(msg types.PushMessage) types.PushMessage {
+	gone := gw.handedOffUsers(msg.StreamID)
+	if len(gone) == 0 {
+		return msg
+	}
+	targets := make([]int64, 0, len(msg.TargetIDs))
+	for _, uid := range msg.TargetIDs {
+		if _, ok := gone[uid]; !ok {
+			targets = append(targets, uid)
+		}
+	}
+	msg.TargetIDs = targets
+	return msg
+}
+
+// recentlyAcked 会话最近确认过该消息
+func (gw *Gateway) recentlyAcked(conn connKey, msgID int64) bool {
+	gw.resume.mu.Lock()
+	defer gw.resume.mu.Unlock()
+	state, ok := gw.resume.sessions[conn]
+	if !ok {
+		return false
+	}
+	for _, id := range state.Acked {
+		if id == msgID {
+			return true
+		}
+	}
+	return false
+}
+
+// peekOfflineQueue 读取用户离线队列中的消息但不取走：队列由用户的所有设备共用，
+// 仍由 send 服务在上线通知时取出投递，本设备收到的重复消息在入队时按 ID 跳过。
+// 队列元素可能是单条消息或推回的一批消息
+func peekOfflineQueue(userID int64) []types.ClientMessage {
+	key := "offline:push:" + strconv.FormatInt(userID, 10)
+	raws, err := Redis.SendQueueLRangeWithRetry(2, key, 0, replayLimit-1)
+	if err != nil {
+		zap.L().Warn("Failed to read offline queue for resume", zap.Int64("userID", userID), zap.Error(err))
+		return nil
+	}
+	var out []types.ClientMessage
+	for _, raw := range raws {
+		var msg types.ClientMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err == nil {
+			out = append(out, msg)
+			continue
+		}
+		var batch []types.ClientMessage
+		if err := json.Unmarshal([]byte(raw), &batch); err == nil {
+			out = append(out, batch...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+package push
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/config"
+)
+
+func newTestResumeStore(secret string, ttl time.Duration) *resumeStore {
+	r := &resumeStore{}
+	r.init(&config.GatewayDispatcherConfig{}, secret)
+	r.ttl = ttl
+	return r
+}
+
+func TestResumeTokenRoundTrip(t *testing.T) {
+	r := newTestResumeStore("secret", time.Hour)
+	state := &resumeState{
+		SessionID: "s1",
+		UserID:    7,
+		DeviceID:  "phone",
+		Stream:    "push_tasks_stream",
+		Group:     "push_group",
+		StreamPos: "5-0",
+		LastMsgID: 42,
+		Acked:     []int64{40, 41, 42},
+	}
+	tok, err := r.verifyToken(r.signToken(state), 7, "phone")
+	if err != nil {
+		t.Fatalf("verifyToken: %v", err)
+	}
+	if tok.SessionID != "s1" || tok.Stream != "push_tasks_stream" || tok.Group != "push_group" ||
+		tok.StreamPos != "5-0" || tok.LastMsgID != 42 {
+		t.Fatalf("token state = %+v, want the signed session", tok.resumeState)
+	}
+	if tok.Acked != nil {
+		t.Fatalf("token carries acked ids %v, they only live in redis", tok.Acked)
+	}
+}
+
+func TestResumeTokenRejected(t *testing.T) {
+	r := newTestResumeStore("secret", time.Hour)
+	state := &resumeState{SessionID: "s1", UserID: 7, DeviceID: "phone"}
+	token := r.signToken(state)
+	payload, sig, _ := strings.Cut(token, ".")
+
+	expired := newTestResumeStore("secret", -time.Minute)
+	cases := []struct {
+		name     string
+		store    *resumeStore
+		token    string
+		userID   int64
+		deviceID string
+	}{
+		{"other user", r, token, 8, "phone"},
+		{"other device", r, token, 7, "desktop"},
+		{"other secret", newTestResumeStore("other", time.Hour), token, 7, "phone"},
+		{"tampered payload", r, "x" + payload + "." + sig, 7, "phone"},
+		{"missing signature", r, payload, 7, "phone"},
+		{"expired", expired, expired.signToken(state), 7, "phone"},
+		{"no session", r, r.signToken(&resumeState{UserID: 7, DeviceID: "phone"}), 7, "phone"},
+	}
+	for _, c := range cases {
+		if _, err := c.store.verifyToken(c.token, c.userID, c.deviceID); err != ErrInvalidResumeToken {
+			t.Errorf("%s: err = %v, want ErrInvalidResumeToken", c.name, err)
+		}
+	}
+}
+
+func TestMergeReplaySkipsAckedAndDuplicates(t *testing.T) {
+	typing := types.ClientMessage{ID: 0, Type: "typing", RoomID: 1}
+	stream := []types.ClientMessage{chatMsg(5), chatMsg(3), chatMsg(4)}
+	offline := []types.ClientMessage{chatMsg(4), chatMsg(2), typing, typing}
+
+	got := mergeReplay([]int64{3}, stream, offline)
+	var ids []int64
+	for _, msg := range got {
+		ids = append(ids, msg.ID)
+	}
+	want := []int64{0, 0, 2, 4, 5}
+	if len(ids) != len(want) {
+		t.Fatalf("replayed ids %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("replayed ids %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestAlreadyDeliveredAdoptsStreamEntry(t *testing.T) {
+	gw := newTestGateway(t, &pushBackRecorder{})
+	conn := connKey{userID: 7, deviceID: "phone"}
+	// 会话恢复补发的离线消息没有 stream 条目
+	gw.trackDelivery(conn, chatMsg(100), "")
+	gw.beginEntry("1-0", 1)
+
+	if !gw.alreadyDelivered(conn, chatMsg(100), "1-0") {
+		t.Fatal("message waiting for ack was delivered again")
+	}
+	if n, ok := remaining(gw, "1-0"); !ok || n != 1 {
+		t.Fatalf("remaining = %d, tracked = %v, want the entry held until the device acks", n, ok)
+	}
+	gw.AckDelivered(7, "phone", []int64{100})
+	if _, ok := remaining(gw, "1-0"); ok {
+		t.Fatal("adopted entry not settled by the device ack")
+	}
+}
+
+func TestAlreadyDeliveredSettlesDuplicateEntry(t *testing.T) {
+	gw := newTestGateway(t, &pushBackRecorder{})
+	conn := connKey{userID: 7, deviceID: "phone"}
+	gw.beginEntry("1-0", 1)
+	gw.beginEntry("2-0", 1)
+	gw.trackDelivery(conn, chatMsg(100), "1-0")
+
+	if !gw.alreadyDelivered(conn, chatMsg(100), "2-0") {
+		t.Fatal("message waiting for ack was delivered again")
+	}
+	if _, ok := remaining(gw, "2-0"); ok {
+		t.Fatal("duplicate entry not settled")
+	}
+	if n, ok := remaining(gw, "1-0"); !ok || n != 1 {
+		t.Fatalf("original entry remaining = %d, tracked = %v, want 1, true", n, ok)
+	}
+}
+
+func TestAlreadyDeliveredChecksRecentAcks(t *testing.T) {
+	gw := newTestGateway(t, &pushBackRecorder{})
+	conn := connKey{userID: 7, deviceID: "phone"}
+	gw.resume.sessions[conn] = &resumeState{SessionID: "s1", UserID: 7, DeviceID: "phone"}
+	gw.noteAcked(conn, "", 100)
+	gw.beginEntry("1-0", 1)
+
+	if !gw.alreadyDelivered(conn, chatMsg(100), "1-0") {
+		t.Fatal("recently acknowledged message was delivered again")
+	}
+	if _, ok := remaining(gw, "1-0"); ok {
+		t.Fatal("entry of an acknowledged message not settled")
+	}
+	if gw.alreadyDelivered(conn, chatMsg(101), "") {
+		t.Fatal("new message reported as already delivered")
+	}
+}
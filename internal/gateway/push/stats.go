@@ -0,0 +1,136 @@
+package push
+
+import (
+	Redis "GoStacker/pkg/db/redis"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// connStats 连接收发的帧数与字节数，快照时不暂停写出，同一快照中的值可能相差几帧
+type connStats struct {
+	connectedAt time.Time
+	remoteAddr  string
+
+	framesIn  atomic.Int64
+	framesOut atomic.Int64
+	bytesIn   atomic.Int64
+	bytesOut  atomic.Int64
+	// lastWriteAt 最近一次写 socket 的时间（ns），lastWriteErr 最近一次写失败的错误
+	lastWriteAt  atomic.Int64
+	lastWriteErr atomic.Pointer[string]
+}
+
+func (s *connStats) recordOut(n int) {
+	s.framesOut.Add(1)
+	s.bytesOut.Add(int64(n))
+}
+
+func (s *connStats) recordWrite(err error) {
+	s.lastWriteAt.Store(time.Now().UnixNano())
+	if err != nil {
+		msg := err.Error()
+		s.lastWriteErr.Store(&msg)
+	}
+}
+
+// RecordFrameIn 记录从客户端读到的一帧，由连接的读循环调用
+func (ch *ConnectionHolder) RecordFrameIn(n int) {
+	ch.stats.framesIn.Add(1)
+	ch.stats.bytesIn.Add(int64(n))
+}
+
+// ConnStats 一个设备连接的诊断信息
+type ConnStats struct {
+	UserID         int64      `json:"user_id"`
+	DeviceID       string     `json:"device_id"`
+	ConnectedAt    time.Time  `json:"connected_at"`
+	RemoteAddr     string     `json:"remote_addr"`
+	Transport      string     `json:"transport"`
+	Binary         bool       `json:"binary"`
+	Compressed     bool       `json:"compressed"`
+	QueueDepth     int        `json:"queue_depth"`
+	QueueCapacity  int        `json:"queue_capacity"`
+	WriteLatencyMs float64    `json:"write_latency_ms"`
+	Slow           bool       `json:"slow"`
+	LastWriteAt    *time.Time `json:"last_write_at,omitempty"`
+	LastWriteError string     `json:"last_write_error,omitempty"`
+	FramesIn       int64      `json:"frames_in"`
+	FramesOut      int64      `json:"frames_out"`
+	BytesIn        int64      `json:"bytes_in"`
+	BytesOut       int64      `json:"bytes_out"`
+	// PendingQueued 已分发、仍在发送队列中的消息，PendingAck 已写出、等待客户端确认的聊天消息
+	PendingQueued int `json:"pending_queued"`
+	PendingAck    int `json:"pending_ack"`
+}
+
+func (ch *ConnectionHolder) snapshot() ConnStats {
+	s := ConnStats{
+		UserID:         ch.userID,
+		DeviceID:       ch.deviceID,
+		ConnectedAt:    ch.stats.connectedAt,
+		RemoteAddr:     ch.stats.remoteAddr,
+		Transport:      ch.transport.Name(),
+		QueueDepth:     ch.queueDepth(),
+		QueueCapacity:  cap(ch.sendCh),
+		WriteLatencyMs: float64(ch.avgWriteLatency()) / float64(time.Millisecond),
+		Slow:           ch.lagging(),
+		FramesIn:       ch.stats.framesIn.Load(),
+		FramesOut:      ch.stats.framesOut.Load(),
+		BytesIn:        ch.stats.bytesIn.Load(),
+		BytesOut:       ch.stats.bytesOut.Load(),
+	}
+	if wt, ok := ch.transport.(*wsTransport); ok {
+		s.Binary, s.Compressed = wt.binary, wt.compress
+	}
+	if ns := ch.stats.lastWriteAt.Load(); ns != 0 {
+		t := time.Unix(0, ns)
+		s.LastWriteAt = &t
+	}
+	if msg := ch.stats.lastWriteErr.Load(); msg != nil {
+		s.LastWriteError = *msg
+	}
+	s.PendingQueued, s.PendingAck = ch.gw.deliveryCounts(ch.key())
+	return s
+}
+
+// UserConnStats 返回用户在本网关所有设备连接的诊断信息
+func (gw *Gateway) UserConnStats(userID int64) []ConnStats {
+	holders := gw.userHolders(userID)
+	res := make([]ConnStats, 0, len(holders))
+	for _, holder := range holders {
+		res = append(res, holder.snapshot())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].DeviceID < res[j].DeviceID })
+	return res
+}
+
+// SlowestConnections 按写出延迟（相同时按队列深度）返回最慢的 n 个连接
+func (gw *Gateway) SlowestConnections(n int) []ConnStats {
+	holders := gw.allHolders()
+	sort.Slice(holders, func(i, j int) bool {
+		li, lj := holders[i].avgWriteLatency(), holders[j].avgWriteLatency()
+		if li != lj {
+			return li > lj
+		}
+		return holders[i].queueDepth() > holders[j].queueDepth()
+	})
+	if n > len(holders) {
+		n = len(holders)
+	}
+	res := make([]ConnStats, 0, n)
+	for _, holder := range holders[:n] {
+		res = append(res, holder.snapshot())
+	}
+	return res
+}
+
+// PendingTasks 所有连接发送队列中待写出的帧总数
+func (gw *Gateway) PendingTasks() int64 {
+	return gw.totalPending.Load()
+}
+
+// WaitQueueLength 用户某个设备在 Redis 等待队列中尚未入队的消息数
+func WaitQueueLength(userID int64, deviceID string) (int64, error) {
+	return Redis.LLenWithRetry(2, waitQueueKey(connKey{userID: userID, deviceID: deviceID}))
+}
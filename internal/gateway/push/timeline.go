@@ -0,0 +1,543 @@
+package push
+
+import (
+	"GoStacker/internal/gateway/push/types"
+	"GoStacker/internal/meta/chat/group"
+	Redis "GoStacker/pkg/db/redis"
+	"GoStacker/pkg/wire"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// timelineDepartGrace 用户离开网关后仍按在线处理其 timeline 条目的时长，
+// 覆盖 send 读取在线集合到追加 timeline 之间的间隔：这段时间内追加的条目 send 可能仍认为用户在线
+const timelineDepartGrace = 10 * time.Second
+
+// timelineIndex 大群读扩散的本地索引：send 只把消息写入一次房间 timeline，网关按 大群->在线用户 索引扇出，
+// 并把索引发布到房间在线集合供 send 判断哪些成员在线
+type timelineIndex struct {
+	enabled atomic.Bool
+	mu      sync.RWMutex
+	// presenceMu 串行化在线集合的写入，避免刷新时的旧快照把刚离开的用户写回
+	presenceMu sync.Mutex
+	// roomID -> connected userIDs on this gateway
+	roomIndex map[int64]map[int64]struct{}
+	// userID -> indexed large rooms, used to unindex on disconnect
+	userRooms map[int64]map[int64]struct{}
+	// roomID -> userID -> until when entries of the room are handed back to the offline queue
+	// for a user that left this gateway
+	departed map[int64]map[int64]time.Time
+	// roomID -> last consumed timeline entry ID, kept while the room has indexed or departed users
+	lastID map[int64]string
+	// large rooms known from registry redis, refreshed periodically
+	largeRooms map[int64]struct{}
+}
+
+func (t *timelineIndex) init() {
+	t.roomIndex = make(map[int64]map[int64]struct{})
+	t.userRooms = make(map[int64]map[int64]struct{})
+	t.departed = make(map[int64]map[int64]time.Time)
+	t.lastID = make(map[int64]string)
+	t.largeRooms = make(map[int64]struct{})
+}
+
+// addLocked indexes a user in a room. A room that starts being read takes the tail resolved
+// by the caller, so entries appended once the user is reported online are not skipped.
+func (t *timelineIndex) addLocked(roomID, userID int64, tails map[int64]string) {
+	users, ok := t.roomIndex[roomID]
+	if !ok {
+		users = make(map[int64]struct{})
+		t.roomIndex[roomID] = users
+	}
+	users[userID] = struct{}{}
+	rooms, ok := t.userRooms[userID]
+	if !ok {
+		rooms = make(map[int64]struct{})
+		t.userRooms[userID] = rooms
+	}
+	rooms[roomID] = struct{}{}
+	if gone, ok := t.departed[roomID]; ok {
+		delete(gone, userID)
+		if len(gone) == 0 {
+			delete(t.departed, roomID)
+		}
+	}
+	if _, ok := t.lastID[roomID]; !ok {
+		if tail, ok := tails[roomID]; ok {
+			t.lastID[roomID] = tail
+		}
+	}
+}
+
+// removeLocked drops a user from a room; departedUntil non-zero keeps handing the room's
+// entries back to the offline queue for the user until then.
+func (t *timelineIndex) removeLocked(roomID, userID int64, departedUntil time.Time) {
+	if users, ok := t.roomIndex[roomID]; ok {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(t.roomIndex, roomID)
+		}
+	}
+	if rooms, ok := t.userRooms[userID]; ok {
+		delete(rooms, roomID)
+		if len(rooms) == 0 {
+			delete(t.userRooms, userID)
+		}
+	}
+	if !departedUntil.IsZero() {
+		gone, ok := t.departed[roomID]
+		if !ok {
+			gone = make(map[int64]time.Time)
+			t.departed[roomID] = gone
+		}
+		gone[userID] = departedUntil
+	}
+	t.forgetIdleLocked(roomID)
+}
+
+// forgetIdleLocked stops reading a room nobody on this gateway needs any more; when a member
+// connects again the room is read from the tail at that time.
+func (t *timelineIndex) forgetIdleLocked(roomID int64) {
+	if _, ok := t.roomIndex[roomID]; ok {
+		return
+	}
+	if _, ok := t.departed[roomID]; ok {
+		return
+	}
+	delete(t.lastID, roomID)
+}
+
+// pruneDepartedLocked drops departed users whose grace period ended before now.
+func (t *timelineIndex) pruneDepartedLocked(roomID int64, now time.Time) {
+	gone, ok := t.departed[roomID]
+	if !ok {
+		return
+	}
+	for uid, until := range gone {
+		if now.After(until) {
+			delete(gone, uid)
+		}
+	}
+	if len(gone) == 0 {
+		delete(t.departed, roomID)
+		t.forgetIdleLocked(roomID)
+	}
+}
+
+// resolveTails returns the current last entry ID of the rooms that are not read yet.
+func (t *timelineIndex) resolveTails(rooms []int64) map[int64]string {
+	t.mu.RLock()
+	var missing []int64
+	for _, roomID := range rooms {
+		if _, ok := t.lastID[roomID]; !ok {
+			missing = append(missing, roomID)
+		}
+	}
+	t.mu.RUnlock()
+	tails := make(map[int64]string, len(missing))
+	for _, roomID := range missing {
+		key := Redis.RoomTimelineKey(roomID)
+		id, err := Redis.XLastIDWithRetry(2, key)
+		if err != nil {
+			// streams() resolves it again on the next read
+			zap.L().Warn("timeline: resolve last id failed", zap.String("stream", key), zap.Error(err))
+			continue
+		}
+		tails[roomID] = id
+	}
+	return tails
+}
+
+func (gw *Gateway) startTimeline() {
+	interval := 30 * time.Second
+	if gw.cfg.Dispatcher.TimelineRefreshInterval > 0 {
+		interval = time.Duration(gw.cfg.Dispatcher.TimelineRefreshInterval) * time.Second
+	}
+	gw.timeline.enabled.Store(true)
+	gw.refreshTimelineIndex()
+	gw.publishPresence()
+	go gw.timelineRefreshLoop(interval)
+	go gw.timelineLoop()
+	zap.L().Info("Room timeline consumer started", zap.Duration("refresh_interval", interval))
+}
+
+// indexConnection adds a newly connected user to the local index of every large room it joined.
+// It runs before the connection is reported to the registry, so the send service only treats
+// the user as online once its rooms are read from a tail that precedes any entry meant for it.
+func (gw *Gateway) indexConnection(userID int64) {
+	tl := &gw.timeline
+	if !tl.enabled.Load() {
+		return
+	}
+	joined, err := group.QueryJoinedRooms(userID)
+	if err != nil {
+		zap.L().Warn("timeline: query joined rooms failed", zap.Int64("userID", userID), zap.Error(err))
+		return
+	}
+	tl.mu.RLock()
+	var rooms []int64
+	for _, roomID := range joined {
+		if _, ok := tl.largeRooms[roomID]; ok {
+			rooms = append(rooms, roomID)
+		}
+	}
+	tl.mu.RUnlock()
+	if len(rooms) == 0 {
+		return
+	}
+	tails := tl.resolveTails(rooms)
+
+	tl.presenceMu.Lock()
+	defer tl.presenceMu.Unlock()
+	tl.mu.Lock()
+	for _, roomID := range rooms {
+		tl.addLocked(roomID, userID, tails)
+	}
+	tl.mu.Unlock()
+	member := Redis.RoomPresenceMember(userID, gw.registry.GatewayID())
+	for _, roomID := range rooms {
+		if err := Redis.RoomPresenceAddWithRetry(2, Redis.RoomPresenceKey(roomID), member); err != nil {
+			zap.L().Warn("timeline: publish presence failed", zap.Int64("roomID", roomID), zap.Int64("userID", userID), zap.Error(err))
+		}
+	}
+}
+
+// unindexConnection removes a disconnected user from the local large-room index.
+func (gw *Gateway) unindexConnection(userID int64) {
+	tl := &gw.timeline
+	if !tl.enabled.Load() {
+		return
+	}
+	until := time.Now().Add(timelineDepartGrace)
+	tl.presenceMu.Lock()
+	defer tl.presenceMu.Unlock()
+	tl.mu.Lock()
+	rooms := make([]int64, 0, len(tl.userRooms[userID]))
+	for roomID := range tl.userRooms[userID] {
+		rooms = append(rooms, roomID)
+	}
+	for _, roomID := range rooms {
+		tl.removeLocked(roomID, userID, until)
+	}
+	tl.mu.Unlock()
+	member := Redis.RoomPresenceMember(userID, gw.registry.GatewayID())
+	for _, roomID := range rooms {
+		if err := Redis.ZRemWithRetry(2, Redis.RoomPresenceKey(roomID), member); err != nil {
+			zap.L().Warn("timeline: withdraw presence failed", zap.Int64("roomID", roomID), zap.Int64("userID", userID), zap.Error(err))
+		}
+	}
+}
+
+// publishPresence 刷新本网关索引的全部大群在线成员，未刷新的成员在 RoomPresenceTTL 后失效
+func (gw *Gateway) publishPresence() {
+	tl := &gw.timeline
+	tl.presenceMu.Lock()
+	defer tl.presenceMu.Unlock()
+	gatewayID := gw.registry.GatewayID()
+	tl.mu.RLock()
+	rooms := make(map[int64][]string, len(tl.roomIndex))
+	for roomID, users := range tl.roomIndex {
+		members := make([]string, 0, len(users))
+		for uid := range users {
+			members = append(members, Redis.RoomPresenceMember(uid, gatewayID))
+		}
+		rooms[roomID] = members
+	}
+	tl.mu.RUnlock()
+	for roomID, members := range rooms {
+		if err := Redis.RoomPresenceAddWithRetry(2, Redis.RoomPresenceKey(roomID), members...); err != nil {
+			zap.L().Warn("timeline: publish presence failed", zap.Int64("roomID", roomID), zap.Error(err))
+		}
+	}
+}
+
+// refreshTimelineIndex picks up rooms that became large and membership changes since users
+// connected. Room members are loaded outside the lock and applied to the live index, so users
+// indexed or unindexed by their connections in the meantime are kept as they are.
+func (gw *Gateway) refreshTimelineIndex() {
+	vals, err := Redis.SMembersWithRetry(2, Redis.LargeRoomsKey)
+	if err != nil {
+		zap.L().Warn("timeline: load large rooms failed", zap.Error(err))
+		return
+	}
+	tl := &gw.timeline
+
+	// users indexed before the member lists are loaded; a user missing from a fresh member
+	// list is only dropped if it was indexed earlier, i.e. it left the room
+	tl.mu.RLock()
+	before := make(map[int64]map[int64]struct{}, len(tl.roomIndex))
+	for roomID, users := range tl.roomIndex {
+		snapshot := make(map[int64]struct{}, len(users))
+		for uid := range users {
+			snapshot[uid] = struct{}{}
+		}
+		before[roomID] = snapshot
+	}
+	tl.mu.RUnlock()
+
+	large := make(map[int64]struct{}, len(vals))
+	members := make(map[int64]map[int64]struct{})
+	var localRooms []int64
+	for _, s := range vals {
+		roomID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			continue
+		}
+		large[roomID] = struct{}{}
+		ids, err := group.QueryRoomMemberIDs(roomID)
+		if err != nil {
+			zap.L().Warn("timeline: query room members failed", zap.Int64("roomID", roomID), zap.Error(err))
+			continue
+		}
+		set := make(map[int64]struct{}, len(ids))
+		local := false
+		for _, uid := range ids {
+			set[uid] = struct{}{}
+			if !local && gw.IsUserConnected(uid) {
+				local = true
+			}
+		}
+		members[roomID] = set
+		if local {
+			localRooms = append(localRooms, roomID)
+		}
+	}
+	tails := tl.resolveTails(localRooms)
+
+	now := time.Now()
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.largeRooms = large
+	for roomID, users := range tl.roomIndex {
+		if _, ok := large[roomID]; ok {
+			continue
+		}
+		for uid := range users {
+			tl.removeLocked(roomID, uid, time.Time{})
+		}
+	}
+	for roomID := range tl.departed {
+		if _, ok := large[roomID]; !ok {
+			delete(tl.departed, roomID)
+			tl.forgetIdleLocked(roomID)
+			continue
+		}
+		tl.pruneDepartedLocked(roomID, now)
+	}
+	for roomID, set := range members {
+		for uid := range before[roomID] {
+			if _, ok := set[uid]; !ok {
+				tl.removeLocked(roomID, uid, time.Time{})
+			}
+		}
+		for uid := range set {
+			// checked under the index lock: a user whose connection is removed after this
+			// point is unindexed by removeConnection afterwards
+			if gw.IsUserConnected(uid) {
+				tl.addLocked(roomID, uid, tails)
+			}
+		}
+	}
+}
+
+func (gw *Gateway) timelineRefreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	presence := time.NewTicker(Redis.RoomPresenceTTL / 3)
+	defer presence.Stop()
+	for {
+		select {
+		case <-gw.ctx.Done():
+			return
+		case <-ticker.C:
+			gw.refreshTimelineIndex()
+			gw.publishPresence()
+		case <-presence.C:
+			gw.publishPresence()
+		}
+	}
+}
+
+// timelineStreams returns the timeline streams of rooms that have local or departed members,
+// together with the last consumed entry ID of each stream.
+func (t *timelineIndex) streams() ([]string, []string, map[string]int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rooms := make([]int64, 0, len(t.roomIndex)+len(t.departed))
+	for roomID := range t.roomIndex {
+		rooms = append(rooms, roomID)
+	}
+	for roomID := range t.departed {
+		if _, ok := t.roomIndex[roomID]; !ok {
+			rooms = append(rooms, roomID)
+		}
+	}
+	streams := make([]string, 0, len(rooms))
+	ids := make([]string, 0, len(rooms))
+	stream2room := make(map[string]int64, len(rooms))
+	for _, roomID := range rooms {
+		key := Redis.RoomTimelineKey(roomID)
+		lastID, ok := t.lastID[roomID]
+		if !ok {
+			// the tail could not be resolved when the room was indexed, start from the current one
+			id, err := Redis.XLastIDWithRetry(2, key)
+			if err != nil {
+				zap.L().Warn("timeline: resolve last id failed", zap.String("stream", key), zap.Error(err))
+				continue
+			}
+			lastID = id
+			t.lastID[roomID] = id
+		}
+		streams = append(streams, key)
+		ids = append(ids, lastID)
+		stream2room[key] = roomID
+	}
+	return streams, ids, stream2room
+}
+
+func (gw *Gateway) timelineLoop() {
+	tl := &gw.timeline
+	for {
+		select {
+		case <-gw.ctx.Done():
+			zap.L().Info("timelineLoop canceled")
+			return
+		default:
+		}
+
+		streams, ids, stream2room := tl.streams()
+		if len(streams) == 0 {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		xstreams, err := Redis.XReadBlockingWithContext(gw.ctx, streams, ids, streamBatchSize, time.Second)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				zap.L().Info("timelineLoop canceled")
+				return
+			}
+			if err != redis.Nil {
+				zap.L().Error("timeline XRead error", zap.Error(err))
+				time.Sleep(100 * time.Millisecond)
+			}
+			continue
+		}
+
+		for _, xs := range xstreams {
+			roomID, ok := stream2room[xs.Stream]
+			if !ok {
+				continue
+			}
+			for _, m := range xs.Messages {
+				var msg types.PushMessage
+				if err := wire.DecodeStreamValues(m.Values, &msg); err != nil {
+					zap.L().Error("timeline: unmarshal message failed", zap.String("stream", xs.Stream), zap.Error(err))
+					continue
+				}
+				gw.fanoutTimeline(roomID, m.ID, msg)
+			}
+		}
+	}
+}
+
+// fanoutTimeline pushes a timeline entry to every local member of the room, and hands it back
+// to the offline queue for members that left before it could reach them.
+func (gw *Gateway) fanoutTimeline(roomID int64, entryID string, msg types.PushMessage) {
+	tl := &gw.timeline
+	appended := Redis.StreamIDTime(entryID)
+	tl.mu.Lock()
+	if _, ok := tl.lastID[roomID]; !ok {
+		// nobody on this gateway needs the room any more
+		tl.mu.Unlock()
+		return
+	}
+	tl.lastID[roomID] = entryID
+	targets := make([]int64, 0, len(tl.roomIndex[roomID]))
+	for uid := range tl.roomIndex[roomID] {
+		targets = append(targets, uid)
+	}
+	var gone []int64
+	for uid, until := range tl.departed[roomID] {
+		if !appended.After(until) {
+			gone = append(gone, uid)
+		}
+	}
+	// entries are read in order, departed users past their grace period no longer get any
+	tl.pruneDepartedLocked(roomID, appended)
+	tl.mu.Unlock()
+
+	clientMsg := types.ClientMessage{
+		ID:       msg.ID,
+		Type:     msg.Type,
+		RoomID:   msg.RoomID,
+		SenderID: msg.SenderID,
+		Payload:  msg.Payload,
+	}
+	for _, uid := range targets {
+		holders := gw.userHolders(uid)
+		if len(holders) == 0 {
+			// connection removed but not unindexed yet
+			gone = append(gone, uid)
+			continue
+		}
+		for _, holder := range holders {
+			// never wait for a single device: one slow member must not hold up the whole room,
+			// a full send queue is retried from the wait queue instead
+			if err := gw.enqueueHolder(holder, 0, clientMsg); err != nil {
+				if err == ErrNoConn || err == errFrameDropped {
+					continue
+				}
+				gw.insertTimelineWait(holder, clientMsg)
+			}
+		}
+	}
+	gw.handBackTimeline(roomID, clientMsg, gone)
+}
+
+// handBackTimeline 把条目退回已离开本网关的成员的离线队列。仍在其他网关上被索引的成员由那里扇出，
+// send 已写入离线队列的成员不再重复退回
+func (gw *Gateway) handBackTimeline(roomID int64, msg types.ClientMessage, uids []int64) {
+	if len(uids) == 0 {
+		return
+	}
+	elsewhere := make(map[int64]struct{})
+	members, err := Redis.RoomPresenceMembersWithRetry(2, Redis.RoomPresenceKey(roomID))
+	if err != nil {
+		zap.L().Warn("timeline: read room presence failed", zap.Int64("roomID", roomID), zap.Error(err))
+	}
+	for _, m := range members {
+		if uid, gatewayID, ok := Redis.ParseRoomPresenceMember(m); ok && gatewayID != gw.registry.GatewayID() {
+			elsewhere[uid] = struct{}{}
+		}
+	}
+	for _, uid := range uids {
+		if _, ok := elsewhere[uid]; ok {
+			continue
+		}
+		queued, err := Redis.SendQueueSIsMemberWithRetry(2, Redis.TimelineOfflineKey(msg.ID), uid)
+		if err == nil && queued {
+			continue
+		}
+		if err := gw.pushBack(msg, uid); err != nil {
+			zap.L().Error("timeline: push back for departed user failed", zap.Int64("userID", uid), zap.Int64("msgID", msg.ID), zap.Error(err))
+		}
+	}
+}
+
+func (gw *Gateway) insertTimelineWait(holder *ConnectionHolder, msg types.ClientMessage) {
+	marshaledMsg, err := json.Marshal(msg)
+	if err != nil {
+		zap.L().Error("timeline: marshal client message failed", zap.Error(err))
+		return
+	}
+	gw.InsertWaitQueue(holder.userID, holder.deviceID, string(marshaledMsg))
+}
@@ -0,0 +1,136 @@
+package push
+
+import (
+	"GoStacker/pkg/wire"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+	TransportPoll      = "poll"
+)
+
+// frameWriteTimeout 单帧写出的超时，超时视为连接失效
+const frameWriteTimeout = 10 * time.Second
+
+var errTransportClosed = errors.New("transport closed")
+
+// Transport 连接的下行通道。WebSocket、SSE 与长轮询只在这里不同，发送队列、writerLoop 与投递跟踪共用；
+// WriteFrame 与 WritePing 只由 writerLoop 调用；Shutdown 可与写入并发，
+// 用于在移除连接前告知客户端关闭原因
+type Transport interface {
+	Name() string
+	// WriteFrame 写出一条消息，返回编码后的字节数
+	WriteFrame(message interface{}) (int, error)
+	WritePing() error
+	// Shutdown 告知客户端连接将以 code 关闭：WebSocket 写关闭帧，SSE 写 close 事件，长轮询在响应中带上关闭原因
+	Shutdown(code int, reason string)
+	Close() error
+	RemoteAddr() string
+}
+
+// wsTransport 按握手协商的子协议与压缩写出 WebSocket 帧
+type wsTransport struct {
+	conn *websocket.Conn
+	// binary 为 true 时客户端通过子协议选择了 MessagePack，消息以二进制帧写出
+	binary bool
+	// compress 连接协商了 permessage-deflate，wire 统计写到 socket 的字节用于计算压缩收益
+	compress bool
+	wire     *countingConn
+	// minSize 编码后小于该字节数的帧不压缩，登记连接时按网关配置设置
+	minSize int
+}
+
+// WebSocketTransport 包装升级后的 WebSocket 连接
+func WebSocketTransport(conn *websocket.Conn) Transport {
+	t := &wsTransport{conn: conn, minSize: 1024}
+	if conn == nil {
+		return t
+	}
+	t.binary = conn.Subprotocol() == wire.SubprotocolMsgpack
+	if cc, ok := conn.NetConn().(*countingConn); ok {
+		t.compress = true
+		t.wire = cc
+		// 默认不压缩，由 WriteFrame 按帧开启
+		conn.EnableWriteCompression(false)
+	}
+	return t
+}
+
+func (t *wsTransport) Name() string {
+	return TransportWebSocket
+}
+
+// WriteFrame 按连接协商的编码写出一条消息
+func (t *wsTransport) WriteFrame(message interface{}) (int, error) {
+	t.conn.SetWriteDeadline(time.Now().Add(frameWriteTimeout))
+	var (
+		data    []byte
+		err     error
+		msgType = websocket.TextMessage
+	)
+	if t.binary {
+		data, err = wire.Marshal(message)
+		msgType = websocket.BinaryMessage
+	} else {
+		data, err = json.Marshal(message)
+	}
+	if err != nil {
+		// 编码失败不是连接问题，丢弃该消息而不关闭连接
+		zap.L().Error("frame encode failed, dropping frame", zap.Bool("binary", t.binary), zap.Error(err))
+		return 0, nil
+	}
+	if !t.compress {
+		return len(data), t.conn.WriteMessage(msgType, data)
+	}
+
+	// 只压缩足够大的批量帧，小帧压缩收益抵不过 CPU 开销
+	compress := len(data) >= t.minSize && isBatchFrame(message)
+	t.conn.EnableWriteCompression(compress)
+	before := t.wire.written.Load()
+	if err := t.conn.WriteMessage(msgType, data); err != nil {
+		return 0, err
+	}
+	if compress {
+		recordCompressed(len(data), t.wire.written.Load()-before)
+	}
+	return len(data), nil
+}
+
+func (t *wsTransport) WritePing() error {
+	return t.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(frameWriteTimeout))
+}
+
+func (t *wsTransport) Shutdown(code int, reason string) {
+	if t.conn == nil {
+		return
+	}
+	t.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second))
+}
+
+func (t *wsTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.Close()
+}
+
+func (t *wsTransport) RemoteAddr() string {
+	if t.conn == nil {
+		return ""
+	}
+	return t.conn.RemoteAddr().String()
+}
+
+// Transport 返回连接的下行通道
+func (ch *ConnectionHolder) Transport() Transport {
+	return ch.transport
+}
@@ -0,0 +1,213 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const pollMaxBatch = 100
+
+// defaultPollSessionTTL 超过该时长没有轮询请求的会话被移除，可由 dispatcher.poll_session_ttl 配置
+const defaultPollSessionTTL = 60 * time.Second
+
+var errPollNotCollected = errors.New("poll client did not collect frames in time")
+
+// PollClose 长轮询会话被关闭的原因，close code 与 WebSocket 连接相同
+type PollClose struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// PollTransport 长轮询：writerLoop 把消息放进 outbox，客户端的下一次轮询请求取走全部消息。
+// outbox 满时写入等待客户端取走，超时视为连接失效（与 WebSocket 的写超时一致），因此读得慢的
+// 客户端同样表现为发送队列积压和写出延迟。同一时刻只有一个轮询请求在等待，新请求使旧请求立即返回
+type PollTransport struct {
+	remote string
+
+	mu        sync.Mutex
+	outbox    []json.RawMessage
+	waiter    chan struct{}
+	lastPoll  time.Time
+	closeInfo *PollClose
+	closed    bool
+
+	space chan struct{}
+	once  sync.Once
+	done  chan struct{}
+}
+
+func NewPollTransport(remoteAddr string) *PollTransport {
+	return &PollTransport{
+		remote:   remoteAddr,
+		lastPoll: time.Now(),
+		space:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// OpenPollSession 建立长轮询会话，会话在 poll_session_ttl 内没有轮询请求时自动移除
+func (gw *Gateway) OpenPollSession(userID int64, deviceID string, t *PollTransport, token string) *ConnectionHolder {
+	holder := gw.OpenSession(userID, deviceID, t, token)
+	go t.expireIdle(holder, gw.pollSessionTTL)
+	return holder
+}
+
+func (t *PollTransport) Name() string {
+	return TransportPoll
+}
+
+// wakeLocked 唤醒等待中的轮询请求，调用方持有 mu
+func (t *PollTransport) wakeLocked() {
+	if t.waiter == nil {
+		return
+	}
+	select {
+	case t.waiter <- struct{}{}:
+	default:
+	}
+}
+
+func (t *PollTransport) WriteFrame(message interface{}) (int, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		zap.L().Error("frame encode failed, dropping frame", zap.String("transport", TransportPoll), zap.Error(err))
+		return 0, nil
+	}
+	deadline := time.NewTimer(frameWriteTimeout)
+	defer deadline.Stop()
+	for {
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			return 0, errTransportClosed
+		}
+		if len(t.outbox) < pollMaxBatch {
+			t.outbox = append(t.outbox, data)
+			t.wakeLocked()
+			t.mu.Unlock()
+			return len(data), nil
+		}
+		t.mu.Unlock()
+		select {
+		case <-t.space:
+		case <-t.done:
+			return 0, errTransportClosed
+		case <-deadline.C:
+			return 0, errPollNotCollected
+		}
+	}
+}
+
+// WritePing 轮询请求本身就能证明客户端在线，不需要心跳
+func (t *PollTransport) WritePing() error {
+	return nil
+}
+
+func (t *PollTransport) Shutdown(code int, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closeInfo == nil {
+		t.closeInfo = &PollClose{Code: code, Reason: reason}
+	}
+	t.wakeLocked()
+}
+
+func (t *PollTransport) Close() error {
+	t.once.Do(func() {
+		t.mu.Lock()
+		t.closed = true
+		t.wakeLocked()
+		t.mu.Unlock()
+		close(t.done)
+	})
+	return nil
+}
+
+func (t *PollTransport) RemoteAddr() string {
+	return t.remote
+}
+
+// Poll 等待到有消息、会话关闭、超过 wait 或 ctx 结束，取走 outbox 中的全部消息。
+// 会话已关闭时返回关闭原因，客户端应重新建立会话
+func (t *PollTransport) Poll(ctx context.Context, wait time.Duration) ([]json.RawMessage, *PollClose) {
+	wake := make(chan struct{}, 1)
+	t.mu.Lock()
+	// 同一会话的上一个轮询请求立即返回
+	t.wakeLocked()
+	t.waiter = wake
+	t.lastPoll = time.Now()
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		if t.waiter == wake {
+			t.waiter = nil
+		}
+		t.lastPoll = time.Now()
+		t.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		t.mu.Lock()
+		if t.waiter != wake {
+			t.mu.Unlock()
+			return nil, nil
+		}
+		if len(t.outbox) > 0 || t.closed || t.closeInfo != nil {
+			frames := t.outbox
+			t.outbox = nil
+			info := t.closeInfo
+			if info == nil && t.closed {
+				info = &PollClose{Code: websocket.CloseNormalClosure, Reason: "connection closed"}
+			}
+			t.mu.Unlock()
+			select {
+			case t.space <- struct{}{}:
+			default:
+			}
+			return frames, info
+		}
+		t.mu.Unlock()
+		select {
+		case <-wake:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// idleFor 没有轮询请求在等待的时长
+func (t *PollTransport) idleFor() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.waiter != nil {
+		return 0
+	}
+	return time.Since(t.lastPoll)
+}
+
+func (t *PollTransport) expireIdle(holder *ConnectionHolder, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+		if t.idleFor() >= ttl {
+			zap.L().Info("poll session expired", zap.Int64("userID", holder.userID), zap.String("deviceID", holder.deviceID))
+			holder.gw.RemoveHolder(holder)
+			return
+		}
+	}
+}
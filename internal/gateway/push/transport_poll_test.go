@@ -0,0 +1,168 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func pollIDs(t *testing.T, frames []json.RawMessage) []int64 {
+	t.Helper()
+	ids := make([]int64, 0, len(frames))
+	for _, f := range frames {
+		var msg struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(f, &msg); err != nil {
+			t.Fatalf("decode frame %s: %v", f, err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func TestPollReturnsQueuedFrames(t *testing.T) {
+	tr := NewPollTransport("127.0.0.1:1000")
+	for _, id := range []int64{1, 2} {
+		if _, err := tr.WriteFrame(chatMsg(id)); err != nil {
+			t.Fatalf("WriteFrame: %v", err)
+		}
+	}
+	frames, closed := tr.Poll(context.Background(), time.Second)
+	if closed != nil {
+		t.Fatalf("session reported closed: %+v", closed)
+	}
+	if ids := pollIDs(t, frames); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("polled ids %v, want [1 2]", ids)
+	}
+	// 已取走的消息不会再次返回
+	frames, _ = tr.Poll(context.Background(), 10*time.Millisecond)
+	if len(frames) != 0 {
+		t.Fatalf("second poll returned %d frames, want none", len(frames))
+	}
+}
+
+func TestPollWakesOnWrite(t *testing.T) {
+	tr := NewPollTransport("127.0.0.1:1000")
+	done := make(chan []json.RawMessage)
+	go func() {
+		frames, _ := tr.Poll(context.Background(), 5*time.Second)
+		done <- frames
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := tr.WriteFrame(chatMsg(9)); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	select {
+	case frames := <-done:
+		if ids := pollIDs(t, frames); len(ids) != 1 || ids[0] != 9 {
+			t.Fatalf("polled ids %v, want [9]", ids)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiting poll not woken by a write")
+	}
+}
+
+func TestNewPollReleasesPreviousPoll(t *testing.T) {
+	tr := NewPollTransport("127.0.0.1:1000")
+	first := make(chan []json.RawMessage)
+	go func() {
+		frames, _ := tr.Poll(context.Background(), 5*time.Second)
+		first <- frames
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	second := make(chan []json.RawMessage)
+	go func() {
+		frames, _ := tr.Poll(context.Background(), 5*time.Second)
+		second <- frames
+	}()
+	select {
+	case frames := <-first:
+		if len(frames) != 0 {
+			t.Fatalf("replaced poll took %d frames", len(frames))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("previous poll not released by the new one")
+	}
+
+	if _, err := tr.WriteFrame(chatMsg(3)); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	select {
+	case frames := <-second:
+		if ids := pollIDs(t, frames); len(ids) != 1 || ids[0] != 3 {
+			t.Fatalf("polled ids %v, want [3]", ids)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("new poll did not receive the frame")
+	}
+}
+
+func TestPollTimesOut(t *testing.T) {
+	tr := NewPollTransport("127.0.0.1:1000")
+	start := time.Now()
+	frames, closed := tr.Poll(context.Background(), 30*time.Millisecond)
+	if frames != nil || closed != nil {
+		t.Fatalf("idle poll returned frames %v, close %+v", frames, closed)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Fatal("poll returned before the wait elapsed")
+	}
+}
+
+func TestPollReportsClose(t *testing.T) {
+	tr := NewPollTransport("127.0.0.1:1000")
+	tr.Shutdown(CloseTooSlow, "too slow")
+	if _, closed := tr.Poll(context.Background(), time.Second); closed == nil || closed.Code != CloseTooSlow {
+		t.Fatalf("close = %+v, want code %d", closed, CloseTooSlow)
+	}
+
+	tr = NewPollTransport("127.0.0.1:1000")
+	tr.Close()
+	if _, closed := tr.Poll(context.Background(), time.Second); closed == nil || closed.Code != websocket.CloseNormalClosure {
+		t.Fatalf("close = %+v, want normal closure", closed)
+	}
+	if _, err := tr.WriteFrame(chatMsg(1)); err != errTransportClosed {
+		t.Fatalf("WriteFrame after close: err = %v, want errTransportClosed", err)
+	}
+}
+
+func TestFullOutboxWaitsForPoll(t *testing.T) {
+	tr := NewPollTransport("127.0.0.1:1000")
+	for i := 0; i < pollMaxBatch; i++ {
+		if _, err := tr.WriteFrame(chatMsg(int64(i + 1))); err != nil {
+			t.Fatalf("WriteFrame %d: %v", i, err)
+		}
+	}
+	written := make(chan error)
+	go func() {
+		_, err := tr.WriteFrame(chatMsg(pollMaxBatch + 1))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("write to a full outbox returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	frames, _ := tr.Poll(context.Background(), time.Second)
+	if len(frames) != pollMaxBatch {
+		t.Fatalf("polled %d frames, want %d", len(frames), pollMaxBatch)
+	}
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("blocked write failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked write not released by the poll")
+	}
+	frames, _ = tr.Poll(context.Background(), time.Second)
+	if ids := pollIDs(t, frames); len(ids) != 1 || ids[0] != pollMaxBatch+1 {
+		t.Fatalf("polled ids %v, want [%d]", ids, pollMaxBatch+1)
+	}
+}
@@ -0,0 +1,113 @@
+package push
+
+import (
+	"GoStacker/internal/gateway/push/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SSETransport 以 text/event-stream 写出消息，每条消息是一个 data 为 JSON 的 message 事件。
+// 写入由 writerLoop 与 Shutdown 共享，用 sem 串行化；Close 之后不再写 ResponseWriter，
+// 请求处理函数在 Done 之后调用 Finish 再返回
+type SSETransport struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	remote string
+
+	sem    chan struct{}
+	closed atomic.Bool
+	once   sync.Once
+	done   chan struct{}
+}
+
+// NewSSETransport 包装一个已写出响应头的 SSE 响应
+func NewSSETransport(w http.ResponseWriter, r *http.Request) *SSETransport {
+	return &SSETransport{
+		w:      w,
+		rc:     http.NewResponseController(w),
+		remote: r.RemoteAddr,
+		sem:    make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// Done 连接被关闭（写入失败、被替换、被踢下线等）时关闭，请求处理函数据此结束响应
+func (t *SSETransport) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *SSETransport) Name() string {
+	return TransportSSE
+}
+
+// write 在 timeout 内取得写入权后写出一个事件并 flush
+func (t *SSETransport) write(event string, timeout time.Duration) error {
+	select {
+	case t.sem <- struct{}{}:
+	case <-time.After(timeout):
+		return errTransportClosed
+	}
+	defer func() { <-t.sem }()
+	if t.closed.Load() {
+		return errTransportClosed
+	}
+	t.rc.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := fmt.Fprint(t.w, event); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+func (t *SSETransport) WriteFrame(message interface{}) (int, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		zap.L().Error("frame encode failed, dropping frame", zap.String("transport", TransportSSE), zap.Error(err))
+		return 0, nil
+	}
+	event := "event: message\n"
+	if m, ok := message.(types.ClientMessage); ok && m.ID > 0 {
+		event += "id: " + strconv.FormatInt(m.ID, 10) + "\n"
+	}
+	event += "data: " + string(data) + "\n\n"
+	if err := t.write(event, frameWriteTimeout); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// WritePing 写一行注释，防止中间代理因空闲断开连接
+func (t *SSETransport) WritePing() error {
+	return t.write(": ping\n\n", frameWriteTimeout)
+}
+
+func (t *SSETransport) Shutdown(code int, reason string) {
+	data, _ := json.Marshal(map[string]interface{}{"code": code, "reason": reason})
+	t.write("event: close\ndata: "+string(data)+"\n\n", time.Second)
+}
+
+// Close 不等待进行中的写入，之后的写入直接失败
+func (t *SSETransport) Close() error {
+	t.once.Do(func() {
+		t.closed.Store(true)
+		close(t.done)
+	})
+	return nil
+}
+
+// Finish 关闭连接并等待进行中的写入结束，之后请求处理函数可以返回
+func (t *SSETransport) Finish() {
+	t.Close()
+	t.sem <- struct{}{}
+	<-t.sem
+}
+
+func (t *SSETransport) RemoteAddr() string {
+	return t.remote
+}
@@ -13,6 +13,8 @@ type PushMessage struct {
 	SenderID  int64       `json:"sender_id"`
 	TargetIDs []int64     `json:"target_ids"`
 	Payload   interface{} `json:"payload"`
+	// StreamID 是该消息在网关 stream 中的条目 ID，仅在网关本地使用，所有目标确认后据此 XACK
+	StreamID string `json:"-"`
 }
 
 // UnmarshalJSON implements a tolerant unmarshaler that accepts both
@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"GoStacker/internal/gateway/push"
+	"GoStacker/pkg/config"
+	"GoStacker/pkg/response"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultPollTimeout = 25 * time.Second
+
+func pollTimeout() time.Duration {
+	if config.Conf != nil && config.Conf.GatewayDispatcherConfig != nil && config.Conf.GatewayDispatcherConfig.PollTimeout > 0 {
+		return time.Duration(config.Conf.GatewayDispatcherConfig.PollTimeout) * time.Second
+	}
+	return defaultPollTimeout
+}
+
+// PollHandler 长轮询：第一次请求建立会话，之后每次请求取走会话中积累的消息，没有消息时最多等待
+// wait 秒（默认且不超过 dispatcher.poll_timeout）。返回 closed 时会话已被关闭，客户端应重新建立会话
+func (h *Handler) PollHandler(c *gin.Context) {
+	wait := pollTimeout()
+	if v := c.Query("wait"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			response.ReplyBadRequest(c, "Invalid wait")
+			return
+		}
+		wait = min(wait, time.Duration(n)*time.Second)
+	}
+	userID := c.GetInt64("userID")
+
+	var t *push.PollTransport
+	holder, ok := h.gw.GetConnectionHolder(userID, deviceIDFromRequest(c))
+	if ok {
+		t, ok = holder.Transport().(*push.PollTransport)
+	}
+	if ok {
+		// 会话按最近一次请求所用 token 的过期时间和吊销状态处理
+		push.SetConnectionAuth(holder, connAuthFromContext(c))
+	} else {
+		deviceID, admitted := h.admitConnection(c)
+		if !admitted {
+			return
+		}
+		t = push.NewPollTransport(c.Request.RemoteAddr)
+		holder = h.gw.OpenPollSession(userID, deviceID, t, resumeTokenFromRequest(c))
+		push.SetConnectionAuth(holder, connAuthFromContext(c))
+	}
+
+	frames, closed := t.Poll(c.Request.Context(), wait)
+	if frames == nil {
+		frames = []json.RawMessage{}
+	}
+	data := gin.H{"messages": frames}
+	if closed != nil {
+		data["closed"] = closed
+	}
+	response.ReplySuccessWithData(c, "success", data)
+}
+
+// AckHandler SSE 与长轮询客户端确认收到的聊天消息，与 WebSocket 的 ack 帧相同
+func (h *Handler) AckHandler(c *gin.Context) {
+	var d ackFrameData
+	if err := c.ShouldBindJSON(&d); err != nil || len(d.IDs) == 0 {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	acked := h.gw.AckDelivered(c.GetInt64("userID"), deviceIDFromRequest(c), d.IDs)
+	response.ReplySuccessWithData(c, "success", gin.H{"acked": acked})
+}
@@ -0,0 +1,362 @@
+package ws
+
+import (
+	"GoStacker/internal/gateway/centerclient"
+	"GoStacker/internal/gateway/push"
+	"GoStacker/pkg/revocation"
+	"GoStacker/pkg/utils"
+	"GoStacker/pkg/wire"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// 客户端请求帧的 op
+const (
+	OpSend   = "send"
+	OpAck    = "ack"
+	OpTyping = "typing"
+	OpRead   = "read"
+	OpSync   = "sync"
+	OpPing   = "ping"
+	OpPong   = "pong"
+	OpError  = "error"
+
+	OpPresence      = "presence"
+	OpPresenceSub   = "presence_sub"
+	OpPresenceUnsub = "presence_unsub"
+	OpAuth          = "auth"
+)
+
+const (
+	// maxFrameSize 单个客户端帧的上限，需容纳最大的加密消息
+	maxFrameSize = 512 * 1024
+	// maxInFlightFrames 每个连接排队等待处理的请求帧上限
+	maxInFlightFrames = 64
+	replyWait         = 5 * time.Second
+)
+
+// ClientFrame 是客户端通过 WebSocket 发来的请求帧，ReqID 由客户端生成，原样带回响应
+type ClientFrame struct {
+	Op    string          `json:"op"`
+	ReqID string          `json:"req_id"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// binaryClientFrame 是 MessagePack 编码的请求帧，data 解出后转成 JSON 交给与文本帧相同的处理逻辑
+type binaryClientFrame struct {
+	Op    string      `json:"op"`
+	ReqID string      `json:"req_id"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// ServerFrame 是请求帧的响应。服务端主动推送的消息带 type 字段，响应帧带 op 字段，客户端据此区分
+type ServerFrame struct {
+	Op    string      `json:"op"`
+	ReqID string      `json:"req_id,omitempty"`
+	OK    bool        `json:"ok"`
+	Code  int         `json:"code,omitempty"`
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Compressible sync 响应携带一批消息，协商了压缩时按 batch 帧处理
+func (f ServerFrame) Compressible() bool {
+	return f.Op == OpSync
+}
+
+type sendFrameData struct {
+	RoomID  int64           `json:"room_id"`
+	Content json.RawMessage `json:"content"`
+}
+
+type ackFrameData struct {
+	IDs []int64 `json:"ids"`
+}
+
+type roomFrameData struct {
+	RoomID int64 `json:"room_id"`
+}
+
+type readFrameData struct {
+	RoomID int64 `json:"room_id"`
+	MsgID  int64 `json:"msg_id"`
+}
+
+type syncFrameData struct {
+	RoomID  int64 `json:"room_id"`
+	AfterID int64 `json:"after_id"`
+	Limit   int   `json:"limit"`
+}
+
+type authFrameData struct {
+	Token string `json:"token"`
+}
+
+type presenceFrameData struct {
+	State string `json:"state"`
+}
+
+type presenceSubFrameData struct {
+	UserIDs []int64 `json:"user_ids"`
+	RoomID  int64   `json:"room_id"`
+}
+
+var errBadFrame = errors.New("invalid frame data")
+
+// clientSession 是一个连接上的请求处理状态。请求帧由单独的 goroutine 按到达顺序处理，
+// 保证同一连接上 send 帧的先后顺序，也不阻塞读循环对心跳的响应。
+type clientSession struct {
+	gw       *push.Gateway
+	userID   int64
+	deviceID string
+	conn     *websocket.Conn
+	// binary 连接协商了 MessagePack 子协议
+	binary bool
+	frames chan ClientFrame
+}
+
+func newClientSession(gw *push.Gateway, userID int64, deviceID string, conn *websocket.Conn) *clientSession {
+	s := &clientSession{
+		gw:       gw,
+		userID:   userID,
+		deviceID: deviceID,
+		conn:     conn,
+		binary:   conn.Subprotocol() == wire.SubprotocolMsgpack,
+		frames:   make(chan ClientFrame, maxInFlightFrames),
+	}
+	go s.serve()
+	return s
+}
+
+// close 在读循环退出后调用，处理完已排队的帧后 serve 退出
+func (s *clientSession) close() {
+	close(s.frames)
+}
+
+// handleFrame 在读循环中调用：ping 直接应答，其余请求排队处理。binary 表示 MessagePack 编码的二进制帧
+func (s *clientSession) handleFrame(raw []byte, binary bool) {
+	f, err := decodeClientFrame(raw, binary)
+	if err != nil || f.Op == "" {
+		s.reply(ServerFrame{Op: OpError, Code: http.StatusBadRequest, Error: "malformed frame"})
+		return
+	}
+	if f.Op == OpPing {
+		s.reply(ServerFrame{Op: OpPong, ReqID: f.ReqID, OK: true, Data: gin.H{"ts": time.Now().UnixMilli()}})
+		return
+	}
+	select {
+	case s.frames <- f:
+	default:
+		s.reply(errorFrame(f, http.StatusTooManyRequests, "too many pending requests"))
+	}
+}
+
+func (s *clientSession) serve() {
+	for f := range s.frames {
+		s.reply(s.process(f))
+	}
+}
+
+func (s *clientSession) process(f ClientFrame) ServerFrame {
+	var (
+		data interface{}
+		err  error
+	)
+	switch f.Op {
+	case OpSend:
+		data, err = s.forwardSend(f.Data)
+	case OpAck:
+		data, err = s.handleAck(f.Data)
+	case OpTyping:
+		var d roomFrameData
+		if err = decodeFrameData(f.Data, &d); err == nil && d.RoomID == 0 {
+			err = errBadFrame
+		}
+		if err == nil {
+			err = s.gw.CallSend("/internal/chat/typing", gin.H{"user_id": s.userID, "room_id": d.RoomID}, nil)
+		}
+	case OpRead:
+		var d readFrameData
+		if err = decodeFrameData(f.Data, &d); err == nil && (d.RoomID == 0 || d.MsgID == 0) {
+			err = errBadFrame
+		}
+		if err == nil {
+			var res json.RawMessage
+			err = s.gw.CallSend("/internal/chat/read", gin.H{"user_id": s.userID, "room_id": d.RoomID, "msg_id": d.MsgID}, &res)
+			data = res
+		}
+	case OpSync:
+		var d syncFrameData
+		if err = decodeFrameData(f.Data, &d); err == nil && d.RoomID == 0 {
+			err = errBadFrame
+		}
+		if err == nil {
+			var res json.RawMessage
+			err = s.gw.CallSend("/internal/chat/sync", gin.H{"user_id": s.userID, "room_id": d.RoomID, "after_id": d.AfterID, "limit": d.Limit}, &res)
+			data = res
+		}
+	case OpPresence:
+		var d presenceFrameData
+		if err = decodeFrameData(f.Data, &d); err == nil && d.State == "" {
+			err = errBadFrame
+		}
+		if err == nil {
+			var res json.RawMessage
+			err = s.gw.CallSend("/internal/presence/set", gin.H{"user_id": s.userID, "state": d.State}, &res)
+			data = res
+		}
+	case OpPresenceSub:
+		var d presenceSubFrameData
+		if err = decodeFrameData(f.Data, &d); err == nil && len(d.UserIDs) == 0 && d.RoomID == 0 {
+			err = errBadFrame
+		}
+		if err == nil {
+			var res json.RawMessage
+			err = s.gw.CallSend("/internal/presence/subscribe", gin.H{"user_id": s.userID, "user_ids": d.UserIDs, "room_id": d.RoomID}, &res)
+			data = res
+		}
+	case OpPresenceUnsub:
+		// 不带 data 时取消全部订阅
+		var d presenceSubFrameData
+		if len(f.Data) > 0 {
+			err = decodeFrameData(f.Data, &d)
+		}
+		if err == nil {
+			err = s.gw.CallSend("/internal/presence/unsubscribe", gin.H{"user_id": s.userID, "user_ids": d.UserIDs, "room_id": d.RoomID}, nil)
+		}
+	case OpAuth:
+		data, err = s.refreshAuth(f.Data)
+	default:
+		return errorFrame(f, http.StatusBadRequest, "unknown op: "+f.Op)
+	}
+	if err != nil {
+		return frameFromError(f, err)
+	}
+	return ServerFrame{Op: f.Op, ReqID: f.ReqID, OK: true, Data: data}
+}
+
+// forwardSend 把 send 帧转发给 registry 选出的 send 实例，返回生成的 msg_id
+func (s *clientSession) forwardSend(raw json.RawMessage) (interface{}, error) {
+	var d sendFrameData
+	if err := decodeFrameData(raw, &d); err != nil {
+		return nil, err
+	}
+	if d.RoomID == 0 || len(d.Content) == 0 {
+		return nil, errBadFrame
+	}
+	var res struct {
+		MsgID int64 `json:"msg_id"`
+	}
+	err := s.gw.CallSend("/internal/chat/send_message", gin.H{"user_id": s.userID, "room_id": d.RoomID, "content": d.Content}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{"msg_id": res.MsgID}, nil
+}
+
+// handleAck 接收客户端对已收到消息的确认，全部目标确认后对应的 stream 条目才会被 XACK
+func (s *clientSession) handleAck(raw json.RawMessage) (interface{}, error) {
+	var d ackFrameData
+	if err := decodeFrameData(raw, &d); err != nil {
+		return nil, err
+	}
+	if len(d.IDs) == 0 {
+		return nil, errBadFrame
+	}
+	return gin.H{"acked": s.gw.AckDelivered(s.userID, s.deviceID, d.IDs)}, nil
+}
+
+// refreshAuth 在连接上换用新 token，连接之后按新 token 的过期时间和吊销状态处理
+func (s *clientSession) refreshAuth(raw json.RawMessage) (interface{}, error) {
+	var d authFrameData
+	if err := decodeFrameData(raw, &d); err != nil {
+		return nil, err
+	}
+	if d.Token == "" {
+		return nil, errBadFrame
+	}
+	claims, err := utils.ParseToken(d.Token)
+	if err != nil {
+		return nil, &centerclient.SendError{Code: http.StatusUnauthorized, Msg: "invalid token"}
+	}
+	if claims.UserID != s.userID {
+		return nil, &centerclient.SendError{Code: http.StatusUnauthorized, Msg: push.ErrTokenUserMismatch.Error()}
+	}
+	if err := revocation.Check(claims); errors.Is(err, revocation.ErrRevoked) {
+		return nil, &centerclient.SendError{Code: http.StatusUnauthorized, Msg: err.Error()}
+	}
+	a := connAuthFromClaims(claims)
+	if err := s.gw.RefreshConnectionAuth(s.userID, s.deviceID, s.conn, a); err != nil {
+		return nil, err
+	}
+	res := gin.H{}
+	if !a.ExpiresAt.IsZero() {
+		res["expires_at"] = a.ExpiresAt.Unix()
+	}
+	return res, nil
+}
+
+func (s *clientSession) reply(frame ServerFrame) {
+	if raw, ok := frame.Data.(json.RawMessage); ok && s.binary {
+		// send 服务返回的 JSON 原样转发给文本帧客户端，二进制客户端需要先展开
+		data, err := wire.FromJSON(raw)
+		if err != nil {
+			frame = ServerFrame{Op: frame.Op, ReqID: frame.ReqID, Code: http.StatusBadGateway, Error: "invalid upstream response"}
+		} else {
+			frame.Data = data
+		}
+	}
+	if err := s.gw.WriteToConn(s.userID, s.conn, replyWait, frame); err != nil && err != push.ErrNoConn {
+		zap.L().Warn("write frame reply failed", zap.Int64("userID", s.userID), zap.String("op", frame.Op), zap.Error(err))
+	}
+}
+
+func decodeClientFrame(raw []byte, binary bool) (ClientFrame, error) {
+	var f ClientFrame
+	if !binary {
+		err := json.Unmarshal(raw, &f)
+		return f, err
+	}
+	var bf binaryClientFrame
+	if err := wire.Unmarshal(raw, &bf); err != nil {
+		return f, err
+	}
+	data, err := wire.ToJSON(bf.Data)
+	if err != nil {
+		return f, err
+	}
+	return ClientFrame{Op: bf.Op, ReqID: bf.ReqID, Data: data}, nil
+}
+
+func decodeFrameData(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return errBadFrame
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return errBadFrame
+	}
+	return nil
+}
+
+func errorFrame(f ClientFrame, code int, msg string) ServerFrame {
+	return ServerFrame{Op: f.Op, ReqID: f.ReqID, Code: code, Error: msg}
+}
+
+// frameFromError 保留 send 服务返回的错误码（400/401 等），其余视为上游不可用
+func frameFromError(f ClientFrame, err error) ServerFrame {
+	if errors.Is(err, errBadFrame) {
+		return errorFrame(f, http.StatusBadRequest, err.Error())
+	}
+	var se *centerclient.SendError
+	if errors.As(err, &se) {
+		return errorFrame(f, se.Code, se.Msg)
+	}
+	zap.L().Warn("forward client frame failed", zap.String("op", f.Op), zap.Error(err))
+	return errorFrame(f, http.StatusBadGateway, "send service unavailable")
+}
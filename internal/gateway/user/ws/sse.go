@@ -0,0 +1,61 @@
+package ws
+
+import (
+	"GoStacker/internal/gateway/push"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 部分企业代理会拦截 WebSocket 升级，这类客户端改用 SSE（GET /api/events）或长轮询（GET /api/poll）
+// 接收消息。两者与 WebSocket 连接一样登记在 push 的设备连接中，分发、离线回退、registry 上报都不区分
+// 传输方式；上行的确认通过 POST /api/ack 发送，发消息等操作走 send 服务的 HTTP 接口。
+
+// ssePingPeriod SSE 注释行心跳的间隔，短于常见代理的空闲超时
+const ssePingPeriod = 15 * time.Second
+
+// SSEHandler 以 text/event-stream 推送消息。EventSource 无法设置请求头，认证可使用 ?ticket=
+func (h *Handler) SSEHandler(c *gin.Context) {
+	deviceID, ok := h.admitConnection(c)
+	if !ok {
+		return
+	}
+	userID := c.GetInt64("userID")
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 关闭 nginx 等反向代理的响应缓冲
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	t := push.NewSSETransport(c.Writer, c.Request)
+	holder := h.gw.OpenSession(userID, deviceID, t, resumeTokenFromRequest(c))
+	// token 过期或被吊销时网关主动关闭连接
+	push.SetConnectionAuth(holder, connAuthFromContext(c))
+
+	ticker := time.NewTicker(ssePingPeriod)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			break loop
+		case <-t.Done():
+			break loop
+		case <-ticker.C:
+			if err := t.WritePing(); err != nil {
+				zap.L().Info("SSE ping failed, closing stream", zap.Int64("userID", userID), zap.Error(err))
+				break loop
+			}
+		}
+	}
+	h.gw.RemoveHolder(holder)
+	// 响应结束后不能再写 ResponseWriter
+	t.Finish()
+	zap.L().Info("SSE stream closed", zap.Int64("userID", userID), zap.String("deviceID", deviceID))
+}
@@ -0,0 +1,187 @@
+package ws
+
+import (
+	"GoStacker/internal/gateway/push"
+	"GoStacker/pkg/config"
+	Redis "GoStacker/pkg/db/redis"
+	"GoStacker/pkg/middleware"
+	"GoStacker/pkg/response"
+	"GoStacker/pkg/revocation"
+	"GoStacker/pkg/utils"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// 浏览器无法在 WebSocket 握手中设置 Authorization 头：客户端先用 JWT 换取一次性票据，
+// 再以 ?ticket= 发起握手。票据存放在 Redis 中，任意网关都可兑换，兑换即删除。
+
+const (
+	ticketKeyPrefix  = "ws:ticket:"
+	defaultTicketTTL = 30 * time.Second
+)
+
+type ticketRequest struct {
+	// BindIP 为 true 时票据只能从签发时的客户端 IP 兑换
+	BindIP bool `json:"bind_ip"`
+}
+
+// wsTicket 保存签发票据所用 token 的身份信息，连接沿用该 token 的过期时间并受其吊销影响
+type wsTicket struct {
+	UserID    int64  `json:"uid"`
+	UserName  string `json:"username"`
+	JTI       string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IP        string `json:"ip,omitempty"`
+}
+
+func (t wsTicket) claims() *utils.JWTClaims {
+	claims := &utils.JWTClaims{UserName: t.UserName, UserID: t.UserID}
+	claims.ID = t.JTI
+	if t.IssuedAt > 0 {
+		claims.IssuedAt = jwt.NewNumericDate(time.Unix(t.IssuedAt, 0))
+	}
+	if t.ExpiresAt > 0 {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Unix(t.ExpiresAt, 0))
+	}
+	return claims
+}
+
+func ticketTTL() time.Duration {
+	if config.Conf != nil && config.Conf.GatewayDispatcherConfig != nil && config.Conf.GatewayDispatcherConfig.TicketTTL > 0 {
+		return time.Duration(config.Conf.GatewayDispatcherConfig.TicketTTL) * time.Second
+	}
+	return defaultTicketTTL
+}
+
+// TicketHandler 为已通过 JWT 认证的用户签发一次性的 WebSocket 握手票据
+func TicketHandler(c *gin.Context) {
+	var req ticketRequest
+	// body 可以为空
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.ReplyBadRequest(c, "Invalid request")
+			return
+		}
+	}
+	t := wsTicket{UserID: c.GetInt64("userID"), UserName: c.GetString("username")}
+	if claims, ok := claimsFromContext(c); ok {
+		t.JTI = claims.ID
+		if claims.IssuedAt != nil {
+			t.IssuedAt = claims.IssuedAt.Unix()
+		}
+		if claims.ExpiresAt != nil {
+			t.ExpiresAt = claims.ExpiresAt.Unix()
+		}
+	}
+	if req.BindIP {
+		t.IP = c.ClientIP()
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		response.ReplyError500(c, "Failed to issue ticket")
+		return
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		response.ReplyError500(c, "Failed to issue ticket")
+		return
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(buf)
+	ttl := ticketTTL()
+	if err := Redis.SetEXWithRetry(2, ticketKeyPrefix+ticket, data, ttl); err != nil {
+		zap.L().Error("Failed to store ws ticket", zap.Int64("user_id", t.UserID), zap.Error(err))
+		response.ReplyError500(c, "Failed to issue ticket")
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{
+		"ticket":     ticket,
+		"expires_in": int(ttl.Seconds()),
+	})
+}
+
+// redeemTicket 兑换票据，票据不存在、已使用、过期或 IP 不符时返回 false
+func redeemTicket(ticket, clientIP string) (wsTicket, bool) {
+	var t wsTicket
+	raw, err := Redis.GetDelWithRetry(2, ticketKeyPrefix+ticket)
+	if err != nil {
+		if err != goredis.Nil {
+			zap.L().Warn("Failed to redeem ws ticket", zap.Error(err))
+		}
+		return t, false
+	}
+	if err := json.Unmarshal([]byte(raw), &t); err != nil || t.UserID == 0 {
+		return t, false
+	}
+	if t.IP != "" && t.IP != clientIP {
+		zap.L().Warn("ws ticket redeemed from a different ip",
+			zap.Int64("user_id", t.UserID),
+			zap.String("bound_ip", t.IP),
+			zap.String("client_ip", clientIP))
+		return t, false
+	}
+	return t, true
+}
+
+// AuthMiddleware 握手认证：带 ticket 查询参数时兑换票据，否则按 Authorization 头校验 JWT
+func AuthMiddleware() gin.HandlerFunc {
+	jwtAuth := middleware.JWTAuthMiddleware()
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			jwtAuth(c)
+			return
+		}
+		t, ok := redeemTicket(ticket, c.ClientIP())
+		if !ok {
+			response.ReplyUnauthorized(c, "Invalid or expired ticket")
+			c.Abort()
+			return
+		}
+		claims := t.claims()
+		if err := revocation.Check(claims); errors.Is(err, revocation.ErrRevoked) {
+			response.ReplyUnauthorized(c, "Token has been revoked")
+			c.Abort()
+			return
+		}
+		c.Set("userID", t.UserID)
+		c.Set("username", t.UserName)
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+func claimsFromContext(c *gin.Context) (*utils.JWTClaims, bool) {
+	v, ok := c.Get("claims")
+	if !ok {
+		return nil, false
+	}
+	claims, ok := v.(*utils.JWTClaims)
+	return claims, ok
+}
+
+func connAuthFromClaims(claims *utils.JWTClaims) push.ConnAuth {
+	a := push.ConnAuth{JTI: claims.ID}
+	if claims.IssuedAt != nil {
+		a.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		a.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return a
+}
+
+func connAuthFromContext(c *gin.Context) push.ConnAuth {
+	if claims, ok := claimsFromContext(c); ok {
+		return connAuthFromClaims(claims)
+	}
+	return push.ConnAuth{}
+}
@@ -3,8 +3,12 @@ package ws
 import (
 	"GoStacker/internal/gateway/push"
 	"GoStacker/internal/gateway/push/types"
+	"GoStacker/pkg/wire"
+	"math"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,23 +17,77 @@ import (
 	"go.uber.org/zap"
 )
 
+// deviceIDPattern 限制客户端自报的设备标识，避免任意字符串进入路由表
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	// 客户端通过 Sec-WebSocket-Protocol 选择编码，未声明时使用 JSON 文本帧
+	Subprotocols: []string{wire.SubprotocolMsgpack, wire.SubprotocolJSON},
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
-func WebSocketHandler(c *gin.Context) {
+// Handler 客户端连接的处理函数，连接登记在 gw 上
+type Handler struct {
+	gw *push.Gateway
+}
+
+func NewHandler(gw *push.Gateway) *Handler {
+	return &Handler{gw: gw}
+}
+
+// admitConnection 建立连接前的检查，WebSocket、SSE 与长轮询共用；不通过时已写出响应，返回 false
+func (h *Handler) admitConnection(c *gin.Context) (string, bool) {
+	// 排空中的网关不再接受新连接，客户端应向 registry 重新获取网关
+	if h.gw.IsDraining() {
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return "", false
+	}
 	//获取当前dispatcher的负载情况，选择性拒绝连接
-	if push.GetConnectionCount() >= push.MaxConnections {
-		zap.L().Warn("Max connections reached, rejecting WebSocket upgrade")
+	if h.gw.GetConnectionCount() >= h.gw.MaxConnections() {
+		zap.L().Warn("Max connections reached, rejecting connection", zap.String("path", c.FullPath()))
 		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return "", false
+	}
+
+	// 同一用户的多个设备（手机、桌面等）以 device_id 区分，可以同时在线
+	deviceID := deviceIDFromRequest(c)
+	if !deviceIDPattern.MatchString(deviceID) {
+		zap.L().Warn("Invalid device id in connection request", zap.String("device_id", deviceID))
+		c.AbortWithStatus(http.StatusBadRequest)
+		return "", false
+	}
+
+	// 被管理员踢下线的用户在禁止期内不能重连
+	if uid, ok := c.Get("userID"); ok {
+		if remaining, reason := push.KickBlocked(uid.(int64), deviceID); remaining > 0 {
+			zap.L().Info("Rejecting reconnect of kicked user", zap.Int64("userID", uid.(int64)), zap.String("deviceID", deviceID), zap.String("reason", reason))
+			c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(remaining.Seconds())), 10))
+			c.AbortWithStatus(http.StatusForbidden)
+			return "", false
+		}
+	}
+	return deviceID, true
+}
+
+func (h *Handler) WebSocketHandler(c *gin.Context) {
+	deviceID, ok := h.admitConnection(c)
+	if !ok {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	// 压缩为可选项：只有配置开启且客户端请求 permessage-deflate 时才协商，
+	// 包装 writer 以统计连接写出的字节
+	up := upgrader
+	var w http.ResponseWriter = c.Writer
+	if h.gw.CompressionEnabled() && push.ClientOffersDeflate(c.Request) {
+		up.EnableCompression = true
+		w = push.CountingResponseWriter(c.Writer)
+	}
+	conn, err := up.Upgrade(w, c.Request, nil)
 	if err != nil {
 		zap.L().Error("Failed to upgrade to WebSocket", zap.Error(err))
 		return
@@ -41,10 +99,13 @@ func WebSocketHandler(c *gin.Context) {
 		return
 	}
 	userIDInt64 := userID.(int64)
-	push.RegisterConnection(userIDInt64, conn)
-	// User connection is now reported to Registry via push.RegisterConnection
+	// 带 resume token 重连时先补发上一个连接未确认的消息
+	holder := h.gw.OpenSession(userIDInt64, deviceID, push.WebSocketTransport(conn), resumeTokenFromRequest(c))
+	// token 过期或被吊销时网关主动关闭连接
+	push.SetConnectionAuth(holder, connAuthFromContext(c))
+	// User connection is now reported to Registry via Gateway.RegisterConnection
 
-	push.PushViaWS(userIDInt64, 10*time.Second, types.ClientMessage{
+	push.WriteJSONSafe(holder, 10*time.Second, types.ClientMessage{
 		ID:       -1,
 		Type:     "system",
 		RoomID:   -1,
@@ -72,10 +133,10 @@ func WebSocketHandler(c *gin.Context) {
 		defer ticker.Stop()
 
 		for range ticker.C {
-			holder, ok := push.GetConnectionHolder(userIDInt64)
-			if !ok {
-				zap.L().Warn("Connection holder not found during heartbeat", zap.Int64("userID", userIDInt64))
-				continue
+			current, ok := h.gw.GetConnectionHolder(userIDInt64, deviceID)
+			if !ok || current != holder {
+				zap.L().Info("Connection replaced or closed, stopping heartbeat", zap.Int64("userID", userIDInt64), zap.String("deviceID", deviceID))
+				return
 			}
 
 			err := push.WriteJSONSafe(holder, writeWait, websocket.PingMessage)
@@ -91,15 +152,20 @@ func WebSocketHandler(c *gin.Context) {
 				err = push.WriteJSONSafe(holder, writeWait, websocket.PingMessage)
 				if err != nil {
 					zap.L().Error("Ping retry failed, removing connection", zap.Int64("userID", userIDInt64), zap.Error(err))
-					push.RemoveConnection(userIDInt64)
+					h.gw.RemoveConnection(userIDInt64, deviceID, conn)
 					return
 				}
 			}
 		}
 	}()
+	// client request frames (send/ack/typing/read/sync/ping), replies go back on this socket
+	conn.SetReadLimit(maxFrameSize)
+	session := newClientSession(h.gw, userIDInt64, deviceID, conn)
+	defer session.close()
+
 	// read loop: classify errors so transient issues (like timeouts) don't always log as fatal
 	for {
-		_, _, err := conn.ReadMessage()
+		msgType, data, err := conn.ReadMessage()
 		if err != nil {
 			// websocket close errors from the peer
 			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
@@ -131,8 +197,36 @@ func WebSocketHandler(c *gin.Context) {
 			zap.L().Error("Failed to read WebSocket message", zap.Int64("userID", userIDInt64), zap.Error(err))
 			break
 		}
+		// any client frame proves the connection is alive
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		holder.RecordFrameIn(len(data))
+		switch msgType {
+		case websocket.TextMessage:
+			session.handleFrame(data, false)
+		case websocket.BinaryMessage:
+			session.handleFrame(data, true)
+		}
+	}
+	h.gw.RemoveConnection(userIDInt64, deviceID, conn)
+	// User disconnection is now reported to Registry via Gateway.RemoveConnection
+	zap.L().Info("WebSocket connection closed", zap.Int64("userID", userIDInt64), zap.String("deviceID", deviceID))
+}
+
+// deviceIDFromRequest 从握手的 device_id 查询参数或 X-Device-ID 头读取设备标识，未提供时视为默认设备
+func deviceIDFromRequest(c *gin.Context) string {
+	if id := c.Query("device_id"); id != "" {
+		return id
+	}
+	if id := c.GetHeader("X-Device-ID"); id != "" {
+		return id
+	}
+	return push.DefaultDeviceID
+}
+
+// resumeTokenFromRequest 重连时携带的 resume token：查询参数 resume 或 X-Resume-Token 头
+func resumeTokenFromRequest(c *gin.Context) string {
+	if token := c.Query("resume"); token != "" {
+		return token
 	}
-	push.RemoveConnection(userIDInt64)
-	// User disconnection is now reported to Registry via push.RemoveConnection
-	zap.L().Info("WebSocket connection closed", zap.Int64("userID", userIDInt64))
+	return c.GetHeader("X-Resume-Token")
 }
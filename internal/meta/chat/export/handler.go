@@ -0,0 +1,114 @@
+package export
+
+import (
+	"GoStacker/pkg/response"
+	"errors"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateExportRequest struct {
+	RoomID int64  `json:"room_id" binding:"required"`
+	From   int64  `json:"from"` // unix 秒，0 表示不限
+	To     int64  `json:"to"`
+	Format string `json:"format" binding:"required"`
+}
+
+func CreateExportHandler(c *gin.Context) {
+	var req CreateExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	job, err := CreateJob(userID.(int64), req.RoomID, req.From, req.To, req.Format)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoPermission):
+			response.ReplyUnauthorized(c, err.Error())
+		case errors.Is(err, ErrQueueFull):
+			response.ReplyError500(c, err.Error())
+		default:
+			response.ReplyBadRequest(c, err.Error())
+		}
+		return
+	}
+	response.ReplySuccessWithData(c, "Export job created", gin.H{"job_id": job.ID})
+}
+
+func ExportStatusHandler(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	job, err := GetJob(userID.(int64), c.Query("job_id"))
+	if err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			response.ReplyNotFound(c, err.Error())
+			return
+		}
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	data := gin.H{"job": job}
+	if job.Status == StatusDone {
+		data["download_url"] = "/api/chat/export/download?job_id=" + url.QueryEscape(job.ID)
+	}
+	response.ReplySuccessWithData(c, "success", data)
+}
+
+func ExportDownloadHandler(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	job, err := GetJob(userID.(int64), c.Query("job_id"))
+	if err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			response.ReplyNotFound(c, err.Error())
+			return
+		}
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	if job.Status != StatusDone || job.File == "" {
+		response.ReplyBadRequest(c, "export is not finished")
+		return
+	}
+	if _, err := os.Stat(job.File); err != nil {
+		// the file was written by another instance and the export dir is not shared
+		if forwardDownload(c, job) {
+			return
+		}
+		response.ReplyNotFound(c, "export file not found")
+		return
+	}
+	c.FileAttachment(job.File, filepath.Base(job.File))
+}
+
+// forwardedHeader 标记已转发过的下载请求，避免实例之间来回转发
+const forwardedHeader = "X-Export-Forwarded"
+
+// forwardDownload 把下载请求原样（包括用户的 token）转发给写出文件的实例
+func forwardDownload(c *gin.Context, job *Job) bool {
+	if job.Owner == "" || job.Owner == advertiseURL || c.GetHeader(forwardedHeader) != "" {
+		return false
+	}
+	target, err := url.Parse(job.Owner)
+	if err != nil {
+		return false
+	}
+	c.Request.Header.Set(forwardedHeader, "1")
+	httputil.NewSingleHostReverseProxy(target).ServeHTTP(c.Writer, c.Request)
+	return true
+}
@@ -0,0 +1,151 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"time"
+)
+
+// exportRecord 是导出文件中的一条消息
+type exportRecord struct {
+	ID         int64           `json:"id"`
+	SenderID   int64           `json:"sender_id"`
+	SenderName string          `json:"sender_name"`
+	Type       string          `json:"type"`
+	Text       string          `json:"text"`
+	Content    json.RawMessage `json:"content"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// renderer 以流的方式写出导出文件
+type renderer interface {
+	Begin() error
+	Write(r exportRecord) error
+	End() error
+}
+
+func newRenderer(format string, w io.Writer, title string) (renderer, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlRenderer{enc: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return &csvRenderer{w: csv.NewWriter(w)}, nil
+	case FormatHTML:
+		return &htmlRenderer{w: w, title: title}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+type jsonlRenderer struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlRenderer) Begin() error { return nil }
+
+func (j *jsonlRenderer) Write(r exportRecord) error { return j.enc.Encode(r) }
+
+func (j *jsonlRenderer) End() error { return nil }
+
+type csvRenderer struct {
+	w *csv.Writer
+}
+
+func (c *csvRenderer) Begin() error {
+	return c.w.Write([]string{"id", "created_at", "sender_id", "sender_name", "type", "text"})
+}
+
+func (c *csvRenderer) Write(r exportRecord) error {
+	return c.w.Write([]string{
+		strconv.FormatInt(r.ID, 10),
+		r.CreatedAt.Format(time.RFC3339),
+		strconv.FormatInt(r.SenderID, 10),
+		r.SenderName,
+		r.Type,
+		r.Text,
+	})
+}
+
+func (c *csvRenderer) End() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// htmlRenderer 输出单个自包含的 HTML 文件（内联样式，无外部资源）
+type htmlRenderer struct {
+	w     io.Writer
+	title string
+}
+
+const htmlHead = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>%s</title>
+<style>
+body{font-family:-apple-system,"Segoe UI",Helvetica,Arial,sans-serif;margin:24px;color:#222}
+h1{font-size:20px}
+table{border-collapse:collapse;width:100%%}
+th,td{border-bottom:1px solid #e5e5e5;padding:6px 8px;text-align:left;vertical-align:top;font-size:14px}
+th{background:#f6f6f6}
+td.time{white-space:nowrap;color:#666}
+td.text{white-space:pre-wrap;word-break:break-word}
+</style></head><body>
+<h1>%s</h1>
+<table><thead><tr><th>Time</th><th>Sender</th><th>Type</th><th>Message</th></tr></thead><tbody>
+`
+
+const htmlTail = `</tbody></table>
+<p style="color:#999;font-size:12px">Exported at %s</p>
+</body></html>
+`
+
+func (h *htmlRenderer) Begin() error {
+	title := html.EscapeString(h.title)
+	_, err := fmt.Fprintf(h.w, htmlHead, title, title)
+	return err
+}
+
+func (h *htmlRenderer) Write(r exportRecord) error {
+	_, err := fmt.Fprintf(h.w, "<tr><td class=\"time\">%s</td><td>%s <small>#%d</small></td><td>%s</td><td class=\"text\">%s</td></tr>\n",
+		r.CreatedAt.Format("2006-01-02 15:04:05"),
+		html.EscapeString(r.SenderName),
+		r.SenderID,
+		html.EscapeString(r.Type),
+		html.EscapeString(r.Text))
+	return err
+}
+
+func (h *htmlRenderer) End() error {
+	_, err := fmt.Fprintf(h.w, htmlTail, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// contentText 把消息 content JSON 转成可读文本，用于 CSV / HTML
+func contentText(msgType string, raw string) string {
+	var c struct {
+		Text     string `json:"text"`
+		URL      string `json:"url"`
+		FileName string `json:"file_name"`
+		Duration int    `json:"duration"`
+	}
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return raw
+	}
+	switch msgType {
+	case "text":
+		return c.Text
+	case "image":
+		return "[image] " + c.URL
+	case "voice":
+		return fmt.Sprintf("[voice %ds] %s", c.Duration, c.URL)
+	case "file":
+		return "[file] " + c.FileName + " " + c.URL
+	default:
+		if c.Text != "" {
+			return c.Text
+		}
+		return "[" + msgType + "]"
+	}
+}
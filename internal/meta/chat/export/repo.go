@@ -0,0 +1,235 @@
+package export
+
+import (
+	"GoStacker/pkg/db/mysql"
+	rdb "GoStacker/pkg/db/redis"
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const jobKeyFmt = "export:job:%s"
+
+// 待执行与执行中的任务放在 redis stream 中，由各 meta 实例的 worker 通过消费组领取；
+// 领取后未确认的条目即执行中的任务，实例宕机后由其他实例按空闲时间认领重跑
+const (
+	jobStream = "export:jobs"
+	jobGroup  = "export"
+)
+
+func jobKey(jobID string) string {
+	return fmt.Sprintf(jobKeyFmt, jobID)
+}
+
+func saveJob(job *Job, ttl time.Duration) error {
+	ctx := context.Background()
+	key := jobKey(job.ID)
+	pipe := rdb.Rdb.TxPipeline()
+	pipe.HSet(ctx, key,
+		"id", job.ID,
+		"room_id", job.RoomID,
+		"user_id", job.UserID,
+		"format", job.Format,
+		"from", job.From,
+		"to", job.To,
+		"status", job.Status,
+		"rows", job.Rows,
+		"error", job.Error,
+		"file", job.File,
+		"owner", job.Owner,
+		"created_at", job.CreatedAt,
+		"finished_at", job.FinishedAt,
+	)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func loadJob(jobID string) (*Job, error) {
+	m, err := rdb.Rdb.HGetAll(context.Background(), jobKey(jobID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, ErrJobNotFound
+	}
+	parse := func(field string) int64 {
+		v, _ := strconv.ParseInt(m[field], 10, 64)
+		return v
+	}
+	return &Job{
+		ID:         m["id"],
+		RoomID:     parse("room_id"),
+		UserID:     parse("user_id"),
+		Format:     m["format"],
+		From:       parse("from"),
+		To:         parse("to"),
+		Status:     m["status"],
+		Rows:       parse("rows"),
+		Error:      m["error"],
+		File:       m["file"],
+		Owner:      m["owner"],
+		CreatedAt:  parse("created_at"),
+		FinishedAt: parse("finished_at"),
+	}, nil
+}
+
+func ensureJobGroup() error {
+	err := rdb.Rdb.XGroupCreateMkStream(context.Background(), jobStream, jobGroup, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// queuedJobs 返回尚未完成的任务数（排队中与执行中）
+func queuedJobs() (int64, error) {
+	return rdb.Rdb.XLen(context.Background(), jobStream).Result()
+}
+
+func enqueueJob(jobID string) error {
+	return rdb.Rdb.XAdd(context.Background(), &goredis.XAddArgs{
+		Stream: jobStream,
+		Values: map[string]interface{}{"job_id": jobID},
+	}).Err()
+}
+
+// readJob 领取一个新任务，block 内没有任务时返回空
+func readJob(consumer string, block time.Duration) ([]goredis.XMessage, error) {
+	streams, err := rdb.Rdb.XReadGroup(context.Background(), &goredis.XReadGroupArgs{
+		Group:    jobGroup,
+		Consumer: consumer,
+		Streams:  []string{jobStream, ">"},
+		Count:    1,
+		Block:    block,
+	}).Result()
+	if err == goredis.Nil || len(streams) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return streams[0].Messages, nil
+}
+
+// claimStaleJobs 认领空闲超过 minIdle 的执行中任务，即执行它的实例已经宕机
+func claimStaleJobs(consumer string, minIdle time.Duration) ([]goredis.XMessage, error) {
+	var claimed []goredis.XMessage
+	start := "0-0"
+	for {
+		msgs, next, err := rdb.XAutoClaimWithContext(context.Background(), jobStream, jobGroup, consumer, minIdle, start, 10)
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, msgs...)
+		if len(msgs) == 0 || next == "" || next == "0-0" || next == start {
+			return claimed, nil
+		}
+		start = next
+	}
+}
+
+// touchJob 重置执行中任务的空闲时间，避免长任务被其他实例认领
+func touchJob(consumer string, entryID string) error {
+	return rdb.Rdb.XClaimJustID(context.Background(), &goredis.XClaimArgs{
+		Stream:   jobStream,
+		Group:    jobGroup,
+		Consumer: consumer,
+		MinIdle:  0,
+		Messages: []string{entryID},
+	}).Err()
+}
+
+// finishJob 确认并删除任务条目
+func finishJob(entryID string) error {
+	ctx := context.Background()
+	pipe := rdb.Rdb.TxPipeline()
+	pipe.XAck(ctx, jobStream, jobGroup, entryID)
+	pipe.XDel(ctx, jobStream, entryID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// messageRow 对应 chat_messages 的一行
+type messageRow struct {
+	ID        int64
+	SenderID  int64
+	Type      string
+	Content   string
+	CreatedAt time.Time
+}
+
+// queryMessages 按 id 顺序流式读取房间消息，from/to 为 0 表示不限
+func queryMessages(ctx context.Context, roomID int64, from, to int64, fn func(messageRow) error) error {
+	query := "SELECT id, sender_id, type, content, created_at FROM chat_messages WHERE room_id = ? AND is_deleted = FALSE"
+	args := []interface{}{roomID}
+	if from > 0 {
+		query += " AND created_at >= ?"
+		args = append(args, time.Unix(from, 0))
+	}
+	if to > 0 {
+		query += " AND created_at < ?"
+		args = append(args, time.Unix(to, 0))
+	}
+	query += " ORDER BY id ASC"
+
+	rows, err := mysql.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r messageRow
+		var msgType sql.NullString
+		if err := rows.Scan(&r.ID, &r.SenderID, &msgType, &r.Content, &r.CreatedAt); err != nil {
+			return err
+		}
+		r.Type = msgType.String
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// queryRoomNicknames 从房间成员表读取群昵称
+func queryRoomNicknames(roomID int64) (map[int64]string, error) {
+	tableName := fmt.Sprintf("chat_room_members_room_%d", roomID)
+	query := fmt.Sprintf("SELECT user_id, nickname FROM %s", tableName)
+	rows, err := mysql.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := make(map[int64]string)
+	for rows.Next() {
+		var uid int64
+		var nickname sql.NullString
+		if err := rows.Scan(&uid, &nickname); err != nil {
+			return nil, err
+		}
+		if nickname.Valid && nickname.String != "" {
+			names[uid] = nickname.String
+		}
+	}
+	return names, rows.Err()
+}
+
+// queryUserDisplayName 成员表中没有群昵称（或已退群）时使用用户昵称，再退回用户名
+func queryUserDisplayName(userID int64) (string, error) {
+	var nickname sql.NullString
+	var username string
+	err := mysql.DB.QueryRow("SELECT nickname, username FROM users WHERE id = ?", userID).Scan(&nickname, &username)
+	if err != nil {
+		return "", err
+	}
+	if nickname.Valid && nickname.String != "" {
+		return nickname.String, nil
+	}
+	return username, nil
+}
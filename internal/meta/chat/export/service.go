@@ -0,0 +1,361 @@
+package export
+
+import (
+	"GoStacker/internal/meta/chat/group"
+	"GoStacker/pkg/config"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+	FormatHTML  = "html"
+)
+
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+var (
+	ErrJobNotFound   = errors.New("export job not found or expired")
+	ErrNoPermission  = errors.New("only room owner or admin can export messages")
+	ErrBadFormat     = errors.New("format must be one of jsonl, csv, html")
+	ErrRangeTooLarge = errors.New("time range exceeds the allowed maximum")
+	ErrQueueFull     = errors.New("export queue is full, try again later")
+)
+
+// Job 是一次导出任务，状态保存在 redis：export:job:<id>
+type Job struct {
+	ID         string `json:"job_id"`
+	RoomID     int64  `json:"room_id"`
+	UserID     int64  `json:"user_id"`
+	Format     string `json:"format"`
+	From       int64  `json:"from"`
+	To         int64  `json:"to"`
+	Status     string `json:"status"`
+	Rows       int64  `json:"rows"`
+	Error      string `json:"error,omitempty"`
+	File       string `json:"-"`
+	Owner      string `json:"-"` // 写出文件的实例的 advertise_url，下载落到其他实例时转发过去
+	CreatedAt  int64  `json:"created_at"`
+	FinishedAt int64  `json:"finished_at,omitempty"`
+}
+
+// staleJobIdle 执行中的任务超过该时间没有心跳即视为执行实例已宕机，由其他实例认领重跑
+const staleJobIdle = 2 * time.Minute
+
+var (
+	exportDir    = "exports"
+	jobTTL       = 24 * time.Hour
+	maxRangeDays int
+	queueSize    = int64(64)
+	advertiseURL string
+	consumerName string
+)
+
+// Init 启动导出 worker、宕机实例遗留任务的认领与过期文件清理；cfg 为 nil 时使用默认值
+func Init(cfg *config.ExportConfig) error {
+	workers := 1
+	if cfg != nil {
+		if cfg.Dir != "" {
+			exportDir = cfg.Dir
+		}
+		if cfg.JobTTL > 0 {
+			jobTTL = time.Duration(cfg.JobTTL) * time.Second
+		}
+		if cfg.WorkerCount > 0 {
+			workers = cfg.WorkerCount
+		}
+		if cfg.QueueSize > 0 {
+			queueSize = int64(cfg.QueueSize)
+		}
+		maxRangeDays = cfg.MaxRangeDays
+		advertiseURL = strings.TrimRight(cfg.AdvertiseURL, "/")
+	}
+	if err := os.MkdirAll(exportDir, 0o755); err != nil {
+		return err
+	}
+	if err := ensureJobGroup(); err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	consumerName = fmt.Sprintf("meta-%s-%d", hostname, os.Getpid())
+
+	// jobs left running by an instance that went down before this one started
+	reclaimStaleJobs()
+	for i := 0; i < workers; i++ {
+		go worker()
+	}
+	go reclaimLoop()
+	go cleanupLoop()
+	zap.L().Info("export workers started", zap.String("dir", exportDir), zap.Int("workers", workers), zap.String("consumer", consumerName))
+	return nil
+}
+
+// CreateJob 校验权限与参数后登记任务并入队，返回任务 ID
+func CreateJob(userID, roomID int64, from, to int64, format string) (*Job, error) {
+	switch format {
+	case FormatJSONL, FormatCSV, FormatHTML:
+	default:
+		return nil, ErrBadFormat
+	}
+	if from > 0 && to > 0 && to <= from {
+		return nil, errors.New("to must be greater than from")
+	}
+	if maxRangeDays > 0 {
+		if from <= 0 || to <= 0 || time.Duration(to-from)*time.Second > time.Duration(maxRangeDays)*24*time.Hour {
+			return nil, ErrRangeTooLarge
+		}
+	}
+	role, err := group.QueryMemberRole(roomID, userID)
+	if err != nil || role > group.RoleAdmin {
+		return nil, ErrNoPermission
+	}
+
+	if n, err := queuedJobs(); err != nil {
+		return nil, err
+	} else if n >= queueSize {
+		return nil, ErrQueueFull
+	}
+
+	job := &Job{
+		ID:        newJobID(),
+		RoomID:    roomID,
+		UserID:    userID,
+		Format:    format,
+		From:      from,
+		To:        to,
+		Status:    StatusQueued,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := saveJob(job, jobTTL); err != nil {
+		return nil, err
+	}
+	if err := enqueueJob(job.ID); err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		_ = saveJob(job, jobTTL)
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetJob 返回任务，仅任务创建者可见
+func GetJob(userID int64, jobID string) (*Job, error) {
+	job, err := loadJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != userID {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+func worker() {
+	for {
+		msgs, err := readJob(consumerName, 5*time.Second)
+		if err != nil {
+			zap.L().Warn("export: read job queue failed", zap.Error(err))
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, m := range msgs {
+			process(m)
+		}
+	}
+}
+
+// reclaimLoop 定期认领宕机实例遗留的任务并在本实例执行
+func reclaimLoop() {
+	ticker := time.NewTicker(staleJobIdle)
+	defer ticker.Stop()
+	for range ticker.C {
+		reclaimStaleJobs()
+	}
+}
+
+func reclaimStaleJobs() {
+	msgs, err := claimStaleJobs(consumerName, staleJobIdle)
+	if err != nil {
+		zap.L().Warn("export: claim stale jobs failed", zap.Error(err))
+	}
+	for _, m := range msgs {
+		zap.L().Info("export: requeue stale job", zap.Any("job_id", m.Values["job_id"]))
+		go process(m)
+	}
+}
+
+// process 执行一个领取到的任务，结束后确认队列条目；执行期间定期心跳，
+// 任务状态已是结束态（上次执行完成但未来得及确认）时直接确认
+func process(m goredis.XMessage) {
+	jobID, _ := m.Values["job_id"].(string)
+	job, err := loadJob(jobID)
+	if err != nil {
+		if err == ErrJobNotFound {
+			_ = finishJob(m.ID)
+			return
+		}
+		zap.L().Warn("export: load job failed", zap.String("job_id", jobID), zap.Error(err))
+		return
+	}
+	if job.Status == StatusDone || job.Status == StatusFailed {
+		_ = finishJob(m.ID)
+		return
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(staleJobIdle / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := touchJob(consumerName, m.ID); err != nil {
+					zap.L().Warn("export: job heartbeat failed", zap.String("job_id", job.ID), zap.Error(err))
+				}
+			}
+		}
+	}()
+	defer close(stop)
+
+	job.Status = StatusRunning
+	job.Rows = 0
+	job.Owner = advertiseURL
+	if err := saveJob(job, jobTTL); err != nil {
+		zap.L().Warn("export: update job status failed", zap.String("job_id", job.ID), zap.Error(err))
+	}
+	err = run(job)
+	job.FinishedAt = time.Now().Unix()
+	if err != nil {
+		zap.L().Error("export job failed", zap.String("job_id", job.ID), zap.Int64("room_id", job.RoomID), zap.Error(err))
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		if job.File != "" {
+			_ = os.Remove(job.File)
+			job.File = ""
+		}
+	} else {
+		job.Status = StatusDone
+		zap.L().Info("export job done", zap.String("job_id", job.ID), zap.Int64("rows", job.Rows))
+	}
+	if err := saveJob(job, jobTTL); err != nil {
+		zap.L().Warn("export: update job status failed", zap.String("job_id", job.ID), zap.Error(err))
+	}
+	if err := finishJob(m.ID); err != nil {
+		zap.L().Warn("export: ack job failed", zap.String("job_id", job.ID), zap.Error(err))
+	}
+}
+
+func run(job *Job) error {
+	names, err := queryRoomNicknames(job.RoomID)
+	if err != nil {
+		return fmt.Errorf("load room nicknames: %w", err)
+	}
+	title := fmt.Sprintf("Room %d", job.RoomID)
+	if room, err := group.QueryRoomByID(job.RoomID); err == nil {
+		title = room.Name
+	}
+
+	job.File = filepath.Join(exportDir, fmt.Sprintf("room_%d_%s.%s", job.RoomID, job.ID, job.Format))
+	f, err := os.Create(job.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+
+	r, err := newRenderer(job.Format, bw, title)
+	if err != nil {
+		return err
+	}
+	if err := r.Begin(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	err = queryMessages(ctx, job.RoomID, job.From, job.To, func(m messageRow) error {
+		content := json.RawMessage(m.Content)
+		if !json.Valid(content) {
+			content, _ = json.Marshal(m.Content)
+		}
+		job.Rows++
+		return r.Write(exportRecord{
+			ID:         m.ID,
+			SenderID:   m.SenderID,
+			SenderName: senderName(names, m.SenderID),
+			Type:       m.Type,
+			Text:       contentText(m.Type, m.Content),
+			Content:    content,
+			CreatedAt:  m.CreatedAt,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.End(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// senderName 优先使用群昵称；退群成员或未设置群昵称时查 users 表并缓存到 names 中
+func senderName(names map[int64]string, userID int64) string {
+	if name, ok := names[userID]; ok {
+		return name
+	}
+	name, err := queryUserDisplayName(userID)
+	if err != nil {
+		name = fmt.Sprintf("user_%d", userID)
+	}
+	names[userID] = name
+	return name
+}
+
+// cleanupLoop 删除超过 jobTTL 的导出文件，任务状态由 redis TTL 自动过期
+func cleanupLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		entries, err := os.ReadDir(exportDir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil || e.IsDir() {
+				continue
+			}
+			if time.Since(info.ModTime()) > jobTTL {
+				_ = os.Remove(filepath.Join(exportDir, e.Name()))
+			}
+		}
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -2,6 +2,7 @@ package group
 
 import (
 	"GoStacker/pkg/response"
+	"errors"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -286,3 +287,30 @@ func GetJoinedRoomsHandler(c *gin.Context) {
 	}
 	response.ReplySuccessWithData(c, "ok", gin.H{"room_ids": roomIDs})
 }
+
+type SetRoomE2ERequest struct {
+	RoomID   int64 `json:"room_id" binding:"required"`
+	Required bool  `json:"required"`
+}
+
+func SetRoomE2EHandler(c *gin.Context) {
+	var req SetRoomE2ERequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	if err := SetRoomRequireE2E(req.RoomID, req.Required, userID.(int64)); err != nil {
+		if errors.Is(err, ErrNoPermission) {
+			response.ReplyUnauthorized(c, err.Error())
+			return
+		}
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccess(c, "Room encryption requirement updated")
+}
@@ -288,3 +288,37 @@ func DeleteJoinRequestByID(id int64) error {
 	_, err := mysql.DB.Exec(q, id)
 	return err
 }
+
+// Room end-to-end encryption requirement
+const roomE2EKeyFmt = "rooms:e2e:%d"
+
+const roomE2ECacheTTL = 5 * time.Minute
+
+func UpsertRoomE2E(roomID int64, required bool, updatedBy int64) error {
+	q := `INSERT INTO chat_room_e2e (room_id, required, updated_by, updated_at) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE required = VALUES(required), updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)`
+	if _, err := mysql.DB.Exec(q, roomID, required, updatedBy, time.Now()); err != nil {
+		return err
+	}
+	_ = rdb.DelWithRetry(redisRetry, fmt.Sprintf(roomE2EKeyFmt, roomID))
+	return nil
+}
+
+// QueryRoomRequiresE2E 返回房间是否要求端到端加密消息，结果在 redis 中短期缓存
+func QueryRoomRequiresE2E(roomID int64) (bool, error) {
+	key := fmt.Sprintf(roomE2EKeyFmt, roomID)
+	if v, err := rdb.GetWithRetry(redisRetry, key); err == nil && v != "" {
+		return v == "1", nil
+	}
+	var required bool
+	err := mysql.DB.QueryRow("SELECT required FROM chat_room_e2e WHERE room_id = ?", roomID).Scan(&required)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	v := "0"
+	if required {
+		v = "1"
+	}
+	_ = rdb.SetEXWithRetry(redisRetry, key, v, roomE2ECacheTTL)
+	return required, nil
+}
@@ -2,6 +2,7 @@ package group
 
 import (
 	"GoStacker/internal/send/push"
+	"database/sql"
 	"errors"
 	"time"
 )
@@ -20,6 +21,8 @@ type MemberInfo struct {
 	JoinedAt string `json:"joined_at"`
 }
 
+var ErrNoPermission = errors.New("permission denied")
+
 const (
 	RoleOwner  = 0
 	RoleAdmin  = 1
@@ -238,3 +241,18 @@ func RespondJoinRequest(requestUserID int64, requestID int64, approve bool) erro
 	// delete request
 	return DeleteJoinRequestByID(requestID)
 }
+
+// SetRoomRequireE2E 群主设置房间是否只接受端到端加密消息
+func SetRoomRequireE2E(roomID int64, required bool, requestUserID int64) error {
+	requestUserRole, err := QueryMemberRole(roomID, requestUserID)
+	if err == sql.ErrNoRows {
+		return ErrNoPermission
+	}
+	if err != nil {
+		return err
+	}
+	if requestUserRole != RoleOwner {
+		return ErrNoPermission
+	}
+	return UpsertRoomE2E(roomID, required, requestUserID)
+}
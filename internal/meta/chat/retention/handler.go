@@ -0,0 +1,78 @@
+package retention
+
+import (
+	"GoStacker/internal/meta/chat/group"
+	"GoStacker/pkg/response"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SetPolicyRequest struct {
+	RoomID   int64 `json:"room_id" binding:"required"`
+	KeepDays int   `json:"keep_days"`
+	KeepLast int   `json:"keep_last"`
+	Reset    bool  `json:"reset"` // true 时删除房间策略，恢复全局默认
+}
+
+func SetPolicyHandler(c *gin.Context) {
+	var req SetPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	var err error
+	if req.Reset {
+		err = ResetRoomPolicy(userID.(int64), req.RoomID)
+	} else {
+		err = SetRoomPolicy(userID.(int64), req.RoomID, req.KeepDays, req.KeepLast)
+	}
+	if err != nil {
+		if errors.Is(err, ErrNoPermission) {
+			response.ReplyUnauthorized(c, err.Error())
+			return
+		}
+		response.ReplyBadRequest(c, err.Error())
+		return
+	}
+	response.ReplySuccess(c, "Retention policy updated")
+}
+
+func GetPolicyHandler(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Query("room_id"), 10, 64)
+	if err != nil {
+		response.ReplyBadRequest(c, "invalid room_id")
+		return
+	}
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	isMember, err := group.IsRoomMember(roomID, userID.(int64))
+	if err != nil || !isMember {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	p, err := GetEffectivePolicy(roomID)
+	if err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	bounds := retentionConf()
+	response.ReplySuccessWithData(c, "success", gin.H{
+		"policy": p,
+		"bounds": gin.H{
+			"min_keep_days": bounds.MinKeepDays,
+			"max_keep_days": bounds.MaxKeepDays,
+			"min_keep_last": bounds.MinKeepLast,
+			"max_keep_last": bounds.MaxKeepLast,
+		},
+	})
+}
@@ -0,0 +1,158 @@
+package retention
+
+import (
+	"GoStacker/pkg/config"
+	"GoStacker/pkg/monitor"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"go.uber.org/zap"
+)
+
+const roomPageSize = 500
+
+var purgeMonitor *monitor.Monitor
+
+// snowflakeIDAt 返回时间 t 对应的最小 Snowflake ID，ID 小于它的消息都早于 t
+func snowflakeIDAt(t time.Time) int64 {
+	return (t.UnixMilli() - snowflake.Epoch) << (snowflake.NodeBits + snowflake.StepBits)
+}
+
+// RunPurger 周期性按保留策略清理 chat_messages，由 cmd/flusher 启动。
+// 每个房间按 Snowflake ID 范围分批 DELETE，批次之间停顿，避免长事务锁表。
+func RunPurger(cfg *config.RetentionConfig, stopCh chan struct{}) {
+	if cfg == nil || !cfg.Enabled {
+		zap.L().Info("retention purger disabled")
+		return
+	}
+	interval := time.Hour
+	if cfg.Interval > 0 {
+		interval = time.Duration(cfg.Interval) * time.Second
+	}
+	batch := 500
+	if cfg.BatchSize > 0 {
+		batch = cfg.BatchSize
+	}
+	pause := time.Duration(cfg.BatchPauseMs) * time.Millisecond
+
+	purgeMonitor = monitor.NewMonitor("retention_purge", 1000, 10000, 600000)
+	purgeMonitor.Run()
+	zap.L().Info("retention purger started", zap.Duration("interval", interval), zap.Int("batch", batch))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purgeAll(batch, pause, stopCh)
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func purgeAll(batch int, pause time.Duration, stopCh chan struct{}) {
+	start := time.Now()
+	policies, err := queryAllRoomPolicies()
+	if err != nil {
+		zap.L().Error("retention: load room policies failed", zap.Error(err))
+		return
+	}
+	var total int64
+	var afterID int64
+	for {
+		roomIDs, err := queryRoomIDsAfter(afterID, roomPageSize)
+		if err != nil {
+			zap.L().Error("retention: list rooms failed", zap.Error(err))
+			return
+		}
+		if len(roomIDs) == 0 {
+			break
+		}
+		for _, roomID := range roomIDs {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			p, ok := policies[roomID]
+			if !ok {
+				p = globalPolicy(roomID)
+			}
+			n, err := purgeRoom(p, batch, pause, stopCh)
+			total += n
+			if err != nil {
+				monitor.AddCount("retention_purge", "room_error", 1)
+				zap.L().Error("retention: purge room failed", zap.Int64("room_id", roomID), zap.Error(err))
+			}
+		}
+		afterID = roomIDs[len(roomIDs)-1]
+	}
+	monitor.AddCount("retention_purge", "run", 1)
+	zap.L().Info("retention purge finished", zap.Int64("rows", total), zap.Duration("took", time.Since(start)))
+}
+
+// cutoffFor 计算需要清理的 ID 上界（不含），两个维度都配置时取更保守（更小）的那个；
+// 返回 0 表示无需清理。
+func cutoffFor(p Policy) (int64, error) {
+	var byCount int64
+	if p.KeepLast > 0 {
+		id, ok, err := queryNthNewestID(p.RoomID, p.KeepLast-1)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			// 消息数不足 keep_last，全部保留
+			return 0, nil
+		}
+		byCount = id
+	}
+	return earlierCutoff(ageCutoff(p.KeepDays, time.Now()), byCount), nil
+}
+
+// ageCutoff 早于 now 前 keepDays 天的消息 ID 上界，keepDays 不为正时返回 0
+func ageCutoff(keepDays int, now time.Time) int64 {
+	if keepDays <= 0 {
+		return 0
+	}
+	return snowflakeIDAt(now.AddDate(0, 0, -keepDays))
+}
+
+// earlierCutoff 取两个上界中较小的一个，0 表示该维度不限制
+func earlierCutoff(a, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func purgeRoom(p Policy, batch int, pause time.Duration, stopCh chan struct{}) (int64, error) {
+	if p.KeepDays <= 0 && p.KeepLast <= 0 {
+		return 0, nil
+	}
+	cutoff, err := cutoffFor(p)
+	if err != nil || cutoff <= 0 {
+		return 0, err
+	}
+	var purged int64
+	for {
+		lo, hi, ok, err := queryBatchRange(p.RoomID, cutoff, batch)
+		if err != nil || !ok {
+			return purged, err
+		}
+		t := monitor.NewTask()
+		n, err := deleteRange(p.RoomID, lo, hi)
+		purgeMonitor.CompleteTask(t, err == nil)
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+		monitor.AddCount("retention_purge", "rows", float64(n))
+		monitor.AddCount("retention_purge", "batches", 1)
+		select {
+		case <-stopCh:
+			return purged, nil
+		case <-time.After(pause):
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+)
+
+func TestSnowflakeIDAtOrdersWithGeneratedIDs(t *testing.T) {
+	node, err := snowflake.NewNode(1)
+	if err != nil {
+		t.Fatalf("NewNode: %v", err)
+	}
+	before := snowflakeIDAt(time.Now().Add(-time.Second))
+	id := node.Generate().Int64()
+	after := snowflakeIDAt(time.Now().Add(time.Second))
+	if id <= before || id >= after {
+		t.Fatalf("generated id %d outside [%d, %d]", id, before, after)
+	}
+	// 同一毫秒生成的 ID 不小于该毫秒的下界
+	at := time.UnixMilli(snowflake.ID(id).Time())
+	if snowflakeIDAt(at) > id {
+		t.Fatalf("lower bound %d of the id's own millisecond exceeds id %d", snowflakeIDAt(at), id)
+	}
+}
+
+func TestAgeCutoff(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	if got := ageCutoff(0, now); got != 0 {
+		t.Fatalf("ageCutoff(0) = %d, want 0", got)
+	}
+	if got := ageCutoff(-1, now); got != 0 {
+		t.Fatalf("ageCutoff(-1) = %d, want 0", got)
+	}
+	want := snowflakeIDAt(time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC))
+	if got := ageCutoff(7, now); got != want {
+		t.Fatalf("ageCutoff(7) = %d, want %d", got, want)
+	}
+	if ageCutoff(30, now) >= ageCutoff(7, now) {
+		t.Fatal("longer retention should give an earlier cutoff")
+	}
+}
+
+func TestEarlierCutoff(t *testing.T) {
+	cases := []struct {
+		a, b, want int64
+	}{
+		{0, 0, 0},
+		{100, 0, 100},
+		{0, 100, 100},
+		{100, 200, 100},
+		{200, 100, 100},
+	}
+	for _, c := range cases {
+		if got := earlierCutoff(c.a, c.b); got != c.want {
+			t.Errorf("earlierCutoff(%d, %d) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
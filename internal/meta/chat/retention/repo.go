@@ -0,0 +1,119 @@
+package retention
+
+import (
+	"GoStacker/pkg/db/mysql"
+	"database/sql"
+	"time"
+)
+
+// Policy 是房间级保留策略；0 表示不按该维度清理
+type Policy struct {
+	RoomID    int64     `json:"room_id"`
+	KeepDays  int       `json:"keep_days"`
+	KeepLast  int       `json:"keep_last"`
+	UpdatedBy int64     `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	Inherited bool      `json:"inherited"` // 房间未设置，使用全局默认
+}
+
+func UpsertRoomPolicy(p Policy) error {
+	q := `INSERT INTO chat_room_retention (room_id, keep_days, keep_last, updated_by, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE keep_days = VALUES(keep_days), keep_last = VALUES(keep_last), updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)`
+	_, err := mysql.DB.Exec(q, p.RoomID, p.KeepDays, p.KeepLast, p.UpdatedBy, time.Now())
+	return err
+}
+
+func DeleteRoomPolicy(roomID int64) error {
+	_, err := mysql.DB.Exec("DELETE FROM chat_room_retention WHERE room_id = ?", roomID)
+	return err
+}
+
+// QueryRoomPolicy 返回房间自己的策略，未设置时 ok=false
+func QueryRoomPolicy(roomID int64) (Policy, bool, error) {
+	p := Policy{RoomID: roomID}
+	err := mysql.DB.QueryRow("SELECT keep_days, keep_last, updated_by, updated_at FROM chat_room_retention WHERE room_id = ?", roomID).
+		Scan(&p.KeepDays, &p.KeepLast, &p.UpdatedBy, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return Policy{}, false, nil
+	}
+	if err != nil {
+		return Policy{}, false, err
+	}
+	return p, true, nil
+}
+
+func queryAllRoomPolicies() (map[int64]Policy, error) {
+	rows, err := mysql.DB.Query("SELECT room_id, keep_days, keep_last FROM chat_room_retention")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make(map[int64]Policy)
+	for rows.Next() {
+		var p Policy
+		if err := rows.Scan(&p.RoomID, &p.KeepDays, &p.KeepLast); err != nil {
+			return nil, err
+		}
+		res[p.RoomID] = p
+	}
+	return res, rows.Err()
+}
+
+// queryRoomIDsAfter 按 id 分页遍历所有房间
+func queryRoomIDsAfter(afterID int64, limit int) ([]int64, error) {
+	rows, err := mysql.DB.Query("SELECT id FROM chat_rooms WHERE id > ? ORDER BY id ASC LIMIT ?", afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0, limit)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// queryNthNewestID 返回房间第 n 新（从 0 开始）的消息 ID，不足 n+1 条时 ok=false
+func queryNthNewestID(roomID int64, n int) (int64, bool, error) {
+	var id int64
+	err := mysql.DB.QueryRow("SELECT id FROM chat_messages WHERE room_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?", roomID, n).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// queryBatchRange 返回 id < cutoff 的最早 batch 条消息的 ID 范围 [lo, hi]，没有可清理的消息时 ok=false
+func queryBatchRange(roomID int64, cutoff int64, batch int) (lo, hi int64, ok bool, err error) {
+	err = mysql.DB.QueryRow("SELECT id FROM chat_messages WHERE room_id = ? AND id < ? ORDER BY id ASC LIMIT 1", roomID, cutoff).Scan(&lo)
+	if err == sql.ErrNoRows {
+		return 0, 0, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	err = mysql.DB.QueryRow("SELECT id FROM chat_messages WHERE room_id = ? AND id < ? ORDER BY id ASC LIMIT 1 OFFSET ?", roomID, cutoff, batch-1).Scan(&hi)
+	if err == sql.ErrNoRows {
+		// 剩余不足一个批次
+		return lo, cutoff - 1, true, nil
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return lo, hi, true, nil
+}
+
+func deleteRange(roomID int64, lo, hi int64) (int64, error) {
+	res, err := mysql.DB.Exec("DELETE FROM chat_messages WHERE room_id = ? AND id BETWEEN ? AND ?", roomID, lo, hi)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
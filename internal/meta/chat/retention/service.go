@@ -0,0 +1,90 @@
+package retention
+
+import (
+	"GoStacker/internal/meta/chat/group"
+	"GoStacker/pkg/config"
+	"errors"
+	"fmt"
+)
+
+var ErrNoPermission = errors.New("only room owner can change retention policy")
+
+func retentionConf() *config.RetentionConfig {
+	if config.Conf == nil || config.Conf.RetentionConfig == nil {
+		return &config.RetentionConfig{}
+	}
+	return config.Conf.RetentionConfig
+}
+
+// globalPolicy 是房间未设置策略时使用的默认值
+func globalPolicy(roomID int64) Policy {
+	c := retentionConf()
+	return Policy{RoomID: roomID, KeepDays: c.DefaultKeepDays, KeepLast: c.DefaultKeepLast, Inherited: true}
+}
+
+// GetEffectivePolicy 返回房间实际生效的策略
+func GetEffectivePolicy(roomID int64) (Policy, error) {
+	p, ok, err := QueryRoomPolicy(roomID)
+	if err != nil {
+		return Policy{}, err
+	}
+	if !ok {
+		return globalPolicy(roomID), nil
+	}
+	return p, nil
+}
+
+// SetRoomPolicy 群主设置房间保留策略，取值必须在管理员配置的范围内
+func SetRoomPolicy(requestUserID, roomID int64, keepDays, keepLast int) error {
+	role, err := group.QueryMemberRole(roomID, requestUserID)
+	if err != nil || role != group.RoleOwner {
+		return ErrNoPermission
+	}
+	keepDays, keepLast = applyCaps(keepDays, keepLast)
+	if err := checkBounds(keepDays, keepLast); err != nil {
+		return err
+	}
+	return UpsertRoomPolicy(Policy{RoomID: roomID, KeepDays: keepDays, KeepLast: keepLast, UpdatedBy: requestUserID})
+}
+
+// ResetRoomPolicy 删除房间策略，恢复为全局默认
+func ResetRoomPolicy(requestUserID, roomID int64) error {
+	role, err := group.QueryMemberRole(roomID, requestUserID)
+	if err != nil || role != group.RoleOwner {
+		return ErrNoPermission
+	}
+	return DeleteRoomPolicy(roomID)
+}
+
+// applyCaps 群主可以只设置一个维度；另一个维度留空（0）而管理员配置了上限时按上限保存，
+// 群主不能借此选择“不限”
+func applyCaps(keepDays, keepLast int) (int, int) {
+	c := retentionConf()
+	if keepDays == 0 && c.MaxKeepDays > 0 {
+		keepDays = c.MaxKeepDays
+	}
+	if keepLast == 0 && c.MaxKeepLast > 0 {
+		keepLast = c.MaxKeepLast
+	}
+	return keepDays, keepLast
+}
+
+func checkBounds(keepDays, keepLast int) error {
+	c := retentionConf()
+	if keepDays < 0 || keepLast < 0 {
+		return errors.New("keep_days and keep_last must not be negative")
+	}
+	if c.MaxKeepDays > 0 && keepDays > c.MaxKeepDays {
+		return fmt.Errorf("keep_days must be between %d and %d", c.MinKeepDays, c.MaxKeepDays)
+	}
+	if c.MinKeepDays > 0 && keepDays > 0 && keepDays < c.MinKeepDays {
+		return fmt.Errorf("keep_days must be at least %d", c.MinKeepDays)
+	}
+	if c.MaxKeepLast > 0 && keepLast > c.MaxKeepLast {
+		return fmt.Errorf("keep_last must be between %d and %d", c.MinKeepLast, c.MaxKeepLast)
+	}
+	if c.MinKeepLast > 0 && keepLast > 0 && keepLast < c.MinKeepLast {
+		return fmt.Errorf("keep_last must be at least %d", c.MinKeepLast)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package retention
+
+import (
+	"testing"
+
+	"GoStacker/pkg/config"
+)
+
+func withRetentionConf(t *testing.T, c config.RetentionConfig) {
+	t.Helper()
+	prev := config.Conf
+	config.Conf = &config.AppConfig{RetentionConfig: &c}
+	t.Cleanup(func() { config.Conf = prev })
+}
+
+func TestApplyCapsFillsUnlimitedWithMax(t *testing.T) {
+	withRetentionConf(t, config.RetentionConfig{MaxKeepDays: 365, MaxKeepLast: 10000})
+	if d, l := applyCaps(0, 0); d != 365 || l != 10000 {
+		t.Fatalf("applyCaps(0, 0) = %d, %d, want 365, 10000", d, l)
+	}
+	if d, l := applyCaps(30, 500); d != 30 || l != 500 {
+		t.Fatalf("applyCaps(30, 500) = %d, %d, want unchanged", d, l)
+	}
+
+	withRetentionConf(t, config.RetentionConfig{})
+	if d, l := applyCaps(0, 0); d != 0 || l != 0 {
+		t.Fatalf("applyCaps without caps = %d, %d, want 0, 0", d, l)
+	}
+}
+
+func TestCheckBounds(t *testing.T) {
+	withRetentionConf(t, config.RetentionConfig{MinKeepDays: 1, MaxKeepDays: 365, MinKeepLast: 100, MaxKeepLast: 10000})
+	cases := []struct {
+		name               string
+		keepDays, keepLast int
+		ok                 bool
+	}{
+		{"unlimited", 0, 0, true},
+		{"within range", 30, 500, true},
+		{"at bounds", 365, 100, true},
+		{"negative days", -1, 0, false},
+		{"negative last", 0, -1, false},
+		{"days over max", 366, 0, false},
+		{"last over max", 0, 10001, false},
+		{"last under min", 0, 99, false},
+	}
+	for _, c := range cases {
+		err := checkBounds(c.keepDays, c.keepLast)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: checkBounds(%d, %d) = %v, want ok = %v", c.name, c.keepDays, c.keepLast, err, c.ok)
+		}
+	}
+}
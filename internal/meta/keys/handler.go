@@ -0,0 +1,122 @@
+package keys
+
+import (
+	"GoStacker/pkg/response"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UploadPreKeysRequest struct {
+	DeviceID string   `json:"device_id" binding:"required"`
+	PreKeys  []PreKey `json:"one_time_prekeys" binding:"required"`
+}
+
+type RemoveDeviceRequest struct {
+	DeviceID string `json:"device_id" binding:"required"`
+}
+
+func UploadBundleHandler(c *gin.Context) {
+	var req DeviceBundle
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	if err := UploadBundle(userID.(int64), req); err != nil {
+		if errors.Is(err, ErrInvalidBundle) {
+			response.ReplyBadRequest(c, err.Error())
+			return
+		}
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccess(c, "Key bundle uploaded")
+}
+
+func UploadPreKeysHandler(c *gin.Context) {
+	var req UploadPreKeysRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	remaining, err := UploadPreKeys(userID.(int64), req.DeviceID, req.PreKeys)
+	if err != nil {
+		if errors.Is(err, ErrInvalidBundle) {
+			response.ReplyBadRequest(c, err.Error())
+			return
+		}
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"remaining": remaining})
+}
+
+func PreKeyCountHandler(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	n, err := PreKeyCount(userID.(int64), c.Query("device_id"))
+	if err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"remaining": n})
+}
+
+func RemoveDeviceHandler(c *gin.Context) {
+	var req RemoveDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	if err := RemoveDevice(userID.(int64), req.DeviceID); err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccess(c, "Device keys removed")
+}
+
+func FetchBundlesHandler(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	targetID, err := strconv.ParseInt(c.Query("user_id"), 10, 64)
+	if err != nil {
+		response.ReplyBadRequest(c, "invalid user_id")
+		return
+	}
+	bundles, err := FetchBundles(userID.(int64), targetID)
+	if err != nil {
+		if errors.Is(err, ErrNoPermission) {
+			response.ReplyUnauthorized(c, err.Error())
+			return
+		}
+		if errors.Is(err, ErrRateLimited) {
+			response.ReplyTooManyRequests(c, err.Error())
+			return
+		}
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"user_id": targetID, "devices": bundles})
+}
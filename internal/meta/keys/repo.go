@@ -0,0 +1,135 @@
+package keys
+
+import (
+	"GoStacker/pkg/db/mysql"
+	"database/sql"
+	"time"
+)
+
+// queryIdentityKey 返回设备当前的身份公钥，设备未登记时 ok=false
+func queryIdentityKey(userID int64, deviceID string) (string, bool, error) {
+	var key string
+	err := mysql.DB.QueryRow("SELECT identity_key FROM e2e_device_keys WHERE user_id = ? AND device_id = ?", userID, deviceID).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return key, true, nil
+}
+
+// upsertDeviceBundle 写入身份密钥与 signed prekey；身份密钥变化时旧的 one-time prekey 一并作废
+func upsertDeviceBundle(userID int64, b DeviceBundle, identityChanged bool) error {
+	tx, err := mysql.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := `INSERT INTO e2e_device_keys (user_id, device_id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_sig, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE identity_key = VALUES(identity_key), signed_prekey_id = VALUES(signed_prekey_id),
+		signed_prekey = VALUES(signed_prekey), signed_prekey_sig = VALUES(signed_prekey_sig), updated_at = VALUES(updated_at)`
+	if _, err := tx.Exec(q, userID, b.DeviceID, b.IdentityKey, b.SignedPreKey.KeyID, b.SignedPreKey.PublicKey, b.SignedPreKey.Signature, time.Now()); err != nil {
+		return err
+	}
+	if identityChanged {
+		if _, err := tx.Exec("DELETE FROM e2e_one_time_prekeys WHERE user_id = ? AND device_id = ?", userID, b.DeviceID); err != nil {
+			return err
+		}
+	}
+	if err := insertPreKeysTx(tx, userID, b.DeviceID, b.OneTimePreKeys); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertPreKeysTx(tx *sql.Tx, userID int64, deviceID string, prekeys []PreKey) error {
+	for _, pk := range prekeys {
+		// 重复上传同一 key_id 时覆盖
+		q := `INSERT INTO e2e_one_time_prekeys (user_id, device_id, key_id, public_key) VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE public_key = VALUES(public_key)`
+		if _, err := tx.Exec(q, userID, deviceID, pk.KeyID, pk.PublicKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertPreKeys(userID int64, deviceID string, prekeys []PreKey) error {
+	tx, err := mysql.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertPreKeysTx(tx, userID, deviceID, prekeys); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func countPreKeys(userID int64, deviceID string) (int, error) {
+	var n int
+	err := mysql.DB.QueryRow("SELECT COUNT(*) FROM e2e_one_time_prekeys WHERE user_id = ? AND device_id = ?", userID, deviceID).Scan(&n)
+	return n, err
+}
+
+func deleteDevice(userID int64, deviceID string) error {
+	tx, err := mysql.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM e2e_one_time_prekeys WHERE user_id = ? AND device_id = ?", userID, deviceID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM e2e_device_keys WHERE user_id = ? AND device_id = ?", userID, deviceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func queryDeviceBundles(userID int64) ([]DeviceBundle, error) {
+	rows, err := mysql.DB.Query(`SELECT device_id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_sig, updated_at
+		FROM e2e_device_keys WHERE user_id = ? ORDER BY device_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]DeviceBundle, 0)
+	for rows.Next() {
+		var b DeviceBundle
+		if err := rows.Scan(&b.DeviceID, &b.IdentityKey, &b.SignedPreKey.KeyID, &b.SignedPreKey.PublicKey, &b.SignedPreKey.Signature, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, b)
+	}
+	return res, rows.Err()
+}
+
+// claimPreKey 取出并删除设备的一个 one-time prekey，每个 prekey 只会发给一个会话发起方
+func claimPreKey(userID int64, deviceID string) (*PreKey, error) {
+	tx, err := mysql.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var id int64
+	var pk PreKey
+	err = tx.QueryRow(`SELECT id, key_id, public_key FROM e2e_one_time_prekeys
+		WHERE user_id = ? AND device_id = ? ORDER BY id LIMIT 1 FOR UPDATE`, userID, deviceID).Scan(&id, &pk.KeyID, &pk.PublicKey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM e2e_one_time_prekeys WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &pk, nil
+}
@@ -0,0 +1,170 @@
+// Package keys 是端到端加密的公钥目录：按设备保存身份密钥、signed prekey 和 one-time prekey。
+// 服务端只保存和分发公钥，不参与加解密。
+package keys
+
+import (
+	"GoStacker/internal/meta/chat/group"
+	rdb "GoStacker/pkg/db/redis"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	maxPreKeysPerUpload = 200
+	maxKeyLength        = 1024
+
+	// 同一请求方对同一目标用户在一个窗口内最多拉取的次数，每次拉取都会消耗目标设备的 one-time prekey
+	maxFetchesPerWindow = 5
+	fetchWindow         = time.Minute
+	fetchLimitKeyFmt    = "keys:fetch:%d:%d"
+	redisRetry          = 2
+)
+
+var (
+	ErrInvalidBundle = errors.New("invalid key bundle")
+	ErrNoPermission  = errors.New("can only fetch keys of users sharing a room with you")
+	ErrRateLimited   = errors.New("too many key fetches for this user, try again later")
+)
+
+type PreKey struct {
+	KeyID     int    `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+type SignedPreKey struct {
+	KeyID     int    `json:"key_id"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// DeviceBundle 是一个设备上传或对外提供的密钥包
+type DeviceBundle struct {
+	DeviceID       string       `json:"device_id"`
+	IdentityKey    string       `json:"identity_key"`
+	SignedPreKey   SignedPreKey `json:"signed_prekey"`
+	OneTimePreKeys []PreKey     `json:"one_time_prekeys,omitempty"`
+	// OneTimePreKey 仅在拉取时返回，是为本次会话领取的一个 prekey，可能为空
+	OneTimePreKey *PreKey   `json:"one_time_prekey,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// UploadBundle 上传或轮换设备的密钥包。身份密钥变化视为设备重置，旧 one-time prekey 全部作废；
+// 只轮换 signed prekey 时保留已有 one-time prekey。
+func UploadBundle(userID int64, b DeviceBundle) error {
+	if err := validateBundle(b); err != nil {
+		return err
+	}
+	current, ok, err := queryIdentityKey(userID, b.DeviceID)
+	if err != nil {
+		return err
+	}
+	return upsertDeviceBundle(userID, b, ok && current != b.IdentityKey)
+}
+
+// UploadPreKeys 补充 one-time prekey，设备必须已上传过密钥包
+func UploadPreKeys(userID int64, deviceID string, prekeys []PreKey) (int, error) {
+	if deviceID == "" || len(prekeys) == 0 || len(prekeys) > maxPreKeysPerUpload {
+		return 0, ErrInvalidBundle
+	}
+	for _, pk := range prekeys {
+		if !validKey(pk.PublicKey) {
+			return 0, ErrInvalidBundle
+		}
+	}
+	if _, ok, err := queryIdentityKey(userID, deviceID); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, errors.New("device has no key bundle")
+	}
+	if err := insertPreKeys(userID, deviceID, prekeys); err != nil {
+		return 0, err
+	}
+	return countPreKeys(userID, deviceID)
+}
+
+// PreKeyCount 返回设备剩余的 one-time prekey 数量，客户端据此决定是否补充
+func PreKeyCount(userID int64, deviceID string) (int, error) {
+	return countPreKeys(userID, deviceID)
+}
+
+func RemoveDevice(userID int64, deviceID string) error {
+	return deleteDevice(userID, deviceID)
+}
+
+// FetchBundles 返回目标用户所有设备的密钥包，并为每个设备领取一个 one-time prekey。
+// 只能拉取自己或与自己同在一个房间的用户，且按 (请求方, 目标) 限频。
+func FetchBundles(requestUserID int64, userID int64) ([]DeviceBundle, error) {
+	if requestUserID != userID {
+		ok, err := shareRoom(requestUserID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrNoPermission
+		}
+	}
+	n, err := rdb.IncrEXWithRetry(redisRetry, fmt.Sprintf(fetchLimitKeyFmt, requestUserID, userID), fetchWindow)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxFetchesPerWindow {
+		return nil, ErrRateLimited
+	}
+	bundles, err := queryDeviceBundles(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bundles {
+		pk, err := claimPreKey(userID, bundles[i].DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		bundles[i].OneTimePreKey = pk
+	}
+	return bundles, nil
+}
+
+// shareRoom 判断两个用户是否至少同在一个房间
+func shareRoom(a, b int64) (bool, error) {
+	roomsA, err := group.GetJoinedChatRooms(a)
+	if err != nil {
+		return false, err
+	}
+	roomsB, err := group.GetJoinedChatRooms(b)
+	if err != nil {
+		return false, err
+	}
+	joined := make(map[int64]struct{}, len(roomsA))
+	for _, r := range roomsA {
+		joined[r] = struct{}{}
+	}
+	for _, r := range roomsB {
+		if _, ok := joined[r]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func validateBundle(b DeviceBundle) error {
+	if b.DeviceID == "" || len(b.DeviceID) > 64 {
+		return ErrInvalidBundle
+	}
+	if !validKey(b.IdentityKey) || !validKey(b.SignedPreKey.PublicKey) || !validKey(b.SignedPreKey.Signature) {
+		return ErrInvalidBundle
+	}
+	if len(b.OneTimePreKeys) > maxPreKeysPerUpload {
+		return ErrInvalidBundle
+	}
+	for _, pk := range b.OneTimePreKeys {
+		if !validKey(pk.PublicKey) {
+			return ErrInvalidBundle
+		}
+	}
+	return nil
+}
+
+func validKey(k string) bool {
+	return k != "" && len(k) <= maxKeyLength
+}
@@ -3,6 +3,7 @@ package user
 import (
 	"GoStacker/pkg/response"
 	"GoStacker/pkg/utils"
+	"errors"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -19,6 +20,15 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type LogoutRequest struct {
+	All bool `json:"all"`
+}
+
+type BanRequest struct {
+	UserID int64 `json:"user_id" binding:"required"`
+	Banned bool  `json:"banned"`
+}
+
 func RegisterHandler(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -57,6 +67,16 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
+	banned, err := IsUserBanned(id)
+	if err != nil {
+		response.ReplyError500(c, "Failed to check user status")
+		return
+	}
+	if banned {
+		response.ReplyUnauthorized(c, "User is banned")
+		return
+	}
+
 	token, err := utils.GenerateToken(req.Username, id)
 	if err != nil {
 		zap.L().Error("failed to generate token", zap.Error(err))
@@ -65,3 +85,43 @@ func LoginHandler(c *gin.Context) {
 	}
 	response.ReplySuccessWithData(c, "Login successful", gin.H{"token": token})
 }
+
+func LogoutHandler(c *gin.Context) {
+	var req LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.ReplyBadRequest(c, err.Error())
+			return
+		}
+	}
+	v, _ := c.Get("claims")
+	claims, ok := v.(*utils.JWTClaims)
+	if !ok {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	if err := Logout(claims, req.All); err != nil {
+		zap.L().Error("failed to revoke token", zap.Int64("user_id", claims.UserID), zap.Error(err))
+		response.ReplyError500(c, "Failed to logout")
+		return
+	}
+	response.ReplySuccess(c, "Logout successful")
+}
+
+// InternalBanHandler 供运维/管理后台调用，不走 JWT，需携带 X-Internal-Token
+func InternalBanHandler(c *gin.Context) {
+	var req BanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, err.Error())
+		return
+	}
+	if err := SetBanned(req.UserID, req.Banned); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			response.ReplyNotFound(c, err.Error())
+			return
+		}
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"user_id": req.UserID, "banned": req.Banned})
+}
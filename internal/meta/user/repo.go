@@ -28,3 +28,22 @@ func GetUserByUsername(username string) (string, int64, error) {
 	}
 	return passwordHash, id, nil
 }
+
+func IsUserBanned(id int64) (bool, error) {
+	var banned bool
+	err := mysql.DB.QueryRow("SELECT is_banned FROM users WHERE id = ?", id).Scan(&banned)
+	if err != nil {
+		return false, fmt.Errorf("failed to query user ban status: %w", err)
+	}
+	return banned, nil
+}
+
+func UpdateUserBanned(id int64, banned bool) (bool, error) {
+	res, err := mysql.DB.Exec("UPDATE users SET is_banned = ? WHERE id = ?", banned, id)
+	if err != nil {
+		zap.L().Error("failed to update user ban status", zap.Int64("user_id", id), zap.Error(err))
+		return false, fmt.Errorf("failed to update user ban status: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
@@ -1,9 +1,13 @@
 package user
 
 import (
+	"GoStacker/pkg/revocation"
+	"GoStacker/pkg/utils"
 	"errors"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+
 func CreateUser(username, hashedPassword, nickname string) error {
 	// Simulate a user creation process
 	if username == "" || hashedPassword == "" {
@@ -22,3 +26,26 @@ func CreateUser(username, hashedPassword, nickname string) error {
 	}
 	return nil
 }
+
+// Logout 吊销当前 token；all 为 true 时吊销该用户已签发的全部 token（所有设备登出）
+func Logout(claims *utils.JWTClaims, all bool) error {
+	if all || claims.ID == "" || claims.ExpiresAt == nil {
+		return revocation.RevokeUser(claims.UserID, revocation.ReasonLogout)
+	}
+	return revocation.RevokeToken(claims.UserID, claims.ID, claims.ExpiresAt.Time, revocation.ReasonLogout)
+}
+
+// SetBanned 封禁或解封用户。封禁时吊销其全部 token，已建立的 WebSocket 连接随之被 gateway 关闭
+func SetBanned(userID int64, banned bool) error {
+	found, err := UpdateUserBanned(userID, banned)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrUserNotFound
+	}
+	if banned {
+		return revocation.RevokeUser(userID, revocation.ReasonBanned)
+	}
+	return nil
+}
@@ -12,7 +12,9 @@ import (
 
 	"GoStacker/pkg/config"
 	rdb "GoStacker/pkg/db/redis"
+	"GoStacker/pkg/middleware"
 	"GoStacker/pkg/registry_client"
+	"GoStacker/pkg/wire"
 
 	goredis "github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
@@ -39,6 +41,8 @@ type Reclaimer struct {
 	gatewayClient *registry_client.GatewayClient
 	sendClient    *registry_client.SendClient
 	httpClient    *http.Client
+	// internalToken 调用 send 服务 /internal/pushback 时携带
+	internalToken string
 
 	consumerName string
 	streamSuffix string
@@ -89,13 +93,14 @@ func NewReclaimer(appCfg *config.AppConfig) (*Reclaimer, error) {
 		httpClient: &http.Client{
 			Timeout: 3 * time.Second,
 		},
-		consumerName: consumerName,
-		streamSuffix: streamSuffix,
-		groupSuffix:  groupSuffix,
-		claimIdle:    claimIdle,
-		interval:     interval,
-		batchSize:    batchSize,
-		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		internalToken: appCfg.RegistryConfig.InternalToken,
+		consumerName:  consumerName,
+		streamSuffix:  streamSuffix,
+		groupSuffix:   groupSuffix,
+		claimIdle:     claimIdle,
+		interval:      interval,
+		batchSize:     batchSize,
+		rand:          rand.New(rand.NewSource(time.Now().UnixNano())),
 	}, nil
 }
 
@@ -244,6 +249,7 @@ func (r *Reclaimer) pushbackToSend(ctx context.Context, targetID int64, forwardR
 			continue
 		}
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.InternalTokenHeader, r.internalToken)
 
 		resp, err := r.httpClient.Do(req)
 		if err != nil {
@@ -264,22 +270,7 @@ func (r *Reclaimer) pushbackToSend(ctx context.Context, targetID int64, forwardR
 
 func xMessageToPushMessage(m goredis.XMessage) (PushMessage, error) {
 	var msgData PushMessage
-	raw, ok := m.Values["data"]
-	if !ok {
-		return msgData, fmt.Errorf("stream message missing data field")
-	}
-
-	var payload []byte
-	switch v := raw.(type) {
-	case string:
-		payload = []byte(v)
-	case []byte:
-		payload = v
-	default:
-		return msgData, fmt.Errorf("unsupported data type: %T", raw)
-	}
-
-	if err := json.Unmarshal(payload, &msgData); err != nil {
+	if err := wire.DecodeStreamValues(m.Values, &msgData); err != nil {
 		return msgData, err
 	}
 	return msgData, nil
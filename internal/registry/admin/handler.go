@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"GoStacker/pkg/config"
+	"GoStacker/pkg/response"
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// AuthMiddleware requires the X-Admin-Token header to match registry.admin_token.
+// Admin endpoints are disabled while no token is configured.
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var token string
+		if config.Conf != nil && config.Conf.RegistryConfig != nil {
+			token = config.Conf.RegistryConfig.AdminToken
+		}
+		provided := c.GetHeader("X-Admin-Token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			zap.L().Warn("Rejected admin request", zap.String("path", c.FullPath()), zap.String("ip", c.ClientIP()))
+			response.ReplyUnauthorized(c, "Admin token required")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// KickHandler force-disconnects a user cluster-wide
+func KickHandler(c *gin.Context) {
+	var req KickRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		zap.L().Warn("Invalid kick request", zap.Error(err))
+		response.ReplyBadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	result, err := KickUser(req)
+	if err != nil {
+		zap.L().Error("Failed to kick user", zap.Int64("user_id", req.UserID), zap.Error(err))
+		response.ReplyError500(c, "Failed to kick user: "+err.Error())
+		return
+	}
+
+	response.ReplySuccessWithData(c, "User kicked", result)
+}
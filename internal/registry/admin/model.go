@@ -0,0 +1,26 @@
+package admin
+
+// KickRequest represents a force-disconnect request from ops
+type KickRequest struct {
+	UserID   int64  `json:"user_id" binding:"required"`
+	DeviceID string `json:"device_id"` // empty kicks every device of the user
+	Reason   string `json:"reason"`
+	// BlockSeconds overrides registry.kick_block_duration; negative disables the reconnect block
+	BlockSeconds int `json:"block_seconds"`
+}
+
+// KickResult reports where the kick command was delivered
+type KickResult struct {
+	UserID       int64         `json:"user_id"`
+	DeviceID     string        `json:"device_id,omitempty"`
+	BlockedUntil int64         `json:"blocked_until,omitempty"`
+	Gateways     []KickGateway `json:"gateways"`
+}
+
+// KickGateway is one gateway that held a connection of the kicked user
+type KickGateway struct {
+	GatewayID string   `json:"gateway_id"`
+	Devices   []string `json:"devices"`
+	// Delivered is false when no gateway process was subscribed to the control channel
+	Delivered bool `json:"delivered"`
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"GoStacker/internal/registry/user"
+	"GoStacker/pkg/config"
+	"GoStacker/pkg/db/redis"
+	"GoStacker/pkg/registry_client"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultKickReason = "kicked by administrator"
+
+func kickBlockDuration(req KickRequest) time.Duration {
+	if req.BlockSeconds < 0 {
+		return 0
+	}
+	if req.BlockSeconds > 0 {
+		return time.Duration(req.BlockSeconds) * time.Second
+	}
+	if config.Conf != nil && config.Conf.RegistryConfig != nil && config.Conf.RegistryConfig.KickBlockDuration > 0 {
+		return time.Duration(config.Conf.RegistryConfig.KickBlockDuration) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// KickUser force-disconnects a user (or one device) on every gateway holding a connection.
+// The reconnect block is written first, so a client reconnecting while the command is in
+// flight is already rejected by whichever gateway it reaches.
+func KickUser(req KickRequest) (*KickResult, error) {
+	if req.Reason == "" {
+		req.Reason = defaultKickReason
+	}
+	result := &KickResult{UserID: req.UserID, DeviceID: req.DeviceID, Gateways: []KickGateway{}}
+
+	if block := kickBlockDuration(req); block > 0 {
+		until := time.Now().Add(block)
+		if err := redis.SetEXWithRetry(2, registry_client.KickBlockKey(req.UserID, req.DeviceID), req.Reason, block); err != nil {
+			return nil, err
+		}
+		result.BlockedUntil = until.Unix()
+	}
+
+	routes, err := user.GetUserRoutes(req.UserID)
+	if err != nil && !errors.Is(err, user.ErrUserRouteNotFound) {
+		return nil, err
+	}
+	devicesByGateway := make(map[string][]string)
+	for _, r := range routes {
+		if r.Status != "connected" || (req.DeviceID != "" && r.DeviceID != req.DeviceID) {
+			continue
+		}
+		devicesByGateway[r.GatewayID] = append(devicesByGateway[r.GatewayID], r.DeviceID)
+	}
+
+	cmd := registry_client.ControlCommand{
+		Command:    registry_client.ControlKick,
+		UserID:     req.UserID,
+		DeviceID:   req.DeviceID,
+		Reason:     req.Reason,
+		BlockUntil: result.BlockedUntil,
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	for gatewayID, devices := range devicesByGateway {
+		receivers, err := redis.PublishWithRetry(2, registry_client.ControlChannel(gatewayID), data)
+		if err != nil {
+			zap.L().Error("Failed to publish kick command",
+				zap.String("gateway_id", gatewayID),
+				zap.Int64("user_id", req.UserID),
+				zap.Error(err))
+		}
+		result.Gateways = append(result.Gateways, KickGateway{GatewayID: gatewayID, Devices: devices, Delivered: receivers > 0})
+	}
+	sort.Slice(result.Gateways, func(i, j int) bool { return result.Gateways[i].GatewayID < result.Gateways[j].GatewayID })
+
+	zap.L().Info("User kicked",
+		zap.Int64("user_id", req.UserID),
+		zap.String("device_id", req.DeviceID),
+		zap.String("reason", req.Reason),
+		zap.Int("gateways", len(result.Gateways)),
+		zap.Int64("blocked_until", result.BlockedUntil))
+	return result, nil
+}
@@ -51,6 +51,29 @@ func HeartbeatHandler(c *gin.Context) {
 	response.ReplySuccess(c, "Heartbeat updated successfully")
 }
 
+// DrainHandler marks a gateway as draining before a rolling restart
+func DrainHandler(c *gin.Context) {
+	var req DrainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		zap.L().Warn("Invalid gateway drain request", zap.Error(err))
+		response.ReplyBadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	err := MarkGatewayDraining(req.GatewayID)
+	if err != nil {
+		if err == ErrGatewayNotFound {
+			response.ReplyNotFound(c, "Gateway not registered")
+			return
+		}
+		zap.L().Error("Failed to mark gateway as draining", zap.String("gateway_id", req.GatewayID), zap.Error(err))
+		response.ReplyError500(c, "Failed to mark gateway as draining: "+err.Error())
+		return
+	}
+
+	response.ReplySuccess(c, "Gateway marked as draining")
+}
+
 // UnregisterHandler handles gateway unregistration
 func UnregisterHandler(c *gin.Context) {
 	gatewayID := c.Param("gateway_id")
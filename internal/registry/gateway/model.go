@@ -11,6 +11,9 @@ type GatewayInfo struct {
 	Capacity       int       `json:"capacity"`
 	ConnectedUsers int       `json:"connected_users"`
 	LastHeartbeat  time.Time `json:"last_heartbeat"`
+	// Draining gateways are shutting down: they keep existing connections for a while but
+	// are never handed out to new clients
+	Draining bool `json:"draining"`
 }
 
 // RegisterRequest represents gateway registration request
@@ -26,6 +29,12 @@ type HeartbeatRequest struct {
 	GatewayID      string  `json:"gateway_id" binding:"required"`
 	Load           float32 `json:"load"`
 	ConnectedUsers int     `json:"connected_users"`
+	Draining       bool    `json:"draining"`
+}
+
+// DrainRequest marks a gateway as draining
+type DrainRequest struct {
+	GatewayID string `json:"gateway_id" binding:"required"`
 }
 
 // HealthCheckResponse represents health check response
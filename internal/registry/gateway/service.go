@@ -103,6 +103,7 @@ func UpdateHeartbeat(req HeartbeatRequest) error {
 	// Update fields
 	info.Load = req.Load
 	info.ConnectedUsers = req.ConnectedUsers
+	info.Draining = info.Draining || req.Draining
 	info.LastHeartbeat = time.Now()
 
 	// Serialize back
@@ -125,6 +126,11 @@ func UpdateHeartbeat(req HeartbeatRequest) error {
 		return err
 	}
 
+	// A draining gateway stays out of the ranking until it registers again
+	if info.Draining {
+		return nil
+	}
+
 	// Update load in ranking (score = load * 1000 for precision)
 	score := float64(req.Load * 1000)
 	err = redis.ZAddWithRetry(2, gatewayRankingKey, score, req.GatewayID)
@@ -141,6 +147,44 @@ func UpdateHeartbeat(req HeartbeatRequest) error {
 	return nil
 }
 
+// MarkGatewayDraining flags a gateway as draining and removes it from the ranking, so
+// GetLowestLoadGateway no longer hands it out while its connections are moved away
+func MarkGatewayDraining(gatewayID string) error {
+	info, err := GetGatewayInfo(gatewayID)
+	if err != nil {
+		return err
+	}
+	info.Draining = true
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		zap.L().Error("Failed to marshal gateway info", zap.Error(err))
+		return err
+	}
+
+	ttl := 30 * time.Second
+	if config.Conf != nil && config.Conf.RegistryConfig != nil {
+		ttl = time.Duration(config.Conf.RegistryConfig.GatewayHeartbeatTimeout) * time.Second
+	}
+
+	key := gatewayInfoPrefix + gatewayID
+	err = redis.SetEXWithRetry(2, key, string(data), ttl)
+	if err != nil {
+		zap.L().Error("Failed to update gateway info", zap.String("gateway_id", gatewayID), zap.Error(err))
+		return err
+	}
+
+	err = redis.ZRemWithRetry(2, gatewayRankingKey, gatewayID)
+	if err != nil {
+		zap.L().Error("Failed to remove gateway from ranking", zap.String("gateway_id", gatewayID), zap.Error(err))
+		return err
+	}
+
+	zap.L().Info("Gateway marked as draining", zap.String("gateway_id", gatewayID))
+
+	return nil
+}
+
 // UnregisterGateway removes a gateway from registry
 func UnregisterGateway(gatewayID string) error {
 	key := gatewayInfoPrefix + gatewayID
@@ -210,7 +254,7 @@ func GetLowestLoadGateway() (*GatewayInfo, error) {
 	}
 
 	// Check capacity
-	if info.ConnectedUsers >= info.Capacity {
+	if info.Draining || info.ConnectedUsers >= info.Capacity {
 		zap.L().Warn("Lowest load gateway is at capacity",
 			zap.String("gateway_id", gatewayID),
 			zap.Int("connected", info.ConnectedUsers),
@@ -238,7 +282,7 @@ func getNextAvailableGateway(startIndex int) (*GatewayInfo, error) {
 		if err != nil {
 			continue
 		}
-		if info.ConnectedUsers < info.Capacity {
+		if !info.Draining && info.ConnectedUsers < info.Capacity {
 			return info, nil
 		}
 	}
@@ -3,6 +3,7 @@ package user
 import (
 	"GoStacker/internal/registry/gateway"
 	sendreg "GoStacker/internal/registry/send"
+	"GoStacker/pkg/middleware"
 	"GoStacker/pkg/response"
 	"bytes"
 	"encoding/json"
@@ -23,10 +24,11 @@ func ConnectHandler(c *gin.Context) {
 		return
 	}
 
-	err := RecordUserConnect(req.UserID, req.GatewayID)
+	err := RecordUserConnect(req.UserID, req.DeviceID, req.GatewayID)
 	if err != nil {
 		zap.L().Error("Failed to record user connection",
 			zap.Int64("user_id", req.UserID),
+			zap.String("device_id", req.DeviceID),
 			zap.String("gateway_id", req.GatewayID),
 			zap.Error(err))
 		response.ReplyError500(c, "Failed to record user connection: "+err.Error())
@@ -51,6 +53,7 @@ func ConnectHandler(c *gin.Context) {
 		for i := 0; i < 2; i++ {
 			req, _ := http.NewRequest("POST", url, bytes.NewReader(data))
 			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(middleware.InternalTokenHeader, middleware.InternalToken())
 			resp, err := client.Do(req)
 			if err != nil {
 				zap.L().Warn("Failed to notify send instance", zap.String("url", url), zap.Int("attempt", i), zap.Error(err))
@@ -79,10 +82,11 @@ func DisconnectHandler(c *gin.Context) {
 		return
 	}
 
-	err := RecordUserDisconnect(req.UserID, req.GatewayID)
+	err := RecordUserDisconnect(req.UserID, req.DeviceID, req.GatewayID)
 	if err != nil {
 		zap.L().Error("Failed to record user disconnection",
 			zap.Int64("user_id", req.UserID),
+			zap.String("device_id", req.DeviceID),
 			zap.String("gateway_id", req.GatewayID),
 			zap.Error(err))
 		response.ReplyError500(c, "Failed to record user disconnection: "+err.Error())
@@ -138,7 +142,7 @@ func GetAvailableGatewayHandler(c *gin.Context) {
 	}
 
 	// Get available gateway for specific user (with reconnection optimization)
-	gwResp, err := GetAvailableGatewayForUser(userID)
+	gwResp, err := GetAvailableGatewayForUser(userID, c.Query("device_id"))
 	if err != nil {
 		if err == gateway.ErrNoAvailableGateway {
 			zap.L().Error("No available gateway for user", zap.Int64("user_id", userID))
@@ -2,24 +2,33 @@ package user
 
 import "time"
 
-// UserRoute represents user to gateway mapping
+// DefaultDeviceID is used when a gateway reports a connection without device id
+const DefaultDeviceID = "default"
+
+// UserRoute represents one device of a user connected to a gateway
 type UserRoute struct {
 	UserID      int64     `json:"user_id"`
+	DeviceID    string    `json:"device_id"`
 	GatewayID   string    `json:"gateway_id"`
 	Address     string    `json:"address"`
 	ConnectedAt time.Time `json:"connected_at"`
-	Status      string    `json:"status"` // "connected" or "disconnected"
+	// LastSeen is the last time the route was written; while connected the gateway's
+	// heartbeat counts as the device being seen
+	LastSeen time.Time `json:"last_seen"`
+	Status   string    `json:"status"` // "connected" or "disconnected"
 }
 
 // ConnectRequest represents user connection notification
 type ConnectRequest struct {
 	UserID    int64  `json:"user_id" binding:"required"`
+	DeviceID  string `json:"device_id"`
 	GatewayID string `json:"gateway_id" binding:"required"`
 }
 
 // DisconnectRequest represents user disconnection notification
 type DisconnectRequest struct {
 	UserID    int64  `json:"user_id" binding:"required"`
+	DeviceID  string `json:"device_id"`
 	GatewayID string `json:"gateway_id" binding:"required"`
 }
 
@@ -28,8 +37,9 @@ type BatchQueryRoutesRequest struct {
 	UserIDs []int64 `json:"user_ids" binding:"required"`
 }
 
-// RouteInfo represents simplified route information in response
+// RouteInfo represents simplified route information of one device in response
 type RouteInfo struct {
+	DeviceID  string `json:"device_id"`
 	GatewayID string `json:"gateway_id"`
 	Address   string `json:"address"`
 }
@@ -6,6 +6,7 @@ import (
 	"GoStacker/pkg/db/redis"
 	"encoding/json"
 	"errors"
+	"sort"
 	"strconv"
 	"time"
 
@@ -13,8 +14,9 @@ import (
 )
 
 const (
-	// Redis key prefix for user routes
-	userRoutePrefix = "route:user:"
+	// Redis key prefix for user routes, one hash per user: field = device id, value = UserRoute JSON.
+	// (the former single-route string keys route:user:<id> simply expire)
+	userRoutePrefix = "route:devices:"
 )
 
 var (
@@ -22,8 +24,45 @@ var (
 	ErrInvalidRouteData  = errors.New("invalid route data")
 )
 
-// RecordUserConnect records user connection to a gateway
-func RecordUserConnect(userID int64, gatewayID string) error {
+func userRouteKey(userID int64) string {
+	return userRoutePrefix + strconv.FormatInt(userID, 10)
+}
+
+func normalizeDeviceID(deviceID string) string {
+	if deviceID == "" {
+		return DefaultDeviceID
+	}
+	return deviceID
+}
+
+func userRouteTTL() time.Duration {
+	ttl := 120 * time.Second
+	if config.Conf != nil && config.Conf.RegistryConfig != nil {
+		ttl = time.Duration(config.Conf.RegistryConfig.UserRouteTTL) * time.Second
+	}
+	return ttl
+}
+
+// parseUserRoutes decodes all device routes of a user, dropping malformed entries
+func parseUserRoutes(userID int64, fields map[string]string) []*UserRoute {
+	routes := make([]*UserRoute, 0, len(fields))
+	for deviceID, val := range fields {
+		var route UserRoute
+		if err := json.Unmarshal([]byte(val), &route); err != nil {
+			zap.L().Warn("Failed to unmarshal user route",
+				zap.Int64("user_id", userID),
+				zap.String("device_id", deviceID),
+				zap.Error(err))
+			continue
+		}
+		routes = append(routes, &route)
+	}
+	return routes
+}
+
+// RecordUserConnect records a device of the user connecting to a gateway
+func RecordUserConnect(userID int64, deviceID, gatewayID string) error {
+	deviceID = normalizeDeviceID(deviceID)
 	// Get gateway info to include address
 	gwInfo, err := gateway.GetGatewayInfo(gatewayID)
 	if err != nil {
@@ -37,9 +76,11 @@ func RecordUserConnect(userID int64, gatewayID string) error {
 	now := time.Now()
 	route := UserRoute{
 		UserID:      userID,
+		DeviceID:    deviceID,
 		GatewayID:   gatewayID,
 		Address:     gateway.GetGatewayAddress(gwInfo),
 		ConnectedAt: now,
+		LastSeen:    now,
 		Status:      "connected",
 	}
 
@@ -50,41 +91,96 @@ func RecordUserConnect(userID int64, gatewayID string) error {
 		return err
 	}
 
-	// Get TTL from config
-	ttl := 120 * time.Second
-	if config.Conf != nil && config.Conf.RegistryConfig != nil {
-		ttl = time.Duration(config.Conf.RegistryConfig.UserRouteTTL) * time.Second
-	}
-
-	// Store in Redis with TTL
-	key := userRoutePrefix + strconv.FormatInt(userID, 10)
-	err = redis.SetEXWithRetry(2, key, string(data), ttl)
+	// Store in Redis, the TTL of the whole hash is refreshed on every change
+	ttl := userRouteTTL()
+	key := userRouteKey(userID)
+	err = redis.HSetWithRetry(2, key, deviceID, string(data), ttl)
 	if err != nil {
 		zap.L().Error("Failed to set user route in Redis",
 			zap.Int64("user_id", userID),
+			zap.String("device_id", deviceID),
 			zap.String("gateway_id", gatewayID),
 			zap.Error(err))
 		return err
 	}
+	pruneStaleDevices(userID, ttl)
 
 	zap.L().Info("User route recorded",
 		zap.Int64("user_id", userID),
+		zap.String("device_id", deviceID),
 		zap.String("gateway_id", gatewayID),
 		zap.String("address", route.Address))
 
 	return nil
 }
 
-// RecordUserDisconnect updates user route on disconnection
-// Does not delete the route, just sets TTL to allow quick reconnection
-func RecordUserDisconnect(userID int64, gatewayID string) error {
-	key := userRoutePrefix + strconv.FormatInt(userID, 10)
+// pruneStaleDevices removes devices not seen for longer than ttl, whatever their status.
+// The hash itself lives as long as any device keeps reconnecting, so without this the
+// "connected" routes left behind by a gateway that crashed would never go away.
+func pruneStaleDevices(userID int64, ttl time.Duration) {
+	routes, err := GetUserRoutes(userID)
+	if err != nil {
+		return
+	}
+	heartbeats := make(map[string]time.Time)
+	var stale []string
+	for _, route := range routes {
+		if time.Since(routeLastSeen(route, heartbeats)) > ttl {
+			stale = append(stale, route.DeviceID)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	if err := redis.HDelWithRetry(2, userRouteKey(userID), stale...); err != nil {
+		zap.L().Warn("Failed to prune stale device routes", zap.Int64("user_id", userID), zap.Error(err))
+	}
+}
+
+// routeLastSeen returns when the device was last known to be alive. A connected device is
+// alive as long as its gateway keeps sending heartbeats; once the gateway is gone only the
+// time the route was written counts. heartbeats caches the gateway lookups of one pass.
+func routeLastSeen(route *UserRoute, heartbeats map[string]time.Time) time.Time {
+	seen := route.LastSeen
+	if seen.IsZero() {
+		// routes written before last_seen was recorded
+		seen = route.ConnectedAt
+	}
+	if route.Status != "connected" {
+		return seen
+	}
+	hb, ok := heartbeats[route.GatewayID]
+	if !ok {
+		if info, err := gateway.GetGatewayInfo(route.GatewayID); err == nil {
+			hb = info.LastHeartbeat
+		}
+		heartbeats[route.GatewayID] = hb
+	}
+	if hb.After(seen) {
+		return hb
+	}
+	return seen
+}
+
+// RecordUserDisconnect updates the device route on disconnection
+// Does not delete the route, just keeps it until TTL to allow quick reconnection
+func RecordUserDisconnect(userID int64, deviceID, gatewayID string) error {
+	deviceID = normalizeDeviceID(deviceID)
+	key := userRouteKey(userID)
 
 	// Get existing route
-	data, err := redis.GetWithRetry(2, key)
+	fields, err := redis.HGetAllWithRetry(2, key)
 	if err != nil {
+		zap.L().Error("Failed to get user routes for disconnect",
+			zap.Int64("user_id", userID),
+			zap.Error(err))
+		return err
+	}
+	data, ok := fields[deviceID]
+	if !ok {
 		zap.L().Warn("User route not found for disconnect",
 			zap.Int64("user_id", userID),
+			zap.String("device_id", deviceID),
 			zap.String("gateway_id", gatewayID))
 		// Not an error, user might have already expired
 		return nil
@@ -101,6 +197,7 @@ func RecordUserDisconnect(userID int64, gatewayID string) error {
 	if route.GatewayID != gatewayID {
 		zap.L().Warn("Gateway ID mismatch on disconnect",
 			zap.Int64("user_id", userID),
+			zap.String("device_id", deviceID),
 			zap.String("expected", gatewayID),
 			zap.String("actual", route.GatewayID))
 		// Don't update if gateway doesn't match
@@ -109,6 +206,7 @@ func RecordUserDisconnect(userID int64, gatewayID string) error {
 
 	// Update status to disconnected
 	route.Status = "disconnected"
+	route.LastSeen = time.Now()
 
 	// Serialize back
 	newData, err := json.Marshal(route)
@@ -117,83 +215,67 @@ func RecordUserDisconnect(userID int64, gatewayID string) error {
 		return err
 	}
 
-	// Get TTL from config (keep same TTL for quick reconnection)
-	ttl := 120 * time.Second
-	if config.Conf != nil && config.Conf.RegistryConfig != nil {
-		ttl = time.Duration(config.Conf.RegistryConfig.UserRouteTTL) * time.Second
-	}
-
-	// Update in Redis with TTL
-	err = redis.SetEXWithRetry(2, key, string(newData), ttl)
+	// keep same TTL for quick reconnection
+	ttl := userRouteTTL()
+	err = redis.HSetWithRetry(2, key, deviceID, string(newData), ttl)
 	if err != nil {
 		zap.L().Error("Failed to update user route on disconnect",
 			zap.Int64("user_id", userID),
+			zap.String("device_id", deviceID),
 			zap.Error(err))
 		return err
 	}
 
 	zap.L().Info("User route updated on disconnect (retained with TTL)",
 		zap.Int64("user_id", userID),
+		zap.String("device_id", deviceID),
 		zap.String("gateway_id", gatewayID),
 		zap.Duration("ttl", ttl))
 
 	return nil
 }
 
-// GetUserRoute retrieves user route information
-func GetUserRoute(userID int64) (*UserRoute, error) {
-	key := userRoutePrefix + strconv.FormatInt(userID, 10)
-	data, err := redis.GetWithRetry(2, key)
+// GetUserRoutes retrieves the routes of all known devices of a user
+func GetUserRoutes(userID int64) ([]*UserRoute, error) {
+	fields, err := redis.HGetAllWithRetry(2, userRouteKey(userID))
 	if err != nil {
-		return nil, ErrUserRouteNotFound
+		return nil, err
 	}
-
-	var route UserRoute
-	err = json.Unmarshal([]byte(data), &route)
-	if err != nil {
-		zap.L().Error("Failed to unmarshal user route", zap.Error(err))
-		return nil, ErrInvalidRouteData
+	if len(fields) == 0 {
+		return nil, ErrUserRouteNotFound
 	}
-
-	return &route, nil
+	return parseUserRoutes(userID, fields), nil
 }
 
-// BatchGetUserRoutes retrieves multiple user routes at once
-func BatchGetUserRoutes(userIDs []int64) (map[int64]*RouteInfo, error) {
-	result := make(map[int64]*RouteInfo)
+// BatchGetUserRoutes retrieves the connected devices of multiple users at once.
+// Users without any connected device are omitted (offline).
+func BatchGetUserRoutes(userIDs []int64) (map[int64][]*RouteInfo, error) {
+	result := make(map[int64][]*RouteInfo)
 
 	// Build keys
 	keys := make([]string, len(userIDs))
 	for i, userID := range userIDs {
-		keys[i] = userRoutePrefix + strconv.FormatInt(userID, 10)
+		keys[i] = userRouteKey(userID)
 	}
 
 	// Batch get from Redis
-	values, err := redis.MGetWithRetry(2, keys)
+	values, err := redis.MHGetAllWithRetry(2, keys)
 	if err != nil {
 		zap.L().Error("Failed to batch get user routes", zap.Error(err))
 		return nil, err
 	}
 
 	// Parse results
-	for i, val := range values {
-		if val == "" {
-			// User offline or route not found
-			continue
-		}
-
-		var route UserRoute
-		err := json.Unmarshal([]byte(val), &route)
-		if err != nil {
-			zap.L().Warn("Failed to unmarshal user route in batch",
-				zap.Int64("user_id", userIDs[i]),
-				zap.Error(err))
-			continue
-		}
-
-		result[userIDs[i]] = &RouteInfo{
-			GatewayID: route.GatewayID,
-			Address:   route.Address,
+	for i, fields := range values {
+		for _, route := range parseUserRoutes(userIDs[i], fields) {
+			if route.Status != "connected" {
+				continue
+			}
+			result[userIDs[i]] = append(result[userIDs[i]], &RouteInfo{
+				DeviceID:  route.DeviceID,
+				GatewayID: route.GatewayID,
+				Address:   route.Address,
+			})
 		}
 	}
 
@@ -201,29 +283,38 @@ func BatchGetUserRoutes(userIDs []int64) (map[int64]*RouteInfo, error) {
 }
 
 // GetAvailableGatewayForUser returns an available gateway for user connection
-// Implements the logic: check existing route first, then select lowest load gateway
-func GetAvailableGatewayForUser(userID int64) (*GatewayAvailableResponse, error) {
-	// 1. Check if user has existing route (for reconnection optimization)
-	existingRoute, err := GetUserRoute(userID)
-	if err == nil && existingRoute != nil {
-		// Verify gateway is still healthy
-		if gateway.IsGatewayHealthy(existingRoute.GatewayID) {
+// Implements the logic: reuse the gateway of the same device, then of another device of the user
+// (so one stream entry reaches all of them), then select lowest load gateway
+func GetAvailableGatewayForUser(userID int64, deviceID string) (*GatewayAvailableResponse, error) {
+	// 1. Check if user has existing routes (for reconnection optimization)
+	routes, err := GetUserRoutes(userID)
+	if err == nil {
+		deviceID = normalizeDeviceID(deviceID)
+		sort.SliceStable(routes, func(i, j int) bool {
+			return routes[i].DeviceID == deviceID && routes[j].DeviceID != deviceID
+		})
+		for _, existingRoute := range routes {
+			// Verify gateway is still healthy
+			if !gateway.IsGatewayHealthy(existingRoute.GatewayID) {
+				continue
+			}
 			gwInfo, err := gateway.GetGatewayInfo(existingRoute.GatewayID)
-			if err == nil {
-				zap.L().Info("User reconnecting to previous gateway",
-					zap.Int64("user_id", userID),
-					zap.String("gateway_id", existingRoute.GatewayID))
-				return &GatewayAvailableResponse{
-					GatewayID: gwInfo.GatewayID,
-					Address:   gwInfo.Address,
-					Port:      gwInfo.Port,
-				}, nil
+			if err != nil || gwInfo.Draining {
+				continue
 			}
+			zap.L().Info("User reconnecting to previous gateway",
+				zap.Int64("user_id", userID),
+				zap.String("device_id", existingRoute.DeviceID),
+				zap.String("gateway_id", existingRoute.GatewayID))
+			return &GatewayAvailableResponse{
+				GatewayID: gwInfo.GatewayID,
+				Address:   gwInfo.Address,
+				Port:      gwInfo.Port,
+			}, nil
 		}
-		// Gateway not healthy anymore, proceed to select new one
-		zap.L().Info("Previous gateway not available, selecting new one",
-			zap.Int64("user_id", userID),
-			zap.String("old_gateway_id", existingRoute.GatewayID))
+		// Gateways not healthy anymore, proceed to select new one
+		zap.L().Info("Previous gateways not available, selecting new one",
+			zap.Int64("user_id", userID))
 	}
 
 	// 2. Select gateway with lowest load
@@ -247,10 +338,9 @@ func GetAvailableGatewayForUser(userID int64) (*GatewayAvailableResponse, error)
 	}, nil
 }
 
-// DeleteUserRoute explicitly deletes a user route (for cleanup)
+// DeleteUserRoute explicitly deletes all device routes of a user (for cleanup)
 func DeleteUserRoute(userID int64) error {
-	key := userRoutePrefix + strconv.FormatInt(userID, 10)
-	err := redis.DelWithRetry(2, key)
+	err := redis.DelWithRetry(2, userRouteKey(userID))
 	if err != nil {
 		zap.L().Error("Failed to delete user route", zap.Int64("user_id", userID), zap.Error(err))
 		return err
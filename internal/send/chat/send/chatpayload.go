@@ -1,6 +1,7 @@
 package send
 
 import (
+	"GoStacker/pkg/wire"
 	"encoding/json"
 	"fmt"
 )
@@ -17,6 +18,16 @@ type ImagePayload struct {
 	URL    string `json:"url"`
 	Width  int    `json:"width"`
 	Height int    `json:"height"`
+	// 图片来自上传服务时由服务端填写，客户端据此在原图下载完成前渲染预览
+	Blurhash   string           `json:"blurhash,omitempty"`
+	Thumbnails []ImageThumbnail `json:"thumbnails,omitempty"`
+}
+
+// ImageThumbnail 是服务端生成的 JPEG 缩略图，URL 与原图一样需要签名后下载
+type ImageThumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
 }
 
 type VoicePayload struct {
@@ -30,6 +41,17 @@ type FilePayload struct {
 	Size     int64  `json:"size"` // 字节
 }
 
+// EncryptedPayload 是端到端加密消息，服务端只存储和转发，不解析 Header / Ciphertext
+type EncryptedPayload struct {
+	Algorithm    string       `json:"algorithm"`
+	SenderDevice string       `json:"sender_device"`
+	Header       wire.RawJSON `json:"header,omitempty"` // 各接收设备的会话头/密钥材料，对服务端不透明
+	Ciphertext   string       `json:"ciphertext"`
+}
+
+// maxCiphertextSize 限制单条加密消息的大小（base64 后）
+const maxCiphertextSize = 256 * 1024
+
 func (t TextPayload) GetType() string {
 	return "text"
 }
@@ -46,6 +68,32 @@ func (f FilePayload) GetType() string {
 	return "file"
 }
 
+func (e EncryptedPayload) GetType() string {
+	return "encrypted"
+}
+
+// Preview 用于离线推送通知的正文
+func (t TextPayload) Preview() string {
+	return t.Text
+}
+
+func (i ImagePayload) Preview() string {
+	return "[Image]"
+}
+
+func (v VoicePayload) Preview() string {
+	return "[Voice]"
+}
+
+func (f FilePayload) Preview() string {
+	return "[File] " + f.FileName
+}
+
+// 加密消息的通知不能泄露任何内容
+func (e EncryptedPayload) Preview() string {
+	return "[Encrypted message]"
+}
+
 // UnmarshalChatPayload 根据 content JSON 内的 "type" 字段反序列化为具体的 payload
 func UnmarshalChatPayload(data json.RawMessage) (ChatPayload, error) {
 	var probe struct {
@@ -79,6 +127,19 @@ func UnmarshalChatPayload(data json.RawMessage) (ChatPayload, error) {
 			return nil, fmt.Errorf("invalid file payload: %w", err)
 		}
 		return p, nil
+	case "encrypted":
+		// 只检查结构与大小，不检查内容
+		var p EncryptedPayload
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("invalid encrypted payload: %w", err)
+		}
+		if p.Ciphertext == "" || p.Algorithm == "" {
+			return nil, fmt.Errorf("invalid encrypted payload: algorithm and ciphertext are required")
+		}
+		if len(p.Ciphertext)+len(p.Header) > maxCiphertextSize {
+			return nil, fmt.Errorf("invalid encrypted payload: too large")
+		}
+		return p, nil
 	default:
 		return nil, fmt.Errorf("unknown content type: %s", probe.Type)
 	}
@@ -0,0 +1,126 @@
+package send
+
+import (
+	"GoStacker/internal/meta/chat/group"
+	"GoStacker/internal/send/push"
+	"GoStacker/pkg/db/redis"
+	"errors"
+	"fmt"
+	"strconv"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	// readCursorKeyFmt 记录房间内每个成员的已读位置：field 为 userID，值为最后已读的 msgID
+	readCursorKeyFmt = "read:room:%d"
+
+	defaultSyncLimit = 50
+	maxSyncLimit     = 200
+)
+
+var ErrNotRoomMember = errors.New("not a member of this room")
+
+// SendTyping 向房间其他在线成员广播“正在输入”，大群不广播
+func SendTyping(userID, roomID int64) error {
+	members, err := memberPeers(userID, roomID)
+	if err != nil {
+		return err
+	}
+	if push.IsLargeGroup(len(members) + 1) {
+		return nil
+	}
+	push.PushEvent(members, push.ClientMessage{
+		ID:       sfNode.Generate().Int64(),
+		Type:     "typing",
+		RoomID:   roomID,
+		SenderID: userID,
+	})
+	return nil
+}
+
+// MarkRead 前移用户在房间的已读位置，并向其他在线成员广播已读回执（大群只记录不广播）。
+// 位置只增不减，返回生效后的已读 msgID。
+func MarkRead(userID, roomID, msgID int64) (int64, error) {
+	members, err := memberPeers(userID, roomID)
+	if err != nil {
+		return 0, err
+	}
+	key := fmt.Sprintf(readCursorKeyFmt, roomID)
+	field := strconv.FormatInt(userID, 10)
+	cur, err := redis.SendQueueHGetWithRetry(2, key, field)
+	if err != nil && err != goredis.Nil {
+		return 0, err
+	}
+	if last, _ := strconv.ParseInt(cur, 10, 64); last >= msgID {
+		return last, nil
+	}
+	if err := redis.SendQueueHSetWithRetry(2, key, field, msgID); err != nil {
+		return 0, err
+	}
+	if !push.IsLargeGroup(len(members) + 1) {
+		push.PushEvent(members, push.ClientMessage{
+			ID:       sfNode.Generate().Int64(),
+			Type:     "read",
+			RoomID:   roomID,
+			SenderID: userID,
+			Payload:  map[string]int64{"msg_id": msgID},
+		})
+	}
+	return msgID, nil
+}
+
+// SyncResult 是一次增量同步的结果，HasMore 为 true 时客户端以最后一条的 ID 继续拉取
+type SyncResult struct {
+	Messages []cachedMessage `json:"messages"`
+	HasMore  bool            `json:"has_more"`
+}
+
+// SyncMessages 返回房间内 ID 大于 afterID 的已落库消息，按 ID 升序。
+// 仍在写回缓存中、尚未刷入 MySQL 的消息不在结果内，会在下次同步时出现。
+func SyncMessages(userID, roomID, afterID int64, limit int) (*SyncResult, error) {
+	ok, err := group.IsRoomMember(roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotRoomMember
+	}
+	if limit <= 0 {
+		limit = defaultSyncLimit
+	}
+	if limit > maxSyncLimit {
+		limit = maxSyncLimit
+	}
+	msgs, err := queryMessagesAfter(roomID, afterID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	res := &SyncResult{Messages: msgs}
+	if len(msgs) > limit {
+		res.Messages = msgs[:limit]
+		res.HasMore = true
+	}
+	return res, nil
+}
+
+// memberPeers 校验成员身份并返回房间内除自己以外的成员
+func memberPeers(userID, roomID int64) ([]int64, error) {
+	members, err := group.QueryRoomMemberIDs(roomID)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]int64, 0, len(members))
+	isMember := false
+	for _, uid := range members {
+		if uid == userID {
+			isMember = true
+			continue
+		}
+		peers = append(peers, uid)
+	}
+	if !isMember {
+		return nil, ErrNotRoomMember
+	}
+	return peers, nil
+}
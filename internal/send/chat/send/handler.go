@@ -1,8 +1,14 @@
 package send
 
 import (
+	"GoStacker/internal/send/push"
+	"GoStacker/internal/send/upload"
+	"GoStacker/pkg/config"
 	"GoStacker/pkg/response"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,6 +23,17 @@ type ResendMessageRequest struct {
 	MessageID int64 `json:"message_id" binding:"required"`
 }
 
+type SendBatchItem struct {
+	RoomID  int64           `json:"room_id"`
+	Content json.RawMessage `json:"content"`
+}
+
+type SendBatchRequest struct {
+	Items []SendBatchItem `json:"items" binding:"required"`
+}
+
+const defaultMaxBatchSendItems = 100
+
 func SendMessageHandler(c *gin.Context) {
 	var req SendMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -30,19 +47,21 @@ func SendMessageHandler(c *gin.Context) {
 	}
 	userID := id.(int64)
 
-	// 将 raw content 解析为具体的 ChatPayload
-	payload, err := UnmarshalChatPayload(req.Content)
+	msgID, err := SubmitMessage(req.RoomID, userID, req.Content)
 	if err != nil {
-		response.ReplyBadRequest(c, "Invalid content: "+err.Error())
+		replySubmitError(c, err)
 		return
 	}
+	response.ReplySuccessWithData(c, "success", gin.H{"msgID": msgID})
+}
 
-	id, err = SendMessage(req.RoomID, userID, payload)
-	if err != nil {
-		response.ReplyError500(c, err.Error())
+// replySubmitError 客户端内容问题返回 400，其余按服务端错误处理
+func replySubmitError(c *gin.Context, err error) {
+	if errors.Is(err, ErrInvalidContent) || errors.Is(err, ErrEncryptionRequired) || errors.Is(err, upload.ErrNotIssued) {
+		response.ReplyBadRequest(c, err.Error())
 		return
 	}
-	response.ReplySuccessWithData(c, "success", gin.H{"msgID": id})
+	response.ReplyError500(c, err.Error())
 }
 
 func ResendHandler(c *gin.Context) {
@@ -72,10 +91,100 @@ func ResendHandler(c *gin.Context) {
 		return
 	}
 	//to do,check msg
-	err = BroadcastMessage(req.MessageID, cm.RoomID, userID, payload)
+	err = ResendMessage(req.MessageID, cm.RoomID, userID, payload)
+	if errors.Is(err, ErrNothingToResend) {
+		response.ReplySuccess(c, err.Error())
+		return
+	}
 	if err != nil {
 		response.ReplyError500(c, err.Error())
 		return
 	}
 	response.ReplySuccess(c, "success")
 }
+
+func SendBatchHandler(c *gin.Context) {
+	var req SendBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	id, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	userID := id.(int64)
+
+	maxItems := defaultMaxBatchSendItems
+	if config.Conf != nil && config.Conf.SendDispatcherConfig != nil && config.Conf.SendDispatcherConfig.MaxBatchSendItems > 0 {
+		maxItems = config.Conf.SendDispatcherConfig.MaxBatchSendItems
+	}
+	if len(req.Items) == 0 {
+		response.ReplyBadRequest(c, "items cannot be empty")
+		return
+	}
+	if len(req.Items) > maxItems {
+		response.ReplyBadRequest(c, fmt.Sprintf("too many items, at most %d per batch", maxItems))
+		return
+	}
+
+	// 逐条校验，非法条目直接标记，不影响其他条目
+	results := make([]BatchItemResult, len(req.Items))
+	valid := make([]BatchItem, 0, len(req.Items))
+	for i, item := range req.Items {
+		if item.RoomID == 0 {
+			results[i] = BatchItemResult{Index: i, Status: "invalid", Error: "room_id is required"}
+			continue
+		}
+		payload, err := UnmarshalChatPayload(item.Content)
+		if err != nil {
+			results[i] = BatchItemResult{Index: i, RoomID: item.RoomID, Status: "invalid", Error: "Invalid content: " + err.Error()}
+			continue
+		}
+		if err := CheckRoomPayload(item.RoomID, payload); err != nil {
+			results[i] = BatchItemResult{Index: i, RoomID: item.RoomID, Status: "invalid", Error: err.Error()}
+			continue
+		}
+		if payload, err = FillImageMeta(item.RoomID, payload); err != nil {
+			results[i] = BatchItemResult{Index: i, RoomID: item.RoomID, Status: "failed", Error: err.Error()}
+			continue
+		}
+		valid = append(valid, BatchItem{Index: i, RoomID: item.RoomID, Payload: payload})
+	}
+
+	if err := SendMessages(userID, valid, results); err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"results": results})
+}
+
+// MessageStatusHandler 返回消息的投递摘要，仅发送者可查询
+func MessageStatusHandler(c *gin.Context) {
+	msgID, err := strconv.ParseInt(c.Query("id"), 10, 64)
+	if err != nil || msgID <= 0 {
+		response.ReplyBadRequest(c, "invalid id")
+		return
+	}
+	id, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	userID := id.(int64)
+	summary, ok, err := push.GetDeliverySummary(msgID)
+	if err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	if !ok {
+		response.ReplyNotFound(c, "delivery status not found or expired")
+		return
+	}
+	if summary.SenderID != userID {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	response.ReplySuccessWithData(c, "success", summary)
+}
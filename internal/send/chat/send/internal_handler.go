@@ -0,0 +1,99 @@
+package send
+
+import (
+	"GoStacker/pkg/response"
+	"encoding/json"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 以下接口供 gateway 转发 WebSocket 客户端帧使用，用户身份已在 gateway 建连时校验，
+// 由请求体中的 user_id 携带，与 /internal/pushback 一样不走 JWT，改由 X-Internal-Token 校验。
+
+type InternalSendRequest struct {
+	UserID  int64           `json:"user_id" binding:"required"`
+	RoomID  int64           `json:"room_id" binding:"required"`
+	Content json.RawMessage `json:"content" binding:"required"`
+}
+
+type InternalTypingRequest struct {
+	UserID int64 `json:"user_id" binding:"required"`
+	RoomID int64 `json:"room_id" binding:"required"`
+}
+
+type InternalReadRequest struct {
+	UserID int64 `json:"user_id" binding:"required"`
+	RoomID int64 `json:"room_id" binding:"required"`
+	MsgID  int64 `json:"msg_id" binding:"required"`
+}
+
+type InternalSyncRequest struct {
+	UserID  int64 `json:"user_id" binding:"required"`
+	RoomID  int64 `json:"room_id" binding:"required"`
+	AfterID int64 `json:"after_id"`
+	Limit   int   `json:"limit"`
+}
+
+func InternalSendHandler(c *gin.Context) {
+	var req InternalSendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	msgID, err := SubmitMessage(req.RoomID, req.UserID, req.Content)
+	if err != nil {
+		replySubmitError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"msg_id": msgID})
+}
+
+func InternalTypingHandler(c *gin.Context) {
+	var req InternalTypingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	if err := SendTyping(req.UserID, req.RoomID); err != nil {
+		replyEventError(c, err)
+		return
+	}
+	response.ReplySuccess(c, "success")
+}
+
+func InternalReadHandler(c *gin.Context) {
+	var req InternalReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	readID, err := MarkRead(req.UserID, req.RoomID, req.MsgID)
+	if err != nil {
+		replyEventError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"room_id": req.RoomID, "msg_id": readID})
+}
+
+func InternalSyncHandler(c *gin.Context) {
+	var req InternalSyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	res, err := SyncMessages(req.UserID, req.RoomID, req.AfterID, req.Limit)
+	if err != nil {
+		replyEventError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", res)
+}
+
+func replyEventError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotRoomMember) {
+		response.ReplyUnauthorized(c, err.Error())
+		return
+	}
+	response.ReplyError500(c, err.Error())
+}
@@ -51,6 +51,40 @@ func InsertMessage(roomID int64, senderID int64, content ChatPayload) (int64, er
 	return msgID, nil
 }
 
+// InsertMessages 为每条消息生成 ID，并通过一次 RPush 写入缓存队列，返回与 contents 一一对应的 msgID。
+func InsertMessages(roomIDs []int64, senderID int64, contents []ChatPayload) ([]int64, error) {
+	ids := make([]int64, 0, len(contents))
+	raws := make([]interface{}, 0, len(contents))
+	now := time.Now()
+	for i, content := range contents {
+		contentData, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		msgID := sfNode.Generate().Int64()
+		raw, err := json.Marshal(cachedMessage{
+			ID:        msgID,
+			RoomID:    roomIDs[i],
+			SenderID:  senderID,
+			Type:      content.GetType(),
+			Content:   contentData,
+			CreatedAt: now,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, msgID)
+		raws = append(raws, raw)
+	}
+	if len(raws) == 0 {
+		return ids, nil
+	}
+	if err := redis.SendCacheRPushWithRetry(2, "cache:send:messages", raws); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
 // cachedMessage 是写入 Redis 的缓存结构
 type cachedMessage struct {
 	ID        int64           `json:"id"`
@@ -137,3 +171,25 @@ func getMsgInfoByID(id int64) (cachedMessage, error) {
 	err := mysql.DB.QueryRow(query, id).Scan(&cm.ID, &cm.RoomID, &cm.SenderID, &cm.Type, &cm.Content, &cm.CreatedAt)
 	return cm, err
 }
+
+// queryMessagesAfter 按 ID 升序返回房间内 ID 大于 afterID 的消息
+func queryMessagesAfter(roomID, afterID int64, limit int) ([]cachedMessage, error) {
+	query := `SELECT id, room_id, sender_id, type, content, created_at FROM chat_messages
+		WHERE room_id = ? AND id > ? AND is_deleted = FALSE ORDER BY id LIMIT ?`
+	rows, err := mysql.DB.Query(query, roomID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := make([]cachedMessage, 0, limit)
+	for rows.Next() {
+		var cm cachedMessage
+		var content string
+		if err := rows.Scan(&cm.ID, &cm.RoomID, &cm.SenderID, &cm.Type, &content, &cm.CreatedAt); err != nil {
+			return nil, err
+		}
+		cm.Content = json.RawMessage(content)
+		msgs = append(msgs, cm)
+	}
+	return msgs, rows.Err()
+}
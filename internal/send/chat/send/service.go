@@ -3,7 +3,13 @@ package send
 import (
 	"GoStacker/internal/meta/chat/group"
 	"GoStacker/internal/send/push"
+	"GoStacker/internal/send/upload"
 	"GoStacker/pkg/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
 )
 
 func BroadcastMessage(id int64, roomID int64, senderID int64, content ChatPayload) error {
@@ -11,7 +17,10 @@ func BroadcastMessage(id int64, roomID int64, senderID int64, content ChatPayloa
 	if err != nil {
 		return err
 	}
+	return dispatchToMembers(id, roomID, senderID, content, members)
+}
 
+func dispatchToMembers(id int64, roomID int64, senderID int64, content ChatPayload, members []int64) error {
 	msg := push.PushMessage{
 		ID:        id,
 		Type:      "chat",
@@ -23,9 +32,87 @@ func BroadcastMessage(id int64, roomID int64, senderID int64, content ChatPayloa
 	if config.Conf.PushMod == "standalone" {
 		return push.Dispatch_StandAlone(msg)
 	}
+	if push.IsLargeGroup(len(members)) {
+		return push.Dispatch_timeline(msg)
+	}
 	return push.Dispatch_gateway(msg)
 }
 
+var (
+	ErrEncryptionRequired = errors.New("this room only accepts end-to-end encrypted messages")
+	ErrInvalidContent     = errors.New("invalid content")
+	// ErrNothingToResend 消息仍在投递中或没有未送达的成员
+	ErrNothingToResend = errors.New("nothing to resend")
+)
+
+// SubmitMessage 解析、校验并发送一条客户端消息，HTTP 接口与网关转发的 send 帧共用
+func SubmitMessage(roomID, senderID int64, raw json.RawMessage) (int64, error) {
+	payload, err := UnmarshalChatPayload(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+	}
+	if err := CheckRoomPayload(roomID, payload); err != nil {
+		return 0, err
+	}
+	if payload, err = FillImageMeta(roomID, payload); err != nil {
+		return 0, err
+	}
+	return SendMessage(roomID, senderID, payload)
+}
+
+// CheckRoomPayload 房间要求端到端加密时拒绝明文消息；
+// 附件上传开启严格模式时，图片/语音/文件消息的 URL 必须由上传服务签发
+func CheckRoomPayload(roomID int64, content ChatPayload) error {
+	if content.GetType() == "encrypted" {
+		return nil
+	}
+	if url, ok := attachmentURL(content); ok {
+		if err := upload.CheckIssuedURL(url, roomID); err != nil {
+			return err
+		}
+	}
+	required, err := group.QueryRoomRequiresE2E(roomID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrEncryptionRequired
+	}
+	return nil
+}
+
+// FillImageMeta 图片来自本房间的上传附件时，用服务端解码得到的尺寸覆盖客户端填写的值，
+// 并附上缩略图与 blurhash；其他消息原样返回
+func FillImageMeta(roomID int64, content ChatPayload) (ChatPayload, error) {
+	img, ok := content.(ImagePayload)
+	if !ok {
+		return content, nil
+	}
+	meta, thumbURLs, err := upload.ImageInfo(img.URL, roomID)
+	if err != nil || meta == nil {
+		return content, err
+	}
+	img.Width, img.Height = meta.Width, meta.Height
+	img.Blurhash = meta.Blurhash
+	img.Thumbnails = make([]ImageThumbnail, 0, len(meta.Thumbnails))
+	for _, t := range meta.Thumbnails {
+		img.Thumbnails = append(img.Thumbnails, ImageThumbnail{URL: thumbURLs[t.Size], Width: t.Width, Height: t.Height})
+	}
+	return img, nil
+}
+
+func attachmentURL(content ChatPayload) (string, bool) {
+	switch p := content.(type) {
+	case ImagePayload:
+		return p.URL, true
+	case VoicePayload:
+		return p.URL, true
+	case FilePayload:
+		return p.URL, true
+	}
+	return "", false
+}
+
 func SendMessage(roomID, senderID int64, text ChatPayload) (int64, error) {
 	id, err := InsertMessage(roomID, senderID, text)
 	if err != nil {
@@ -33,3 +120,130 @@ func SendMessage(roomID, senderID int64, text ChatPayload) (int64, error) {
 	}
 	return id, BroadcastMessage(id, roomID, senderID, text)
 }
+
+// ResendMessage 只向上次未送达的用户重发，避免已收到的成员收到重复消息。
+// 没有未送达记录时不再整群重发：全部送达时只给发送者补发 ACK，否则返回 ErrNothingToResend；
+// 只有消息从未进入投递流程（没有投递摘要）时才整群投递。standalone 模式没有失败记录，始终整群重发。
+func ResendMessage(id int64, roomID int64, senderID int64, content ChatPayload) error {
+	if config.Conf.PushMod == "standalone" {
+		return BroadcastMessage(id, roomID, senderID, content)
+	}
+	failed, err := push.GetFailedTargets(id)
+	if err != nil {
+		zap.L().Warn("resend: load failed targets failed", zap.Int64("msgID", id), zap.Error(err))
+		return err
+	}
+	if len(failed) == 0 {
+		summary, ok, err := push.GetDeliverySummary(id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return BroadcastMessage(id, roomID, senderID, content)
+		}
+		if summary.Status == push.DeliveryStatusAcked {
+			// 全部投递成功，只是发送者没收到 ACK
+			return push.AckSender(id, senderID)
+		}
+		return ErrNothingToResend
+	}
+
+	members, err := group.QueryRoomMemberIDs(roomID)
+	if err != nil {
+		return err
+	}
+	// 已退群的用户不再重发
+	inRoom := make(map[int64]struct{}, len(members))
+	for _, uid := range members {
+		inRoom[uid] = struct{}{}
+	}
+	targets := make([]int64, 0, len(failed))
+	for _, uid := range failed {
+		if _, ok := inRoom[uid]; ok {
+			targets = append(targets, uid)
+		}
+	}
+	if err := push.ClearFailedTargets(id); err != nil {
+		zap.L().Warn("resend: clear failed targets failed", zap.Int64("msgID", id), zap.Error(err))
+	}
+	if len(targets) == 0 {
+		return push.AckSender(id, senderID)
+	}
+	zap.L().Info("resend to failed targets only", zap.Int64("msgID", id), zap.Int("targets", len(targets)))
+	return push.Dispatch_gateway(push.PushMessage{
+		ID:        id,
+		Type:      "chat",
+		RoomID:    roomID,
+		SenderID:  senderID,
+		TargetIDs: targets,
+		Payload:   content,
+	})
+}
+
+// BatchItem 是批量发送中一条已校验的消息
+type BatchItem struct {
+	Index   int
+	RoomID  int64
+	Payload ChatPayload
+}
+
+// BatchItemResult 是批量发送中每条消息的处理结果
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	RoomID int64  `json:"room_id"`
+	MsgID  int64  `json:"msg_id,omitempty"`
+	Status string `json:"status"` // "ok" / "invalid" / "failed"
+	Error  string `json:"error,omitempty"`
+}
+
+// SendMessages 一次性持久化所有消息，然后按房间分组投递，每个房间只查询一次成员列表。
+// 返回结果按 item 的 Index 写入 results 对应位置。
+func SendMessages(senderID int64, items []BatchItem, results []BatchItemResult) error {
+	if len(items) == 0 {
+		return nil
+	}
+	roomIDs := make([]int64, len(items))
+	contents := make([]ChatPayload, len(items))
+	for i, item := range items {
+		roomIDs[i] = item.RoomID
+		contents[i] = item.Payload
+	}
+	ids, err := InsertMessages(roomIDs, senderID, contents)
+	if err != nil {
+		for _, item := range items {
+			results[item.Index] = BatchItemResult{Index: item.Index, RoomID: item.RoomID, Status: "failed", Error: err.Error()}
+		}
+		return err
+	}
+
+	// group by room, keeping the original order inside each room
+	byRoom := make(map[int64][]int)
+	var roomOrder []int64
+	for i, item := range items {
+		if _, ok := byRoom[item.RoomID]; !ok {
+			roomOrder = append(roomOrder, item.RoomID)
+		}
+		byRoom[item.RoomID] = append(byRoom[item.RoomID], i)
+		results[item.Index] = BatchItemResult{Index: item.Index, RoomID: item.RoomID, MsgID: ids[i], Status: "ok"}
+	}
+
+	for _, roomID := range roomOrder {
+		members, err := group.QueryRoomMemberIDs(roomID)
+		if err != nil {
+			zap.L().Error("send batch: query room members failed", zap.Int64("room_id", roomID), zap.Error(err))
+			for _, i := range byRoom[roomID] {
+				results[items[i].Index].Status = "failed"
+				results[items[i].Index].Error = err.Error()
+			}
+			continue
+		}
+		for _, i := range byRoom[roomID] {
+			if err := dispatchToMembers(ids[i], roomID, senderID, items[i].Payload, members); err != nil {
+				zap.L().Error("send batch: dispatch failed", zap.Int64("room_id", roomID), zap.Int64("msgID", ids[i]), zap.Error(err))
+				results[items[i].Index].Status = "failed"
+				results[items[i].Index].Error = err.Error()
+			}
+		}
+	}
+	return nil
+}
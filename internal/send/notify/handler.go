@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"GoStacker/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RegisterDeviceRequest struct {
+	Platform string `json:"platform" binding:"required"`
+	Token    string `json:"token" binding:"required"`
+}
+
+type UnregisterDeviceRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func RegisterDeviceHandler(c *gin.Context) {
+	var req RegisterDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	id, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	userID := id.(int64)
+	switch req.Platform {
+	case PlatformAPNs, PlatformFCM, PlatformLog:
+	default:
+		response.ReplyBadRequest(c, "unsupported platform")
+		return
+	}
+	if err := RegisterDevice(userID, Device{Platform: req.Platform, Token: req.Token}); err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccess(c, "success")
+}
+
+func UnregisterDeviceHandler(c *gin.Context) {
+	var req UnregisterDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	id, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	if err := UnregisterDevice(id.(int64), req.Token); err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccess(c, "success")
+}
+
+func SetDNDHandler(c *gin.Context) {
+	var req DNDSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	id, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	if (req.Start != "" || req.End != "") && !validClockRange(req.Start, req.End) {
+		response.ReplyBadRequest(c, "start and end must both be HH:MM")
+		return
+	}
+	if err := SetDND(id.(int64), req); err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccess(c, "success")
+}
+
+func GetDNDHandler(c *gin.Context) {
+	id, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	s, err := GetDND(id.(int64))
+	if err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccessWithData(c, "success", s)
+}
+
+// OutcomesHandler 返回当前用户最近的离线推送结果，便于排查收不到通知的问题
+func OutcomesHandler(c *gin.Context) {
+	id, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	outcomes, err := GetOutcomes(id.(int64), maxOutcomesPerUser)
+	if err != nil {
+		response.ReplyError500(c, err.Error())
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"outcomes": outcomes})
+}
+
+func validClockRange(start, end string) bool {
+	_, ok1 := parseClock(start)
+	_, ok2 := parseClock(end)
+	return ok1 && ok2
+}
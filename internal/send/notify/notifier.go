@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	PlatformAPNs = "apns"
+	PlatformFCM  = "fcm"
+	PlatformLog  = "log"
+)
+
+var ErrNoProvider = errors.New("no notifier registered for platform")
+
+// Notification 是合并后发给单个用户的一条离线通知
+type Notification struct {
+	UserID      int64  `json:"user_id"`
+	RoomID      int64  `json:"room_id"`
+	MsgID       int64  `json:"msg_id"` // 合并窗口内最后一条消息
+	SenderID    int64  `json:"sender_id"`
+	Count       int    `json:"count"` // 合并的消息条数
+	Title       string `json:"title"`
+	Body        string `json:"body"`
+	CollapseKey string `json:"collapse_key"`
+}
+
+// Device 是用户登记的一个推送目标
+type Device struct {
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+}
+
+// Notifier 是离线推送的提供方，按 Device.Platform 选择
+type Notifier interface {
+	Platform() string
+	Notify(ctx context.Context, device Device, n Notification) error
+}
+
+// Previewer 由消息 payload 实现，用于生成通知正文
+type Previewer interface {
+	Preview() string
+}
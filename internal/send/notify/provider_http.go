@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"GoStacker/pkg/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpNotifier 以 HTTP/JSON 方式调用推送服务，APNs 与 FCM 只在请求构造上不同
+type httpNotifier struct {
+	platform string
+	cfg      config.NotifyHTTPConfig
+	client   *http.Client
+	build    func(cfg config.NotifyHTTPConfig, device Device, n Notification) (*http.Request, error)
+}
+
+func (h *httpNotifier) Platform() string {
+	return h.platform
+}
+
+func (h *httpNotifier) Notify(ctx context.Context, device Device, n Notification) error {
+	req, err := h.build(h.cfg, device, n)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if h.cfg.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.cfg.AuthToken)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s push rejected: status %d: %s", h.platform, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// NewAPNsNotifier 构造 APNs 形式的推送客户端：POST {endpoint}/3/device/{token}
+func NewAPNsNotifier(cfg config.NotifyHTTPConfig, timeout time.Duration) Notifier {
+	return &httpNotifier{
+		platform: PlatformAPNs,
+		cfg:      cfg,
+		client:   &http.Client{Timeout: timeout},
+		build:    buildAPNsRequest,
+	}
+}
+
+// NewFCMNotifier 构造 FCM HTTP v1 形式的推送客户端：POST {endpoint}
+func NewFCMNotifier(cfg config.NotifyHTTPConfig, timeout time.Duration) Notifier {
+	return &httpNotifier{
+		platform: PlatformFCM,
+		cfg:      cfg,
+		client:   &http.Client{Timeout: timeout},
+		build:    buildFCMRequest,
+	}
+}
+
+func buildAPNsRequest(cfg config.NotifyHTTPConfig, device Device, n Notification) (*http.Request, error) {
+	body := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": n.Title,
+				"body":  n.Body,
+			},
+			"thread-id": n.CollapseKey,
+			"sound":     "default",
+		},
+		"room_id": n.RoomID,
+		"msg_id":  n.MsgID,
+		"count":   n.Count,
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimRight(cfg.Endpoint, "/") + "/3/device/" + device.Token
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-collapse-id", n.CollapseKey)
+	if cfg.Topic != "" {
+		req.Header.Set("apns-topic", cfg.Topic)
+	}
+	return req, nil
+}
+
+func buildFCMRequest(cfg config.NotifyHTTPConfig, device Device, n Notification) (*http.Request, error) {
+	body := map[string]interface{}{
+		"message": map[string]interface{}{
+			"token": device.Token,
+			"notification": map[string]string{
+				"title": n.Title,
+				"body":  n.Body,
+			},
+			// FCM data 只接受字符串值
+			"data": map[string]string{
+				"room_id": strconv.FormatInt(n.RoomID, 10),
+				"msg_id":  strconv.FormatInt(n.MsgID, 10),
+				"count":   strconv.Itoa(n.Count),
+			},
+			"android": map[string]string{
+				"collapse_key": n.CollapseKey,
+			},
+		},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequest(http.MethodPost, cfg.Endpoint, bytes.NewReader(data))
+}
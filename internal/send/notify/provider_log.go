@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// logNotifier 不真正推送，只把通知写入文件（JSON Lines）或 zap 日志，用于本地调试与测试
+type logNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewLogNotifier path 为空时写入 zap 日志
+func NewLogNotifier(path string) Notifier {
+	return &logNotifier{path: path}
+}
+
+func (l *logNotifier) Platform() string {
+	return PlatformLog
+}
+
+func (l *logNotifier) Notify(ctx context.Context, device Device, n Notification) error {
+	if l.path == "" {
+		zap.L().Info("offline notification",
+			zap.Int64("user_id", n.UserID),
+			zap.Int64("room_id", n.RoomID),
+			zap.Int("count", n.Count),
+			zap.String("token", device.Token),
+			zap.String("body", n.Body))
+		return nil
+	}
+	line, err := json.Marshal(struct {
+		Notification
+		Token string    `json:"token"`
+		At    time.Time `json:"at"`
+	}{n, device.Token, time.Now()})
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"GoStacker/pkg/db/redis"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	devicesKeyFmt  = "notify:devices:%d"  // hash: token -> platform
+	dndKeyFmt      = "notify:dnd:%d"      // hash: until/start/end/tz_offset
+	outcomesKeyFmt = "notify:outcomes:%d" // list: newest first
+
+	maxOutcomesPerUser = 100
+	outcomesTTL        = 7 * 24 * time.Hour
+)
+
+// DNDSettings 用户免打扰设置：Until 之前一律静默；Start/End 为每日静默时段（HH:MM，按 TZOffset 分钟换算本地时间）
+type DNDSettings struct {
+	Until    int64  `json:"until"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	TZOffset int    `json:"tz_offset"`
+}
+
+// Outcome 记录一次推送的结果
+type Outcome struct {
+	MsgID    int64  `json:"msg_id"`
+	RoomID   int64  `json:"room_id"`
+	Count    int    `json:"count"`
+	Platform string `json:"platform,omitempty"`
+	Status   string `json:"status"` // sent / failed / dnd / no_device / no_provider / dropped
+	Error    string `json:"error,omitempty"`
+	At       int64  `json:"at"`
+}
+
+func RegisterDevice(userID int64, d Device) error {
+	return redis.SendQueueHSetWithRetry(2, fmt.Sprintf(devicesKeyFmt, userID), d.Token, d.Platform)
+}
+
+func UnregisterDevice(userID int64, token string) error {
+	return redis.SendQueueHDelWithRetry(2, fmt.Sprintf(devicesKeyFmt, userID), token)
+}
+
+func getDevices(userID int64) ([]Device, error) {
+	m, err := redis.SendQueueHGetAllWithRetry(2, fmt.Sprintf(devicesKeyFmt, userID))
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]Device, 0, len(m))
+	for token, platform := range m {
+		devices = append(devices, Device{Platform: platform, Token: token})
+	}
+	return devices, nil
+}
+
+func SetDND(userID int64, s DNDSettings) error {
+	return redis.SendQueueHSetWithRetry(2, fmt.Sprintf(dndKeyFmt, userID),
+		"until", s.Until,
+		"start", s.Start,
+		"end", s.End,
+		"tz_offset", s.TZOffset,
+	)
+}
+
+func GetDND(userID int64) (DNDSettings, error) {
+	var s DNDSettings
+	m, err := redis.SendQueueHGetAllWithRetry(2, fmt.Sprintf(dndKeyFmt, userID))
+	if err != nil {
+		return s, err
+	}
+	s.Until, _ = strconv.ParseInt(m["until"], 10, 64)
+	s.Start = m["start"]
+	s.End = m["end"]
+	s.TZOffset, _ = strconv.Atoi(m["tz_offset"])
+	return s, nil
+}
+
+func recordOutcomeToRedis(userID int64, o Outcome) error {
+	raw, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return redis.SendQueueLPushCappedWithRetry(2, fmt.Sprintf(outcomesKeyFmt, userID), raw, maxOutcomesPerUser, outcomesTTL)
+}
+
+// GetOutcomes 返回最近的推送结果，最新的在前
+func GetOutcomes(userID int64, limit int64) ([]Outcome, error) {
+	raws, err := redis.SendQueueLRangeWithRetry(2, fmt.Sprintf(outcomesKeyFmt, userID), 0, limit-1)
+	if err != nil {
+		return nil, err
+	}
+	outcomes := make([]Outcome, 0, len(raws))
+	for _, raw := range raws {
+		var o Outcome
+		if err := json.Unmarshal([]byte(raw), &o); err != nil {
+			continue
+		}
+		outcomes = append(outcomes, o)
+	}
+	return outcomes, nil
+}
@@ -0,0 +1,249 @@
+package notify
+
+import (
+	"GoStacker/pkg/config"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Message 是触发离线通知的消息，由 push 包在收件人离线时传入
+type Message struct {
+	ID       int64
+	RoomID   int64
+	SenderID int64
+	Payload  interface{}
+}
+
+type collapseKey struct {
+	userID int64
+	roomID int64
+}
+
+var (
+	enabled        bool
+	collapseWindow time.Duration
+	pushTimeout    time.Duration
+	providers      = make(map[string]Notifier)
+	queue          chan Notification
+
+	pendingMu sync.Mutex
+	pending   = make(map[collapseKey]*Notification)
+
+	notifyCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "send_offline_notify_total",
+		Help: "Offline push notifications by platform and outcome status",
+	}, []string{"platform", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(notifyCounter)
+}
+
+// Init 根据配置注册 provider 并启动推送 worker；未启用时 Enqueue 为空操作
+func Init(cfg *config.NotifyConfig) {
+	if cfg == nil || !cfg.Enabled {
+		zap.L().Info("offline notify disabled")
+		return
+	}
+	collapseWindow = 5 * time.Second
+	if cfg.CollapseWindow > 0 {
+		collapseWindow = time.Duration(cfg.CollapseWindow) * time.Second
+	}
+	pushTimeout = 5 * time.Second
+	if cfg.Timeout > 0 {
+		pushTimeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	RegisterNotifier(NewLogNotifier(cfg.LogFile))
+	if cfg.APNs != nil && cfg.APNs.Endpoint != "" {
+		RegisterNotifier(NewAPNsNotifier(*cfg.APNs, pushTimeout))
+	}
+	if cfg.FCM != nil && cfg.FCM.Endpoint != "" {
+		RegisterNotifier(NewFCMNotifier(*cfg.FCM, pushTimeout))
+	}
+
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1024
+	}
+	workers := cfg.WorkerCount
+	if workers <= 0 {
+		workers = 2
+	}
+	queue = make(chan Notification, queueSize)
+	for i := 0; i < workers; i++ {
+		go worker()
+	}
+	enabled = true
+	zap.L().Info("offline notify started",
+		zap.Int("workers", workers),
+		zap.Duration("collapse_window", collapseWindow))
+}
+
+// RegisterNotifier 注册（或替换）某个平台的 provider
+func RegisterNotifier(n Notifier) {
+	providers[n.Platform()] = n
+}
+
+// Enqueue 为离线用户登记一条消息；同一用户同一房间在合并窗口内只发一条通知
+func Enqueue(userID int64, msg Message) {
+	if !enabled || userID == msg.SenderID {
+		return
+	}
+	key := collapseKey{userID: userID, roomID: msg.RoomID}
+	body := preview(msg.Payload)
+
+	pendingMu.Lock()
+	if n, ok := pending[key]; ok {
+		n.Count++
+		n.MsgID = msg.ID
+		n.SenderID = msg.SenderID
+		n.Body = body
+		pendingMu.Unlock()
+		return
+	}
+	pending[key] = &Notification{
+		UserID:      userID,
+		RoomID:      msg.RoomID,
+		MsgID:       msg.ID,
+		SenderID:    msg.SenderID,
+		Count:       1,
+		Body:        body,
+		CollapseKey: "room:" + strconv.FormatInt(msg.RoomID, 10),
+	}
+	pendingMu.Unlock()
+
+	time.AfterFunc(collapseWindow, func() { flush(key) })
+}
+
+func flush(key collapseKey) {
+	pendingMu.Lock()
+	n, ok := pending[key]
+	delete(pending, key)
+	pendingMu.Unlock()
+	if !ok {
+		return
+	}
+	if n.Count > 1 {
+		n.Title = fmt.Sprintf("%d new messages", n.Count)
+	} else {
+		n.Title = "New message"
+	}
+	select {
+	case queue <- *n:
+	default:
+		zap.L().Warn("offline notify queue full, dropping notification", zap.Int64("user_id", n.UserID), zap.Int64("room_id", n.RoomID))
+		recordOutcome(*n, "", "dropped", nil)
+	}
+}
+
+func worker() {
+	for n := range queue {
+		deliver(n)
+	}
+}
+
+func deliver(n Notification) {
+	dnd, err := GetDND(n.UserID)
+	if err != nil {
+		zap.L().Warn("offline notify: load dnd settings failed", zap.Int64("user_id", n.UserID), zap.Error(err))
+	} else if dnd.Active(time.Now()) {
+		recordOutcome(n, "", "dnd", nil)
+		return
+	}
+
+	devices, err := getDevices(n.UserID)
+	if err != nil {
+		recordOutcome(n, "", "failed", err)
+		return
+	}
+	if len(devices) == 0 {
+		recordOutcome(n, "", "no_device", nil)
+		return
+	}
+	for _, d := range devices {
+		p, ok := providers[d.Platform]
+		if !ok {
+			recordOutcome(n, d.Platform, "no_provider", ErrNoProvider)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+		err := p.Notify(ctx, d, n)
+		cancel()
+		if err != nil {
+			zap.L().Warn("offline notify failed", zap.Int64("user_id", n.UserID), zap.String("platform", d.Platform), zap.Error(err))
+			recordOutcome(n, d.Platform, "failed", err)
+			continue
+		}
+		recordOutcome(n, d.Platform, "sent", nil)
+	}
+}
+
+func recordOutcome(n Notification, platform, status string, err error) {
+	notifyCounter.WithLabelValues(platform, status).Inc()
+	o := Outcome{
+		MsgID:    n.MsgID,
+		RoomID:   n.RoomID,
+		Count:    n.Count,
+		Platform: platform,
+		Status:   status,
+		At:       time.Now().Unix(),
+	}
+	if err != nil {
+		o.Error = err.Error()
+	}
+	if err := recordOutcomeToRedis(n.UserID, o); err != nil {
+		zap.L().Warn("offline notify: record outcome failed", zap.Int64("user_id", n.UserID), zap.Error(err))
+	}
+}
+
+// Active 判断 now 是否处于免打扰状态
+func (s DNDSettings) Active(now time.Time) bool {
+	if s.Until > 0 && now.Unix() < s.Until {
+		return true
+	}
+	start, ok1 := parseClock(s.Start)
+	end, ok2 := parseClock(s.End)
+	if !ok1 || !ok2 || start == end {
+		return false
+	}
+	local := now.UTC().Add(time.Duration(s.TZOffset) * time.Minute)
+	cur := local.Hour()*60 + local.Minute()
+	if start < end {
+		return cur >= start && cur < end
+	}
+	// 跨零点，例如 22:00 - 08:00
+	return cur >= start || cur < end
+}
+
+// parseClock 解析 "HH:MM"，返回当天的分钟数
+func parseClock(s string) (int, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+func preview(payload interface{}) string {
+	p, ok := payload.(Previewer)
+	if !ok {
+		return "You have a new message"
+	}
+	text := []rune(p.Preview())
+	if len(text) > 100 {
+		return string(text[:100]) + "…"
+	}
+	return string(text)
+}
@@ -0,0 +1,97 @@
+package presence
+
+import (
+	"GoStacker/pkg/response"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 以下接口供 gateway 上报连接变化和转发客户端的 presence 帧，与 /internal/chat/* 一样不走 JWT。
+
+type InternalDeviceRequest struct {
+	UserID   int64  `json:"user_id" binding:"required"`
+	DeviceID string `json:"device_id"`
+}
+
+type InternalStateRequest struct {
+	UserID int64  `json:"user_id" binding:"required"`
+	State  string `json:"state" binding:"required"`
+}
+
+type InternalSubscribeRequest struct {
+	UserID  int64   `json:"user_id" binding:"required"`
+	UserIDs []int64 `json:"user_ids"`
+	RoomID  int64   `json:"room_id"`
+}
+
+func InternalConnectHandler(c *gin.Context) {
+	var req InternalDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	Connect(req.UserID, req.DeviceID)
+	response.ReplySuccess(c, "success")
+}
+
+func InternalDisconnectHandler(c *gin.Context) {
+	var req InternalDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	Disconnect(req.UserID, req.DeviceID)
+	response.ReplySuccess(c, "success")
+}
+
+func InternalSetStateHandler(c *gin.Context) {
+	var req InternalStateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	if err := SetState(req.UserID, req.State); err != nil {
+		replyPresenceError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"state": req.State})
+}
+
+func InternalSubscribeHandler(c *gin.Context) {
+	var req InternalSubscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (len(req.UserIDs) == 0 && req.RoomID == 0) {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	snapshot, err := Subscribe(req.UserID, req.UserIDs, req.RoomID)
+	if err != nil {
+		replyPresenceError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"presences": snapshot})
+}
+
+func InternalUnsubscribeHandler(c *gin.Context) {
+	var req InternalSubscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	if err := Unsubscribe(req.UserID, req.UserIDs, req.RoomID); err != nil {
+		replyPresenceError(c, err)
+		return
+	}
+	response.ReplySuccess(c, "success")
+}
+
+func replyPresenceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotRoomMember):
+		response.ReplyUnauthorized(c, err.Error())
+	case errors.Is(err, ErrInvalidState), errors.Is(err, ErrRoomTooLarge), errors.Is(err, ErrTooManySubscriptions):
+		response.ReplyBadRequest(c, err.Error())
+	default:
+		response.ReplyError500(c, err.Error())
+	}
+}
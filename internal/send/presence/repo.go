@@ -0,0 +1,112 @@
+package presence
+
+import (
+	"GoStacker/pkg/db/mysql"
+	"GoStacker/pkg/db/redis"
+	"fmt"
+	"strconv"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	stateKeyFmt   = "presence:user:%d"    // hash: state(手动设置的 away/busy) / announced(最近广播的状态) / last_seen(毫秒)
+	pendingKeyFmt = "presence:pending:%d" // 合并窗口内只有一个 send 实例负责广播
+	subsKeyFmt    = "presence:subs:%d"    // set: 订阅了该用户的用户
+	subOfKeyFmt   = "presence:subof:%d"   // set: 该用户订阅的用户
+
+	// subsTTL 订阅在订阅者离线时清除，TTL 兜底清理异常残留
+	subsTTL = 24 * time.Hour
+)
+
+type storedPresence struct {
+	manual    string
+	announced string
+	lastSeen  int64
+}
+
+func loadPresence(userID int64) (storedPresence, error) {
+	m, err := redis.SendQueueHGetAllWithRetry(2, fmt.Sprintf(stateKeyFmt, userID))
+	if err != nil {
+		return storedPresence{}, err
+	}
+	p := storedPresence{manual: m["state"], announced: m["announced"]}
+	p.lastSeen, _ = strconv.ParseInt(m["last_seen"], 10, 64)
+	return p, nil
+}
+
+func touchLastSeen(userID int64, at time.Time) error {
+	return redis.SendQueueHSetWithRetry(2, fmt.Sprintf(stateKeyFmt, userID), "last_seen", at.UnixMilli())
+}
+
+func saveManualState(userID int64, state string) error {
+	key := fmt.Sprintf(stateKeyFmt, userID)
+	if state == StateOnline {
+		return redis.SendQueueHDelWithRetry(2, key, "state")
+	}
+	return redis.SendQueueHSetWithRetry(2, key, "state", state)
+}
+
+func saveAnnounced(userID int64, state string, lastSeen int64) error {
+	return redis.SendQueueHSetWithRetry(2, fmt.Sprintf(stateKeyFmt, userID), "announced", state, "last_seen", lastSeen)
+}
+
+// tryClaimPending 抢占用户的合并窗口，返回 false 表示已有实例在窗口内等待广播
+func tryClaimPending(userID int64, ttl time.Duration) (bool, error) {
+	return redis.SendQueueSetNXWithRetry(2, fmt.Sprintf(pendingKeyFmt, userID), 1, ttl)
+}
+
+func releasePending(userID int64) error {
+	return redis.SendQueueDelWithRetry(2, fmt.Sprintf(pendingKeyFmt, userID))
+}
+
+func addSubscriptions(subscriber int64, targets []int64) error {
+	members := make([]interface{}, 0, len(targets))
+	for _, t := range targets {
+		if err := redis.SendQueueSAddEXWithRetry(2, fmt.Sprintf(subsKeyFmt, t), subsTTL, subscriber); err != nil {
+			return err
+		}
+		members = append(members, t)
+	}
+	return redis.SendQueueSAddEXWithRetry(2, fmt.Sprintf(subOfKeyFmt, subscriber), subsTTL, members...)
+}
+
+func removeSubscriptions(subscriber int64, targets []int64) error {
+	members := make([]interface{}, 0, len(targets))
+	for _, t := range targets {
+		if err := redis.SendQueueSRemWithRetry(2, fmt.Sprintf(subsKeyFmt, t), subscriber); err != nil {
+			return err
+		}
+		members = append(members, t)
+	}
+	return redis.SendQueueSRemWithRetry(2, fmt.Sprintf(subOfKeyFmt, subscriber), members...)
+}
+
+func subscriptionsOf(subscriber int64) ([]int64, error) {
+	return smembersInt64(fmt.Sprintf(subOfKeyFmt, subscriber))
+}
+
+func subscribersOf(target int64) ([]int64, error) {
+	return smembersInt64(fmt.Sprintf(subsKeyFmt, target))
+}
+
+func smembersInt64(key string) ([]int64, error) {
+	members, err := redis.SendQueueSMembersWithRetry(2, key)
+	if err != nil && err != goredis.Nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(members))
+	for _, m := range members {
+		if id, err := strconv.ParseInt(m, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// updateOnlineFlag 同步 users.is_online，只在 online/offline 之间切换时调用
+func updateOnlineFlag(userID int64, online bool) error {
+	_, err := mysql.DB.Exec("UPDATE users SET is_online = ? WHERE id = ?", online, userID)
+	return err
+}
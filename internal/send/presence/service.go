@@ -0,0 +1,370 @@
+// Package presence 维护用户在线状态（online/away/busy/offline）与最后在线时间。
+// 状态由 gateway 的连接/断开上报和客户端的 presence 帧驱动；变化先进入合并窗口，
+// 窗口结束时只有与上次广播不同的状态才推给订阅者，频繁断线重连不会产生事件风暴。
+package presence
+
+import (
+	"GoStacker/internal/meta/chat/group"
+	"GoStacker/internal/send/push"
+	"GoStacker/internal/send/route"
+	"GoStacker/pkg/config"
+	"errors"
+	"time"
+
+	snowflake "github.com/bwmarrin/snowflake"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	StateOnline  = "online"
+	StateAway    = "away"
+	StateBusy    = "busy"
+	StateOffline = "offline"
+)
+
+var (
+	ErrInvalidState         = errors.New("invalid presence state")
+	ErrNotRoomMember        = errors.New("not a member of this room")
+	ErrRoomTooLarge         = errors.New("presence is not available for large rooms")
+	ErrTooManySubscriptions = errors.New("too many presence subscriptions")
+)
+
+// Presence 是推给订阅者的状态事件，也是订阅时返回的快照
+type Presence struct {
+	UserID   int64  `json:"user_id"`
+	State    string `json:"state"`
+	LastSeen int64  `json:"last_seen"` // 毫秒时间戳
+}
+
+var (
+	coalesceWindow   = 2 * time.Second
+	maxSubscriptions = 500
+
+	// 事件 ID 与消息 ID 使用不同的节点号
+	sfNode *snowflake.Node
+
+	changeCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "send_presence_changes_total",
+		Help: "Presence changes by outcome: broadcast, or suppressed when the coalesced state did not change",
+	}, []string{"result"})
+)
+
+func init() {
+	node, err := snowflake.NewNode(2)
+	if err != nil {
+		panic(err)
+	}
+	sfNode = node
+	prometheus.MustRegister(changeCounter)
+}
+
+func Init(cfg *config.PresenceConfig) {
+	if cfg != nil {
+		if cfg.CoalesceWindow > 0 {
+			coalesceWindow = time.Duration(cfg.CoalesceWindow) * time.Millisecond
+		}
+		if cfg.MaxSubscriptions > 0 {
+			maxSubscriptions = cfg.MaxSubscriptions
+		}
+	}
+	zap.L().Info("presence service initialized",
+		zap.Duration("coalesce_window", coalesceWindow),
+		zap.Int("max_subscriptions", maxSubscriptions))
+}
+
+// Connect 设备建立连接
+func Connect(userID int64, deviceID string) {
+	zap.L().Debug("presence connect", zap.Int64("user_id", userID), zap.String("device_id", deviceID))
+	touch(userID)
+}
+
+// Disconnect 设备断开，last_seen 记为断开时间
+func Disconnect(userID int64, deviceID string) {
+	zap.L().Debug("presence disconnect", zap.Int64("user_id", userID), zap.String("device_id", deviceID))
+	touch(userID)
+}
+
+// SetState 客户端手动设置状态，只接受 online/away/busy；用户完全离线后恢复为 online
+func SetState(userID int64, state string) error {
+	switch state {
+	case StateOnline, StateAway, StateBusy:
+	default:
+		return ErrInvalidState
+	}
+	if err := saveManualState(userID, state); err != nil {
+		return err
+	}
+	touch(userID)
+	return nil
+}
+
+func touch(userID int64) {
+	route.InvalidateCache(userID)
+	if err := touchLastSeen(userID, time.Now()); err != nil {
+		zap.L().Warn("presence: update last_seen failed", zap.Int64("user_id", userID), zap.Error(err))
+	}
+	schedule(userID)
+}
+
+// schedule 开启合并窗口。多个 send 实例之间通过 Redis 抢占，窗口内只有一个实例等待广播；
+// 广播时读取的是窗口结束时的最新状态，窗口内的其他变化不会丢失
+func schedule(userID int64) {
+	claimed, err := tryClaimPending(userID, coalesceWindow+5*time.Second)
+	if err != nil {
+		zap.L().Warn("presence: claim coalesce window failed", zap.Int64("user_id", userID), zap.Error(err))
+		claimed = true
+	}
+	if !claimed {
+		return
+	}
+	time.AfterFunc(coalesceWindow, func() { flush(userID) })
+}
+
+// flush 计算当前状态，与上次广播的状态不同时更新 users.is_online 并通知订阅者
+func flush(userID int64) {
+	// 先释放窗口，计算期间到达的变化会开启新的窗口
+	if err := releasePending(userID); err != nil {
+		zap.L().Warn("presence: release coalesce window failed", zap.Int64("user_id", userID), zap.Error(err))
+	}
+	online, err := liveOnline([]int64{userID}, true)
+	if err != nil {
+		zap.L().Warn("presence: query connection state failed", zap.Int64("user_id", userID), zap.Error(err))
+		return
+	}
+	p, err := loadPresence(userID)
+	if err != nil {
+		zap.L().Warn("presence: load state failed", zap.Int64("user_id", userID), zap.Error(err))
+		return
+	}
+	state := effectiveState(online[userID], p.manual)
+	previous := p.announced
+	if previous == "" {
+		previous = StateOffline
+	}
+	if state == previous {
+		changeCounter.WithLabelValues("suppressed").Inc()
+		return
+	}
+
+	lastSeen := p.lastSeen
+	if state != StateOffline || lastSeen == 0 {
+		lastSeen = time.Now().UnixMilli()
+	}
+	if err := saveAnnounced(userID, state, lastSeen); err != nil {
+		zap.L().Warn("presence: save state failed", zap.Int64("user_id", userID), zap.Error(err))
+		return
+	}
+	if (previous == StateOffline) != (state == StateOffline) {
+		if err := updateOnlineFlag(userID, state != StateOffline); err != nil {
+			zap.L().Warn("presence: update users.is_online failed", zap.Int64("user_id", userID), zap.Error(err))
+		}
+	}
+	if state == StateOffline {
+		// 手动状态和订阅都只在本次在线期间有效
+		if err := saveManualState(userID, StateOnline); err != nil {
+			zap.L().Warn("presence: reset manual state failed", zap.Int64("user_id", userID), zap.Error(err))
+		}
+		if err := unsubscribeAll(userID); err != nil {
+			zap.L().Warn("presence: clear subscriptions failed", zap.Int64("user_id", userID), zap.Error(err))
+		}
+	}
+
+	subscribers, err := subscribersOf(userID)
+	if err != nil {
+		zap.L().Warn("presence: load subscribers failed", zap.Int64("user_id", userID), zap.Error(err))
+		return
+	}
+	changeCounter.WithLabelValues("broadcast").Inc()
+	push.PushEvent(subscribers, push.ClientMessage{
+		ID:       sfNode.Generate().Int64(),
+		Type:     "presence",
+		SenderID: userID,
+		Payload:  Presence{UserID: userID, State: state, LastSeen: lastSeen},
+	})
+}
+
+func effectiveState(online bool, manual string) string {
+	if !online {
+		return StateOffline
+	}
+	if manual == StateAway || manual == StateBusy {
+		return manual
+	}
+	return StateOnline
+}
+
+// liveOnline 返回用户当前是否有设备在线。gateway 模式以 registry 中的设备路由为准，
+// fresh 为 true 时绕过本地路由缓存；standalone 模式查看本实例的连接
+func liveOnline(userIDs []int64, fresh bool) (map[int64]bool, error) {
+	online := make(map[int64]bool, len(userIDs))
+	if config.Conf != nil && config.Conf.PushMod == "standalone" {
+		for _, uid := range userIDs {
+			_, online[uid] = push.GetConnectionHolder(uid)
+		}
+		return online, nil
+	}
+	if fresh {
+		for _, uid := range userIDs {
+			route.InvalidateCache(uid)
+		}
+	}
+	routes, err := route.BatchGetUserGateways(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, uid := range userIDs {
+		online[uid] = len(routes[uid]) > 0
+	}
+	return online, nil
+}
+
+// Snapshot 返回一组用户的当前状态
+func Snapshot(userIDs []int64) ([]Presence, error) {
+	if len(userIDs) == 0 {
+		return []Presence{}, nil
+	}
+	online, err := liveOnline(userIDs, false)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Presence, 0, len(userIDs))
+	for _, uid := range userIDs {
+		p, err := loadPresence(uid)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, Presence{UserID: uid, State: effectiveState(online[uid], p.manual), LastSeen: p.lastSeen})
+	}
+	return res, nil
+}
+
+// Subscribe 订阅用户状态变化并返回其当前状态。userIDs 只接受与订阅者同在某个房间的用户（联系人），
+// roomID 不为 0 时订阅该房间的全部其他成员，大群不支持
+func Subscribe(userID int64, userIDs []int64, roomID int64) ([]Presence, error) {
+	targets, err := resolveTargets(userID, userIDs, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return []Presence{}, nil
+	}
+	existing, err := subscriptionsOf(userID)
+	if err != nil {
+		return nil, err
+	}
+	total := make(map[int64]struct{}, len(existing)+len(targets))
+	for _, id := range existing {
+		total[id] = struct{}{}
+	}
+	for _, id := range targets {
+		total[id] = struct{}{}
+	}
+	if len(total) > maxSubscriptions {
+		return nil, ErrTooManySubscriptions
+	}
+	if err := addSubscriptions(userID, targets); err != nil {
+		return nil, err
+	}
+	return Snapshot(targets)
+}
+
+// Unsubscribe 取消订阅；userIDs 与 roomID 都为空时取消全部订阅
+func Unsubscribe(userID int64, userIDs []int64, roomID int64) error {
+	if len(userIDs) == 0 && roomID == 0 {
+		return unsubscribeAll(userID)
+	}
+	targets := append([]int64(nil), userIDs...)
+	if roomID != 0 {
+		members, err := group.QueryRoomMemberIDs(roomID)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, members...)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	return removeSubscriptions(userID, targets)
+}
+
+func unsubscribeAll(userID int64) error {
+	targets, err := subscriptionsOf(userID)
+	if err != nil || len(targets) == 0 {
+		return err
+	}
+	return removeSubscriptions(userID, targets)
+}
+
+func resolveTargets(userID int64, userIDs []int64, roomID int64) ([]int64, error) {
+	seen := make(map[int64]struct{})
+	var targets []int64
+	add := func(id int64) {
+		if id == userID {
+			return
+		}
+		if _, ok := seen[id]; ok {
+			return
+		}
+		seen[id] = struct{}{}
+		targets = append(targets, id)
+	}
+
+	if roomID != 0 {
+		members, err := group.QueryRoomMemberIDs(roomID)
+		if err != nil {
+			return nil, err
+		}
+		isMember := false
+		for _, id := range members {
+			if id == userID {
+				isMember = true
+				break
+			}
+		}
+		if !isMember {
+			return nil, ErrNotRoomMember
+		}
+		if push.IsLargeGroup(len(members)) {
+			return nil, ErrRoomTooLarge
+		}
+		for _, id := range members {
+			add(id)
+		}
+	}
+
+	if len(userIDs) > 0 {
+		contacts, err := contactsOf(userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range userIDs {
+			if _, ok := contacts[id]; ok {
+				add(id)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// contactsOf 与用户同在某个房间（大群除外）的其他用户
+func contactsOf(userID int64) (map[int64]struct{}, error) {
+	rooms, err := group.QueryJoinedRooms(userID)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make(map[int64]struct{})
+	for _, roomID := range rooms {
+		members, err := group.QueryRoomMemberIDs(roomID)
+		if err != nil {
+			return nil, err
+		}
+		if push.IsLargeGroup(len(members)) {
+			continue
+		}
+		for _, id := range members {
+			contacts[id] = struct{}{}
+		}
+	}
+	return contacts, nil
+}
@@ -1,6 +1,7 @@
 package push
 
 import (
+	"GoStacker/internal/send/notify"
 	"GoStacker/pkg/config"
 	"GoStacker/pkg/db/redis"
 	"encoding/json"
@@ -63,6 +64,19 @@ func InsertOfflineQueue(userID int64, marshaledMsg string) {
 	}
 }
 
+// notifyOffline 为离线收件人触发移动端推送（合并、免打扰由 notify 包处理）
+func notifyOffline(userID int64, msg ClientMessage) {
+	if msg.Type != "chat" {
+		return
+	}
+	notify.Enqueue(userID, notify.Message{
+		ID:       msg.ID,
+		RoomID:   msg.RoomID,
+		SenderID: msg.SenderID,
+		Payload:  msg.Payload,
+	})
+}
+
 func InsertWaitQueue(userID int64, marshaledMsg string) {
 	InsertWaitSet(userID)
 	err := redis.SendQueueRPushWithRetry(2, "wait:push:"+strconv.FormatInt(userID, 10), marshaledMsg)
@@ -0,0 +1,200 @@
+package push
+
+import (
+	"GoStacker/pkg/config"
+	"GoStacker/pkg/db/redis"
+	"GoStacker/pkg/pendingTask"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 每条消息的投递摘要，写入 send queue redis 的 hash：delivery:msg:<id>
+// PendingManager 只在内存中保存在途计数，完成后即丢弃，这里保留一份可查询的结果。
+const deliveryKeyFmt = "delivery:msg:%d"
+
+// 投递失败的目标用户集合：delivery:failed:<id>，与摘要同样的 TTL，供定向重发使用
+const failedTargetsKeyFmt = "delivery:failed:%d"
+
+const defaultDeliveryStatusTTL = 7 * 24 * time.Hour
+
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusAcked   = "acked"
+	DeliveryStatusFailed  = "failed"
+	DeliveryStatusDone    = "done" // standalone 模式，全部入队即结束
+)
+
+// DeliverySummary 是 GET /api/chat/message_status 返回的结构，时间均为 unix 毫秒
+type DeliverySummary struct {
+	MsgID       int64  `json:"msg_id"`
+	RoomID      int64  `json:"room_id"`
+	SenderID    int64  `json:"sender_id"`
+	Mode        string `json:"mode"` // gateway / timeline / standalone
+	Status      string `json:"status"`
+	Targets     int64  `json:"targets"`
+	Enqueued    int64  `json:"enqueued"` // 写入 gateway stream / 房间 timeline / 本地连接队列
+	Offline     int64  `json:"offline"`  // 写入离线队列
+	Waiting     int64  `json:"waiting"`  // standalone 下写入等待队列
+	Failed      int64  `json:"failed"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+	CompletedAt int64  `json:"completed_at,omitempty"`
+}
+
+func deliveryKey(msgID int64) string {
+	return fmt.Sprintf(deliveryKeyFmt, msgID)
+}
+
+func failedTargetsKey(msgID int64) string {
+	return fmt.Sprintf(failedTargetsKeyFmt, msgID)
+}
+
+func deliveryTTL() time.Duration {
+	if config.Conf != nil && config.Conf.SendDispatcherConfig != nil && config.Conf.SendDispatcherConfig.DeliveryStatusTTL > 0 {
+		return time.Duration(config.Conf.SendDispatcherConfig.DeliveryStatusTTL) * time.Second
+	}
+	return defaultDeliveryStatusTTL
+}
+
+// initDelivery 在消息进入投递流程时写入初始摘要；重发会覆盖计数从头开始
+func initDelivery(msg PushMessage, mode string, targets int) {
+	now := time.Now().UnixMilli()
+	err := redis.SendQueueHSetEXWithRetry(2, deliveryKey(msg.ID), deliveryTTL(),
+		"msg_id", msg.ID,
+		"room_id", msg.RoomID,
+		"sender_id", msg.SenderID,
+		"mode", mode,
+		"status", DeliveryStatusPending,
+		"targets", targets,
+		"enqueued", 0,
+		"offline", 0,
+		"waiting", 0,
+		"failed", 0,
+		"created_at", now,
+		"updated_at", now,
+		"completed_at", 0,
+	)
+	if err != nil {
+		zap.L().Warn("delivery summary: init failed", zap.Int64("msgID", msg.ID), zap.Error(err))
+	}
+}
+
+// recordDelivery 累加投递计数，字段为 enqueued / offline / waiting / failed。
+// 计数不重试，宁可少计也不重复累加
+func recordDelivery(msgID int64, enqueued, offline, waiting, failed int64) {
+	incrs := make(map[string]int64, 4)
+	if enqueued > 0 {
+		incrs["enqueued"] = enqueued
+	}
+	if offline > 0 {
+		incrs["offline"] = offline
+	}
+	if waiting > 0 {
+		incrs["waiting"] = waiting
+	}
+	if failed > 0 {
+		incrs["failed"] = failed
+	}
+	if len(incrs) == 0 {
+		return
+	}
+	if err := redis.SendQueueHIncrByEX(deliveryKey(msgID), deliveryTTL(), incrs, "updated_at"); err != nil {
+		zap.L().Warn("delivery summary: update failed", zap.Int64("msgID", msgID), zap.Error(err))
+	}
+}
+
+// completeDelivery 在 PendingManager 的 done / fail 回调中标记最终状态
+func completeDelivery(msgID int64, status string) {
+	now := time.Now().UnixMilli()
+	err := redis.SendQueueHSetEXWithRetry(2, deliveryKey(msgID), deliveryTTL(),
+		"status", status,
+		"updated_at", now,
+		"completed_at", now,
+	)
+	if err != nil {
+		zap.L().Warn("delivery summary: complete failed", zap.Int64("msgID", msgID), zap.Error(err))
+	}
+}
+
+// GetDeliverySummary 读取投递摘要，记录不存在或已过期时返回 ok=false
+func GetDeliverySummary(msgID int64) (DeliverySummary, bool, error) {
+	var s DeliverySummary
+	m, err := redis.SendQueueHGetAllWithRetry(2, deliveryKey(msgID))
+	if err != nil {
+		return s, false, err
+	}
+	if len(m) == 0 {
+		return s, false, nil
+	}
+	parse := func(field string) int64 {
+		v, _ := strconv.ParseInt(m[field], 10, 64)
+		return v
+	}
+	s = DeliverySummary{
+		MsgID:       parse("msg_id"),
+		RoomID:      parse("room_id"),
+		SenderID:    parse("sender_id"),
+		Mode:        m["mode"],
+		Status:      m["status"],
+		Targets:     parse("targets"),
+		Enqueued:    parse("enqueued"),
+		Offline:     parse("offline"),
+		Waiting:     parse("waiting"),
+		Failed:      parse("failed"),
+		CreatedAt:   parse("created_at"),
+		UpdatedAt:   parse("updated_at"),
+		CompletedAt: parse("completed_at"),
+	}
+	return s, true, nil
+}
+
+// failTargets 记录失败的目标用户，再推进 PendingManager 的失败计数。
+// 必须先写记录：FailN 可能立即触发 OnFailMessage，客户端随后就会发起重发。
+func failTargets(msgID int64, targets []int64) {
+	if len(targets) == 0 {
+		return
+	}
+	if err := RecordUndelivered(msgID, targets...); err != nil {
+		zap.L().Warn("delivery summary: record failed targets failed", zap.Int64("msgID", msgID), zap.Error(err))
+	}
+	pendingTask.DefaultPendingManager.FailN(msgID, int32(len(targets)))
+}
+
+// RecordUndelivered 记录未能在线送达的目标用户，供定向重发使用。除 send 侧的失败外，
+// gateway 推回离线队列的消息（用户已断开、客户端一直未确认、网关下线）也经 pushback 记录到这里，
+// 此时消息的 pending 计数通常早已结束，因此不再推进 PendingManager
+func RecordUndelivered(msgID int64, targets ...int64) error {
+	if len(targets) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(targets))
+	for i, uid := range targets {
+		members[i] = uid
+	}
+	return redis.SendQueueSAddEXWithRetry(2, failedTargetsKey(msgID), deliveryTTL(), members...)
+}
+
+// GetFailedTargets 返回上次投递失败的用户；记录不存在或已过期时返回空
+func GetFailedTargets(msgID int64) ([]int64, error) {
+	vals, err := redis.SendQueueSMembersWithRetry(2, failedTargetsKey(msgID))
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]int64, 0, len(vals))
+	for _, v := range vals {
+		uid, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, uid)
+	}
+	return targets, nil
+}
+
+// ClearFailedTargets 在定向重发前清除记录，重发再失败时会重新写入
+func ClearFailedTargets(msgID int64) error {
+	return redis.SendQueueDelWithRetry(2, failedTargetsKey(msgID))
+}
@@ -2,8 +2,10 @@ package push
 
 import (
 	"GoStacker/internal/send/route"
+	"GoStacker/pkg/config"
 	"GoStacker/pkg/db/redis"
 	"GoStacker/pkg/pendingTask"
+	"GoStacker/pkg/wire"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -20,9 +22,10 @@ var msg2sender sync.Map // key: msgID, value: senderID
 func Dispatch_gateway(msg PushMessage) error {
 	// 把任务分割成多个子任务，然后推送到队列，分割方法是每100个用户ID为一组
 	msg2sender.Store(msg.ID, msg.SenderID)
+	initDelivery(msg, "gateway", len(msg.TargetIDs))
 	batchSize := 100
 	pendingTask.DefaultPendingManager.Init(msg.ID, int32(len(msg.TargetIDs)))
-	droppedTargets := int32(0)
+	var droppedTargets []int64
 	for i := 0; i < len(msg.TargetIDs); i += batchSize {
 		end := i + batchSize
 		if end > len(msg.TargetIDs) {
@@ -42,12 +45,13 @@ func Dispatch_gateway(msg PushMessage) error {
 			// enqueued
 		case <-time.After(200 * time.Millisecond):
 			zap.L().Warn("gateway dispatch queue full, dropping sub-message", zap.Any("msg", subMsg))
-			droppedTargets += int32(len(subMsg.TargetIDs))
+			droppedTargets = append(droppedTargets, subMsg.TargetIDs...)
 			// continue to next batch
 		}
 	}
-	if droppedTargets > 0 {
-		pendingTask.DefaultPendingManager.FailN(msg.ID, droppedTargets)
+	if len(droppedTargets) > 0 {
+		recordDelivery(msg.ID, 0, 0, 0, int64(len(droppedTargets)))
+		failTargets(msg.ID, droppedTargets)
 	}
 	return nil
 }
@@ -58,6 +62,7 @@ func SendACKToSender(msgID int64) {
 		ID:   msgID,
 		Type: "ack",
 	}
+	completeDelivery(msgID, DeliveryStatusAcked)
 	senderID, ok := msg2sender.LoadAndDelete(msgID)
 	if !ok {
 		zap.L().Warn("sendACKToSender: no senderID found for msgID", zap.Int64("msgID", msgID))
@@ -71,8 +76,14 @@ func SendACKToSender(msgID int64) {
 	}
 }
 
+// AckSender 直接给发送者补发 ACK，用于重发时发现消息已无需再投递的情况
+func AckSender(msgID int64, senderID int64) error {
+	return PushSingleViaGateway(senderID, ClientMessage{ID: msgID, Type: "ack"})
+}
+
 // OnFailMessage only closes server-side lifecycle and lets client retry by ACK timeout.
 func OnFailMessage(msgID int64, failedCount int32) {
+	completeDelivery(msgID, DeliveryStatusFailed)
 	if senderID, ok := msg2sender.LoadAndDelete(msgID); ok {
 		zap.L().Warn("message failed before ack, sender should retry by timeout",
 			zap.Int64("msgID", msgID),
@@ -87,7 +98,7 @@ func OnFailMessage(msgID int64, failedCount int32) {
 
 func PushSingleViaGateway(userID int64, msg ClientMessage) error {
 	// Query route from cache/registry
-	gatewayID, _, err := route.GetUserGateway(userID)
+	routes, err := route.GetUserGateways(userID)
 	if err != nil {
 		if err == route.ErrUserOffline {
 			zap.L().Debug("User offline, skipping push", zap.Int64("user_id", userID))
@@ -106,29 +117,44 @@ func PushSingleViaGateway(userID int64, msg ClientMessage) error {
 		Payload:   msg.Payload,
 	}
 
-	// Send to gateway via Redis Stream
-	if err := sendToGatewayWithRedisStream(gatewayID, gwMsg); err != nil {
-		return err
+	// Send to every gateway holding a device of the user via Redis Stream
+	var lastErr error
+	sent := false
+	for _, gatewayID := range route.GatewayIDs(routes) {
+		if err := sendToGatewayWithRedisStream(gatewayID, gwMsg); err != nil {
+			lastErr = err
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		return lastErr
 	}
 	return nil
 }
 
+// streamEncoding 返回写入 stream 的编码，默认 MessagePack
+func streamEncoding() string {
+	if config.Conf != nil && config.Conf.SendDispatcherConfig != nil {
+		return wire.NormalizeEncoding(config.Conf.SendDispatcherConfig.StreamEncoding)
+	}
+	return wire.EncodingMsgpack
+}
+
 // sendToGatewayWithRedisStream sends message to gateway via Redis Stream
 func sendToGatewayWithRedisStream(gatewayID string, message interface{}) error {
 	zap.L().Debug("Sending message to gateway via Redis Stream",
 		zap.String("gateway_id", gatewayID),
 		zap.Any("message", message))
 
-	data, err := json.Marshal(message)
+	values, err := wire.EncodeStreamValues(message, streamEncoding())
 	if err != nil {
 		zap.L().Error("Failed to marshal message for Redis Stream", zap.Error(err))
 		return err
 	}
 
 	streamName := fmt.Sprintf("%s_stream", gatewayID)
-	err = redis.SendStreamXAddWithRetry(2, streamName, map[string]interface{}{
-		"data": data,
-	})
+	err = redis.SendStreamXAddWithRetry(2, streamName, values)
 	zap.L().Debug("Redis stream enqueue", zap.String("stream", streamName))
 	if err != nil {
 		zap.L().Error("Failed to add message to Redis Stream", zap.Error(err))
@@ -140,22 +166,27 @@ func sendToGatewayWithRedisStream(gatewayID string, message interface{}) error {
 func gatewayWorker() {
 	for msg := range gatewayQueue {
 		offlineDoneCount := int32(0)
-		offlineFailCount := int32(0)
+		var offlineFailed []int64
 
 		// Batch query routes for all target users
 		routeMap, err := route.BatchGetUserGateways(msg.TargetIDs)
 		if err != nil {
 			zap.L().Error("Failed to batch query routes", zap.Error(err))
 			// Continue with empty map, will push all to offline
-			routeMap = make(map[int64]*route.RouteInfo)
+			routeMap = make(map[int64][]*route.RouteInfo)
 		}
 
-		// Group target ids by gateway id
+		// Group target ids by gateway id, a user whose devices sit on several gateways joins each group
 		groups := make(map[string][]int64)
+		// userID -> number of gateway groups still to be sent for this user
+		remaining := make(map[int64]int)
 		for _, uid := range msg.TargetIDs {
-			routeInfo, found := routeMap[uid]
-			if found {
-				groups[routeInfo.GatewayID] = append(groups[routeInfo.GatewayID], uid)
+			gids := route.GatewayIDs(routeMap[uid])
+			if len(gids) > 0 {
+				for _, gid := range gids {
+					groups[gid] = append(groups[gid], uid)
+				}
+				remaining[uid] = len(gids)
 			} else {
 				// No gateway found -> push to offline redis queue
 				clientMsg := ClientMessage{
@@ -168,26 +199,39 @@ func gatewayWorker() {
 				marshaledMsg, err := json.Marshal(clientMsg)
 				if err != nil {
 					zap.L().Error("gateway dispatch: marshal offline client msg failed", zap.Error(err), zap.Int64("user", uid))
-					offlineFailCount++
+					offlineFailed = append(offlineFailed, uid)
 					continue
 				}
 				InsertOfflineQueue(uid, string(marshaledMsg))
+				notifyOffline(uid, clientMsg)
 				offlineDoneCount++
 			}
 		}
 
+		// 先记录摘要再推进 pending 计数，完成回调里的最终状态总在计数之后写入
+		recordDelivery(msg.ID, 0, int64(offlineDoneCount), 0, int64(len(offlineFailed)))
 		if offlineDoneCount > 0 {
 			pendingTask.DefaultPendingManager.DoneN(msg.ID, offlineDoneCount)
 		}
-		if offlineFailCount > 0 {
-			pendingTask.DefaultPendingManager.FailN(msg.ID, offlineFailCount)
+		if len(offlineFailed) > 0 {
+			failTargets(msg.ID, offlineFailed)
+		}
+
+		// 多设备用户在每个网关组都会出现，pending 计数与投递摘要按用户计：
+		// 只要有一个网关收到即算在线投递，所有网关都失败才转入离线队列
+		delivered := make(map[int64]bool)
+		var offlineFallback []int64
+		// settle 在用户的最后一个网关组处理完后返回 true
+		settle := func(uid int64, ok bool) bool {
+			if ok {
+				delivered[uid] = true
+			}
+			remaining[uid]--
+			return remaining[uid] == 0
 		}
 
 		// For each gateway, create a PushMessage and send to gateway via Redis Stream
 		for gid, uids := range groups {
-			groupDoneCount := int32(0)
-			groupFailCount := int32(0)
-
 			gwMsg := PushMessage{
 				ID:        msg.ID,
 				Type:      msg.Type,
@@ -198,39 +242,60 @@ func gatewayWorker() {
 			}
 
 			// Send to gateway via Redis Stream
-			if err := sendToGatewayWithRedisStream(gid, gwMsg); err != nil {
+			err := sendToGatewayWithRedisStream(gid, gwMsg)
+			if err != nil {
 				zap.L().Error("gateway dispatch: send to gateway failed", zap.Error(err), zap.String("gateway", gid))
-				// Push to offline queues as fallback
-				for _, uid := range uids {
-					clientMsg := ClientMessage{
-						ID:       msg.ID,
-						Type:     msg.Type,
-						RoomID:   msg.RoomID,
-						SenderID: msg.SenderID,
-						Payload:  msg.Payload,
-					}
-					marshaledMsg, err := json.Marshal(clientMsg)
-					if err != nil {
-						zap.L().Error("gateway dispatch: marshal offline client msg failed", zap.Error(err), zap.Int64("user", uid))
-						groupFailCount++
-						continue
-					}
-					if err := redis.SendQueueRPushWithRetry(2, "offline:push:"+strconv.FormatInt(uid, 10), marshaledMsg); err != nil {
-						zap.L().Error("gateway dispatch: rpush offline failed", zap.Error(err), zap.Int64("user", uid))
-						groupFailCount++
-						continue
-					}
-					groupDoneCount++
+			}
+			onlineCount := int32(0)
+			for _, uid := range uids {
+				if !settle(uid, err == nil) {
+					continue
+				}
+				if delivered[uid] {
+					onlineCount++
+				} else {
+					offlineFallback = append(offlineFallback, uid)
+				}
+			}
+			if onlineCount > 0 {
+				recordDelivery(msg.ID, int64(onlineCount), 0, 0, 0)
+				pendingTask.DefaultPendingManager.DoneN(msg.ID, onlineCount)
+			}
+		}
+
+		// Push to offline queues as fallback for users none of whose gateways could be reached
+		if len(offlineFallback) > 0 {
+			groupDoneCount := int32(0)
+			var groupFailed []int64
+			for _, uid := range offlineFallback {
+				clientMsg := ClientMessage{
+					ID:       msg.ID,
+					Type:     msg.Type,
+					RoomID:   msg.RoomID,
+					SenderID: msg.SenderID,
+					Payload:  msg.Payload,
 				}
-				if groupDoneCount > 0 {
-					pendingTask.DefaultPendingManager.DoneN(msg.ID, groupDoneCount)
+				marshaledMsg, err := json.Marshal(clientMsg)
+				if err != nil {
+					zap.L().Error("gateway dispatch: marshal offline client msg failed", zap.Error(err), zap.Int64("user", uid))
+					groupFailed = append(groupFailed, uid)
+					continue
 				}
-				if groupFailCount > 0 {
-					pendingTask.DefaultPendingManager.FailN(msg.ID, groupFailCount)
+				if err := redis.SendQueueRPushWithRetry(2, "offline:push:"+strconv.FormatInt(uid, 10), marshaledMsg); err != nil {
+					zap.L().Error("gateway dispatch: rpush offline failed", zap.Error(err), zap.Int64("user", uid))
+					groupFailed = append(groupFailed, uid)
+					continue
 				}
-				continue
+				notifyOffline(uid, clientMsg)
+				groupDoneCount++
+			}
+			recordDelivery(msg.ID, 0, int64(groupDoneCount), 0, int64(len(groupFailed)))
+			if groupDoneCount > 0 {
+				pendingTask.DefaultPendingManager.DoneN(msg.ID, groupDoneCount)
+			}
+			if len(groupFailed) > 0 {
+				failTargets(msg.ID, groupFailed)
 			}
-			pendingTask.DefaultPendingManager.DoneN(msg.ID, int32(len(uids)))
 		}
 	}
 }
@@ -34,6 +34,8 @@ func Dispatch_StandAlone(msg PushMessage) error {
 		zap.L().Error("Failed to marshal client message", zap.Error(err), zap.Any("message", clientMsg))
 		return err
 	}
+	initDelivery(msg, "standalone", len(msg.TargetIDs))
+	var enqueued, offline, waiting int64
 	for _, uid := range msg.TargetIDs {
 		// try to enqueue directly to the user's send channel; small timeout to avoid blocking
 		if err := EnqueueMessage(uid, 100*time.Millisecond, clientMsg); err != nil {
@@ -41,11 +43,19 @@ func Dispatch_StandAlone(msg PushMessage) error {
 			if err == ErrNoConn {
 				zap.L().Info("EnqueueMessage failed due to no connection, pushing to offline queue", zap.Int64("userID", uid))
 				InsertOfflineQueue(uid, string(marshaledMsg))
+				notifyOffline(uid, clientMsg)
+				offline++
 				continue
 			}
 			InsertWaitQueue(uid, string(marshaledMsg))
+			waiting++
+			continue
 		}
+		enqueued++
 	}
+	// standalone 没有 ACK 流程，入队结束即视为完成
+	recordDelivery(msg.ID, enqueued, offline, waiting, 0)
+	completeDelivery(msg.ID, DeliveryStatusDone)
 	return nil
 }
 
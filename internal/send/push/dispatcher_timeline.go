@@ -0,0 +1,153 @@
+package push
+
+import (
+	"GoStacker/pkg/config"
+	"GoStacker/pkg/db/redis"
+	"GoStacker/pkg/pendingTask"
+	"GoStacker/pkg/wire"
+	"encoding/json"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const defaultTimelineMaxLen = 10000
+
+// IsLargeGroup 判断成员数是否达到读扩散阈值，阈值未配置时始终返回 false
+func IsLargeGroup(memberCount int) bool {
+	if config.Conf == nil || config.Conf.SendDispatcherConfig == nil {
+		return false
+	}
+	threshold := config.Conf.SendDispatcherConfig.LargeGroupThreshold
+	return threshold > 0 && memberCount > threshold
+}
+
+// Dispatch_timeline 读扩散投递：消息只写一次到房间 timeline，
+// 由各 gateway 根据本地的 大群->在线用户 索引自行扇出，不再按网关分组写 stream。
+// 是否在线不逐个查询成员路由，而是读取各 gateway 维护的房间在线集合：集合外的成员与写 timeline
+// 失败时的全部成员转入离线队列，gateway 扇出时发现成员已断开也会把消息退回离线队列。
+func Dispatch_timeline(msg PushMessage) error {
+	maxLen := int64(defaultTimelineMaxLen)
+	if config.Conf != nil && config.Conf.SendDispatcherConfig != nil && config.Conf.SendDispatcherConfig.TimelineMaxLen > 0 {
+		maxLen = config.Conf.SendDispatcherConfig.TimelineMaxLen
+	}
+
+	msg2sender.Store(msg.ID, msg.SenderID)
+	initDelivery(msg, "timeline", len(msg.TargetIDs))
+	pendingTask.DefaultPendingManager.Init(msg.ID, int32(len(msg.TargetIDs)))
+
+	// the room may have just crossed the threshold, make sure gateways start indexing it
+	if err := redis.SendStreamSAddWithRetry(2, redis.LargeRoomsKey, strconv.FormatInt(msg.RoomID, 10)); err != nil {
+		zap.L().Warn("timeline dispatch: mark large room failed", zap.Int64("room_id", msg.RoomID), zap.Error(err))
+	}
+
+	present := roomPresence(msg.RoomID)
+	var online, offline []int64
+	for _, uid := range msg.TargetIDs {
+		if _, ok := present[uid]; ok {
+			online = append(online, uid)
+		} else {
+			offline = append(offline, uid)
+		}
+	}
+
+	// offline targets are recorded before the entry is appended, so a gateway that sees the
+	// entry after the member left does not hand it back a second time
+	pushOfflineTargets(msg, offline)
+	if len(online) == 0 {
+		return nil
+	}
+	if appendTimeline(msg, maxLen) != nil {
+		pushOfflineTargets(msg, online)
+		return nil
+	}
+	// 在线成员按写入 timeline 计入 enqueued，后续由 gateway 扇出
+	recordDelivery(msg.ID, int64(len(online)), 0, 0, 0)
+	pendingTask.DefaultPendingManager.DoneN(msg.ID, int32(len(online)))
+	return nil
+}
+
+// roomPresence 返回有 gateway 在索引的房间成员。读取失败时按全部离线处理，消息改走离线队列
+func roomPresence(roomID int64) map[int64]struct{} {
+	members, err := redis.SendStreamRoomPresenceMembersWithRetry(2, redis.RoomPresenceKey(roomID))
+	if err != nil {
+		zap.L().Error("timeline dispatch: read room presence failed", zap.Int64("room_id", roomID), zap.Error(err))
+		return nil
+	}
+	present := make(map[int64]struct{}, len(members))
+	for _, m := range members {
+		if uid, _, ok := redis.ParseRoomPresenceMember(m); ok {
+			present[uid] = struct{}{}
+		}
+	}
+	return present
+}
+
+// appendTimeline 把消息追加到房间 timeline，timeline 条目不带目标用户
+func appendTimeline(msg PushMessage, maxLen int64) error {
+	timelineMsg := PushMessage{
+		ID:       msg.ID,
+		Type:     msg.Type,
+		RoomID:   msg.RoomID,
+		SenderID: msg.SenderID,
+		Payload:  msg.Payload,
+	}
+	values, err := wire.EncodeStreamValues(timelineMsg, streamEncoding())
+	if err != nil {
+		zap.L().Error("timeline dispatch: marshal message failed", zap.Int64("msgID", msg.ID), zap.Error(err))
+		return err
+	}
+	stream := redis.RoomTimelineKey(msg.RoomID)
+	if _, err := redis.SendStreamXAddCappedWithRetry(2, stream, maxLen, values); err != nil {
+		zap.L().Error("timeline dispatch: append to room timeline failed", zap.String("stream", stream), zap.Error(err))
+		return err
+	}
+	zap.L().Debug("Message appended to room timeline", zap.Int64("msgID", msg.ID), zap.String("stream", stream))
+	return nil
+}
+
+// pushOfflineTargets 记录这些离线目标并把消息写入各成员的离线队列，推进投递摘要与 pending 计数
+func pushOfflineTargets(msg PushMessage, uids []int64) {
+	if len(uids) == 0 {
+		return
+	}
+	clientMsg := ClientMessage{
+		ID:       msg.ID,
+		Type:     msg.Type,
+		RoomID:   msg.RoomID,
+		SenderID: msg.SenderID,
+		Payload:  msg.Payload,
+	}
+	marshaledMsg, err := json.Marshal(clientMsg)
+	if err != nil {
+		zap.L().Error("timeline dispatch: marshal offline client msg failed", zap.Int64("msgID", msg.ID), zap.Error(err))
+		recordDelivery(msg.ID, 0, 0, 0, int64(len(uids)))
+		failTargets(msg.ID, uids)
+		return
+	}
+	members := make([]interface{}, 0, len(uids))
+	for _, uid := range uids {
+		members = append(members, uid)
+	}
+	if err := redis.SendQueueSAddEXWithRetry(2, redis.TimelineOfflineKey(msg.ID), redis.TimelineOfflineTTL, members...); err != nil {
+		zap.L().Warn("timeline dispatch: record offline targets failed", zap.Int64("msgID", msg.ID), zap.Error(err))
+	}
+	doneCount := int32(0)
+	var failed []int64
+	for _, uid := range uids {
+		if err := redis.SendQueueRPushWithRetry(2, "offline:push:"+strconv.FormatInt(uid, 10), marshaledMsg); err != nil {
+			zap.L().Error("timeline dispatch: rpush offline failed", zap.Int64("user", uid), zap.Error(err))
+			failed = append(failed, uid)
+			continue
+		}
+		notifyOffline(uid, clientMsg)
+		doneCount++
+	}
+	recordDelivery(msg.ID, 0, int64(doneCount), 0, int64(len(failed)))
+	if doneCount > 0 {
+		pendingTask.DefaultPendingManager.DoneN(msg.ID, doneCount)
+	}
+	if len(failed) > 0 {
+		failTargets(msg.ID, failed)
+	}
+}
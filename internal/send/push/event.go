@@ -0,0 +1,48 @@
+package push
+
+import (
+	"GoStacker/internal/send/route"
+	"GoStacker/pkg/config"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PushEvent 把临时事件（输入中、已读回执等）尽力推给在线用户。
+// 事件不落库、不进离线队列，也不参与投递摘要与 ACK 统计；离线或推送失败直接丢弃。
+func PushEvent(targets []int64, msg ClientMessage) {
+	if len(targets) == 0 {
+		return
+	}
+	if config.Conf.PushMod == "standalone" {
+		for _, uid := range targets {
+			_ = EnqueueMessage(uid, 50*time.Millisecond, msg)
+		}
+		return
+	}
+
+	routeMap, err := route.BatchGetUserGateways(targets)
+	if err != nil {
+		zap.L().Warn("push event: batch query routes failed", zap.String("type", msg.Type), zap.Error(err))
+		return
+	}
+	groups := make(map[string][]int64)
+	for _, uid := range targets {
+		for _, gid := range route.GatewayIDs(routeMap[uid]) {
+			groups[gid] = append(groups[gid], uid)
+		}
+	}
+	for gid, uids := range groups {
+		gwMsg := PushMessage{
+			ID:        msg.ID,
+			Type:      msg.Type,
+			RoomID:    msg.RoomID,
+			SenderID:  msg.SenderID,
+			TargetIDs: uids,
+			Payload:   msg.Payload,
+		}
+		if err := sendToGatewayWithRedisStream(gid, gwMsg); err != nil {
+			zap.L().Warn("push event: send to gateway failed", zap.String("gateway", gid), zap.String("type", msg.Type), zap.Error(err))
+		}
+	}
+}
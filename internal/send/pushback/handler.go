@@ -1,6 +1,7 @@
 package pushback
 
 import (
+	"GoStacker/internal/send/push"
 	"GoStacker/pkg/db/redis"
 	"GoStacker/pkg/response"
 	"encoding/json"
@@ -36,6 +37,16 @@ func PushbackHandler(c *gin.Context) {
 		return
 	}
 
+	// the gateway could not deliver these messages online, record them for a targeted resend
+	for _, msgID := range chatMessageIDs(marshaledMsg) {
+		if err := push.RecordUndelivered(msgID, req.TargetID); err != nil {
+			zap.L().Warn("Failed to record undelivered target",
+				zap.Int64("msg_id", msgID),
+				zap.Int64("target_id", req.TargetID),
+				zap.Error(err))
+		}
+	}
+
 	// Push to Redis offline queue
 	queueKey := "offline:push:" + strconv.FormatInt(req.TargetID, 10)
 	err = redis.SendQueueRPushWithRetry(2, queueKey, marshaledMsg)
@@ -54,3 +65,27 @@ func PushbackHandler(c *gin.Context) {
 
 	response.ReplySuccess(c, "Pushback success")
 }
+
+// chatMessageIDs returns the IDs of the chat messages in a pushed back request, which holds
+// either a single message or a batch of messages drained from a connection.
+func chatMessageIDs(raw []byte) []int64 {
+	type pushedMsg struct {
+		ID   int64  `json:"id"`
+		Type string `json:"type"`
+	}
+	var batch []pushedMsg
+	if err := json.Unmarshal(raw, &batch); err != nil {
+		var msg pushedMsg
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return nil
+		}
+		batch = []pushedMsg{msg}
+	}
+	ids := make([]int64, 0, len(batch))
+	for _, msg := range batch {
+		if msg.Type == "chat" && msg.ID > 0 {
+			ids = append(ids, msg.ID)
+		}
+	}
+	return ids
+}
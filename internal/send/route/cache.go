@@ -7,8 +7,9 @@ import (
 	"go.uber.org/zap"
 )
 
-// RouteCacheEntry represents a cached route entry
+// RouteCacheEntry represents a cached route entry of one connected device
 type RouteCacheEntry struct {
+	DeviceID  string
 	GatewayID string
 	Address   string
 }
@@ -38,32 +39,27 @@ func NewRouteCache() *RouteCache {
 	}
 }
 
-// Get retrieves a route from cache if not expired
-func (rc *RouteCache) Get(userID int64) (*RouteCacheEntry, bool) {
+// Get retrieves the device routes of a user from cache if not expired
+func (rc *RouteCache) Get(userID int64) ([]RouteCacheEntry, bool) {
 	value, found := rc.cache.Get(userID)
 	if !found {
 		return nil, false
 	}
 
-	entry, ok := value.(*RouteCacheEntry)
+	entries, ok := value.([]RouteCacheEntry)
 	if !ok {
 		return nil, false
 	}
 
-	return entry, true
+	return entries, true
 }
 
-// Set adds or updates a route in cache
-// Cost is set to 1 for each entry (can be adjusted based on actual size)
-func (rc *RouteCache) Set(userID int64, gatewayID, address string, ttl time.Duration) {
-	entry := &RouteCacheEntry{
-		GatewayID: gatewayID,
-		Address:   address,
-	}
-
+// Set adds or updates the device routes of a user in cache
+// Cost is set to 1 for each user (can be adjusted based on actual size)
+func (rc *RouteCache) Set(userID int64, entries []RouteCacheEntry, ttl time.Duration) {
 	// SetWithTTL returns false if the entry is rejected due to cost or other reasons
 	// We set cost to 1 for each route entry
-	rc.cache.SetWithTTL(userID, entry, 1, ttl)
+	rc.cache.SetWithTTL(userID, entries, 1, ttl)
 }
 
 // Delete removes a route from cache
@@ -33,23 +33,23 @@ func InitRouteService(client *registry_client.SendClient) {
 	zap.L().Info("Route service initialized with local cache")
 }
 
-// GetUserGateway retrieves the gateway information for a user
+// GetUserGateways retrieves the routes of every connected device of a user
 // It first checks local cache, then queries Registry if cache miss
-func GetUserGateway(userID int64) (gatewayID, address string, err error) {
+func GetUserGateways(userID int64) ([]*RouteInfo, error) {
 	// 1. Check local cache first
 	if localCache != nil {
-		if entry, ok := localCache.Get(userID); ok {
+		if entries, ok := localCache.Get(userID); ok {
 			zap.L().Debug("Route cache hit",
 				zap.Int64("user_id", userID),
-				zap.String("gateway_id", entry.GatewayID))
-			return entry.GatewayID, entry.Address, nil
+				zap.Int("devices", len(entries)))
+			return routesFromEntries(entries), nil
 		}
 	}
 
 	// 2. Cache miss, query from Registry
 	if registryClient == nil {
 		zap.L().Error("Registry client not initialized")
-		return "", "", ErrRegistryNotReady
+		return nil, ErrRegistryNotReady
 	}
 
 	routes, err := registryClient.QueryUserRoutes([]int64{userID})
@@ -57,46 +57,44 @@ func GetUserGateway(userID int64) (gatewayID, address string, err error) {
 		zap.L().Error("Failed to query user route from registry",
 			zap.Int64("user_id", userID),
 			zap.Error(err))
-		return "", "", err
+		return nil, err
 	}
 
-	routeInfo, exists := routes[userID]
-	if !exists {
+	devices := routes[userID]
+	if len(devices) == 0 {
 		zap.L().Debug("User route not found in registry (offline)",
 			zap.Int64("user_id", userID))
-		return "", "", ErrUserOffline
+		return nil, ErrUserOffline
 	}
 
 	// 3. Update local cache
+	entries := entriesFromRoutes(devices)
 	if localCache != nil {
-		localCache.Set(userID, routeInfo.GatewayID, routeInfo.Address, LocalRouteCacheTTL)
+		localCache.Set(userID, entries, LocalRouteCacheTTL)
 		zap.L().Debug("Route cached locally",
 			zap.Int64("user_id", userID),
-			zap.String("gateway_id", routeInfo.GatewayID),
+			zap.Int("devices", len(entries)),
 			zap.Duration("ttl", LocalRouteCacheTTL))
 	}
 
-	return routeInfo.GatewayID, routeInfo.Address, nil
+	return routesFromEntries(entries), nil
 }
 
-// BatchGetUserGateways retrieves gateway information for multiple users
-// Uses batch query to Registry for better performance
-func BatchGetUserGateways(userIDs []int64) (map[int64]*RouteInfo, error) {
+// BatchGetUserGateways retrieves the device routes for multiple users
+// Uses batch query to Registry for better performance; offline users are absent from the result
+func BatchGetUserGateways(userIDs []int64) (map[int64][]*RouteInfo, error) {
 	if registryClient == nil {
 		return nil, ErrRegistryNotReady
 	}
 
-	result := make(map[int64]*RouteInfo)
+	result := make(map[int64][]*RouteInfo)
 	missingUserIDs := []int64{}
 
 	// 1. Check local cache for all users
 	if localCache != nil {
 		for _, userID := range userIDs {
-			if entry, ok := localCache.Get(userID); ok {
-				result[userID] = &RouteInfo{
-					GatewayID: entry.GatewayID,
-					Address:   entry.Address,
-				}
+			if entries, ok := localCache.Get(userID); ok {
+				result[userID] = routesFromEntries(entries)
 			} else {
 				missingUserIDs = append(missingUserIDs, userID)
 			}
@@ -116,15 +114,16 @@ func BatchGetUserGateways(userIDs []int64) (map[int64]*RouteInfo, error) {
 		}
 
 		// 3. Merge results and update cache
-		for userID, routeInfo := range routes {
-			result[userID] = &RouteInfo{
-				GatewayID: routeInfo.GatewayID,
-				Address:   routeInfo.Address,
+		for userID, devices := range routes {
+			if len(devices) == 0 {
+				continue
 			}
+			entries := entriesFromRoutes(devices)
+			result[userID] = routesFromEntries(entries)
 
 			// Update local cache
 			if localCache != nil {
-				localCache.Set(userID, routeInfo.GatewayID, routeInfo.Address, LocalRouteCacheTTL)
+				localCache.Set(userID, entries, LocalRouteCacheTTL)
 			}
 		}
 
@@ -138,12 +137,43 @@ func BatchGetUserGateways(userIDs []int64) (map[int64]*RouteInfo, error) {
 	return result, nil
 }
 
-// RouteInfo represents route information
+// RouteInfo represents route information of one connected device
 type RouteInfo struct {
+	DeviceID  string
 	GatewayID string
 	Address   string
 }
 
+// GatewayIDs returns the distinct gateways holding the given device routes
+func GatewayIDs(routes []*RouteInfo) []string {
+	seen := make(map[string]struct{}, len(routes))
+	ids := make([]string, 0, len(routes))
+	for _, r := range routes {
+		if _, ok := seen[r.GatewayID]; ok {
+			continue
+		}
+		seen[r.GatewayID] = struct{}{}
+		ids = append(ids, r.GatewayID)
+	}
+	return ids
+}
+
+func entriesFromRoutes(routes []*registry_client.RouteInfo) []RouteCacheEntry {
+	entries := make([]RouteCacheEntry, 0, len(routes))
+	for _, r := range routes {
+		entries = append(entries, RouteCacheEntry{DeviceID: r.DeviceID, GatewayID: r.GatewayID, Address: r.Address})
+	}
+	return entries
+}
+
+func routesFromEntries(entries []RouteCacheEntry) []*RouteInfo {
+	routes := make([]*RouteInfo, 0, len(entries))
+	for _, e := range entries {
+		routes = append(routes, &RouteInfo{DeviceID: e.DeviceID, GatewayID: e.GatewayID, Address: e.Address})
+	}
+	return routes
+}
+
 // InvalidateCache removes a user route from local cache
 func InvalidateCache(userID int64) {
 	if localCache != nil {
@@ -0,0 +1,159 @@
+package upload
+
+import (
+	"GoStacker/pkg/response"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type CompleteRequest struct {
+	UploadID string `json:"upload_id" binding:"required"`
+}
+
+func InitUploadHandler(c *gin.Context) {
+	var req InitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	res, err := StartUpload(userID.(int64), req)
+	if err != nil {
+		replyUploadError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", res)
+}
+
+// UploadChunkHandler 请求体为原始字节：PUT /api/upload/chunk?upload_id=&offset=
+func UploadChunkHandler(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		response.ReplyBadRequest(c, "invalid offset")
+		return
+	}
+	next, err := UploadChunk(userID.(int64), c.Query("upload_id"), offset, c.Request.Body)
+	if err != nil {
+		if errors.Is(err, ErrOffsetMismatch) {
+			// 带回服务端进度，客户端从该位置续传
+			c.JSON(http.StatusConflict, response.StandardResponse{Code: http.StatusConflict, Msg: err.Error(), Data: gin.H{"offset": next}})
+			return
+		}
+		replyUploadError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"offset": next})
+}
+
+func UploadStatusHandler(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	res, err := UploadStatus(userID.(int64), c.Query("upload_id"))
+	if err != nil {
+		replyUploadError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", res)
+}
+
+func CompleteUploadHandler(c *gin.Context) {
+	var req CompleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.ReplyBadRequest(c, "Invalid request")
+		return
+	}
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	a, err := CompleteUpload(userID.(int64), req.UploadID)
+	if err != nil {
+		replyUploadError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"attachment": a, "url": FileURL(a.ID)})
+}
+
+func SignDownloadHandler(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		response.ReplyUnauthorized(c, "Unauthorized")
+		return
+	}
+	thumb, err := thumbParam(c)
+	if err != nil {
+		response.ReplyBadRequest(c, "invalid thumb")
+		return
+	}
+	u, exp, err := SignDownload(userID.(int64), c.Query("file_id"), thumb)
+	if err != nil {
+		replyUploadError(c, err)
+		return
+	}
+	response.ReplySuccessWithData(c, "success", gin.H{"url": u, "expires_at": exp.Unix()})
+}
+
+// DownloadHandler 不走 JWT，凭签名链接下载，便于直接用于 <img src> 等场景
+func DownloadHandler(c *gin.Context) {
+	thumb, err := thumbParam(c)
+	if err != nil {
+		response.ReplyBadRequest(c, "invalid thumb")
+		return
+	}
+	a, rc, err := OpenDownload(c.Param("file_id"), c.Query("uid"), c.Query("exp"), c.Query("sig"), thumb)
+	if err != nil {
+		replyUploadError(c, err)
+		return
+	}
+	defer rc.Close()
+	c.Header("Content-Type", a.MimeType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.FileName}))
+	c.Header("Cache-Control", "private, max-age=300")
+	http.ServeContent(c.Writer, c.Request, "", a.CreatedAt, rc)
+}
+
+// thumbParam 解析可选的缩略图规格，缺省为 0 表示原图
+func thumbParam(c *gin.Context) (int, error) {
+	raw := c.Query("thumb")
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid thumb")
+	}
+	return n, nil
+}
+
+func replyUploadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrAttachmentNotFound), errors.Is(err, ErrObjectNotFound):
+		response.ReplyNotFound(c, err.Error())
+	case errors.Is(err, ErrNotRoomMember), errors.Is(err, ErrBadSignature), errors.Is(err, ErrURLExpired):
+		response.ReplyUnauthorized(c, err.Error())
+	case errors.Is(err, ErrInvalidUpload), errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrIncomplete),
+		errors.Is(err, ErrHashMismatch):
+		response.ReplyBadRequest(c, err.Error())
+	default:
+		zap.L().Error("upload request failed", zap.Error(err))
+		response.ReplyError500(c, err.Error())
+	}
+}
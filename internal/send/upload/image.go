@@ -0,0 +1,227 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	thumbnailQuality = 80
+	blurhashEdge     = 32 // 计算 blurhash 前先缩到该尺寸以内，足够表达整体色块
+)
+
+var (
+	thumbnailSizes       = []int{160, 480, 1080}
+	maxImagePixels int64 = 50 * 1000 * 1000
+
+	ErrImageTooLarge = errors.New("image dimensions exceed the allowed maximum")
+	ErrImageEmpty    = errors.New("image has no pixels")
+)
+
+// Thumbnail 是一个缩略图规格，Size 为长边上限，Width/Height 为实际尺寸
+type Thumbnail struct {
+	Size   int `json:"size"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// ImageMeta 是服务端解码图片得到的元数据，按内容哈希保存，去重的附件共用一份
+type ImageMeta struct {
+	Width      int         `json:"width"`
+	Height     int         `json:"height"`
+	Blurhash   string      `json:"blurhash"`
+	Thumbnails []Thumbnail `json:"thumbnails,omitempty"`
+}
+
+// processImage 解码图片，生成各尺寸 JPEG 缩略图与 blurhash，并保存元数据。
+// 只支持标准库能解码的格式（jpeg/png/gif），其他类型按普通文件处理。
+func processImage(hash string) (*ImageMeta, error) {
+	if meta, err := queryImageMeta(hash); err != nil || meta != nil {
+		return meta, err
+	}
+	rc, err := storage.Open(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	cfg, _, err := image.DecodeConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+	// 先看尺寸再完整解码，避免解压炸弹；宽或高为 0 的头部（截断或伪造）无法计算缩放比例
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrImageEmpty
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+	if _, err := rc.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(rc)
+	if err != nil {
+		return nil, err
+	}
+	// 透明区域铺白底，JPEG 不支持 alpha
+	b := src.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return nil, ErrImageEmpty
+	}
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, b.Min, draw.Over)
+
+	meta := &ImageMeta{Width: b.Dx(), Height: b.Dy()}
+	for _, size := range thumbnailSizes {
+		if size >= meta.Width && size >= meta.Height {
+			// 原图已小于该规格，不再生成更大的缩略图
+			break
+		}
+		w, h := fitWithin(meta.Width, meta.Height, size)
+		thumb := downscale(flat, w, h)
+		if err := saveThumbnail(hash, size, thumb); err != nil {
+			return nil, err
+		}
+		meta.Thumbnails = append(meta.Thumbnails, Thumbnail{Size: size, Width: w, Height: h})
+	}
+	bw, bh := fitWithin(meta.Width, meta.Height, blurhashEdge)
+	meta.Blurhash = encodeBlurhash(downscale(flat, bw, bh))
+
+	if err := upsertImageMeta(hash, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// imageMetaFor 在附件完成时调用，失败只记录日志，附件仍按普通文件保存
+func imageMetaFor(a *Attachment) *ImageMeta {
+	if !strings.HasPrefix(a.MimeType, "image/") {
+		return nil
+	}
+	meta, err := processImage(a.Hash)
+	if err != nil {
+		if !errors.Is(err, image.ErrFormat) {
+			zap.L().Warn("process uploaded image failed", zap.String("file_id", a.ID), zap.Error(err))
+		}
+		return nil
+	}
+	return meta
+}
+
+// ImageInfo 返回本服务签发的图片 URL 对应的元数据与缩略图地址，
+// 非签发地址、非图片或附件不属于 roomID 时返回 nil，避免把别的房间的附件信息带进消息
+func ImageInfo(rawURL string, roomID int64) (*ImageMeta, map[int]string, error) {
+	fileID, ok := ParseFileURL(rawURL)
+	if !ok {
+		return nil, nil, nil
+	}
+	a, err := queryAttachment(fileID)
+	if errors.Is(err, ErrAttachmentNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.RoomID != roomID {
+		return nil, nil, nil
+	}
+	meta, err := queryImageMeta(a.Hash)
+	if err != nil || meta == nil {
+		return nil, nil, err
+	}
+	urls := make(map[int]string, len(meta.Thumbnails))
+	for _, t := range meta.Thumbnails {
+		urls[t.Size] = ThumbnailURL(a.ID, t.Size)
+	}
+	return meta, urls, nil
+}
+
+// ThumbnailURL 返回缩略图的规范地址，与原图一样需要签名后下载
+func ThumbnailURL(fileID string, size int) string {
+	return fmt.Sprintf("%s?thumb=%d", FileURL(fileID), size)
+}
+
+func thumbnailKey(hash string, size int) string {
+	return fmt.Sprintf("%s_t%d", hash, size)
+}
+
+func saveThumbnail(hash string, size int, img image.Image) error {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return err
+	}
+	tmp := tmpPath(newID())
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return storage.PutFile(thumbnailKey(hash, size), tmp)
+}
+
+// fitWithin 等比缩放，使长边不超过 edge；宽或高不为正时返回 0, 0
+func fitWithin(w, h, edge int) (int, int) {
+	if w <= 0 || h <= 0 {
+		return 0, 0
+	}
+	if w >= h {
+		nh := h * edge / w
+		if nh < 1 {
+			nh = 1
+		}
+		return edge, nh
+	}
+	nw := w * edge / h
+	if nw < 1 {
+		nw = 1
+	}
+	return nw, edge
+}
+
+// downscale 用区域平均（box filter）缩小图片，只用于缩小
+func downscale(src *image.RGBA, w, h int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := y * sh / h
+		y1 := (y + 1) * sh / h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0 := x * sw / w
+			x1 := (x + 1) * sw / w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				off := src.PixOffset(sb.Min.X+x0, sb.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(src.Pix[off])
+					g += uint64(src.Pix[off+1])
+					b += uint64(src.Pix[off+2])
+					off += 4
+					n++
+				}
+			}
+			d := dst.PixOffset(x, y)
+			dst.Pix[d] = uint8(r / n)
+			dst.Pix[d+1] = uint8(g / n)
+			dst.Pix[d+2] = uint8(b / n)
+			dst.Pix[d+3] = 0xff
+		}
+	}
+	return dst
+}
@@ -0,0 +1,130 @@
+package upload
+
+import (
+	"GoStacker/pkg/db/mysql"
+	rdb "GoStacker/pkg/db/redis"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const sessionKeyFmt = "upload:session:%s"
+
+// Attachment 是一个已完成上传的附件
+type Attachment struct {
+	ID         string    `json:"file_id"`
+	Hash       string    `json:"-"` // 内容 sha256，同内容附件共用存储；知道哈希不代表持有内容，不能据此跨房间秒传
+	RoomID     int64     `json:"room_id"`
+	UploaderID int64     `json:"uploader_id"`
+	FileName   string    `json:"file_name"`
+	Size       int64     `json:"size"`
+	MimeType   string    `json:"mime_type"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Image 仅图片附件有值，由服务端解码得到
+	Image *ImageMeta `json:"image,omitempty"`
+}
+
+// session 是进行中的分片上传，保存在 redis，数据写在本机临时文件中
+type session struct {
+	ID       string `json:"upload_id"`
+	UserID   int64  `json:"user_id"`
+	RoomID   int64  `json:"room_id"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Offset   int64  `json:"offset"`
+	Hash     string `json:"sha256,omitempty"` // 客户端声明的哈希，完成时校验
+}
+
+func saveSession(s *session, ttl time.Duration) error {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return rdb.SetEXWithRetry(2, fmt.Sprintf(sessionKeyFmt, s.ID), raw, ttl)
+}
+
+func loadSession(id string) (*session, error) {
+	raw, err := rdb.GetWithRetry(2, fmt.Sprintf(sessionKeyFmt, id))
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s session
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func deleteSession(id string) error {
+	return rdb.Rdb.Del(context.Background(), fmt.Sprintf(sessionKeyFmt, id)).Err()
+}
+
+func insertAttachment(a *Attachment) error {
+	q := "INSERT INTO attachments (id, hash, room_id, uploader_id, file_name, size, mime_type, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err := mysql.DB.Exec(q, a.ID, a.Hash, a.RoomID, a.UploaderID, a.FileName, a.Size, a.MimeType, a.CreatedAt)
+	return err
+}
+
+func queryAttachment(id string) (*Attachment, error) {
+	var a Attachment
+	q := "SELECT id, hash, room_id, uploader_id, file_name, size, mime_type, created_at FROM attachments WHERE id = ?"
+	err := mysql.DB.QueryRow(q, id).Scan(&a.ID, &a.Hash, &a.RoomID, &a.UploaderID, &a.FileName, &a.Size, &a.MimeType, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// queryVisibleAttachmentByHash 用于秒传：返回一个相同内容、且由 userID 上传或属于 roomID 的附件
+func queryVisibleAttachmentByHash(hash string, userID, roomID int64) (*Attachment, error) {
+	var a Attachment
+	q := "SELECT id, hash, room_id, uploader_id, file_name, size, mime_type, created_at FROM attachments WHERE hash = ? AND (uploader_id = ? OR room_id = ?) LIMIT 1"
+	err := mysql.DB.QueryRow(q, hash, userID, roomID).Scan(&a.ID, &a.Hash, &a.RoomID, &a.UploaderID, &a.FileName, &a.Size, &a.MimeType, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// queryImageMeta 返回内容对应的图片元数据，未处理过时返回 nil
+func queryImageMeta(hash string) (*ImageMeta, error) {
+	var meta ImageMeta
+	var thumbs string
+	err := mysql.DB.QueryRow("SELECT width, height, blurhash, thumbnails FROM attachment_images WHERE hash = ?", hash).
+		Scan(&meta.Width, &meta.Height, &meta.Blurhash, &thumbs)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(thumbs), &meta.Thumbnails); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func upsertImageMeta(hash string, meta *ImageMeta) error {
+	thumbs, err := json.Marshal(meta.Thumbnails)
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO attachment_images (hash, width, height, blurhash, thumbnails) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE width = VALUES(width), height = VALUES(height), blurhash = VALUES(blurhash), thumbnails = VALUES(thumbnails)`
+	_, err = mysql.DB.Exec(q, hash, meta.Width, meta.Height, meta.Blurhash, string(thumbs))
+	return err
+}
@@ -0,0 +1,470 @@
+// Package upload 提供附件上传与下载：分片断点续传、按内容哈希去重存储，
+// 以及校验房间成员身份的限时签名下载链接。
+package upload
+
+import (
+	"GoStacker/internal/meta/chat/group"
+	"GoStacker/pkg/config"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("upload session not found or expired")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrNotRoomMember      = errors.New("not a member of this room")
+	ErrInvalidUpload      = errors.New("invalid upload request")
+	ErrFileTooLarge       = errors.New("file exceeds the maximum allowed size")
+	ErrOffsetMismatch     = errors.New("chunk offset does not match upload progress")
+	ErrIncomplete         = errors.New("upload is not complete")
+	ErrHashMismatch       = errors.New("uploaded content does not match sha256")
+	ErrBadSignature       = errors.New("invalid download signature")
+	ErrURLExpired         = errors.New("download url expired")
+	ErrNotIssued          = errors.New("attachment url was not issued by the upload service")
+)
+
+var (
+	storage       Storage
+	tmpDir              = filepath.Join("uploads", "tmp")
+	chunkSize     int64 = 4 << 20
+	maxFileSize   int64 = 100 << 20
+	sessionTTL          = 24 * time.Hour
+	urlTTL              = 10 * time.Minute
+	signSecret    []byte
+	strict        bool
+	publicBaseURL string
+
+	// 同一上传会话的分片必须串行写入
+	sessionLocks sync.Map
+)
+
+// InitRequest 创建上传会话。携带 sha256 且内容已存在时直接完成（秒传）
+type InitRequest struct {
+	RoomID   int64  `json:"room_id" binding:"required"`
+	FileName string `json:"file_name" binding:"required"`
+	Size     int64  `json:"size" binding:"required"`
+	MimeType string `json:"mime_type"`
+	SHA256   string `json:"sha256"`
+}
+
+type InitResult struct {
+	UploadID   string      `json:"upload_id,omitempty"`
+	ChunkSize  int64       `json:"chunk_size,omitempty"`
+	Offset     int64       `json:"offset"`
+	Completed  bool        `json:"completed"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+	URL        string      `json:"url,omitempty"`
+}
+
+// Init 初始化本地存储与签名密钥；cfg 为 nil 时使用默认值
+func Init(cfg *config.UploadConfig) error {
+	dir := "uploads"
+	if cfg != nil {
+		if cfg.Dir != "" {
+			dir = cfg.Dir
+		}
+		if cfg.ChunkSize > 0 {
+			chunkSize = cfg.ChunkSize
+		}
+		if cfg.MaxFileSize > 0 {
+			maxFileSize = cfg.MaxFileSize
+		}
+		if cfg.SessionTTL > 0 {
+			sessionTTL = time.Duration(cfg.SessionTTL) * time.Second
+		}
+		if cfg.URLTTL > 0 {
+			urlTTL = time.Duration(cfg.URLTTL) * time.Second
+		}
+		strict = cfg.Strict
+		if len(cfg.ThumbnailSizes) > 0 {
+			thumbnailSizes = append([]int(nil), cfg.ThumbnailSizes...)
+			sort.Ints(thumbnailSizes)
+		}
+		if cfg.MaxImagePixels > 0 {
+			maxImagePixels = cfg.MaxImagePixels
+		}
+		publicBaseURL = strings.TrimRight(cfg.PublicBaseURL, "/")
+		signSecret = []byte(cfg.SignSecret)
+	}
+	if len(signSecret) == 0 && config.Conf != nil && config.Conf.JWTConfig != nil {
+		signSecret = []byte(config.Conf.JWTConfig.Secret)
+	}
+	if len(signSecret) == 0 {
+		return errors.New("upload sign secret is empty")
+	}
+
+	local, err := NewLocalStorage(dir)
+	if err != nil {
+		return err
+	}
+	storage = local
+	tmpDir = filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return err
+	}
+	go cleanupLoop()
+	zap.L().Info("upload service initialized", zap.String("dir", dir), zap.Bool("strict", strict))
+	return nil
+}
+
+// StartUpload 校验成员身份后创建上传会话。
+// 会话数据写在本机临时目录，同一会话的后续分片需要落到同一个 send 实例。
+func StartUpload(userID int64, req InitRequest) (*InitResult, error) {
+	if req.Size <= 0 || req.FileName == "" || len(req.FileName) > 255 {
+		return nil, ErrInvalidUpload
+	}
+	if req.Size > maxFileSize {
+		return nil, ErrFileTooLarge
+	}
+	if err := checkMember(req.RoomID, userID); err != nil {
+		return nil, err
+	}
+	req.SHA256 = strings.ToLower(req.SHA256)
+
+	if req.SHA256 != "" {
+		if a, err := instantUpload(userID, req); err != nil {
+			return nil, err
+		} else if a != nil {
+			return &InitResult{Completed: true, Offset: a.Size, Attachment: a, URL: FileURL(a.ID)}, nil
+		}
+	}
+
+	s := &session{
+		ID:       newID(),
+		UserID:   userID,
+		RoomID:   req.RoomID,
+		FileName: req.FileName,
+		MimeType: req.MimeType,
+		Size:     req.Size,
+		Hash:     req.SHA256,
+	}
+	f, err := os.Create(tmpPath(s.ID))
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if err := saveSession(s, sessionTTL); err != nil {
+		os.Remove(tmpPath(s.ID))
+		return nil, err
+	}
+	return &InitResult{UploadID: s.ID, ChunkSize: chunkSize}, nil
+}
+
+// instantUpload 内容已存在且大小一致时直接登记新附件，不再传输数据。
+// 只复用请求方本人上传过或目标房间内已有的附件：仅凭哈希和大小不能证明持有内容，
+// 跨用户秒传会让知道哈希的人拿到别人的文件；其余情况走正常上传，存储层仍按哈希去重
+func instantUpload(userID int64, req InitRequest) (*Attachment, error) {
+	existing, err := queryVisibleAttachmentByHash(req.SHA256, userID, req.RoomID)
+	if errors.Is(err, ErrAttachmentNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing.Size != req.Size {
+		return nil, nil
+	}
+	if ok, err := storage.Exists(req.SHA256); err != nil || !ok {
+		return nil, err
+	}
+	a := &Attachment{
+		ID:         newID(),
+		Hash:       existing.Hash,
+		RoomID:     req.RoomID,
+		UploaderID: userID,
+		FileName:   req.FileName,
+		Size:       existing.Size,
+		MimeType:   existing.MimeType,
+		CreatedAt:  time.Now(),
+	}
+	if err := insertAttachment(a); err != nil {
+		return nil, err
+	}
+	a.Image = imageMetaFor(a)
+	return a, nil
+}
+
+// UploadChunk 在 offset 处追加一段数据，offset 必须等于当前进度。返回新的进度
+func UploadChunk(userID int64, uploadID string, offset int64, r io.Reader) (int64, error) {
+	unlock := lockSession(uploadID)
+	defer unlock()
+
+	s, err := ownSession(userID, uploadID)
+	if err != nil {
+		return 0, err
+	}
+	if offset != s.Offset {
+		return s.Offset, ErrOffsetMismatch
+	}
+	f, err := os.OpenFile(tmpPath(s.ID), os.O_WRONLY, 0o644)
+	if os.IsNotExist(err) {
+		// 会话在别的实例上创建，或临时文件已被清理
+		return 0, ErrSessionNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	// 上次写入成功但进度未保存时，丢弃多出的部分
+	if err := f.Truncate(s.Offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(s.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	limit := s.Size - s.Offset
+	if limit > chunkSize {
+		limit = chunkSize
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if err != nil {
+		return s.Offset, err
+	}
+	if n > limit {
+		f.Truncate(s.Offset)
+		if limit == chunkSize {
+			return s.Offset, fmt.Errorf("%w: chunk exceeds %d bytes", ErrInvalidUpload, chunkSize)
+		}
+		return s.Offset, ErrFileTooLarge
+	}
+	s.Offset += n
+	if err := saveSession(s, sessionTTL); err != nil {
+		return 0, err
+	}
+	return s.Offset, nil
+}
+
+// UploadStatus 返回会话当前进度，客户端断线后据此续传
+func UploadStatus(userID int64, uploadID string) (*InitResult, error) {
+	s, err := ownSession(userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return &InitResult{UploadID: s.ID, ChunkSize: chunkSize, Offset: s.Offset}, nil
+}
+
+// CompleteUpload 校验数据完整后按哈希入库，并登记附件
+func CompleteUpload(userID int64, uploadID string) (*Attachment, error) {
+	unlock := lockSession(uploadID)
+	defer unlock()
+
+	s, err := ownSession(userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if s.Offset != s.Size {
+		return nil, ErrIncomplete
+	}
+	path := tmpPath(s.ID)
+	hash, sniffed, err := hashFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Hash != "" && s.Hash != hash {
+		discardSession(s.ID)
+		return nil, ErrHashMismatch
+	}
+	mimeType := s.MimeType
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = sniffed
+	}
+	if err := storage.PutFile(hash, path); err != nil {
+		return nil, err
+	}
+	a := &Attachment{
+		ID:         newID(),
+		Hash:       hash,
+		RoomID:     s.RoomID,
+		UploaderID: userID,
+		FileName:   s.FileName,
+		Size:       s.Size,
+		MimeType:   mimeType,
+		CreatedAt:  time.Now(),
+	}
+	if err := insertAttachment(a); err != nil {
+		return nil, err
+	}
+	a.Image = imageMetaFor(a)
+	if err := deleteSession(s.ID); err != nil {
+		zap.L().Warn("delete upload session failed", zap.String("upload_id", s.ID), zap.Error(err))
+	}
+	return a, nil
+}
+
+// SignDownload 为房间成员签发限时下载链接，thumb > 0 时链接指向该规格的缩略图
+func SignDownload(userID int64, fileID string, thumb int) (string, time.Time, error) {
+	a, err := queryAttachment(fileID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := checkMember(a.RoomID, userID); err != nil {
+		return "", time.Time{}, err
+	}
+	exp := time.Now().Add(urlTTL)
+	u := signedURL(a.ID, userID, exp.Unix())
+	if thumb > 0 {
+		u += fmt.Sprintf("&thumb=%d", thumb)
+	}
+	return u, exp, nil
+}
+
+// OpenDownload 校验签名后打开附件内容（thumb > 0 时打开缩略图）。
+// 下载时再次检查成员身份，退群后未过期的链接也会失效
+func OpenDownload(fileID, uid, exp, sig string, thumb int) (*Attachment, io.ReadSeekCloser, error) {
+	userID, err := verifySignature(fileID, uid, exp, sig)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := queryAttachment(fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkMember(a.RoomID, userID); err != nil {
+		return nil, nil, err
+	}
+	key := a.Hash
+	if thumb > 0 {
+		// 签名只绑定附件，同一链接可以取原图或任意缩略图
+		key = thumbnailKey(a.Hash, thumb)
+		a.MimeType = "image/jpeg"
+		a.FileName = fmt.Sprintf("%s_%d.jpg", strings.TrimSuffix(a.FileName, filepath.Ext(a.FileName)), thumb)
+	}
+	rc, err := storage.Open(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, rc, nil
+}
+
+// CheckIssuedURL 严格模式下要求附件 URL 由本服务签发且属于消息所在房间；非严格模式不做检查
+func CheckIssuedURL(rawURL string, roomID int64) error {
+	if !strict {
+		return nil
+	}
+	fileID, ok := ParseFileURL(rawURL)
+	if !ok {
+		return ErrNotIssued
+	}
+	a, err := queryAttachment(fileID)
+	if errors.Is(err, ErrAttachmentNotFound) {
+		return ErrNotIssued
+	}
+	if err != nil {
+		return err
+	}
+	if a.RoomID != roomID {
+		return ErrNotIssued
+	}
+	return nil
+}
+
+func checkMember(roomID, userID int64) error {
+	ok, err := group.IsRoomMember(roomID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotRoomMember
+	}
+	return nil
+}
+
+func ownSession(userID int64, uploadID string) (*session, error) {
+	if !validID(uploadID) {
+		return nil, ErrSessionNotFound
+	}
+	s, err := loadSession(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if s.UserID != userID {
+		return nil, ErrSessionNotFound
+	}
+	return s, nil
+}
+
+func discardSession(id string) {
+	os.Remove(tmpPath(id))
+	if err := deleteSession(id); err != nil {
+		zap.L().Warn("delete upload session failed", zap.String("upload_id", id), zap.Error(err))
+	}
+}
+
+func lockSession(id string) func() {
+	v, _ := sessionLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return func() {
+		mu.Unlock()
+	}
+}
+
+func tmpPath(id string) string {
+	return filepath.Join(tmpDir, id)
+}
+
+// hashFile 计算文件 sha256，同时根据文件头嗅探 MIME 类型
+func hashFile(path string) (string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", "", err
+	}
+	h := sha256.New()
+	h.Write(head[:n])
+	if _, err := io.Copy(h, f); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), http.DetectContentType(head[:n]), nil
+}
+
+// cleanupLoop 删除超过会话有效期仍未完成的临时文件
+func cleanupLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		entries, err := os.ReadDir(tmpDir)
+		if err != nil {
+			zap.L().Warn("read upload tmp dir failed", zap.Error(err))
+			continue
+		}
+		cutoff := time.Now().Add(-sessionTTL)
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+			os.Remove(filepath.Join(tmpDir, e.Name()))
+			sessionLocks.Delete(e.Name())
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
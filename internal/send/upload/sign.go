@@ -0,0 +1,82 @@
+package upload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filePathPrefix 是签发给消息使用的附件地址前缀：<public_base_url>/files/<file_id>。
+// 该地址本身不可直接下载，需要通过 /api/files/sign 换取带签名的限时链接。
+const filePathPrefix = "/files/"
+
+// FileURL 返回附件的规范地址，客户端把它放进图片/语音/文件消息的 url 字段
+func FileURL(fileID string) string {
+	return publicBaseURL + filePathPrefix + fileID
+}
+
+// ParseFileURL 从规范地址中解析出 file_id，不是本服务签发的地址时 ok=false
+func ParseFileURL(raw string) (string, bool) {
+	path := raw
+	if publicBaseURL != "" {
+		if !strings.HasPrefix(raw, publicBaseURL) {
+			return "", false
+		}
+		path = strings.TrimPrefix(raw, publicBaseURL)
+	}
+	if !strings.HasPrefix(path, filePathPrefix) {
+		return "", false
+	}
+	id := strings.TrimPrefix(path, filePathPrefix)
+	if !validID(id) {
+		return "", false
+	}
+	return id, true
+}
+
+// signature 绑定 file_id、下载用户与过期时间，链接泄露后也只能在有效期内使用
+func signature(fileID string, userID int64, exp int64) string {
+	mac := hmac.New(sha256.New, signSecret)
+	fmt.Fprintf(mac, "%s|%d|%d", fileID, userID, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signedURL(fileID string, userID int64, exp int64) string {
+	q := url.Values{}
+	q.Set("uid", strconv.FormatInt(userID, 10))
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("sig", signature(fileID, userID, exp))
+	return FileURL(fileID) + "?" + q.Encode()
+}
+
+// verifySignature 校验签名与有效期，返回签名中的下载用户
+func verifySignature(fileID, uid, exp, sig string) (int64, error) {
+	userID, err := strconv.ParseInt(uid, 10, 64)
+	if err != nil {
+		return 0, ErrBadSignature
+	}
+	expAt, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return 0, ErrBadSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(fileID, userID, expAt))) {
+		return 0, ErrBadSignature
+	}
+	if time.Now().Unix() > expAt {
+		return 0, ErrURLExpired
+	}
+	return userID, nil
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
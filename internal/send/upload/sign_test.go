@@ -0,0 +1,129 @@
+package upload
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFileID = "0123456789abcdef0123456789abcdef"
+
+func withSigning(t *testing.T, secret, baseURL string) {
+	t.Helper()
+	prevSecret, prevBase := signSecret, publicBaseURL
+	signSecret, publicBaseURL = []byte(secret), baseURL
+	t.Cleanup(func() { signSecret, publicBaseURL = prevSecret, prevBase })
+}
+
+// splitSigned 从签名链接中取出 file_id 与 uid/exp/sig 参数
+func splitSigned(t *testing.T, raw string) (string, url.Values) {
+	t.Helper()
+	base, query, _ := strings.Cut(raw, "?")
+	id, ok := ParseFileURL(base)
+	if !ok {
+		t.Fatalf("signed url %q does not carry a file url", raw)
+	}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("parse query: %v", err)
+	}
+	return id, q
+}
+
+func TestSignedURLRoundTrip(t *testing.T) {
+	withSigning(t, "secret", "https://cdn.example.com")
+	exp := time.Now().Add(time.Minute).Unix()
+	id, q := splitSigned(t, signedURL(testFileID, 7, exp))
+	if id != testFileID {
+		t.Fatalf("file id = %q, want %q", id, testFileID)
+	}
+	userID, err := verifySignature(id, q.Get("uid"), q.Get("exp"), q.Get("sig"))
+	if err != nil || userID != 7 {
+		t.Fatalf("verifySignature = %d, %v, want 7, nil", userID, err)
+	}
+}
+
+func TestVerifySignatureRejects(t *testing.T) {
+	withSigning(t, "secret", "")
+	exp := time.Now().Add(time.Minute).Unix()
+	expStr := strconv.FormatInt(exp, 10)
+	sig := signature(testFileID, 7, exp)
+	otherFile := strings.Repeat("f", 32)
+
+	cases := []struct {
+		name             string
+		fileID, uid, exp string
+		sig              string
+	}{
+		{"tampered signature", testFileID, "7", expStr, strings.Repeat("0", len(sig))},
+		{"other user", testFileID, "8", expStr, sig},
+		{"other file", otherFile, "7", expStr, sig},
+		{"extended expiry", testFileID, "7", strconv.FormatInt(exp+3600, 10), sig},
+		{"bad uid", testFileID, "x", expStr, sig},
+		{"bad exp", testFileID, "7", "soon", sig},
+	}
+	for _, c := range cases {
+		if _, err := verifySignature(c.fileID, c.uid, c.exp, c.sig); err != ErrBadSignature {
+			t.Errorf("%s: err = %v, want ErrBadSignature", c.name, err)
+		}
+	}
+
+	withSigning(t, "other", "")
+	if _, err := verifySignature(testFileID, "7", expStr, sig); err != ErrBadSignature {
+		t.Errorf("other secret: err = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestVerifySignatureExpired(t *testing.T) {
+	withSigning(t, "secret", "")
+	exp := time.Now().Add(-time.Second).Unix()
+	_, err := verifySignature(testFileID, "7", strconv.FormatInt(exp, 10), signature(testFileID, 7, exp))
+	if err != ErrURLExpired {
+		t.Fatalf("err = %v, want ErrURLExpired", err)
+	}
+}
+
+func TestParseFileURL(t *testing.T) {
+	withSigning(t, "secret", "https://cdn.example.com")
+	cases := []struct {
+		raw string
+		ok  bool
+	}{
+		{"https://cdn.example.com/files/" + testFileID, true},
+		{"https://evil.example.com/files/" + testFileID, false},
+		{"/files/" + testFileID, false},
+		{"https://cdn.example.com/files/" + testFileID[:31], false},
+		{"https://cdn.example.com/files/" + strings.Repeat("z", 32), false},
+		{"https://cdn.example.com/other/" + testFileID, false},
+	}
+	for _, c := range cases {
+		if _, ok := ParseFileURL(c.raw); ok != c.ok {
+			t.Errorf("ParseFileURL(%q) ok = %v, want %v", c.raw, ok, c.ok)
+		}
+	}
+
+	withSigning(t, "secret", "")
+	if id, ok := ParseFileURL("/files/" + testFileID); !ok || id != testFileID {
+		t.Fatalf("ParseFileURL without base = %q, %v, want %q, true", id, ok, testFileID)
+	}
+}
+
+func TestFitWithin(t *testing.T) {
+	cases := []struct {
+		w, h, edge   int
+		wantW, wantH int
+	}{
+		{1000, 500, 200, 200, 100},
+		{500, 1000, 200, 100, 200},
+		{10000, 1, 200, 200, 1},
+		{0, 500, 200, 0, 0},
+		{500, 0, 200, 0, 0},
+	}
+	for _, c := range cases {
+		if w, h := fitWithin(c.w, c.h, c.edge); w != c.wantW || h != c.wantH {
+			t.Errorf("fitWithin(%d, %d, %d) = %d, %d, want %d, %d", c.w, c.h, c.edge, w, h, c.wantW, c.wantH)
+		}
+	}
+}
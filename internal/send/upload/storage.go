@@ -0,0 +1,64 @@
+package upload
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// Storage 按内容哈希保存附件，相同内容只存一份
+type Storage interface {
+	// PutFile 把本地临时文件以 hash 为 key 存入，已存在时丢弃临时文件
+	PutFile(hash string, tmpPath string) error
+	Open(hash string) (io.ReadSeekCloser, error)
+	Exists(hash string) (bool, error)
+}
+
+// LocalStorage 把对象保存在本地目录：<root>/objects/<hash[0:2]>/<hash>
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	if err := os.MkdirAll(filepath.Join(root, "objects"), 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{root: root}, nil
+}
+
+func (l *LocalStorage) objectPath(hash string) string {
+	return filepath.Join(l.root, "objects", hash[:2], hash)
+}
+
+func (l *LocalStorage) PutFile(hash string, tmpPath string) error {
+	dst := l.objectPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return os.Remove(tmpPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+func (l *LocalStorage) Open(hash string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(l.objectPath(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	return f, err
+}
+
+func (l *LocalStorage) Exists(hash string) (bool, error) {
+	_, err := os.Stat(l.objectPath(hash))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
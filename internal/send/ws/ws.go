@@ -1,6 +1,7 @@
 package ws
 
 import (
+	"GoStacker/internal/send/presence"
 	"GoStacker/internal/send/push"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -33,6 +34,7 @@ func WebSocketHandler(c *gin.Context) {
 	}
 	userIDInt64 := userID.(int64)
 	push.RegisterConnection(userIDInt64, conn)
+	presence.Connect(userIDInt64, "")
 
 	push.PushViaWS(userIDInt64, 10*time.Second, push.ClientMessage{
 		ID:       -1,
@@ -123,5 +125,6 @@ func WebSocketHandler(c *gin.Context) {
 		}
 	}
 	push.RemoveConnection(userIDInt64)
+	presence.Disconnect(userIDInt64, "")
 	zap.L().Info("WebSocket connection closed", zap.Int64("userID", userIDInt64))
 }
@@ -32,6 +32,7 @@ func NewRouter(PushMod string) *gin.Engine {
 	g.POST("/login", user.LoginHandler)
 	auth := g.Group("/api", middleware.JWTAuthMiddleware())
 	{
+		auth.POST("/logout", user.LogoutHandler)
 		auth.POST("/chat/group/create", group.CreateRoomHandler)
 		auth.POST("/chat/group/add_member", group.AddRoomMemberHandler)
 		auth.POST("/chat/group/add_members", group.AddRoomMembersHandler)
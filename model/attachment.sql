@@ -0,0 +1,19 @@
+CREATE TABLE attachments (
+    id VARCHAR(32) PRIMARY KEY COMMENT '附件ID',
+    hash CHAR(64) NOT NULL COMMENT '内容 sha256，同内容共用一份存储',
+    room_id BIGINT NOT NULL COMMENT '所属聊天室ID',
+    uploader_id BIGINT NOT NULL COMMENT '上传者ID',
+    file_name VARCHAR(255) NOT NULL COMMENT '文件名',
+    size BIGINT NOT NULL COMMENT '文件大小（字节）',
+    mime_type VARCHAR(100) NOT NULL COMMENT 'MIME 类型',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP COMMENT '上传时间',
+    INDEX idx_hash (hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='附件表';
+
+CREATE TABLE attachment_images (
+    hash CHAR(64) PRIMARY KEY COMMENT '内容 sha256',
+    width INT NOT NULL COMMENT '宽度',
+    height INT NOT NULL COMMENT '高度',
+    blurhash VARCHAR(64) NOT NULL COMMENT 'BlurHash 占位图',
+    thumbnails TEXT NOT NULL COMMENT '缩略图规格（JSON）'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='图片附件元数据';
@@ -5,5 +5,6 @@ CREATE TABLE chat_messages (
     content TEXT NOT NULL,
     type VARCHAR(20) DEFAULT 'text', -- text/image/file/system
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    is_deleted BOOLEAN DEFAULT FALSE
+    is_deleted BOOLEAN DEFAULT FALSE,
+    INDEX idx_room_id (room_id, id)
 );
\ No newline at end of file
@@ -5,4 +5,19 @@ CREATE TABLE chat_rooms (
     creator_id BIGINT UNSIGNED NOT NULL COMMENT '创建者ID',
     created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
     FOREIGN KEY (creator_id) REFERENCES users(id)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='聊天室表';
\ No newline at end of file
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='聊天室表';
+
+CREATE TABLE chat_room_retention (
+    room_id BIGINT PRIMARY KEY COMMENT '聊天室ID',
+    keep_days INT NOT NULL DEFAULT 0 COMMENT '保留天数，0 表示不按天数清理',
+    keep_last INT NOT NULL DEFAULT 0 COMMENT '保留最近条数，0 表示不按条数清理',
+    updated_by BIGINT NOT NULL COMMENT '最后修改的群主',
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP COMMENT '修改时间'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='聊天室消息保留策略';
+
+CREATE TABLE chat_room_e2e (
+    room_id BIGINT PRIMARY KEY COMMENT '聊天室ID',
+    required BOOLEAN NOT NULL DEFAULT FALSE COMMENT '是否要求端到端加密消息',
+    updated_by BIGINT NOT NULL COMMENT '最后修改的群主',
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP COMMENT '修改时间'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='聊天室端到端加密要求';
@@ -0,0 +1,19 @@
+CREATE TABLE e2e_device_keys (
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    device_id VARCHAR(64) NOT NULL COMMENT '设备ID',
+    identity_key TEXT NOT NULL COMMENT '身份公钥',
+    signed_prekey_id INT NOT NULL COMMENT 'signed prekey 编号',
+    signed_prekey TEXT NOT NULL COMMENT 'signed prekey 公钥',
+    signed_prekey_sig TEXT NOT NULL COMMENT 'signed prekey 签名',
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP COMMENT '更新时间',
+    PRIMARY KEY (user_id, device_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='设备密钥包';
+
+CREATE TABLE e2e_one_time_prekeys (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY COMMENT '自增ID',
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    device_id VARCHAR(64) NOT NULL COMMENT '设备ID',
+    key_id INT NOT NULL COMMENT 'prekey 编号',
+    public_key TEXT NOT NULL COMMENT 'prekey 公钥',
+    UNIQUE KEY uk_device_key (user_id, device_id, key_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='设备 one-time prekey';
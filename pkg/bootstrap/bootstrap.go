@@ -40,6 +40,7 @@ func InitAll(configPath string) (cleanup func(), err error) {
 	monitor.InitMonitor()
 
 	cleanup = func() {
+		monitor.Stop()
 		mysql.Close()
 		rdb.Close()
 		// flush logger
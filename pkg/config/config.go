@@ -30,6 +30,14 @@ type AppConfig struct {
 	*PendingMsgFlusherConfig `mapstructure:"pending_msg_flusher"`
 	*CenterConfig            `mapstructure:"center"`
 	*RegistryConfig          `mapstructure:"registry"`
+	*NotifyConfig            `mapstructure:"notify"`
+	*ExportConfig            `mapstructure:"export"`
+	*RetentionConfig         `mapstructure:"retention"`
+	*UploadConfig            `mapstructure:"upload"`
+	*PresenceConfig          `mapstructure:"presence"`
+
+	*GatewayCompressionConfig  `mapstructure:"compression"`
+	*GatewayBackpressureConfig `mapstructure:"backpressure"`
 }
 
 type LogConfig struct {
@@ -86,6 +94,16 @@ type SendDispatcherConfig struct {
 	SendChannelSize    int `mapstructure:"send_channel_size"`
 	GatewayWorkerCount int `mapstructure:"gateway_worker_count"`
 	GatewayQueueSize   int `mapstructure:"gateway_queue_size"`
+	// LargeGroupThreshold 群成员数超过该值时改用读扩散（room timeline）投递，0 表示关闭
+	LargeGroupThreshold int   `mapstructure:"large_group_threshold"`
+	TimelineMaxLen      int64 `mapstructure:"timeline_max_len"`
+	// MaxBatchSendItems 批量发送接口单次允许的最大消息条数
+	MaxBatchSendItems int `mapstructure:"max_batch_send_items"`
+	// DeliveryStatusTTL 每条消息投递摘要的保留时间（秒）
+	DeliveryStatusTTL int `mapstructure:"delivery_status_ttl"`
+	// StreamEncoding 写入 gateway stream / room timeline 的编码：msgpack（默认）或 json。
+	// 滚动升级期间旧版 gateway 只能解 JSON，可暂时设为 json
+	StreamEncoding string `mapstructure:"stream_encoding"`
 }
 
 type GatewayDispatcherConfig struct {
@@ -98,6 +116,119 @@ type GatewayDispatcherConfig struct {
 	ConsumerName     string `mapstructure:"consumer_name"`
 	Interval         int    `mapstructure:"interval"`
 	ThresholdPending int64  `mapstructure:"threshold_pending"`
+	// TimelineRefreshInterval 重建本地大群成员索引的间隔（秒）
+	TimelineRefreshInterval int `mapstructure:"timeline_refresh_interval"`
+	// AckTimeout 消息写出后等待客户端 ack 帧的时间（秒），超时重发
+	AckTimeout int `mapstructure:"ack_timeout"`
+	// MaxRedeliveries 超时重发次数上限，超过后转入离线队列
+	MaxRedeliveries int `mapstructure:"max_redeliveries"`
+	// DrainWaveSize 停机排空时每一波关闭的连接数
+	DrainWaveSize int `mapstructure:"drain_wave_size"`
+	// DrainWaveInterval 两波之间的间隔（秒），避免所有客户端同时重连
+	DrainWaveInterval int `mapstructure:"drain_wave_interval"`
+	// DrainTimeout 排空的总时长上限（秒），超时后未清空的 stream 条目留待下次启动重放
+	DrainTimeout int `mapstructure:"drain_timeout"`
+	// ResumeTTL resume token 与会话位置记录的有效期（秒）
+	ResumeTTL int `mapstructure:"resume_ttl"`
+	// TicketTTL WebSocket 握手票据的有效期（秒）
+	TicketTTL int `mapstructure:"ticket_ttl"`
+	// DebugToken 连接诊断接口（/debug/*）的访问令牌，为空时诊断接口不可用
+	DebugToken string `mapstructure:"debug_token"`
+	// PollTimeout 长轮询请求最长等待时间（秒），PollSessionTTL 超过该时长没有轮询请求的会话被移除（秒）
+	PollTimeout    int `mapstructure:"poll_timeout"`
+	PollSessionTTL int `mapstructure:"poll_session_ttl"`
+}
+
+// GatewayCompressionConfig WebSocket permessage-deflate 配置，仅 gateway 使用。
+// 开启后只对编码后不小于 MinSize 的批量帧（离线 batch、sync 响应）压缩
+type GatewayCompressionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	Level   int  `mapstructure:"level"`    // flate 压缩级别 -2..9，0 表示默认（1，BestSpeed）
+	MinSize int  `mapstructure:"min_size"` // 字节，默认 1024
+}
+
+// GatewayBackpressureConfig 慢消费者处理策略，仅 gateway 使用。发送队列占用达到 QueueHighWatermark
+// 或写出延迟（滑动平均）超过 MaxWriteLatency 的连接视为慢连接
+type GatewayBackpressureConfig struct {
+	Policy             string  `mapstructure:"policy"`               // none / coalesce / drop / disconnect，默认 none
+	QueueHighWatermark float64 `mapstructure:"queue_high_watermark"` // 发送队列占用比例，默认 0.8
+	MaxWriteLatency    int     `mapstructure:"max_write_latency"`    // ms，0 表示不按延迟判断
+	SlowGrace          int     `mapstructure:"slow_grace"`           // 秒，disconnect 策略下持续慢多久后断开，默认 10
+}
+
+// NotifyConfig 离线推送（APNs/FCM 等）配置，仅 send 服务使用
+type NotifyConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// CollapseWindow 同一用户同一房间在该窗口（秒）内的多条消息合并为一条通知
+	CollapseWindow int `mapstructure:"collapse_window"`
+	WorkerCount    int `mapstructure:"worker_count"`
+	QueueSize      int `mapstructure:"queue_size"`
+	Timeout        int `mapstructure:"timeout"` // 单次推送请求超时（秒）
+	// LogFile log provider 的输出文件，为空时写入 zap 日志
+	LogFile string            `mapstructure:"log_file"`
+	APNs    *NotifyHTTPConfig `mapstructure:"apns"`
+	FCM     *NotifyHTTPConfig `mapstructure:"fcm"`
+}
+
+type NotifyHTTPConfig struct {
+	Endpoint  string `mapstructure:"endpoint"`
+	AuthToken string `mapstructure:"auth_token"`
+	Topic     string `mapstructure:"topic"`
+}
+
+// ExportConfig 聊天记录导出任务配置，仅 meta 服务使用
+type ExportConfig struct {
+	Dir          string `mapstructure:"dir"` // 导出文件存放目录
+	WorkerCount  int    `mapstructure:"worker_count"`
+	QueueSize    int    `mapstructure:"queue_size"`
+	JobTTL       int    `mapstructure:"job_ttl"`        // 任务状态与导出文件保留时间（秒）
+	MaxRangeDays int    `mapstructure:"max_range_days"` // 单次导出允许的最大时间跨度，0 表示不限
+	// AdvertiseURL 其他 meta 实例转发导出文件下载时使用的本实例地址，如 http://10.0.0.5:8082；
+	// Dir 为所有实例共享的存储时可留空
+	AdvertiseURL string `mapstructure:"advertise_url"`
+}
+
+// RetentionConfig 消息保留策略：全局默认值、群主可设置的范围，以及 flusher 清理任务参数。
+// 天数与条数为 0 表示不按该维度清理。
+type RetentionConfig struct {
+	Enabled         bool `mapstructure:"enabled"`
+	DefaultKeepDays int  `mapstructure:"default_keep_days"`
+	DefaultKeepLast int  `mapstructure:"default_keep_last"`
+	MinKeepDays     int  `mapstructure:"min_keep_days"`
+	MaxKeepDays     int  `mapstructure:"max_keep_days"`
+	MinKeepLast     int  `mapstructure:"min_keep_last"`
+	MaxKeepLast     int  `mapstructure:"max_keep_last"`
+	Interval        int  `mapstructure:"interval"`       // 两轮清理的间隔（秒）
+	BatchSize       int  `mapstructure:"batch_size"`     // 单次 DELETE 的最大行数
+	BatchPauseMs    int  `mapstructure:"batch_pause_ms"` // 两个批次之间的停顿，避免长时间占用 MySQL
+	// MetricsAddr flusher 没有 HTTP 服务，配置后单独暴露 /metrics
+	MetricsAddr string `mapstructure:"metrics_addr"`
+}
+
+// UploadConfig 附件上传配置，仅 send 服务使用
+type UploadConfig struct {
+	Dir         string `mapstructure:"dir"`           // 本地存储根目录
+	ChunkSize   int64  `mapstructure:"chunk_size"`    // 建议的分片大小（字节）
+	MaxFileSize int64  `mapstructure:"max_file_size"` // 单个文件上限（字节）
+	SessionTTL  int    `mapstructure:"session_ttl"`   // 未完成上传会话的保留时间（秒）
+	SignSecret  string `mapstructure:"sign_secret"`   // 下载链接签名密钥，为空时使用 jwt.secret
+	URLTTL      int    `mapstructure:"url_ttl"`       // 签名下载链接有效期（秒）
+	// Strict 开启后，图片/语音/文件消息的 URL 必须是本服务签发的附件地址
+	Strict bool `mapstructure:"strict"`
+	// PublicBaseURL 签发 URL 的前缀，例如 https://send.example.com，为空时签发相对路径
+	PublicBaseURL string `mapstructure:"public_base_url"`
+	// ThumbnailSizes 图片缩略图长边规格（像素），只生成小于原图的规格
+	ThumbnailSizes []int `mapstructure:"thumbnail_sizes"`
+	// MaxImagePixels 超过该像素数的图片不解码，按普通文件处理
+	MaxImagePixels int64 `mapstructure:"max_image_pixels"`
+}
+
+// PresenceConfig 在线状态配置，仅 send 服务使用
+type PresenceConfig struct {
+	// CoalesceWindow 状态变化后等待该时间（毫秒）再广播，窗口内的反复上下线合并为一次事件
+	CoalesceWindow int `mapstructure:"coalesce_window"`
+	// MaxSubscriptions 每个用户最多订阅的用户数
+	MaxSubscriptions int `mapstructure:"max_subscriptions"`
 }
 
 type CenterConfig struct {
@@ -110,6 +241,12 @@ type RegistryConfig struct {
 	SendHeartbeatTimeout    int    `mapstructure:"send_heartbeat_timeout"`
 	UserRouteTTL            int    `mapstructure:"user_route_ttl"`
 	CleanupInterval         int    `mapstructure:"cleanup_interval"`
+	// AdminToken 管理接口（/registry/admin/*）的访问令牌，为空时管理接口不可用
+	AdminToken string `mapstructure:"admin_token"`
+	// KickBlockDuration 踢下线后默认禁止重连的时长（秒）
+	KickBlockDuration int `mapstructure:"kick_block_duration"`
+	// InternalToken 服务间内部接口（send 的 /internal/*）的共享令牌，调用方与 send 必须配置相同的值
+	InternalToken string `mapstructure:"internal_token"`
 }
 type PendingMsgFlusherConfig struct {
 	Interval         int    `mapstructure:"interval"`
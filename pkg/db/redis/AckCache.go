@@ -2,81 +2,85 @@ package redis
 
 import (
 	"context"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-var (
-	msgCh       chan string
-	groupName   string
-	streamName  string
-	interval    time.Duration
-	cacheCancel context.CancelFunc
-	cacheCtx    context.Context
-)
+// AckCache buffers the IDs of settled stream entries and XACKs them in batches, either when
+// a batch is full or every interval. Each gateway stream has its own cache.
+type AckCache struct {
+	msgCh      chan string
+	groupName  string
+	streamName string
+	interval   time.Duration
+	cancel     context.CancelFunc
+	ctx        context.Context
+	done       chan struct{}
+}
 
-func cacheFlushWorker() {
+func (c *AckCache) flushWorker() {
 	const maxBatchSize = 1024
 	batch := make([]string, 0, maxBatchSize)
-	ticker := time.NewTicker(interval)
+	ticker := time.NewTicker(c.interval)
 	defer ticker.Stop()
+	defer close(c.done)
 
 	for {
 		select {
-		case <-cacheCtx.Done():
-			// flush remaining
+		case <-c.ctx.Done():
+			// flush remaining, including ids still waiting in the channel
+			for len(c.msgCh) > 0 {
+				batch = append(batch, <-c.msgCh)
+			}
 			if len(batch) > 0 {
-				XAckWithRetry(3, streamName, groupName, batch...)
+				XAckWithRetry(3, c.streamName, c.groupName, batch...)
 			}
-			zap.L().Info("AckCache flush worker exiting due to context cancellation")
+			zap.L().Info("AckCache flush worker exiting due to context cancellation", zap.String("stream", c.streamName))
 			return
-		case id := <-msgCh:
+		case id := <-c.msgCh:
 			batch = append(batch, id)
 			if len(batch) >= maxBatchSize {
-				XAckWithRetry(3, streamName, groupName, batch...)
+				XAckWithRetry(3, c.streamName, c.groupName, batch...)
 				batch = batch[:0]
 			}
 		case <-ticker.C:
 			if len(batch) > 0 {
-				XAckWithRetry(3, streamName, groupName, batch...)
+				XAckWithRetry(3, c.streamName, c.groupName, batch...)
 				batch = batch[:0]
 			}
 		}
 	}
 }
 
-func waitforAckShutdown() {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	zap.L().Info("AckCache shutdown signal received, canceling cache context")
-	if cacheCancel != nil {
-		cacheCancel()
-	}
-	zap.L().Info("AckCache flush worker has been signaled to exit")
+// Stop XACKs the buffered entry IDs and stops the flush worker. The gateway calls it
+// after draining, once no more entries are settled.
+func (c *AckCache) Stop() {
+	zap.L().Info("AckCache stopping, flushing buffered acks", zap.String("stream", c.streamName))
+	c.cancel()
+	<-c.done
 }
 
-
-func InsertAckCache(messageIDs ...string) {
+func (c *AckCache) Insert(messageIDs ...string) {
 	for _, id := range messageIDs {
 		select {
-		case msgCh <- id:
+		case c.msgCh <- id:
 		default:
 			zap.L().Warn("AckCache buffer full, dropping message id", zap.String("id", id))
 		}
 	}
 }
 
-func InitAckCache(StreamName string, GroupName string, Interval time.Duration) {
-	cacheCtx, cacheCancel = context.WithCancel(context.Background())
-	streamName = StreamName
-	groupName = GroupName
-	interval = Interval
-	msgCh = make(chan string, 10000)
-	go cacheFlushWorker()
-	go waitforAckShutdown()
+// NewAckCache starts the flush worker of a stream's consumer group.
+func NewAckCache(StreamName string, GroupName string, Interval time.Duration) *AckCache {
+	c := &AckCache{
+		streamName: StreamName,
+		groupName:  GroupName,
+		interval:   Interval,
+		msgCh:      make(chan string, 10000),
+		done:       make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.flushWorker()
+	return c
 }
@@ -5,6 +5,8 @@ import (
 	"GoStacker/pkg/monitor"
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -71,6 +73,24 @@ func SAddWithRetry(retry int, key string, members ...interface{}) error {
 	return err
 }
 
+// SAddEXWithRetry adds members to a set and refreshes the key TTL in one transaction
+func SAddEXWithRetry(retry int, key string, expiration time.Duration, members ...interface{}) error {
+	var err error
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		_, err = Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, key, members...)
+			pipe.Expire(ctx, key, expiration)
+			return nil
+		})
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
 // query everything in set
 func SMembersWithRetry(retry int, key string) ([]string, error) {
 	var err error
@@ -183,6 +203,120 @@ func XAckWithRetry(retry int, stream string, group string, ids ...string) error
 	return err
 }
 
+// XPendingMessagesWithRetry returns up to count entries of the group's pending list (delivered but
+// not XACKed) starting at start, together with their fields.
+func XPendingMessagesWithRetry(retry int, stream string, group string, start string, count int64) ([]redis.XMessage, error) {
+	var err error
+	for i := 0; i < retry; i++ {
+		var msgs []redis.XMessage
+		msgs, err = xPendingMessages(context.Background(), stream, group, start, count)
+		if err == nil {
+			return msgs, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, err
+}
+
+func xPendingMessages(ctx context.Context, stream string, group string, start string, count int64) ([]redis.XMessage, error) {
+	pending, err := Rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  start,
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	pipe := Rdb.Pipeline()
+	cmds := make([]*redis.XMessageSliceCmd, 0, len(pending))
+	for _, p := range pending {
+		cmds = append(cmds, pipe.XRangeN(ctx, stream, p.ID, p.ID, 1))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	msgs := make([]redis.XMessage, 0, len(cmds))
+	for _, cmd := range cmds {
+		// entries trimmed from the stream are still listed as pending, skip them
+		msgs = append(msgs, cmd.Val()...)
+	}
+	return msgs, nil
+}
+
+// XGroupBacklogWithRetry reports how many entries of a consumer group are delivered but not
+// yet XACKed, and whether the stream still holds entries the group has not read.
+func XGroupBacklogWithRetry(retry int, stream string, group string) (int64, bool, error) {
+	var err error
+	for i := 0; i < retry; i++ {
+		var (
+			pending int64
+			unread  bool
+		)
+		pending, unread, err = xGroupBacklog(context.Background(), stream, group)
+		if err == nil {
+			return pending, unread, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return 0, false, err
+}
+
+func xGroupBacklog(ctx context.Context, stream string, group string) (int64, bool, error) {
+	groups, err := Rdb.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	for _, g := range groups {
+		if g.Name != group {
+			continue
+		}
+		last, err := Rdb.XRevRangeN(ctx, stream, "+", "-", 1).Result()
+		if err != nil {
+			return 0, false, err
+		}
+		unread := len(last) > 0 && CompareStreamID(last[0].ID, g.LastDeliveredID) > 0
+		return g.Pending, unread, nil
+	}
+	return 0, false, fmt.Errorf("consumer group %s not found on stream %s", group, stream)
+}
+
+// CompareStreamID compares two stream entry IDs of the form <ms>-<seq>
+func CompareStreamID(a, b string) int {
+	am, as := splitStreamID(a)
+	bm, bs := splitStreamID(b)
+	switch {
+	case am != bm:
+		if am < bm {
+			return -1
+		}
+		return 1
+	case as != bs:
+		if as < bs {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// StreamIDTime returns the time encoded in a stream entry ID, set by redis when the entry was added
+func StreamIDTime(id string) time.Time {
+	ms, _ := splitStreamID(id)
+	return time.UnixMilli(int64(ms))
+}
+
+func splitStreamID(id string) (uint64, uint64) {
+	ms, seq, _ := strings.Cut(id, "-")
+	m, _ := strconv.ParseUint(ms, 10, 64)
+	s, _ := strconv.ParseUint(seq, 10, 64)
+	return m, s
+}
+
 // SetEXWithRetry sets a key with an expiration time
 func SetEXWithRetry(retry int, key string, value interface{}, expiration time.Duration) error {
 	var err error
@@ -197,6 +331,26 @@ func SetEXWithRetry(retry int, key string, value interface{}, expiration time.Du
 	return err
 }
 
+// IncrEXWithRetry increments a counter and returns the new value. The expiration is set when
+// the counter is created and is not extended by later increments, so the key works as a fixed window.
+func IncrEXWithRetry(retry int, key string, expiration time.Duration) (int64, error) {
+	var err error
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		var incr *redis.IntCmd
+		_, err = Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetNX(ctx, key, 0, expiration)
+			incr = pipe.Incr(ctx, key)
+			return nil
+		})
+		if err == nil {
+			return incr.Val(), nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return 0, err
+}
+
 // GetWithRetry gets a value by key
 func GetWithRetry(retry int, key string) (string, error) {
 	var err error
@@ -215,6 +369,82 @@ func GetWithRetry(retry int, key string) (string, error) {
 	return result, err
 }
 
+// GetDelWithRetry gets a value and deletes the key atomically, so only one caller can consume it
+func GetDelWithRetry(retry int, key string) (string, error) {
+	var err error
+	var result string
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		result, err = Rdb.GetDel(ctx, key).Result()
+		if err == nil {
+			return result, nil
+		}
+		if err == redis.Nil {
+			return "", redis.Nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return result, err
+}
+
+// PublishWithRetry publishes a message to a pub/sub channel and returns the number of subscribers that received it
+func PublishWithRetry(retry int, channel string, message interface{}) (int64, error) {
+	var (
+		err       error
+		receivers int64
+	)
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		receivers, err = Rdb.Publish(ctx, channel, message).Result()
+		if err == nil {
+			return receivers, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return 0, err
+}
+
+// ListenChannel delivers pub/sub messages of channel to handle until ctx is done,
+// subscribing again after a short pause if the subscription breaks
+func ListenChannel(ctx context.Context, channel string, handle func(payload string)) {
+	for ctx.Err() == nil {
+		sub := Rdb.Subscribe(ctx, channel)
+		ch := sub.Channel()
+	recv:
+		for {
+			select {
+			case <-ctx.Done():
+				break recv
+			case msg, ok := <-ch:
+				if !ok {
+					break recv
+				}
+				handle(msg.Payload)
+			}
+		}
+		sub.Close()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// TTLWithRetry returns the remaining time to live of a key
+func TTLWithRetry(retry int, key string) (time.Duration, error) {
+	var err error
+	var ttl time.Duration
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		ttl, err = Rdb.TTL(ctx, key).Result()
+		if err == nil {
+			return ttl, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return 0, err
+}
+
 // MGetWithRetry gets multiple values by keys
 func MGetWithRetry(retry int, keys []string) ([]string, error) {
 	var err error
@@ -352,6 +582,21 @@ func SCardWithRetry(retry int, key string) (int64, error) {
 	return result, err
 }
 
+// LLenWithRetry gets list length
+func LLenWithRetry(retry int, key string) (int64, error) {
+	var err error
+	var result int64
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		result, err = Rdb.LLen(ctx, key).Result()
+		if err == nil {
+			return result, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return result, err
+}
+
 // SRandMemberWithRetry gets a random member from set
 func SRandMemberWithRetry(retry int, key string) (string, error) {
 	var err error
@@ -370,6 +615,75 @@ func SRandMemberWithRetry(retry int, key string) (string, error) {
 	return result, err
 }
 
+// HSetWithRetry sets a hash field and refreshes the key expiration
+func HSetWithRetry(retry int, key string, field string, value interface{}, expiration time.Duration) error {
+	var err error
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		pipe := Rdb.TxPipeline()
+		pipe.HSet(ctx, key, field, value)
+		pipe.Expire(ctx, key, expiration)
+		_, err = pipe.Exec(ctx)
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// HDelWithRetry deletes hash fields
+func HDelWithRetry(retry int, key string, fields ...string) error {
+	var err error
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		err = Rdb.HDel(ctx, key, fields...).Err()
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// HGetAllWithRetry gets all fields of a hash, a missing key yields an empty map
+func HGetAllWithRetry(retry int, key string) (map[string]string, error) {
+	var err error
+	var result map[string]string
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		result, err = Rdb.HGetAll(ctx, key).Result()
+		if err == nil {
+			return result, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return result, err
+}
+
+// MHGetAllWithRetry gets all fields of multiple hashes in one round trip, results follow keys order
+func MHGetAllWithRetry(retry int, keys []string) ([]map[string]string, error) {
+	var err error
+	for i := 0; i < retry; i++ {
+		ctx := context.Background()
+		pipe := Rdb.Pipeline()
+		cmds := make([]*redis.MapStringStringCmd, len(keys))
+		for j, key := range keys {
+			cmds[j] = pipe.HGetAll(ctx, key)
+		}
+		_, err = pipe.Exec(ctx)
+		if err == nil {
+			result := make([]map[string]string, len(keys))
+			for j, cmd := range cmds {
+				result[j] = cmd.Val()
+			}
+			return result, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, err
+}
+
 // Ping checks Redis connection
 func Ping() (string, error) {
 	ctx := context.Background()
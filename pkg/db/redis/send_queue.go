@@ -0,0 +1,296 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// SendQueueHSetWithRetry sets hash fields on the send queue role.
+// values is passed to HSET as-is, e.g. "field1", v1, "field2", v2 or a map.
+func SendQueueHSetWithRetry(retry int, key string, values ...interface{}) error {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	var err error
+	for i := 0; i < retry; i++ {
+		err = client.HSet(context.Background(), key, values...).Err()
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// SendQueueHGetAllWithRetry returns all fields of a hash on the send queue role.
+// A missing key yields an empty map and no error.
+func SendQueueHGetAllWithRetry(retry int, key string) (map[string]string, error) {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+	var (
+		err    error
+		result map[string]string
+	)
+	for i := 0; i < retry; i++ {
+		result, err = client.HGetAll(context.Background(), key).Result()
+		if err == nil {
+			return result, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, err
+}
+
+// SendQueueHDelWithRetry removes hash fields on the send queue role.
+func SendQueueHDelWithRetry(retry int, key string, fields ...string) error {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	var err error
+	for i := 0; i < retry; i++ {
+		err = client.HDel(context.Background(), key, fields...).Err()
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// SendQueueLPushCappedWithRetry prepends value to a list on the send queue role and
+// keeps only the newest maxLen entries. ttl <= 0 leaves the key without expiration.
+func SendQueueLPushCappedWithRetry(retry int, key string, value interface{}, maxLen int64, ttl time.Duration) error {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	var err error
+	for i := 0; i < retry; i++ {
+		_, err = client.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+			pipe.LPush(context.Background(), key, value)
+			pipe.LTrim(context.Background(), key, 0, maxLen-1)
+			if ttl > 0 {
+				pipe.Expire(context.Background(), key, ttl)
+			}
+			return nil
+		})
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// SendQueueLRangeWithRetry returns list entries in [start, stop] on the send queue role.
+func SendQueueLRangeWithRetry(retry int, key string, start, stop int64) ([]string, error) {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+	var (
+		err    error
+		result []string
+	)
+	for i := 0; i < retry; i++ {
+		result, err = client.LRange(context.Background(), key, start, stop).Result()
+		if err == nil {
+			return result, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, err
+}
+
+// SendQueueHSetEXWithRetry sets hash fields on the send queue role and refreshes the key TTL.
+func SendQueueHSetEXWithRetry(retry int, key string, ttl time.Duration, values ...interface{}) error {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	var err error
+	for i := 0; i < retry; i++ {
+		_, err = client.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+			pipe.HSet(context.Background(), key, values...)
+			if ttl > 0 {
+				pipe.Expire(context.Background(), key, ttl)
+			}
+			return nil
+		})
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// SendQueueHIncrByEX increments the given hash counters on the send queue role, stamps
+// updatedField with the current unix time (ms) and refreshes the key TTL in the same transaction,
+// so a counter written after the hash expired does not leave a key without expiration.
+// It is not retried: after an ambiguous error the increments may already have been applied.
+func SendQueueHIncrByEX(key string, ttl time.Duration, incrs map[string]int64, updatedField string) error {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	_, err := client.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		for field, n := range incrs {
+			pipe.HIncrBy(context.Background(), key, field, n)
+		}
+		if updatedField != "" {
+			pipe.HSet(context.Background(), key, updatedField, time.Now().UnixMilli())
+		}
+		if ttl > 0 {
+			pipe.Expire(context.Background(), key, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// SendQueueSAddEXWithRetry adds members to a set on the send queue role and refreshes the key TTL.
+func SendQueueSAddEXWithRetry(retry int, key string, ttl time.Duration, members ...interface{}) error {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	var err error
+	for i := 0; i < retry; i++ {
+		_, err = client.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+			pipe.SAdd(context.Background(), key, members...)
+			if ttl > 0 {
+				pipe.Expire(context.Background(), key, ttl)
+			}
+			return nil
+		})
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// SendQueueSIsMemberWithRetry reports whether member is in a set on the send queue role.
+func SendQueueSIsMemberWithRetry(retry int, key string, member interface{}) (bool, error) {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return false, fmt.Errorf("redis client not initialized")
+	}
+	var (
+		err error
+		ok  bool
+	)
+	for i := 0; i < retry; i++ {
+		ok, err = client.SIsMember(context.Background(), key, member).Result()
+		if err == nil {
+			return ok, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false, err
+}
+
+// SendQueueSMembersWithRetry returns all members of a set on the send queue role.
+func SendQueueSMembersWithRetry(retry int, key string) ([]string, error) {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+	var (
+		err    error
+		result []string
+	)
+	for i := 0; i < retry; i++ {
+		result, err = client.SMembers(context.Background(), key).Result()
+		if err == nil {
+			return result, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, err
+}
+
+// SendQueueDelWithRetry deletes keys on the send queue role.
+func SendQueueDelWithRetry(retry int, keys ...string) error {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	var err error
+	for i := 0; i < retry; i++ {
+		err = client.Del(context.Background(), keys...).Err()
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// SendQueueHGetWithRetry returns one hash field on the send queue role.
+// A missing key or field yields goredis.Nil.
+func SendQueueHGetWithRetry(retry int, key string, field string) (string, error) {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return "", fmt.Errorf("redis client not initialized")
+	}
+	var (
+		err    error
+		result string
+	)
+	for i := 0; i < retry; i++ {
+		result, err = client.HGet(context.Background(), key, field).Result()
+		if err == nil || err == goredis.Nil {
+			return result, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "", err
+}
+
+// SendQueueSRemWithRetry removes members from a set on the send queue role.
+func SendQueueSRemWithRetry(retry int, key string, members ...interface{}) error {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	var err error
+	for i := 0; i < retry; i++ {
+		err = client.SRem(context.Background(), key, members...).Err()
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// SendQueueSetNXWithRetry sets key only if it does not exist on the send queue role.
+// It reports whether the key was set.
+func SendQueueSetNXWithRetry(retry int, key string, value interface{}, ttl time.Duration) (bool, error) {
+	client := getSendRoleClient(sendRedisRoleQueue)
+	if client == nil {
+		return false, fmt.Errorf("redis client not initialized")
+	}
+	var (
+		err error
+		ok  bool
+	)
+	for i := 0; i < retry; i++ {
+		ok, err = client.SetNX(context.Background(), key, value, ttl).Result()
+		if err == nil {
+			return ok, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false, err
+}
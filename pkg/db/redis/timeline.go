@@ -0,0 +1,199 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	// LargeRoomsKey 记录走读扩散（room timeline）投递的大群 ID 集合
+	LargeRoomsKey      = "timeline:rooms:large"
+	roomTimelineFmt    = "timeline:room:%d"
+	roomPresenceFmt    = "timeline:online:%d"
+	timelineOfflineFmt = "timeline:offline:%d"
+
+	// RoomPresenceTTL 网关超过这段时间没有刷新的大群在线成员视为已离线
+	RoomPresenceTTL = 90 * time.Second
+	// TimelineOfflineTTL 记录 send 已写入离线队列的大群消息目标的保留时长
+	TimelineOfflineTTL = 10 * time.Minute
+)
+
+// RoomTimelineKey returns the stream key holding the timeline of a large room.
+func RoomTimelineKey(roomID int64) string {
+	return fmt.Sprintf(roomTimelineFmt, roomID)
+}
+
+// RoomPresenceKey returns the sorted set of members of a large room that gateways have
+// indexed, scored by the time the gateway last refreshed them.
+func RoomPresenceKey(roomID int64) string {
+	return fmt.Sprintf(roomPresenceFmt, roomID)
+}
+
+// TimelineOfflineKey returns the set of targets of a large-room message that the send
+// service wrote to the offline queue instead of the room timeline.
+func TimelineOfflineKey(msgID int64) string {
+	return fmt.Sprintf(timelineOfflineFmt, msgID)
+}
+
+// RoomPresenceMember identifies a user indexed by one gateway; a user connected to several
+// gateways has one member per gateway.
+func RoomPresenceMember(userID int64, gatewayID string) string {
+	return strconv.FormatInt(userID, 10) + "@" + gatewayID
+}
+
+// ParseRoomPresenceMember splits a member written by RoomPresenceMember.
+func ParseRoomPresenceMember(member string) (int64, string, bool) {
+	uid, gatewayID, ok := strings.Cut(member, "@")
+	if !ok {
+		return 0, "", false
+	}
+	userID, err := strconv.ParseInt(uid, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return userID, gatewayID, true
+}
+
+// RoomPresenceAddWithRetry marks members of a large room as present now, drops members no
+// gateway refreshed within RoomPresenceTTL and refreshes the key TTL.
+func RoomPresenceAddWithRetry(retry int, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	now := time.Now()
+	zs := make([]goredis.Z, 0, len(members))
+	for _, m := range members {
+		zs = append(zs, goredis.Z{Score: float64(now.Unix()), Member: m})
+	}
+	stale := strconv.FormatInt(now.Add(-RoomPresenceTTL).Unix(), 10)
+	var err error
+	for i := 0; i < retry; i++ {
+		_, err = Rdb.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+			pipe.ZAdd(context.Background(), key, zs...)
+			pipe.ZRemRangeByScore(context.Background(), key, "-inf", "("+stale)
+			pipe.Expire(context.Background(), key, RoomPresenceTTL)
+			return nil
+		})
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// RoomPresenceMembersWithRetry returns the members of a large room refreshed within RoomPresenceTTL.
+func RoomPresenceMembersWithRetry(retry int, key string) ([]string, error) {
+	return roomPresenceMembers(Rdb, retry, key)
+}
+
+// SendStreamRoomPresenceMembersWithRetry is RoomPresenceMembersWithRetry on the send stream
+// role, which gateways share with the send service.
+func SendStreamRoomPresenceMembersWithRetry(retry int, key string) ([]string, error) {
+	client := getSendRoleClient(sendRedisRoleStream)
+	if client == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+	return roomPresenceMembers(client, retry, key)
+}
+
+func roomPresenceMembers(client *goredis.Client, retry int, key string) ([]string, error) {
+	opt := &goredis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Add(-RoomPresenceTTL).Unix(), 10),
+		Max: "+inf",
+	}
+	var (
+		err    error
+		result []string
+	)
+	for i := 0; i < retry; i++ {
+		result, err = client.ZRangeByScore(context.Background(), key, opt).Result()
+		if err == nil {
+			return result, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, err
+}
+
+// SendStreamXAddCappedWithRetry appends an entry to a stream on the send stream role
+// and trims it approximately to maxLen entries. It returns the generated entry ID.
+func SendStreamXAddCappedWithRetry(retry int, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	client := getSendRoleClient(sendRedisRoleStream)
+	if client == nil {
+		return "", fmt.Errorf("redis client not initialized")
+	}
+	var (
+		err error
+		id  string
+	)
+	for i := 0; i < retry; i++ {
+		id, err = client.XAdd(context.Background(), &goredis.XAddArgs{
+			Stream: stream,
+			MaxLen: maxLen,
+			Approx: true,
+			Values: values,
+		}).Result()
+		if err == nil {
+			return id, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "", err
+}
+
+// SendStreamSAddWithRetry adds members to a set on the send stream role, so that
+// gateways consuming the same redis can read it.
+func SendStreamSAddWithRetry(retry int, key string, members ...interface{}) error {
+	client := getSendRoleClient(sendRedisRoleStream)
+	if client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	var err error
+	for i := 0; i < retry; i++ {
+		err = client.SAdd(context.Background(), key, members...).Err()
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// XReadBlockingWithContext reads from several streams at once without a consumer group.
+// ids must have the same length as streams and holds the last seen entry ID of each stream.
+func XReadBlockingWithContext(ctx context.Context, streams []string, ids []string, count int64, block time.Duration) ([]goredis.XStream, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	args := make([]string, 0, len(streams)*2)
+	args = append(args, streams...)
+	args = append(args, ids...)
+	return Rdb.XRead(ctx, &goredis.XReadArgs{
+		Streams: args,
+		Count:   count,
+		Block:   block,
+	}).Result()
+}
+
+// XLastIDWithRetry returns the ID of the newest entry in a stream, or "0-0" if the stream is empty.
+func XLastIDWithRetry(retry int, stream string) (string, error) {
+	var err error
+	for i := 0; i < retry; i++ {
+		var msgs []goredis.XMessage
+		msgs, err = Rdb.XRevRangeN(context.Background(), stream, "+", "-", 1).Result()
+		if err == nil {
+			if len(msgs) == 0 {
+				return "0-0", nil
+			}
+			return msgs[0].ID, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "", err
+}
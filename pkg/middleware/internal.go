@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"crypto/subtle"
+
+	"GoStacker/pkg/config"
+	"GoStacker/pkg/response"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// InternalTokenHeader 服务间调用携带共享令牌的请求头
+const InternalTokenHeader = "X-Internal-Token"
+
+// InternalToken 返回 registry.internal_token，未配置时为空
+func InternalToken() string {
+	if config.Conf == nil || config.Conf.RegistryConfig == nil {
+		return ""
+	}
+	return config.Conf.RegistryConfig.InternalToken
+}
+
+// InternalAuthMiddleware 要求请求头 X-Internal-Token 与 registry.internal_token 一致。
+// 这些接口直接信任请求体中的 user_id，只允许集群内的服务调用；未配置令牌时一律拒绝
+func InternalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := InternalToken()
+		provided := c.GetHeader(InternalTokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			zap.L().Warn("Rejected internal request", zap.String("path", c.FullPath()), zap.String("ip", c.ClientIP()))
+			response.ReplyUnauthorized(c, "Internal token required")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
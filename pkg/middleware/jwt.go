@@ -1,12 +1,15 @@
 package middleware
 
 import (
+	"errors"
 	"strings"
 
 	"GoStacker/pkg/response"
+	"GoStacker/pkg/revocation"
 	"GoStacker/pkg/utils"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 func JWTAuthMiddleware() gin.HandlerFunc {
@@ -29,8 +32,18 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if err := revocation.Check(claims); err != nil {
+			if errors.Is(err, revocation.ErrRevoked) {
+				response.ReplyUnauthorized(c, "Token has been revoked")
+				c.Abort()
+				return
+			}
+			// 吊销列表暂时不可用时放行，避免 Redis 故障导致全部请求失败
+			zap.L().Warn("revocation check failed", zap.Int64("user_id", claims.UserID), zap.Error(err))
+		}
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.UserName)
+		c.Set("claims", claims)
 		c.Next()
 	}
 }
@@ -29,6 +29,11 @@ var (
 		Name: "app_monitor_count",
 		Help: "Number of samples in sliding window for monitor",
 	}, []string{"monitor"})
+
+	eventCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_monitor_events_total",
+		Help: "Cumulative event counts reported by background jobs",
+	}, []string{"monitor", "event"})
 )
 
 func init() {
@@ -36,6 +41,12 @@ func init() {
 	prometheus.MustRegister(avgTimeGauge)
 	prometheus.MustRegister(successRateGauge)
 	prometheus.MustRegister(countGauge)
+	prometheus.MustRegister(eventCounter)
+}
+
+// AddCount adds n to the cumulative counter of an event, e.g. rows purged by a job.
+func AddCount(monitorName string, event string, n float64) {
+	eventCounter.WithLabelValues(monitorName, event).Add(n)
 }
 
 // registerMonitor registers a monitor for metric collection.
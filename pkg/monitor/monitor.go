@@ -2,10 +2,7 @@ package monitor
 
 import (
 	"context"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -13,6 +10,7 @@ import (
 
 var monitorCtx context.Context
 var monitorCancel context.CancelFunc
+var monitorOnce sync.Once
 
 type Task struct {
 	sTime   int64
@@ -77,17 +75,25 @@ func (m *Monitor) GetStats() (avgTime float64, successRate float64, count int) {
 	count = m.count
 	return
 }
+
+// Run 运行到 Stop 被调用
 func (m *Monitor) Run() {
-	go func() {
-		// ensure package monitor context is not nil to avoid nil deref
+	// ensure package monitor context is not nil to avoid nil deref
+	monitorOnce.Do(func() {
 		if monitorCtx == nil {
 			monitorCtx, monitorCancel = context.WithCancel(context.Background())
 			zap.L().Warn("monitor: package context was not initialized; created default background context")
 		}
+	})
+	m.RunContext(monitorCtx)
+}
 
+// RunContext 运行到 ctx 结束，供生命周期短于进程的组件（如嵌入的网关实例）使用
+func (m *Monitor) RunContext(ctx context.Context) {
+	go func() {
 		for {
 			select {
-			case <-monitorCtx.Done():
+			case <-ctx.Done():
 				zap.L().Info("Monitor " + m.name + " received shutdown signal, exiting")
 				return
 			case t := <-m.insertChan:
@@ -140,19 +146,16 @@ func (m *Monitor) Run() {
 	}()
 }
 
-func waitForShutdown() {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	zap.L().Info("Monitor shutdown signal received, canceling monitor context")
+// Stop 停止所有以 Run 启动的 monitor。信号由各服务的 main 处理，停机时调用
+func Stop() {
 	if monitorCancel != nil {
 		monitorCancel()
 	}
-	// other goroutines should observe monitorCtx.Done() and exit.
 	zap.L().Info("Monitor shutdown initiated; background listeners will exit")
 }
 
 func InitMonitor() {
-	monitorCtx, monitorCancel = context.WithCancel(context.Background())
-	go waitForShutdown()
+	monitorOnce.Do(func() {
+		monitorCtx, monitorCancel = context.WithCancel(context.Background())
+	})
 }
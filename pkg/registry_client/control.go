@@ -0,0 +1,33 @@
+package registry_client
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ControlKick 强制断开用户连接
+const ControlKick = "kick"
+
+// ControlCommand 是 registry 通过 pub/sub 下发给某个网关的控制命令
+type ControlCommand struct {
+	Command  string `json:"cmd"`
+	UserID   int64  `json:"user_id"`
+	DeviceID string `json:"device_id,omitempty"` // 为空表示该用户的所有设备
+	Reason   string `json:"reason,omitempty"`
+	// BlockUntil 秒级时间戳，在此之前拒绝该用户（或设备）重连
+	BlockUntil int64 `json:"block_until,omitempty"`
+}
+
+// ControlChannel 网关订阅的控制命令频道
+func ControlChannel(gatewayID string) string {
+	return fmt.Sprintf("gateway:control:%s", gatewayID)
+}
+
+// KickBlockKey 被踢用户禁止重连的标记，deviceID 为空时作用于该用户的所有设备
+func KickBlockKey(userID int64, deviceID string) string {
+	key := "kick:block:" + strconv.FormatInt(userID, 10)
+	if deviceID != "" {
+		key += ":" + deviceID
+	}
+	return key
+}
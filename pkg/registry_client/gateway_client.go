@@ -3,6 +3,7 @@ package registry_client
 import (
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -12,6 +13,8 @@ import (
 type GatewayClient struct {
 	*Client
 	gatewayID string
+	// draining is reported with every heartbeat once MarkDraining was called
+	draining atomic.Bool
 }
 
 // GatewayInstanceInfo represents gateway instance information from registry.
@@ -22,6 +25,7 @@ type GatewayInstanceInfo struct {
 	Load           float32 `json:"load"`
 	Capacity       int     `json:"capacity"`
 	ConnectedUsers int     `json:"connected_users"`
+	Draining       bool    `json:"draining"`
 }
 
 // NewGatewayClient creates a new gateway client
@@ -32,6 +36,11 @@ func NewGatewayClient(registryURL, gatewayID string) *GatewayClient {
 	}
 }
 
+// GatewayID returns the ID this client registers under
+func (gc *GatewayClient) GatewayID() string {
+	return gc.gatewayID
+}
+
 // RegisterGatewayRequest represents gateway registration request
 type RegisterGatewayRequest struct {
 	GatewayID string `json:"gateway_id"`
@@ -80,6 +89,7 @@ func (gc *GatewayClient) Heartbeat(load float32, connections int, cpu float32, m
 		"connections": connections,
 		"cpu":         cpu,
 		"memory":      memory,
+		"draining":    gc.draining.Load(),
 	}
 
 	resp, err := gc.doRequest("POST", "/registry/gateway/heartbeat", req, 2)
@@ -101,6 +111,31 @@ func (gc *GatewayClient) Heartbeat(load float32, connections int, cpu float32, m
 	return nil
 }
 
+// MarkDraining tells the registry that the gateway is shutting down, so it is no longer
+// handed out to new clients while its existing connections are moved away
+func (gc *GatewayClient) MarkDraining() error {
+	// later heartbeats carry the flag even if this request is lost
+	gc.draining.Store(true)
+	req := map[string]interface{}{
+		"gateway_id": gc.gatewayID,
+	}
+
+	resp, err := gc.doRequest("POST", "/registry/gateway/drain", req, 2)
+	if err != nil {
+		zap.L().Error("Failed to mark gateway as draining",
+			zap.String("gateway_id", gc.gatewayID),
+			zap.Error(err))
+		return err
+	}
+
+	if resp.Code != 0 {
+		return fmt.Errorf("drain failed: %s", resp.Message)
+	}
+
+	zap.L().Info("Gateway marked as draining", zap.String("gateway_id", gc.gatewayID))
+	return nil
+}
+
 // Unregister unregisters the gateway from registry service
 func (gc *GatewayClient) Unregister() error {
 	path := fmt.Sprintf("/registry/gateway/%s", gc.gatewayID)
@@ -152,13 +187,15 @@ func (gc *GatewayClient) ListGateways() ([]GatewayInstanceInfo, error) {
 // NotifyUserConnectRequest represents user connection notification
 type NotifyUserConnectRequest struct {
 	UserID    int64  `json:"user_id"`
+	DeviceID  string `json:"device_id,omitempty"`
 	GatewayID string `json:"gateway_id"`
 }
 
-// ReportUserConnect notifies registry that a user has connected
-func (gc *GatewayClient) ReportUserConnect(userID int64) error {
+// ReportUserConnect notifies registry that a device of the user has connected
+func (gc *GatewayClient) ReportUserConnect(userID int64, deviceID string) error {
 	req := NotifyUserConnectRequest{
 		UserID:    userID,
+		DeviceID:  deviceID,
 		GatewayID: gc.gatewayID,
 	}
 
@@ -177,14 +214,16 @@ func (gc *GatewayClient) ReportUserConnect(userID int64) error {
 
 	zap.L().Debug("User connect notified",
 		zap.Int64("user_id", userID),
+		zap.String("device_id", deviceID),
 		zap.String("gateway_id", gc.gatewayID))
 	return nil
 }
 
-// ReportUserDisconnect notifies registry that a user has disconnected
-func (gc *GatewayClient) ReportUserDisconnect(userID int64) error {
+// ReportUserDisconnect notifies registry that a device of the user has disconnected
+func (gc *GatewayClient) ReportUserDisconnect(userID int64, deviceID string) error {
 	req := NotifyUserDisconnectRequest{
 		UserID:    userID,
+		DeviceID:  deviceID,
 		GatewayID: gc.gatewayID,
 	}
 
@@ -203,22 +242,24 @@ func (gc *GatewayClient) ReportUserDisconnect(userID int64) error {
 
 	zap.L().Debug("User disconnect notified",
 		zap.Int64("user_id", userID),
+		zap.String("device_id", deviceID),
 		zap.String("gateway_id", gc.gatewayID))
 	return nil
 }
 
-// Legacy method aliases for backward compatibility
+// Legacy method aliases for backward compatibility, report the registry's default device
 func (gc *GatewayClient) NotifyUserConnect(userID int64) error {
-	return gc.ReportUserConnect(userID)
+	return gc.ReportUserConnect(userID, "")
 }
 
 func (gc *GatewayClient) NotifyUserDisconnect(userID int64) error {
-	return gc.ReportUserDisconnect(userID)
+	return gc.ReportUserDisconnect(userID, "")
 }
 
 // NotifyUserDisconnectRequest represents user disconnection notification
 type NotifyUserDisconnectRequest struct {
 	UserID    int64  `json:"user_id"`
+	DeviceID  string `json:"device_id,omitempty"`
 	GatewayID string `json:"gateway_id"`
 }
 
@@ -99,8 +99,9 @@ func (sc *SendClient) Unregister() error {
 	return nil
 }
 
-// RouteInfo represents user route information
+// RouteInfo represents the route of one connected device of a user
 type RouteInfo struct {
+	DeviceID  string `json:"device_id"`
 	GatewayID string `json:"gateway_id"`
 	Address   string `json:"address"`
 }
@@ -110,8 +111,8 @@ type BatchQueryRoutesRequest struct {
 	UserIDs []int64 `json:"user_ids"`
 }
 
-// QueryUserRoutes queries routes for multiple users at once
-func (sc *SendClient) QueryUserRoutes(userIDs []int64) (map[int64]*RouteInfo, error) {
+// QueryUserRoutes queries routes for multiple users at once, one entry per connected device
+func (sc *SendClient) QueryUserRoutes(userIDs []int64) (map[int64][]*RouteInfo, error) {
 	req := BatchQueryRoutesRequest{
 		UserIDs: userIDs,
 	}
@@ -127,7 +128,7 @@ func (sc *SendClient) QueryUserRoutes(userIDs []int64) (map[int64]*RouteInfo, er
 	}
 
 	// Parse data
-	result := make(map[int64]*RouteInfo)
+	result := make(map[int64][]*RouteInfo)
 	if resp.Data != nil {
 		// Convert data to map
 		dataBytes, err := json.Marshal(resp.Data)
@@ -35,6 +35,11 @@ func ReplyUnauthorized(c *gin.Context, msg string) {
 func ReplyNotFound(c *gin.Context, msg string) {
 	c.JSON(http.StatusNotFound, StandardResponse{Code: 404, Msg: msg})
 }
+// ReplyTooManyRequests sends a 429 Too Many Requests with error message
+func ReplyTooManyRequests(c *gin.Context, msg string) {
+	c.JSON(http.StatusTooManyRequests, StandardResponse{Code: 429, Msg: msg})
+}
+
 // ReplyError500 sends a 500 Internal Server Error with error message
 func ReplyError500(c *gin.Context, msg string) {
 	c.JSON(http.StatusInternalServerError, StandardResponse{Code: 500, Msg: msg})
@@ -0,0 +1,123 @@
+// Package revocation 维护 JWT 吊销列表：按 jti 吊销单个 token，或按用户吊销某一时刻之前签发的全部 token。
+// 记录保存在 Redis 中，由鉴权中间件检查；吊销同时通过 pub/sub 广播，gateway 据此关闭已建立的连接。
+package revocation
+
+import (
+	Redis "GoStacker/pkg/db/redis"
+	"GoStacker/pkg/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	jtiKeyPrefix  = "auth:revoked:jti:"
+	userKeyPrefix = "auth:revoked:user:" // 值为秒级时间戳，早于它签发的 token 全部失效
+
+	// Channel 吊销事件的 pub/sub 频道
+	Channel = "auth:revocations"
+)
+
+// 吊销原因
+const (
+	ReasonLogout  = "logout"
+	ReasonBanned  = "banned"
+	ReasonRevoked = "revoked"
+)
+
+var ErrRevoked = errors.New("token has been revoked")
+
+// Event 是广播的吊销事件。JTI 不为空时只吊销该 token，否则吊销 Before 之前签发的全部 token
+type Event struct {
+	UserID int64  `json:"user_id"`
+	JTI    string `json:"jti,omitempty"`
+	Before int64  `json:"before,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Matches 判断事件是否吊销了 jti、签发时间为 issuedAt 的 token
+func (e Event) Matches(jti string, issuedAt time.Time) bool {
+	if e.JTI != "" {
+		return e.JTI == jti
+	}
+	return issuedAt.Unix() < e.Before
+}
+
+// RevokeToken 吊销单个 token，记录保留到 token 过期
+func RevokeToken(userID int64, jti string, expiresAt time.Time, reason string) error {
+	if jti == "" {
+		return errors.New("token has no jti")
+	}
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := Redis.SetEXWithRetry(2, jtiKeyPrefix+jti, strconv.FormatInt(userID, 10), ttl); err != nil {
+		return err
+	}
+	publish(Event{UserID: userID, JTI: jti, Reason: reason})
+	return nil
+}
+
+// RevokeUser 吊销用户当前已签发的全部 token（封禁、全部设备登出），之后签发的 token 不受影响
+func RevokeUser(userID int64, reason string) error {
+	before := time.Now().Unix()
+	// 超过 token 有效期后旧 token 已自然过期，记录随之失效
+	ttl := utils.TokenLifetime() + time.Minute
+	if err := Redis.SetEXWithRetry(2, userKeyPrefix+strconv.FormatInt(userID, 10), before, ttl); err != nil {
+		return err
+	}
+	publish(Event{UserID: userID, Before: before, Reason: reason})
+	return nil
+}
+
+// Check 检查 token 是否已被吊销，已吊销时返回 ErrRevoked
+func Check(claims *utils.JWTClaims) error {
+	if claims.ID != "" {
+		_, err := Redis.GetWithRetry(2, jtiKeyPrefix+claims.ID)
+		if err == nil {
+			return ErrRevoked
+		}
+		if err != goredis.Nil {
+			return err
+		}
+	}
+	v, err := Redis.GetWithRetry(2, userKeyPrefix+strconv.FormatInt(claims.UserID, 10))
+	if err == goredis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	before, _ := strconv.ParseInt(v, 10, 64)
+	if claims.IssuedAt == nil || claims.IssuedAt.Unix() < before {
+		return ErrRevoked
+	}
+	return nil
+}
+
+func publish(e Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := Redis.PublishWithRetry(2, Channel, data); err != nil {
+		zap.L().Warn("Failed to publish revocation event", zap.Int64("user_id", e.UserID), zap.Error(err))
+	}
+}
+
+// Listen 订阅吊销事件直到 ctx 结束
+func Listen(ctx context.Context, handle func(Event)) {
+	Redis.ListenChannel(ctx, Channel, func(payload string) {
+		var e Event
+		if err := json.Unmarshal([]byte(payload), &e); err != nil || e.UserID == 0 {
+			return
+		}
+		handle(e)
+	})
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"GoStacker/pkg/config"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -24,11 +26,22 @@ func SetJWTConfig(cfg *config.JWTConfig) {
 	expireDuration = time.Duration(cfg.ExpireDuration) * time.Second
 }
 
+// TokenLifetime 签发的 token 的有效期
+func TokenLifetime() time.Duration {
+	return expireDuration
+}
+
 func GenerateToken(username string, userID int64) (string, error) {
+	// jti 用于单独吊销某个 token
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
 	claims := JWTClaims{
 		UserName: username,
 		UserID:   userID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expireDuration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
@@ -0,0 +1,183 @@
+// Package wire 定义消息的紧凑二进制编码（MessagePack）：
+// 客户端通过 WebSocket 子协议选择编码，send→gateway 的 Redis stream 条目也使用它。
+// 字段名沿用各类型的 json tag，JSON 与 MessagePack 两种编码下的结构一致。
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+// WebSocket 子协议（Sec-WebSocket-Protocol），客户端未声明时使用 JSON 文本帧
+const (
+	SubprotocolJSON    = "gostacker.json"
+	SubprotocolMsgpack = "gostacker.msgpack"
+)
+
+// stream 条目的编码
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+
+	// StreamDataField 保存编码后的消息，StreamEncodingField 标记编码，缺省为 JSON（旧版本写入的条目）
+	StreamDataField     = "data"
+	StreamEncodingField = "enc"
+)
+
+var msgpackHandle = newMsgpackHandle()
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	// 解码到 interface{} 时与 encoding/json 保持一致，得到 map[string]interface{} 和 string，
+	// payload 可以原样再编码成 JSON 发给文本帧客户端
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}
+
+// Marshal 以 MessagePack 编码 v
+func Marshal(v interface{}) ([]byte, error) {
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(v); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Unmarshal 解码 MessagePack 数据到 v
+func Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
+
+// ToJSON 把 MessagePack 解出的任意值转成 json.RawMessage，供仍按 JSON 处理的代码使用
+func ToJSON(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// FromJSON 把 JSON 解成可按 MessagePack 编码的通用值，整数保持 int64（snowflake ID 不丢精度）
+func FromJSON(raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = convertNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = convertNumbers(e)
+		}
+	}
+	return v
+}
+
+// NormalizeEncoding 未知或空的编码按 MessagePack 处理
+func NormalizeEncoding(encoding string) string {
+	if encoding == EncodingJSON {
+		return EncodingJSON
+	}
+	return EncodingMsgpack
+}
+
+// EncodeStreamValues 生成 XADD 的字段
+func EncodeStreamValues(v interface{}, encoding string) (map[string]interface{}, error) {
+	encoding = NormalizeEncoding(encoding)
+	var (
+		data []byte
+		err  error
+	)
+	if encoding == EncodingJSON {
+		data, err = json.Marshal(v)
+	} else {
+		data, err = Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		StreamDataField:     data,
+		StreamEncodingField: encoding,
+	}, nil
+}
+
+// DecodeStreamValues 解码 stream 条目，兼容不带 enc 字段的 JSON 条目
+func DecodeStreamValues(values map[string]interface{}, v interface{}) error {
+	raw, ok := values[StreamDataField]
+	if !ok {
+		return fmt.Errorf("stream message missing %s field", StreamDataField)
+	}
+	var data []byte
+	switch d := raw.(type) {
+	case string:
+		data = []byte(d)
+	case []byte:
+		data = d
+	default:
+		return fmt.Errorf("unsupported data type: %T", raw)
+	}
+	if enc, _ := values[StreamEncodingField].(string); enc == EncodingMsgpack {
+		return Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// RawJSON 与 json.RawMessage 相同，但 MessagePack 编码时按其 JSON 结构展开而不是当作字节串，
+// 不透明的 JSON 字段（如加密消息的 header）在两种编码下保持同一结构
+type RawJSON []byte
+
+func (m RawJSON) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	return m, nil
+}
+
+func (m *RawJSON) UnmarshalJSON(data []byte) error {
+	*m = append((*m)[0:0], data...)
+	return nil
+}
+
+func (m RawJSON) CodecEncodeSelf(e *codec.Encoder) {
+	var v interface{}
+	if len(m) > 0 {
+		if err := json.Unmarshal(m, &v); err != nil {
+			panic(err)
+		}
+	}
+	e.MustEncode(v)
+}
+
+func (m *RawJSON) CodecDecodeSelf(d *codec.Decoder) {
+	var v interface{}
+	d.MustDecode(&v)
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	*m = data
+}